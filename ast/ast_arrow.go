@@ -1,8 +1,12 @@
 package ast
 
-import "strings"
+import (
+	"strings"
 
-// ArrowExpr ::= UnaryExpr ( "=>" ArrowFunctionSpecifier ArgumentList )*
+	"github.com/zzossig/rabbit/token"
+)
+
+// ArrowExpr ::= UnaryExpr ( ("=>" | "=!>") ArrowFunctionSpecifier ArgumentList )*
 type ArrowExpr struct {
 	ExprSingle
 	Bindings []ArrowBinding
@@ -21,16 +25,22 @@ func (ae *ArrowExpr) String() string {
 	return sb.String()
 }
 
-// ArrowBinding ::= "=>" ArrowFunctionSpecifier ArgumentList **custom**
+// ArrowBinding ::= ("=>" | "=!>") ArrowFunctionSpecifier ArgumentList **custom**
+// The mapping arrow "=!>" of XPath 4.0 applies the function to each item of the left operand
 type ArrowBinding struct {
 	ArrowFunctionSpecifier
 	ArgumentList
+	Token token.Token // token.ARROW or token.MARROW
 }
 
 func (ab *ArrowBinding) String() string {
 	var sb strings.Builder
 
-	sb.WriteString("=>")
+	if ab.Token.Type == token.MARROW {
+		sb.WriteString("=!>")
+	} else {
+		sb.WriteString("=>")
+	}
 	sb.WriteString(" ")
 	sb.WriteString(ab.ArrowFunctionSpecifier.String())
 	sb.WriteString(ab.ArgumentList.String())
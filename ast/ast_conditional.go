@@ -2,7 +2,8 @@ package ast
 
 import "strings"
 
-// IfExpr ::= "if" "(" Expr ")" "then" ExprSingle "else" ExprSingle
+// IfExpr ::= "if" "(" Expr ")" (("then" ExprSingle "else" ExprSingle) | EnclosedExpr)
+// The braced form of XPath 4.0 has no else branch, the ThenExpr is an *EnclosedExpr and the ElseExpr is nil
 type IfExpr struct {
 	TestExpr ExprSingle
 	ThenExpr ExprSingle
@@ -17,6 +18,11 @@ func (ie *IfExpr) String() string {
 	sb.WriteString("(")
 	sb.WriteString(ie.TestExpr.String())
 	sb.WriteString(")")
+	if ie.ElseExpr == nil {
+		sb.WriteString(" ")
+		sb.WriteString(ie.ThenExpr.String())
+		return sb.String()
+	}
 	sb.WriteString(" then ")
 	sb.WriteString(ie.ThenExpr.String())
 	sb.WriteString(" else ")
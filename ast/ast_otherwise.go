@@ -0,0 +1,29 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/zzossig/rabbit/token"
+)
+
+// OtherwiseExpr ::= StringConcatExpr ( "otherwise" StringConcatExpr )* **XPath 4.0**
+type OtherwiseExpr struct {
+	LeftExpr  ExprSingle
+	RightExpr ExprSingle
+	Token     token.Token // token.OTHERWISE
+}
+
+func (oe *OtherwiseExpr) exprSingle() {}
+func (oe *OtherwiseExpr) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("(")
+	sb.WriteString(oe.LeftExpr.String())
+	sb.WriteString(" ")
+	sb.WriteString(oe.Token.Literal)
+	sb.WriteString(" ")
+	sb.WriteString(oe.RightExpr.String())
+	sb.WriteString(")")
+
+	return sb.String()
+}
@@ -15,7 +15,8 @@ type PAL interface {
 // PostfixExpr ::= PrimaryExpr (Predicate | ArgumentList | Lookup)*
 type PostfixExpr struct {
 	ExprSingle
-	Pals []PAL
+	Pals  []PAL
+	Token token.Token // token.LBRACKET, token.LPAREN or token.QUESTION of the first Pal
 }
 
 func (pe *PostfixExpr) exprSingle() {}
@@ -119,6 +119,7 @@ func (i *Identifier) String() string {
 // VarRef ::= "$" VarName
 type VarRef struct {
 	VarName
+	Token token.Token // token.DOLLAR
 }
 
 func (vr *VarRef) exprSingle()  {}
@@ -182,6 +183,7 @@ func (cie *ContextItemExpr) String() string {
 type FunctionCall struct {
 	EQName
 	ArgumentList
+	Token token.Token // the first token of the function name
 }
 
 func (fc *FunctionCall) exprSingle()  {}
@@ -258,16 +260,24 @@ func (nfr *NamedFunctionRef) String() string {
 }
 
 // InlineFunctionExpr ::= "function" "(" ParamList? ")" ("as" SequenceType)? FunctionBody
+// A focus function of XPath 4.0 such as fn { . + 1 } has one parameter without a name, the argument becomes the context item
 type InlineFunctionExpr struct {
 	ParamList
 	SequenceType
 	FunctionBody
+	Focus bool
 }
 
 func (ifr *InlineFunctionExpr) exprSingle() {}
 func (ifr *InlineFunctionExpr) String() string {
 	var sb strings.Builder
 
+	if ifr.Focus {
+		sb.WriteString("fn ")
+		sb.WriteString(ifr.FunctionBody.String())
+		return sb.String()
+	}
+
 	sb.WriteString("function(")
 	sb.WriteString(ifr.ParamList.String())
 	sb.WriteString(")")
@@ -417,6 +417,7 @@ func (tat *TypedArrayTest) String() string {
 type InstanceofExpr struct {
 	ExprSingle
 	SequenceType
+	Token token.Token // token.INSTANCE
 }
 
 func (ie *InstanceofExpr) exprSingle() {}
@@ -438,6 +439,7 @@ func (ie *InstanceofExpr) String() string {
 type CastExpr struct {
 	ExprSingle
 	SingleType
+	Token token.Token // token.CAST
 }
 
 func (ce *CastExpr) exprSingle() {}
@@ -474,6 +476,7 @@ func (st *SingleType) String() string {
 type CastableExpr struct {
 	ExprSingle
 	SingleType
+	Token token.Token // token.CASTABLE
 }
 
 func (ce *CastableExpr) exprSingle() {}
@@ -495,6 +498,7 @@ func (ce *CastableExpr) String() string {
 type TreatExpr struct {
 	ExprSingle
 	SequenceType
+	Token token.Token // token.TREAT
 }
 
 func (te *TreatExpr) exprSingle() {}
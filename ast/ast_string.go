@@ -30,6 +30,31 @@ func (sce *StringConcatExpr) String() string {
 	return sb.String()
 }
 
+// StringTemplate ::= "`" (StringTemplateFixedPart | StringTemplateVariablePart)* "`" **XPath 4.0**
+// Parts are *StringLiteral for the fixed parts and *EnclosedExpr for the variable parts
+type StringTemplate struct {
+	Parts []ExprSingle
+}
+
+func (st *StringTemplate) exprSingle() {}
+func (st *StringTemplate) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("`")
+	for _, part := range st.Parts {
+		switch part := part.(type) {
+		case *StringLiteral:
+			r := strings.NewReplacer("`", "``", "{", "{{", "}", "}}")
+			sb.WriteString(r.Replace(part.Value))
+		default:
+			sb.WriteString(part.String())
+		}
+	}
+	sb.WriteString("`")
+
+	return sb.String()
+}
+
 // EQName ::= QName | URIQualifiedName
 // TypeID ::= 1			| 2
 type EQName struct {
@@ -0,0 +1,51 @@
+package ast
+
+import (
+	"strings"
+
+	"github.com/zzossig/rabbit/token"
+)
+
+// TryCatchExpr ::= "try" EnclosedExpr CatchClause+ **XQuery 3.1**
+type TryCatchExpr struct {
+	EnclosedExpr
+	CatchClauses []CatchClause
+	Token        token.Token // try
+}
+
+func (tce *TryCatchExpr) exprSingle() {}
+func (tce *TryCatchExpr) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("try ")
+	sb.WriteString(tce.EnclosedExpr.String())
+	for _, cc := range tce.CatchClauses {
+		sb.WriteString(" ")
+		sb.WriteString(cc.String())
+	}
+
+	return sb.String()
+}
+
+// CatchClause ::= "catch" CatchErrorList EnclosedExpr
+// CatchErrorList ::= NameTest ( "|" NameTest )*
+type CatchClause struct {
+	NameTests []*NameTest
+	EnclosedExpr
+}
+
+func (cc *CatchClause) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("catch ")
+	for i, nt := range cc.NameTests {
+		if i > 0 {
+			sb.WriteString(" | ")
+		}
+		sb.WriteString(nt.String())
+	}
+	sb.WriteString(" ")
+	sb.WriteString(cc.EnclosedExpr.String())
+
+	return sb.String()
+}
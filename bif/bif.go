@@ -2,11 +2,15 @@ package bif
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/zzossig/rabbit/ast"
 	"github.com/zzossig/rabbit/object"
 	"github.com/zzossig/rabbit/token"
+	"golang.org/x/net/html"
 )
 
 // F is a map that have built-in functions
@@ -17,6 +21,10 @@ var F = map[string]object.Func{
 	"fn:data":      fnData,
 	"fn:base-uri":  fnBaseURI,
 
+	// 3
+	"fn:error": fnError,
+	"fn:trace": fnTrace,
+
 	// 4.2
 	"op:numeric-add":            numericAdd,
 	"op:numeric-subtract":       numericSubtract,
@@ -42,6 +50,12 @@ var F = map[string]object.Func{
 	// 4.5
 	"fn:number": fnNumber,
 
+	// 4.6
+	"fn:format-integer": fnFormatInteger,
+
+	// 4.7
+	"fn:format-number": fnFormatNumber,
+
 	// 4.8
 	"math:pi":    mathPI,
 	"math:exp":   mathExp,
@@ -79,6 +93,12 @@ var F = map[string]object.Func{
 	"fn:substring-before": fnSubstringBefore,
 	"fn:substring-after":  fnSubstringAfter,
 
+	// 5.6
+	"fn:matches":        fnMatches,
+	"fn:replace":        fnReplace,
+	"fn:tokenize":       fnTokenize,
+	"fn:analyze-string": fnAnalyzeString,
+
 	// 7
 	"fn:true":    fnTrue,
 	"fn:false":   fnFalse,
@@ -89,6 +109,76 @@ var F = map[string]object.Func{
 	"op:boolean-less-than":    opBooleanLessThan,
 	"op:boolean-greater-than": opBooleanGreaterThan,
 
+	// 8.2
+	"op:add-yearMonthDurations":                        opAddYearMonthDurations,
+	"op:subtract-yearMonthDurations":                   opSubtractYearMonthDurations,
+	"op:multiply-yearMonthDuration":                    opMultiplyYearMonthDuration,
+	"op:divide-yearMonthDuration":                      opDivideYearMonthDuration,
+	"op:divide-yearMonthDuration-by-yearMonthDuration": opDivideYearMonthDurationByYearMonthDuration,
+	"op:add-dayTimeDurations":                          opAddDayTimeDurations,
+	"op:subtract-dayTimeDurations":                     opSubtractDayTimeDurations,
+	"op:multiply-dayTimeDuration":                      opMultiplyDayTimeDuration,
+	"op:divide-dayTimeDuration":                        opDivideDayTimeDuration,
+	"op:divide-dayTimeDuration-by-dayTimeDuration":     opDivideDayTimeDurationByDayTimeDuration,
+
+	// 8.3
+	"fn:years-from-duration":   fnYearsFromDuration,
+	"fn:months-from-duration":  fnMonthsFromDuration,
+	"fn:days-from-duration":    fnDaysFromDuration,
+	"fn:hours-from-duration":   fnHoursFromDuration,
+	"fn:minutes-from-duration": fnMinutesFromDuration,
+	"fn:seconds-from-duration": fnSecondsFromDuration,
+
+	// 9.3
+	"fn:dateTime": fnDateTime,
+
+	// 9.5
+	"fn:year-from-dateTime":     fnYearFromDateTime,
+	"fn:month-from-dateTime":    fnMonthFromDateTime,
+	"fn:day-from-dateTime":      fnDayFromDateTime,
+	"fn:hours-from-dateTime":    fnHoursFromDateTime,
+	"fn:minutes-from-dateTime":  fnMinutesFromDateTime,
+	"fn:seconds-from-dateTime":  fnSecondsFromDateTime,
+	"fn:timezone-from-dateTime": fnTimezoneFromDateTime,
+	"fn:year-from-date":         fnYearFromDate,
+	"fn:month-from-date":        fnMonthFromDate,
+	"fn:day-from-date":          fnDayFromDate,
+	"fn:timezone-from-date":     fnTimezoneFromDate,
+	"fn:hours-from-time":        fnHoursFromTime,
+	"fn:minutes-from-time":      fnMinutesFromTime,
+	"fn:seconds-from-time":      fnSecondsFromTime,
+	"fn:timezone-from-time":     fnTimezoneFromTime,
+
+	// 9.6
+	"fn:adjust-dateTime-to-timezone": fnAdjustDateTimeToTimezone,
+	"fn:adjust-date-to-timezone":     fnAdjustDateToTimezone,
+	"fn:adjust-time-to-timezone":     fnAdjustTimeToTimezone,
+
+	// 9.7
+	"op:subtract-dateTimes":                       opSubtractDateTimes,
+	"op:subtract-dates":                           opSubtractDates,
+	"op:subtract-times":                           opSubtractTimes,
+	"op:add-yearMonthDuration-to-dateTime":        opAddYearMonthDurationToDateTime,
+	"op:add-dayTimeDuration-to-dateTime":          opAddDayTimeDurationToDateTime,
+	"op:subtract-yearMonthDuration-from-dateTime": opSubtractYearMonthDurationFromDateTime,
+	"op:subtract-dayTimeDuration-from-dateTime":   opSubtractDayTimeDurationFromDateTime,
+	"op:add-yearMonthDuration-to-date":            opAddYearMonthDurationToDate,
+	"op:add-dayTimeDuration-to-date":              opAddDayTimeDurationToDate,
+	"op:subtract-yearMonthDuration-from-date":     opSubtractYearMonthDurationFromDate,
+	"op:subtract-dayTimeDuration-from-date":       opSubtractDayTimeDurationFromDate,
+	"op:add-dayTimeDuration-to-time":              opAddDayTimeDurationToTime,
+	"op:subtract-dayTimeDuration-from-time":       opSubtractDayTimeDurationFromTime,
+
+	// 9.8
+	"fn:format-dateTime": fnFormatDateTime,
+	"fn:format-date":     fnFormatDate,
+	"fn:format-time":     fnFormatTime,
+
+	// 13
+	"fn:name":          fnName,
+	"fn:local-name":    fnLocalName,
+	"fn:namespace-uri": fnNamespaceURI,
+
 	// 14.1
 	"fn:empty":         fnEmpty,
 	"fn:exists":        fnExists,
@@ -98,6 +188,17 @@ var F = map[string]object.Func{
 	"fn:remove":        fnRemove,
 	"fn:reverse":       fnReverse,
 	"fn:subsequence":   fnSubsequence,
+	"fn:unordered":     fnUnordered,
+
+	// 14.2
+	"fn:distinct-values": fnDistinctValues,
+	"fn:index-of":        fnIndexOf,
+	"fn:deep-equal":      fnDeepEqual,
+
+	// 14.3
+	"fn:zero-or-one": fnZeroOrOne,
+	"fn:one-or-more": fnOneOrMore,
+	"fn:exactly-one": fnExactlyOne,
 
 	// 14.4
 	"fn:count": fnCount,
@@ -106,17 +207,35 @@ var F = map[string]object.Func{
 	"fn:min":   fnMin,
 	"fn:sum":   fnSum,
 
+	// 14.5
+	"fn:id": fnID,
+
 	// 14
-	"fn:doc": fnDoc,
+	"fn:doc":           fnDoc,
+	"fn:doc-available": fnDocAvailable,
+	"fn:serialize":     fnSerialize,
 
 	// 15
 	"fn:position": fnPosition,
 	"fn:last":     fnLast,
 
+	"fn:current-dateTime":  fnCurrentDateTime,
+	"fn:current-date":      fnCurrentDate,
+	"fn:current-time":      fnCurrentTime,
+	"fn:implicit-timezone": fnImplicitTimezone,
+
+	// 16.1
+	"fn:function-name":  fnFunctionName,
+	"fn:function-arity": fnFunctionArity,
+
 	// 16.2
 	"fn:for-each":      fnForEach,
 	"fn:for-each-pair": fnForEachPair,
 	"fn:filter":        fnFilter,
+	"fn:fold-left":     fnFoldLeft,
+	"fn:fold-right":    fnFoldRight,
+	"fn:sort":          fnSort,
+	"fn:apply":         fnApply,
 
 	// 17.1
 	"map:size":     mapSize,
@@ -146,6 +265,14 @@ var F = map[string]object.Func{
 	"array:for-each-pair": arrForEachPair,
 	"array:sort":          arrSort,
 	"array:flatten":       arrFlatten,
+	"array:fold-left":     arrFoldLeft,
+	"array:fold-right":    arrFoldRight,
+
+	// 17.5
+	"fn:parse-json":  fnParseJSON,
+	"fn:json-doc":    fnJSONDoc,
+	"fn:json-to-xml": fnJSONToXML,
+	"fn:xml-to-json": fnXMLToJSON,
 
 	// 19
 	"xs:integer": xsInteger,
@@ -153,6 +280,18 @@ var F = map[string]object.Func{
 	"xs:double":  xsDouble,
 	"xs:string":  xsString,
 	"xs:boolean": xsBoolean,
+
+	"xs:dateTime":          xsDateTime,
+	"xs:date":              xsDate,
+	"xs:time":              xsTime,
+	"xs:duration":          xsDuration,
+	"xs:dayTimeDuration":   xsDayTimeDuration,
+	"xs:yearMonthDuration": xsYearMonthDuration,
+}
+
+// fn:function-lookup refers to F, so it is added after F is initialized
+func init() {
+	F["fn:function-lookup"] = fnFunctionLookup
 }
 
 // NewError cteates object.Error
@@ -160,6 +299,11 @@ func NewError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// NewCodedError creates object.Error with an error code such as XPST0008, the code is in the err namespace
+func NewCodedError(code string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Code: "err:" + code, Message: fmt.Sprintf(format, a...)}
+}
+
 // NewString creates object.String
 func NewString(s string) *object.String {
 	str := &object.String{}
@@ -195,6 +339,48 @@ func NewDouble(d float64) *object.Double {
 	return double
 }
 
+// NewDateTime creates object.DateTime
+func NewDateTime(t time.Time, tz bool) *object.DateTime {
+	dt := &object.DateTime{}
+	dt.SetValue(t, tz)
+	return dt
+}
+
+// NewDate creates object.Date
+func NewDate(t time.Time, tz bool) *object.Date {
+	date := &object.Date{}
+	date.SetValue(t, tz)
+	return date
+}
+
+// NewTime creates object.Time
+func NewTime(t time.Time, tz bool) *object.Time {
+	tm := &object.Time{}
+	tm.SetValue(t, tz)
+	return tm
+}
+
+// NewDuration creates object.Duration
+func NewDuration(months int, dur time.Duration) *object.Duration {
+	d := &object.Duration{}
+	d.SetValue(months, dur)
+	return d
+}
+
+// NewDayTimeDuration creates object.DayTimeDuration
+func NewDayTimeDuration(dur time.Duration) *object.DayTimeDuration {
+	d := &object.DayTimeDuration{}
+	d.SetValue(dur)
+	return d
+}
+
+// NewYearMonthDuration creates object.YearMonthDuration
+func NewYearMonthDuration(months int) *object.YearMonthDuration {
+	d := &object.YearMonthDuration{}
+	d.SetValue(months)
+	return d
+}
+
 // NewSequence creates object.Sequence
 func NewSequence(items ...object.Item) *object.Sequence {
 	seq := &object.Sequence{}
@@ -274,7 +460,33 @@ func IsAnyAtomic(item object.Item) bool {
 		item.Type() == object.DecimalType ||
 		item.Type() == object.IntegerType ||
 		item.Type() == object.StringType ||
-		item.Type() == object.BooleanType
+		item.Type() == object.BooleanType ||
+		IsTemporal(item)
+}
+
+// IsTemporal checks if item is a date, time or duration
+func IsTemporal(item object.Item) bool {
+	return IsDateTime(item) || IsDuration(item)
+}
+
+// IsDateTime checks if item is one of xs:dateTime, xs:date, xs:time
+func IsDateTime(item object.Item) bool {
+	if item == nil {
+		return false
+	}
+	return item.Type() == object.DateTimeType ||
+		item.Type() == object.DateType ||
+		item.Type() == object.TimeType
+}
+
+// IsDuration checks if item is one of xs:duration, xs:dayTimeDuration, xs:yearMonthDuration
+func IsDuration(item object.Item) bool {
+	if item == nil {
+		return false
+	}
+	return item.Type() == object.DurationType ||
+		item.Type() == object.DayTimeDurationType ||
+		item.Type() == object.YearMonthDurationType
 }
 
 // IsAnyFunc checks if item is a function or map or array
@@ -297,7 +509,9 @@ func IsNode(item object.Item) bool {
 		item.Type() == object.AttributeNodeType ||
 		item.Type() == object.DocumentNodeType ||
 		item.Type() == object.CommentNodeType ||
-		item.Type() == object.DoctypeNodeType
+		item.Type() == object.DoctypeNodeType ||
+		item.Type() == object.PINodeType ||
+		item.Type() == object.NamespaceNodeType
 
 }
 
@@ -525,9 +739,33 @@ func IsCastable(tg object.Item, ty object.Type) object.Item {
 	switch tg := tg.(type) {
 	case *object.Sequence:
 		if len(tg.Items) != 1 {
-			return NewError("wrong number of sequence items. got=%d, expected=1", len(tg.Items))
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, expected=1", len(tg.Items))
 		}
 		return IsCastable(tg.Items[0], ty)
+	case *object.DateTime:
+		switch ty {
+		case object.DateTimeType, object.DateType, object.TimeType, object.StringType:
+			return NewBoolean(true)
+		}
+		return NewBoolean(false)
+	case *object.Date:
+		switch ty {
+		case object.DateTimeType, object.DateType, object.StringType:
+			return NewBoolean(true)
+		}
+		return NewBoolean(false)
+	case *object.Time:
+		switch ty {
+		case object.TimeType, object.StringType:
+			return NewBoolean(true)
+		}
+		return NewBoolean(false)
+	case *object.Duration, *object.DayTimeDuration, *object.YearMonthDuration:
+		switch ty {
+		case object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType, object.StringType:
+			return NewBoolean(true)
+		}
+		return NewBoolean(false)
 	case *object.Double:
 		switch ty {
 		case object.DoubleType:
@@ -584,6 +822,12 @@ func IsCastable(tg object.Item, ty object.Type) object.Item {
 				return NewBoolean(true)
 			}
 			return NewBoolean(false)
+		case object.DateTimeType, object.DateType, object.TimeType, object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+			item := castString(tg.Value(), ty)
+			if IsError(item) {
+				return item
+			}
+			return NewBoolean(item != nil)
 		case object.StringType:
 			return NewBoolean(true)
 		case object.BooleanType:
@@ -624,6 +868,12 @@ func IsCastable(tg object.Item, ty object.Type) object.Item {
 				return NewBoolean(true)
 			}
 			return NewBoolean(false)
+		case object.DateTimeType, object.DateType, object.TimeType, object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+			item := castString(tg.Text(), ty)
+			if IsError(item) {
+				return item
+			}
+			return NewBoolean(item != nil)
 		case object.StringType:
 			return NewBoolean(true)
 		case object.BooleanType:
@@ -650,6 +900,12 @@ func IsCastable(tg object.Item, ty object.Type) object.Item {
 				return NewBoolean(true)
 			}
 			return NewBoolean(false)
+		case object.DateTimeType, object.DateType, object.TimeType, object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+			item := castString(tg.Text(), ty)
+			if IsError(item) {
+				return item
+			}
+			return NewBoolean(item != nil)
 		case object.StringType:
 			return NewBoolean(true)
 		case object.BooleanType:
@@ -676,12 +932,51 @@ func CastType(tg object.Item, ty object.Type) object.Item {
 
 	blObj := bl.(*object.Boolean)
 	if !blObj.Value() {
-		return NewError("cannot convert %s with value %s to %s", tg.Type(), tg.Inspect(), ty)
+		return NewCodedError("FORG0001", "cannot convert %s with value %s to %s", TypeName(tg), tg.Inspect(), ty)
 	}
 
 	switch tg := tg.(type) {
 	case *object.Sequence:
 		return CastType(tg.Items[0], ty)
+	case *object.DateTime:
+		switch ty {
+		case object.DateTimeType:
+			return tg
+		case object.DateType:
+			return NewDate(tg.Value(), tg.HasTZ())
+		case object.TimeType:
+			return NewTime(tg.Value(), tg.HasTZ())
+		case object.StringType:
+			return NewString(tg.Inspect())
+		}
+	case *object.Date:
+		switch ty {
+		case object.DateTimeType:
+			return NewDateTime(tg.Value(), tg.HasTZ())
+		case object.DateType:
+			return tg
+		case object.StringType:
+			return NewString(tg.Inspect())
+		}
+	case *object.Time:
+		switch ty {
+		case object.TimeType:
+			return tg
+		case object.StringType:
+			return NewString(tg.Inspect())
+		}
+	case *object.Duration, *object.DayTimeDuration, *object.YearMonthDuration:
+		d := tg.(object.DurationItem)
+		switch ty {
+		case object.DurationType:
+			return NewDuration(d.Months(), d.Duration())
+		case object.DayTimeDurationType:
+			return NewDayTimeDuration(d.Duration())
+		case object.YearMonthDurationType:
+			return NewYearMonthDuration(d.Months())
+		case object.StringType:
+			return NewString(tg.Inspect())
+		}
 	case *object.Double:
 		switch ty {
 		case object.DoubleType:
@@ -735,6 +1030,10 @@ func CastType(tg object.Item, ty object.Type) object.Item {
 			if i, err := strconv.ParseInt(tg.Value(), 0, 64); err == nil {
 				return NewInteger(int(i))
 			}
+		case object.DateTimeType, object.DateType, object.TimeType, object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+			if item := castString(tg.Value(), ty); item != nil {
+				return item
+			}
 		case object.StringType:
 			return tg
 		case object.BooleanType:
@@ -784,6 +1083,10 @@ func CastType(tg object.Item, ty object.Type) object.Item {
 			if i, err := strconv.ParseInt(tg.Text(), 0, 64); err == nil {
 				return NewInteger(int(i))
 			}
+		case object.DateTimeType, object.DateType, object.TimeType, object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+			if item := castString(tg.Text(), ty); item != nil {
+				return item
+			}
 		case object.StringType:
 			return NewString(tg.Text())
 		case object.BooleanType:
@@ -808,6 +1111,10 @@ func CastType(tg object.Item, ty object.Type) object.Item {
 			if i, err := strconv.ParseInt(tg.Text(), 0, 64); err == nil {
 				return NewInteger(int(i))
 			}
+		case object.DateTimeType, object.DateType, object.TimeType, object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+			if item := castString(tg.Text(), ty); item != nil {
+				return item
+			}
 		case object.StringType:
 			return NewString(tg.Text())
 		case object.BooleanType:
@@ -820,7 +1127,7 @@ func CastType(tg object.Item, ty object.Type) object.Item {
 		}
 	}
 
-	return NewError("cannot convert %s with value %s to %s", tg.Type(), tg.Inspect(), ty)
+	return NewCodedError("FORG0001", "cannot convert %s with value %s to %s", TypeName(tg), tg.Inspect(), ty)
 }
 
 // IsPrecede checks if n1 is precede n2 in document order
@@ -885,6 +1192,37 @@ func IsPrecede(n1, n2 object.Node, src *object.BaseNode) object.Item {
 	return nil
 }
 
+// Precedes checks if n1 precedes n2 in document order
+// It uses the index of the context if it contains the nodes, otherwise it traverses the document with IsPrecede
+// The nodes in different documents are ordered by the ranks of the documents
+func Precedes(n1, n2 object.Node, ctx *object.Context) object.Item {
+	if c, ok := ctx.Index.Compare(n1, n2); ok {
+		return NewBoolean(c < 0)
+	}
+
+	root := object.TreeRoot(n1)
+	if root != object.TreeRoot(n2) {
+		return NewBoolean(ctx.DocumentRank(n1) < ctx.DocumentRank(n2))
+	}
+	// IsPrecede does not know the namespace nodes
+	if n1.Type() == object.NamespaceNodeType || n2.Type() == object.NamespaceNodeType {
+		c, _ := object.NewIndex(n1).Compare(n1, n2)
+		return NewBoolean(c < 0)
+	}
+	if n2.Tree() == root {
+		return NewBoolean(false)
+	}
+	if n1.Tree() == root {
+		return NewBoolean(true)
+	}
+	doc := &object.BaseNode{}
+	doc.SetTree(root)
+	if result := IsPrecede(n1, n2, doc); result != nil {
+		return result
+	}
+	return NewBoolean(false)
+}
+
 // IsSameAtomic compares object.Item with golang primitive type
 // and return true if value is the same
 func IsSameAtomic(item object.Item, val interface{}) bool {
@@ -925,6 +1263,14 @@ func IsSameAtomic(item object.Item, val interface{}) bool {
 
 // IsEQ checks if left item and right item has eqaul value
 func IsEQ(left, right object.Item) object.Item {
+	if IsTemporal(left) || IsTemporal(right) {
+		c, err := compareTemporal(left, right, false)
+		if err != nil {
+			return err
+		}
+		return NewBoolean(c == 0)
+	}
+
 	if leftVal, ok := left.(*object.Integer); ok {
 		switch rightVal := right.(type) {
 		case *object.Integer:
@@ -1041,11 +1387,19 @@ func IsEQ(left, right object.Item) object.Item {
 		}
 	}
 
-	return NewError("cannot compare: %s, %s", left.Inspect(), right.Inspect())
+	return NewCodedError("XPTY0004", "cannot compare: %s, %s", left.Inspect(), right.Inspect())
 }
 
 // IsNE checks if left item is not eqaul to the right item
 func IsNE(left, right object.Item) object.Item {
+	if IsTemporal(left) || IsTemporal(right) {
+		c, err := compareTemporal(left, right, false)
+		if err != nil {
+			return err
+		}
+		return NewBoolean(c != 0)
+	}
+
 	if leftVal, ok := left.(*object.Integer); ok {
 		switch rightVal := right.(type) {
 		case *object.Integer:
@@ -1162,11 +1516,19 @@ func IsNE(left, right object.Item) object.Item {
 		}
 	}
 
-	return NewError("cannot compare: %s, %s", left.Inspect(), right.Inspect())
+	return NewCodedError("XPTY0004", "cannot compare: %s, %s", left.Inspect(), right.Inspect())
 }
 
 // IsLT checks if left item is less-than the right item
 func IsLT(left, right object.Item) object.Item {
+	if IsTemporal(left) || IsTemporal(right) {
+		c, err := compareTemporal(left, right, true)
+		if err != nil {
+			return err
+		}
+		return NewBoolean(c < 0)
+	}
+
 	if leftVal, ok := left.(*object.Integer); ok {
 		switch rightVal := right.(type) {
 		case *object.Integer:
@@ -1283,11 +1645,19 @@ func IsLT(left, right object.Item) object.Item {
 		}
 	}
 
-	return NewError("cannot compare: %s, %s", left.Inspect(), right.Inspect())
+	return NewCodedError("XPTY0004", "cannot compare: %s, %s", left.Inspect(), right.Inspect())
 }
 
 // IsLE checks if left item is less-than-or-equal to the right item
 func IsLE(left, right object.Item) object.Item {
+	if IsTemporal(left) || IsTemporal(right) {
+		c, err := compareTemporal(left, right, true)
+		if err != nil {
+			return err
+		}
+		return NewBoolean(c <= 0)
+	}
+
 	if leftVal, ok := left.(*object.Integer); ok {
 		switch rightVal := right.(type) {
 		case *object.Integer:
@@ -1404,11 +1774,19 @@ func IsLE(left, right object.Item) object.Item {
 		}
 	}
 
-	return NewError("cannot compare: %s, %s", left.Inspect(), right.Inspect())
+	return NewCodedError("XPTY0004", "cannot compare: %s, %s", left.Inspect(), right.Inspect())
 }
 
 // IsGT checks if left item is greater-than the right item
 func IsGT(left, right object.Item) object.Item {
+	if IsTemporal(left) || IsTemporal(right) {
+		c, err := compareTemporal(left, right, true)
+		if err != nil {
+			return err
+		}
+		return NewBoolean(c > 0)
+	}
+
 	if leftVal, ok := left.(*object.Integer); ok {
 		switch rightVal := right.(type) {
 		case *object.Integer:
@@ -1525,11 +1903,19 @@ func IsGT(left, right object.Item) object.Item {
 		}
 	}
 
-	return NewError("cannot compare: %s, %s", left.Inspect(), right.Inspect())
+	return NewCodedError("XPTY0004", "cannot compare: %s, %s", left.Inspect(), right.Inspect())
 }
 
 // IsGE checks if left item is greater-than-or-equal to the right item
 func IsGE(left, right object.Item) object.Item {
+	if IsTemporal(left) || IsTemporal(right) {
+		c, err := compareTemporal(left, right, true)
+		if err != nil {
+			return err
+		}
+		return NewBoolean(c >= 0)
+	}
+
 	if leftVal, ok := left.(*object.Integer); ok {
 		switch rightVal := right.(type) {
 		case *object.Integer:
@@ -1646,7 +2032,70 @@ func IsGE(left, right object.Item) object.Item {
 		}
 	}
 
-	return NewError("cannot compare: %s, %s", left.Inspect(), right.Inspect())
+	return NewCodedError("XPTY0004", "cannot compare: %s, %s", left.Inspect(), right.Inspect())
+}
+
+// compareTemporal compares date, time and duration values.
+// The string value of a string or a node is cast to the type of the other operand.
+// Only xs:dayTimeDuration and xs:yearMonthDuration are ordered among the durations.
+func compareTemporal(left, right object.Item, ordered bool) (int, object.Item) {
+	if !IsTemporal(left) {
+		left = CastType(left, right.Type())
+		if IsError(left) {
+			return 0, left
+		}
+	}
+	if !IsTemporal(right) {
+		right = CastType(right, left.Type())
+		if IsError(right) {
+			return 0, right
+		}
+	}
+
+	if IsDateTime(left) && left.Type() == right.Type() {
+		l := left.(object.DateTimeItem)
+		r := right.(object.DateTimeItem)
+		li := object.Instant(l.Value(), l.HasTZ())
+		ri := object.Instant(r.Value(), r.HasTZ())
+		switch {
+		case li.Before(ri):
+			return -1, nil
+		case li.After(ri):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if IsDuration(left) && IsDuration(right) {
+		l := left.(object.DurationItem)
+		r := right.(object.DurationItem)
+
+		if !ordered {
+			if l.Months() == r.Months() && l.Duration() == r.Duration() {
+				return 0, nil
+			}
+			return 1, nil
+		}
+
+		switch {
+		case left.Type() == object.DayTimeDurationType && right.Type() == object.DayTimeDurationType:
+			return compareInt(int64(l.Duration()), int64(r.Duration())), nil
+		case left.Type() == object.YearMonthDurationType && right.Type() == object.YearMonthDurationType:
+			return compareInt(int64(l.Months()), int64(r.Months())), nil
+		}
+	}
+
+	return 0, NewCodedError("XPTY0004", "cannot compare types: %s, %s", TypeName(left), TypeName(right))
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
 }
 
 // IsOccurMatch checks if item occurrence match with the type t
@@ -1678,7 +2127,7 @@ func IsOccurMatch(item object.Item, t token.Token) bool {
 }
 
 // IsTypeMatch checks if item type is match with the SequenceType
-func IsTypeMatch(item object.Item, st *ast.SequenceType) object.Item {
+func IsTypeMatch(item object.Item, st *ast.SequenceType, ctx *object.Context) object.Item {
 	switch st.TypeID {
 	case 1:
 		seq, ok := item.(*object.Sequence)
@@ -1696,22 +2145,24 @@ func IsTypeMatch(item object.Item, st *ast.SequenceType) object.Item {
 		switch it.TypeID {
 		case 1:
 			kt := it.NodeTest.(*ast.KindTest)
+			if e := KindTestError(kt); e != nil {
+				return e
+			}
 			switch kt.TypeID {
-			case 1:
-				if item.Type() == object.SequenceType {
-					return NewBoolean(IsOccurMatch(item, oi.Token) && IsDocNodeSeq(item))
-				}
-				return NewBoolean(item.Type() == object.DocumentNodeType)
-			case 2:
-				if item.Type() == object.SequenceType {
-					return NewBoolean(IsOccurMatch(item, oi.Token) && IsElemNodeSeq(item))
-				}
-				return NewBoolean(item.Type() == object.ElementNodeType)
-			case 3:
-				if item.Type() == object.SequenceType {
-					return NewBoolean(IsOccurMatch(item, oi.Token) && IsAttrNodeSeq(item))
+			case 1, 2, 3, 6, 9:
+				if seq, ok := item.(*object.Sequence); ok {
+					if !IsOccurMatch(seq, oi.Token) {
+						return NewBoolean(false)
+					}
+					for _, i := range seq.Items {
+						if n, ok := i.(object.Node); !ok || !IsKindMatch(n, kt, ctx) {
+							return NewBoolean(false)
+						}
+					}
+					return NewBoolean(true)
 				}
-				return NewBoolean(item.Type() == object.AttributeNodeType)
+				n, ok := item.(object.Node)
+				return NewBoolean(ok && IsKindMatch(n, kt, ctx))
 			case 7:
 				if item.Type() == object.SequenceType {
 					return NewBoolean(IsOccurMatch(item, oi.Token) && IsCommNodeSeq(item))
@@ -1730,11 +2181,7 @@ func IsTypeMatch(item object.Item, st *ast.SequenceType) object.Item {
 			case 4:
 				fallthrough
 			case 5:
-				fallthrough
-			case 6:
-				fallthrough
-			case 9:
-				return NewError("not supported kind test")
+				return NewCodedError("XPST0008", "not supported kind test")
 			}
 		case 2:
 			if item.Type() == object.SequenceType {
@@ -1757,51 +2204,288 @@ func IsTypeMatch(item object.Item, st *ast.SequenceType) object.Item {
 			}
 			return NewBoolean(IsArray(item))
 		case 6:
-			if item.Type() == object.SequenceType {
-				return NewBoolean(IsOccurMatch(item, oi.Token) && IsAtomicSeq(item))
+			name := it.NodeTest.(*ast.AtomicOrUnionType).EQName.Value()
+			if seq, ok := item.(*object.Sequence); ok {
+				if !IsOccurMatch(seq, oi.Token) {
+					return NewBoolean(false)
+				}
+				for _, i := range seq.Items {
+					if !IsAtomicTypeMatch(i, name) {
+						return NewBoolean(false)
+					}
+				}
+				return NewBoolean(true)
 			}
-			return NewBoolean(IsAnyAtomic(item))
+			return NewBoolean(IsAtomicTypeMatch(item, name))
 		case 7:
 			pit := it.NodeTest.(*ast.ParenthesizedItemType)
-			st.NodeTest = pit.NodeTest
-			return IsTypeMatch(item, st)
+			return IsTypeMatch(item, &ast.SequenceType{NodeTest: pit.NodeTest, OccurrenceIndicator: oi, TypeID: st.TypeID}, ctx)
 		}
 	}
 
 	return NewBoolean(false)
 }
 
-// IsKindMatch checks node kind by typeID
-func IsKindMatch(n object.Node, typeID byte) bool {
-	switch typeID {
+// IsAtomicTypeMatch checks if item is an instance of the atomic type such as xs:decimal.
+// xs:integer is derived from xs:decimal, and xs:dayTimeDuration and xs:yearMonthDuration are derived from xs:duration.
+func IsAtomicTypeMatch(item object.Item, name string) bool {
+	if !IsAnyAtomic(item) {
+		return false
+	}
+
+	switch atomicTypeName(name) {
+	case "xs:anyAtomicType":
+		return true
+	case "xs:numeric":
+		return IsNumeric(item)
+	case "xs:decimal":
+		return item.Type() == object.DecimalType || item.Type() == object.IntegerType
+	case "xs:duration":
+		return IsDuration(item)
+	}
+	return item.Type() == object.Type(atomicTypeName(name))
+}
+
+// atomicTypeName returns the name of an atomic type with the xs prefix
+func atomicTypeName(name string) string {
+	const xsNS = "Q{http://www.w3.org/2001/XMLSchema}"
+	if strings.HasPrefix(name, xsNS) {
+		return "xs:" + name[len(xsNS):]
+	}
+	return name
+}
+
+// Coerce converts value to the sequence type with the function coercion rules.
+// Nodes are atomized and cast to the required atomic type, xs:integer and xs:decimal values are promoted to xs:double,
+// and the number of items is checked against the occurrence indicator. A mismatch is an XPTY0004 error.
+// https://www.w3.org/TR/xpath-31/#id-function-coercion
+func Coerce(value object.Item, st *ast.SequenceType, ctx *object.Context) object.Item {
+	if st.TypeID == 0 {
+		return value
+	}
+
+	seq := &object.Sequence{Items: UnwrapSeq(value)}
+	if st.TypeID == 1 {
+		if len(seq.Items) > 0 {
+			return coercionError(value, st)
+		}
+		return seq
+	}
+	if !IsOccurMatch(seq, st.OccurrenceIndicator.Token) {
+		return coercionError(value, st)
+	}
+
+	it := st.NodeTest.(*ast.ItemType)
+	for it.TypeID == 7 {
+		it = it.NodeTest.(*ast.ParenthesizedItemType).NodeTest.(*ast.ItemType)
+	}
+
+	items := make([]object.Item, len(seq.Items))
+	for i, item := range seq.Items {
+		if it.TypeID == 6 {
+			item = coerceAtomic(item, it.NodeTest.(*ast.AtomicOrUnionType).EQName.Value())
+			if item == nil {
+				return coercionError(value, st)
+			}
+			if IsError(item) {
+				return item
+			}
+		} else {
+			matched := IsTypeMatch(item, &ast.SequenceType{NodeTest: it, TypeID: 2}, ctx)
+			if IsError(matched) {
+				return matched
+			}
+			if !matched.(*object.Boolean).Value() {
+				return coercionError(value, st)
+			}
+		}
+		items[i] = item
+	}
+
+	if len(items) == 1 {
+		return items[0]
+	}
+	return &object.Sequence{Items: items}
+}
+
+// coerceAtomic atomizes and promotes item to the atomic type, nil is returned if item cannot be the type
+func coerceAtomic(item object.Item, name string) object.Item {
+	name = atomicTypeName(name)
+
+	if IsNode(item) {
+		switch name {
+		case "xs:anyAtomicType":
+			return CastType(item, object.StringType)
+		case "xs:numeric":
+			return CastType(item, object.DoubleType)
+		}
+		return CastType(item, object.Type(name))
+	}
+
+	if IsAtomicTypeMatch(item, name) {
+		return item
+	}
+	if name == "xs:double" && (item.Type() == object.IntegerType || item.Type() == object.DecimalType) {
+		return CastType(item, object.DoubleType)
+	}
+	return nil
+}
+
+func coercionError(value object.Item, st *ast.SequenceType) *object.Error {
+	return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=%s", TypeName(value), st.String())
+}
+
+// TypeName returns the type of the item as it is written in a sequence type such as element(p) or xs:string
+// A sequence of items that have the same type is written with the occurrence indicator +
+func TypeName(item object.Item) string {
+	switch item := item.(type) {
+	case *object.Sequence:
+		items := UnwrapSeq(item)
+		switch len(items) {
+		case 0:
+			return "empty-sequence()"
+		case 1:
+			return TypeName(items[0])
+		}
+		name := TypeName(items[0])
+		for _, it := range items[1:] {
+			if TypeName(it) != name {
+				return "item()+"
+			}
+		}
+		return name + "+"
+	case *object.Map:
+		return "map(*)"
+	case *object.Array:
+		return "array(*)"
+	case *object.FuncNamed, *object.FuncInline, *object.FuncPartial:
+		return "function(*)"
+	case object.Node:
+		switch item.Type() {
+		case object.DocumentNodeType:
+			return "document-node()"
+		case object.ElementNodeType:
+			return "element(" + nodeName(item) + ")"
+		case object.AttributeNodeType:
+			return "attribute(" + nodeName(item) + ")"
+		case object.TextNodeType:
+			return "text()"
+		case object.CommentNodeType:
+			return "comment()"
+		case object.PINodeType:
+			return "processing-instruction(" + nodeName(item) + ")"
+		case object.NamespaceNodeType:
+			return "namespace-node()"
+		}
+		return "node()"
+	}
+	return string(item.Type())
+}
+
+// IsKindMatch checks if the node matches the kind test.
+// The name arguments of element(name), attribute(name) and document-node(element(name)) are matched like name tests,
+// the name argument of processing-instruction(name) is compared with the target.
+func IsKindMatch(n object.Node, t *ast.KindTest, ctx *object.Context) bool {
+	switch t.TypeID {
 	case 1:
-		if n.Type() == object.DocumentNodeType {
+		if n.Type() != object.DocumentNodeType {
+			return false
+		}
+		dt, ok := t.NodeTest.(*ast.DocumentTest)
+		if !ok || dt.NodeTest == nil {
 			return true
 		}
+		et, ok := dt.NodeTest.(*ast.KindTest)
+		if !ok {
+			return false
+		}
+
+		// the document node matches if it has exactly one element child that matches the element test
+		var elem object.Node
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			switch c.Type() {
+			case object.ElementNodeType:
+				if elem != nil {
+					return false
+				}
+				elem = c
+			case object.TextNodeType:
+				if strings.TrimSpace(c.Tree().Data) != "" {
+					return false
+				}
+			}
+		}
+		return elem != nil && IsKindMatch(elem, et, ctx)
 	case 2:
-		if n.Type() == object.ElementNodeType {
+		if n.Type() != object.ElementNodeType {
+			return false
+		}
+		et, ok := t.NodeTest.(*ast.ElementTest)
+		if !ok || et.WC != "" || et.ElementName.Value() == "" {
 			return true
 		}
+		return IsNameMatch(n, &ast.NameTest{EQName: et.ElementName, TypeID: 1}, ctx)
 	case 3:
-		if n.Type() == object.AttributeNodeType {
-			return true
+		if n.Type() != object.AttributeNodeType {
+			return false
 		}
-	case 7:
-		if n.Type() == object.CommentNodeType {
+		at, ok := t.NodeTest.(*ast.AttributeTest)
+		if !ok || at.WC != "" || at.AttributeName.Value() == "" {
 			return true
 		}
-	case 8:
-		if n.Type() == object.TextNodeType {
-			return true
+		return IsNameMatch(n, &ast.NameTest{EQName: at.AttributeName, TypeID: 1}, ctx)
+	case 6:
+		if n.Type() != object.PINodeType {
+			return false
 		}
-	case 10:
-		if n.Type() != object.AttributeNodeType {
+		pt, ok := t.NodeTest.(*ast.PITest)
+		if !ok {
 			return true
 		}
+		switch {
+		case pt.NCName.Value() != "":
+			return n.Tree().Data == pt.NCName.Value()
+		case pt.StringLiteral.Value != "":
+			return n.Tree().Data == strings.Join(strings.Fields(pt.StringLiteral.Value), " ")
+		}
+		return true
+	case 7:
+		return n.Type() == object.CommentNodeType
+	case 8:
+		return n.Type() == object.TextNodeType
+	case 9:
+		return n.Type() == object.NamespaceNodeType
+	case 10:
+		return n.Type() != object.AttributeNodeType
 	}
 	return false
 }
 
+// KindTestError returns an error if the kind test has a type annotation argument such as element(*, xs:string)
+// or is a schema test such as schema-element(p).
+// There is no schema in a html document, so the type annotations and the declarations cannot be checked.
+func KindTestError(t *ast.KindTest) object.Item {
+	switch test := t.NodeTest.(type) {
+	case *ast.DocumentTest:
+		if et, ok := test.NodeTest.(*ast.KindTest); ok {
+			return KindTestError(et)
+		}
+	case *ast.ElementTest:
+		if test.TypeName.Value() != "" {
+			return NewCodedError("XPST0008", "type annotation is not supported in the element test: %s", test.String())
+		}
+	case *ast.AttributeTest:
+		if test.TypeName.Value() != "" {
+			return NewCodedError("XPST0008", "type annotation is not supported in the attribute test: %s", test.String())
+		}
+	case *ast.SchemaElementTest:
+		return NewCodedError("XPST0008", "element declaration is not in the in-scope schema definitions: %s", test.String())
+	case *ast.SchemaAttributeTest:
+		return NewCodedError("XPST0008", "attribute declaration is not in the in-scope schema definitions: %s", test.String())
+	}
+	return nil
+}
+
 // IsContainN checks if src contains the target node
 func IsContainN(src []object.Node, target object.Node) bool {
 	for _, item := range src {
@@ -1822,7 +2506,7 @@ func IsContainN(src []object.Node, target object.Node) bool {
 				return false
 			}
 
-			if item.Tree() == target.Tree() && item.Key() == target.Key() {
+			if item.Tree() == target.Tree() && item.Key() == target.Key() && item.Attr().Namespace == target.Attr().Namespace {
 				return true
 			}
 		}
@@ -1838,6 +2522,68 @@ func AppendNode(src []object.Node, target object.Node) []object.Node {
 	return src
 }
 
+// nodeKey identifies a node, an attribute node is identified by its element and name
+type nodeKey struct {
+	tree *html.Node
+	attr string
+	ns   string
+}
+
+// NodeKey returns a comparable key that is the same for the nodes that are the same node
+func NodeKey(n object.Node) interface{} {
+	if an, ok := n.(*object.AttrNode); ok {
+		return nodeKey{tree: an.Tree(), attr: an.Key(), ns: an.Attr().Namespace}
+	}
+	return nodeKey{tree: n.Tree()}
+}
+
+// SortNodes removes the duplicate nodes and sorts the nodes in document order
+// The nodes in different documents are ordered by the ranks of the documents, the nodes in a document are compared
+// with the index of the context if it contains them, otherwise the tree of the nodes is indexed
+func SortNodes(nodes []object.Node, ctx *object.Context) []object.Node {
+	seen := make(map[interface{}]bool, len(nodes))
+	result := make([]object.Node, 0, len(nodes))
+	for _, n := range nodes {
+		k := NodeKey(n)
+		if !seen[k] {
+			seen[k] = true
+			result = append(result, n)
+		}
+	}
+	if len(result) < 2 {
+		return result
+	}
+
+	type docNode struct {
+		node object.Node
+		rank int
+		idx  *object.Index
+	}
+	indexes := map[*html.Node]*object.Index{}
+	dns := make([]docNode, len(result))
+	for i, n := range result {
+		dns[i] = docNode{node: n, rank: ctx.DocumentRank(n), idx: ctx.Index}
+		if !ctx.Index.Contains(n) {
+			root := object.TreeRoot(n)
+			if _, ok := indexes[root]; !ok {
+				indexes[root] = object.NewIndex(n)
+			}
+			dns[i].idx = indexes[root]
+		}
+	}
+	sort.SliceStable(dns, func(i, j int) bool {
+		if dns[i].idx != dns[j].idx {
+			return dns[i].rank < dns[j].rank
+		}
+		c, _ := dns[i].idx.Compare(dns[i].node, dns[j].node)
+		return c < 0
+	})
+	for i, dn := range dns {
+		result[i] = dn.node
+	}
+	return result
+}
+
 // CopyFocus copy focus from context
 func CopyFocus(ctx *object.Context) *object.Focus {
 	return &object.Focus{CSize: ctx.CSize, CPos: ctx.CPos, CAxis: ctx.CAxis}
@@ -1897,3 +2643,204 @@ func UnwrapArr(item object.Item) []object.Item {
 
 	return []object.Item{item}
 }
+
+// stringArg converts a string argument of the built-in functions to a golang string.
+// An empty sequence is treated as a zero-length string.
+func stringArg(item object.Item) (string, *object.Error) {
+	switch item := item.(type) {
+	case *object.String:
+		return item.Value(), nil
+	case *object.BaseNode:
+		return item.Text(), nil
+	case *object.AttrNode:
+		return item.Text(), nil
+	case *object.Sequence:
+		switch len(item.Items) {
+		case 0:
+			return "", nil
+		case 1:
+			return stringArg(item.Items[0])
+		default:
+			return "", NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(item.Items))
+		}
+	}
+	return "", NewCodedError("XPTY0004", "cannot match item type with required type")
+}
+
+// setFocus makes the argument of a focus function the context item of the function body
+func setFocus(ctx *object.Context, arg object.Item) object.Item {
+	items := UnwrapSeq(arg)
+	if len(items) != 1 {
+		return NewCodedError("XPTY0004", "the argument of a focus function must be a single item. got=%d items", len(items))
+	}
+
+	ctx.CItem = items[0]
+	ctx.CNode = []object.Node{}
+	if n, ok := items[0].(object.Node); ok {
+		ctx.CNode = []object.Node{n}
+	}
+	ctx.CPos, ctx.CSize = 1, 1
+	return nil
+}
+
+// CallFunc calls a function item with the arguments.
+// It is used for dynamic function calls and higher-order functions.
+func CallFunc(ctx *object.Context, f object.Item, args ...object.Item) object.Item {
+	if seq, ok := f.(*object.Sequence); ok && len(seq.Items) == 1 {
+		f = seq.Items[0]
+	}
+
+	switch f := f.(type) {
+	case *object.FuncInline:
+		if len(f.PL.Params) != len(args) {
+			return NewCodedError("XPTY0004", "wrong number of argument. got=%d, want=%d", len(args), len(f.PL.Params))
+		}
+
+		if e := ctx.Guard.Enter(); e != nil {
+			return e
+		}
+		defer ctx.Guard.Leave()
+
+		enclosedCtx := object.NewEnclosedContext(ctx)
+		if f.Focus {
+			if e := setFocus(enclosedCtx, args[0]); e != nil {
+				return e
+			}
+		} else {
+			for i, param := range f.PL.Params {
+				arg := Coerce(args[i], &param.TypeDeclaration.SequenceType, ctx)
+				if IsError(arg) {
+					return arg
+				}
+				enclosedCtx.Set(param.EQName.Value(), arg)
+			}
+		}
+
+		result := f.Fn(&f.Body.Expr, enclosedCtx)
+		if IsError(result) {
+			return result
+		}
+		if f.ST != nil {
+			result = Coerce(result, f.ST, ctx)
+			if IsError(result) {
+				return result
+			}
+		}
+
+		// a function body that evaluates to a single item returns the item itself
+		items := UnwrapSeq(result)
+		if len(items) == 1 {
+			return items[0]
+		}
+		return &object.Sequence{Items: items}
+	case *object.FuncNamed:
+		if len(args) != f.Num {
+			return NewCodedError("XPTY0004", "wrong number of argument. got=%d, want=%d", len(args), f.Num)
+		}
+
+		if f.Func == nil {
+			return NewCodedError("XPST0017", "function not found: %s", f.Name.Value())
+		}
+
+		return (*f.Func)(ctx, args...)
+	case *object.FuncPartial:
+		if len(args) != f.PCnt {
+			return NewCodedError("XPTY0004", "wrong number of argument. got=%d, want=%d", len(args), f.PCnt)
+		}
+
+		pcnt := 0
+		a := []object.Item{}
+		for _, arg := range f.Args {
+			switch arg.Type() {
+			case object.VarrefType:
+				it, ok := ctx.Get(arg.Inspect())
+				if !ok {
+					return NewCodedError("XPST0008", "variable not defined: $%s", arg.Inspect())
+				}
+				a = append(a, it)
+			case object.PholderType:
+				a = append(a, args[pcnt])
+				pcnt++
+			default:
+				a = append(a, arg)
+			}
+		}
+
+		return (*f.Func)(ctx, a...)
+	case *object.Array:
+		if len(args) != 1 {
+			return NewCodedError("XPTY0004", "wrong number of argument. got=%d, want=1", len(args))
+		}
+
+		index, ok := args[0].(*object.Integer)
+		if !ok {
+			return NewCodedError("XPTY0004", "dynamic function call on array should have integer argument")
+		}
+		if index.Value() <= 0 || index.Value() > len(f.Items) {
+			return NewCodedError("FOAY0001", "Index out of range: size(%d)", len(f.Items))
+		}
+		return f.Items[index.Value()-1]
+	case *object.Map:
+		if len(args) != 1 {
+			return NewCodedError("XPTY0004", "wrong number of argument. got=%d, want=1", len(args))
+		}
+
+		h, ok := args[0].(object.Hasher)
+		if !ok {
+			return NewCodedError("XPTY0004", "dynamic function call on map should have atomic argument")
+		}
+
+		pair, ok := f.Pairs[h.HashKey()]
+		if !ok {
+			return NewSequence()
+		}
+		return pair.Value
+	}
+	return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=function(*)", TypeName(f))
+}
+
+// FuncArity returns the number of arguments that a function item takes
+func FuncArity(f object.Item) int {
+	switch f := f.(type) {
+	case *object.FuncInline:
+		return len(f.PL.Params)
+	case *object.FuncNamed:
+		return f.Num
+	case *object.FuncPartial:
+		return f.PCnt
+	case *object.Map, *object.Array:
+		return 1
+	}
+	return -1
+}
+
+// Function returns the function with the prefixed name such as fn:count.
+// The functions in the static context are looked up before the built-in functions in F.
+func Function(ctx *object.Context, name string) (object.Func, bool) {
+	if ctx != nil {
+		if f, ok := ctx.Functions[name]; ok {
+			return f, true
+		}
+	}
+	f, ok := F[name]
+	return f, ok
+}
+
+// HasArity reports whether the function with the prefixed name accepts n arguments
+func HasArity(ctx *object.Context, name string, n int) bool {
+	a, ok := arities[name]
+	if ctx != nil {
+		if ca, found := ctx.Arities[name]; found {
+			a, ok = ca, true
+		}
+	}
+	if !ok {
+		return true
+	}
+	for i, m := range a {
+		if m == n || (m < 0 && n > a[i-1]) {
+			return true
+		}
+	}
+	return false
+}
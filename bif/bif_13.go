@@ -0,0 +1,223 @@
+package bif
+
+import (
+	"strings"
+
+	"github.com/zzossig/rabbit/ast"
+	"github.com/zzossig/rabbit/object"
+	"golang.org/x/net/html"
+)
+
+// Namespaces is the default namespace table, the prefixes in it can be used in name tests such as svg:path.
+// The prefixes bound in the context take precedence over the table.
+var Namespaces = map[string]string{
+	"html":   "http://www.w3.org/1999/xhtml",
+	"svg":    "http://www.w3.org/2000/svg",
+	"mathml": "http://www.w3.org/1998/Math/MathML",
+	"xlink":  "http://www.w3.org/1999/xlink",
+	"xml":    "http://www.w3.org/XML/1998/namespace",
+	"xmlns":  "http://www.w3.org/2000/xmlns/",
+}
+
+// nodeNamespaces maps the namespace names that the html parser assigns to the foreign elements and attributes
+var nodeNamespaces = map[string]string{
+	"svg":   Namespaces["svg"],
+	"math":  Namespaces["mathml"],
+	"xlink": Namespaces["xlink"],
+	"xml":   Namespaces["xml"],
+	"xmlns": Namespaces["xmlns"],
+}
+
+// NamespaceURI returns the namespace uri bound to the prefix
+func NamespaceURI(ctx *object.Context, prefix string) (string, bool) {
+	if ctx != nil {
+		if uri, ok := ctx.Namespaces[prefix]; ok {
+			return uri, true
+		}
+	}
+	uri, ok := Namespaces[prefix]
+	return uri, ok
+}
+
+// NodeNamespaceURI returns the namespace uri of the element or attribute node.
+// Elements parsed as html are in the xhtml namespace, attributes without a prefix are in no namespace.
+// Elements parsed as xml without a namespace are in no namespace.
+func NodeNamespaceURI(n object.Node) string {
+	var ns string
+	switch n := n.(type) {
+	case *object.AttrNode:
+		ns = n.Attr().Namespace
+		if ns == "" || n.Type() == object.NamespaceNodeType {
+			return ""
+		}
+	case *object.BaseNode:
+		if n.Type() != object.ElementNodeType {
+			return ""
+		}
+		ns = n.Tree().Namespace
+		if ns == "" {
+			if object.IsXML(n) {
+				return ""
+			}
+			return Namespaces["html"]
+		}
+	default:
+		return ""
+	}
+
+	if uri, ok := nodeNamespaces[ns]; ok {
+		return uri
+	}
+	return ns
+}
+
+// nodeLocalName returns the name of the element or attribute node without the namespace
+// The name of a namespace node is its prefix and the name of a processing instruction is its target
+func nodeLocalName(n object.Node) string {
+	switch n := n.(type) {
+	case *object.AttrNode:
+		return n.Key()
+	case *object.BaseNode:
+		if n.Type() == object.ElementNodeType || n.Type() == object.PINodeType {
+			return n.Tree().Data
+		}
+	}
+	return ""
+}
+
+// prefixURI returns the namespace uri bound to the prefix of a name test
+// The prefixes bound in the context come first, then the namespace declarations in the scope of a xml node
+// and the default namespace table
+func prefixURI(ctx *object.Context, n object.Node, prefix string) (string, bool) {
+	if ctx != nil {
+		if uri, ok := ctx.Namespaces[prefix]; ok {
+			return uri, true
+		}
+	}
+	if object.IsXML(n) {
+		if uri, ok := object.LookupNamespaceURI(n.Tree(), prefix); ok {
+			return uri, true
+		}
+	}
+	uri, ok := Namespaces[prefix]
+	return uri, ok
+}
+
+// IsNameMatch checks if the element, attribute or namespace node matches the name test.
+// An unprefixed name matches the local name in any namespace, so //path selects both html and svg path elements.
+// A prefixed name is resolved with the context, the namespace declarations of a xml document and the default namespace table,
+// a name with an unbound prefix is compared with the node name as it is.
+func IsNameMatch(n object.Node, t *ast.NameTest, ctx *object.Context) bool {
+	local := nodeLocalName(n)
+	if local == "" {
+		return false
+	}
+
+	switch t.TypeID {
+	case 1:
+		if t.EQName.TypeID == 2 {
+			qn := t.EQName.URIQualifiedName
+			return local == qn.NCName.Value() && NodeNamespaceURI(n) == qn.BracedURILiteral.URI()
+		}
+
+		qn := t.EQName.QName
+		if qn.Prefix() == "" {
+			return local == qn.Local()
+		}
+		if uri, ok := prefixURI(ctx, n, qn.Prefix()); ok {
+			return local == qn.Local() && NodeNamespaceURI(n) == uri
+		}
+		return local == qn.Value()
+	case 2:
+		switch t.Wildcard.TypeID {
+		case 1:
+			return true
+		case 2:
+			if uri, ok := prefixURI(ctx, n, t.Wildcard.NCName.Value()); ok {
+				return NodeNamespaceURI(n) == uri
+			}
+			return strings.HasPrefix(local, t.Wildcard.NCName.Value()+":")
+		case 3:
+			return local == t.Wildcard.NCName.Value()
+		case 4:
+			return NodeNamespaceURI(n) == t.Wildcard.BracedURILiteral.URI()
+		}
+	}
+	return false
+}
+
+func fnName(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:name")
+	}
+	return nodeNames(ctx, "fn:name", nodeName, args...)
+}
+
+func fnLocalName(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:local-name")
+	}
+	return nodeNames(ctx, "fn:local-name", nodeLocalName, args...)
+}
+
+func fnNamespaceURI(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:namespace-uri")
+	}
+	return nodeNames(ctx, "fn:namespace-uri", NodeNamespaceURI, args...)
+}
+
+// nodeNames applies f to the argument node, or to the context nodes if the argument is omitted.
+// The empty sequence results in the zero-length string.
+func nodeNames(ctx *object.Context, fname string, f func(object.Node) string, args ...object.Item) object.Item {
+	if len(args) == 1 {
+		items := UnwrapSeq(args[0])
+		switch {
+		case len(items) == 0:
+			return NewString("")
+		case len(items) > 1:
+			return NewCodedError("XPTY0004", "%s: a sequence of more than one item is not allowed as the argument", fname)
+		}
+
+		n, ok := items[0].(object.Node)
+		if !ok {
+			return NewCodedError("XPTY0004", "%s: the argument is not a node: %s", fname, TypeName(items[0]))
+		}
+		return NewString(f(n))
+	}
+
+	if len(ctx.CNode) > 0 {
+		seq := &object.Sequence{}
+		for _, n := range ctx.CNode {
+			seq.Items = append(seq.Items, NewString(f(n)))
+		}
+		return seq
+	}
+
+	return NewCodedError("XPDY0002", "context node is undefined")
+}
+
+// nodeName returns the name of the node as it appears in the html document.
+// Foreign elements have no prefix, attributes in the xlink, xml and xmlns namespaces have the prefix.
+// The elements and attributes of a xml document have the prefix bound to their namespace.
+func nodeName(n object.Node) string {
+	switch n.Type() {
+	case object.NamespaceNodeType:
+		return nodeLocalName(n)
+	case object.AttributeNodeType:
+		a := n.(*object.AttrNode).Attr()
+		return qualifiedName(n.Tree(), a.Namespace, a.Key, attrName(a))
+	case object.ElementNodeType:
+		return qualifiedName(n.Tree(), n.Tree().Namespace, n.Tree().Data, n.Tree().Data)
+	}
+	return nodeLocalName(n)
+}
+
+// qualifiedName returns prefix:local if a prefix is bound to the namespace uri in the scope of the element n, otherwise name
+// Only the namespace declarations of a xml document bind prefixes, so the names of html nodes are name
+func qualifiedName(n *html.Node, uri, local, name string) string {
+	if p, ok := object.LookupPrefix(n, uri); ok && p != "" {
+		return p + ":" + local
+	}
+	return name
+}
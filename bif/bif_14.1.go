@@ -4,10 +4,10 @@ import "github.com/zzossig/rabbit/object"
 
 func fnEmpty(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:empty")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:empty")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:empty")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:empty")
 	}
 
 	if IsSeqEmpty(args[0]) {
@@ -18,10 +18,10 @@ func fnEmpty(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnExists(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:exists")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:exists")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:exists")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:exists")
 	}
 
 	if IsSeqEmpty(args[0]) {
@@ -32,16 +32,16 @@ func fnExists(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnRemove(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:remove")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:remove")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: fn:remove")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:remove")
 	}
 
 	if !IsSeq(args[0]) {
 		pos, ok := args[1].(*object.Integer)
 		if !ok {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		if pos.Value() == 1 {
 			return NewSequence()
@@ -52,7 +52,7 @@ func fnRemove(ctx *object.Context, args ...object.Item) object.Item {
 	target, ok1 := args[0].(*object.Sequence)
 	pos, ok2 := args[1].(*object.Integer)
 	if !ok1 || !ok2 {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if pos.Value() == 0 {
 		return target
@@ -69,10 +69,10 @@ func fnRemove(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnHead(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:head")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:head")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:head")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:head")
 	}
 
 	if IsSeqEmpty(args[0]) || !IsSeq(args[0]) {
@@ -85,10 +85,10 @@ func fnHead(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnTail(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:tail")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:tail")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:tail")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:tail")
 	}
 
 	if IsSeqEmpty(args[0]) || !IsSeq(args[0]) {
@@ -101,15 +101,15 @@ func fnTail(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnInsertBefore(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 3 {
-		return NewError("too few parameters for function call: fn:insert-before")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:insert-before")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:insert-before")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:insert-before")
 	}
 
 	position, ok := args[1].(*object.Integer)
 	if !ok {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	idx := position.Value()
 	if idx != 0 {
@@ -139,10 +139,10 @@ func fnInsertBefore(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnReverse(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:reverse")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:reverse")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:reverse")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:reverse")
 	}
 
 	if IsSeqEmpty(args[0]) || !IsSeq(args[0]) {
@@ -159,10 +159,10 @@ func fnReverse(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnSubsequence(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:subsequence")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:subsequence")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:subsequence")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:subsequence")
 	}
 
 	sourceSeq := &object.Sequence{}
@@ -226,3 +226,14 @@ func fnSubsequence(ctx *object.Context, args ...object.Item) object.Item {
 	}
 	return NewSequence(sourceSeq.Items[idx:last]...)
 }
+
+func fnUnordered(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:unordered")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:unordered")
+	}
+
+	return args[0]
+}
@@ -0,0 +1,243 @@
+package bif
+
+import (
+	"math"
+
+	"github.com/zzossig/rabbit/object"
+	"golang.org/x/net/html"
+)
+
+func fnDistinctValues(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:distinct-values")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:distinct-values")
+	}
+	if len(args) == 2 {
+		if e := collationArg(args[1]); e != nil {
+			return e
+		}
+	}
+
+	result := &object.Sequence{}
+	seen := make(map[object.HashKey]bool)
+
+	for _, item := range atomize(args[0]) {
+		if IsError(item) {
+			return item
+		}
+
+		h, ok := item.(object.Hasher)
+		if !ok {
+			return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=xs:anyAtomicType", TypeName(item))
+		}
+
+		key := h.HashKey()
+		if !seen[key] {
+			seen[key] = true
+			result.Items = append(result.Items, item)
+		}
+	}
+
+	return result
+}
+
+func fnIndexOf(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:index-of")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:index-of")
+	}
+	if len(args) == 3 {
+		if e := collationArg(args[2]); e != nil {
+			return e
+		}
+	}
+
+	search := atomize(args[1])
+	if len(search) != 1 {
+		return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(search))
+	}
+
+	result := &object.Sequence{}
+	for i, item := range atomize(args[0]) {
+		if equalAtomic(item, search[0]) {
+			result.Items = append(result.Items, NewInteger(i+1))
+		}
+	}
+
+	return result
+}
+
+func fnDeepEqual(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:deep-equal")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:deep-equal")
+	}
+	if len(args) == 3 {
+		if e := collationArg(args[2]); e != nil {
+			return e
+		}
+	}
+
+	eq, err := deepEqualSeq(UnwrapSeq(args[0]), UnwrapSeq(args[1]))
+	if err != nil {
+		return err
+	}
+	return NewBoolean(eq)
+}
+
+func deepEqualSeq(s1, s2 []object.Item) (bool, object.Item) {
+	if len(s1) != len(s2) {
+		return false, nil
+	}
+
+	for i := range s1 {
+		eq, err := deepEqualItem(s1[i], s2[i])
+		if err != nil || !eq {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func deepEqualItem(i1, i2 object.Item) (bool, object.Item) {
+	switch i1 := i1.(type) {
+	case *object.Map:
+		i2, ok := i2.(*object.Map)
+		if !ok || len(i1.Pairs) != len(i2.Pairs) {
+			return false, nil
+		}
+
+		for key, p1 := range i1.Pairs {
+			p2, ok := i2.Pairs[key]
+			if !ok {
+				return false, nil
+			}
+			eq, err := deepEqualSeq(UnwrapSeq(p1.Value), UnwrapSeq(p2.Value))
+			if err != nil || !eq {
+				return false, err
+			}
+		}
+		return true, nil
+	case *object.Array:
+		i2, ok := i2.(*object.Array)
+		if !ok || len(i1.Items) != len(i2.Items) {
+			return false, nil
+		}
+
+		for i := range i1.Items {
+			eq, err := deepEqualSeq(UnwrapSeq(i1.Items[i]), UnwrapSeq(i2.Items[i]))
+			if err != nil || !eq {
+				return false, err
+			}
+		}
+		return true, nil
+	case *object.FuncInline, *object.FuncNamed, *object.FuncPartial:
+		return false, NewCodedError("FOTY0015", "fn:deep-equal cannot compare function items")
+	case *object.AttrNode:
+		i2, ok := i2.(*object.AttrNode)
+		return ok && equalAttr(i1.Attr(), i2.Attr()), nil
+	case *object.BaseNode:
+		i2, ok := i2.(*object.BaseNode)
+		return ok && deepEqualNode(i1.Tree(), i2.Tree()), nil
+	}
+
+	if IsFunc(i2) {
+		return false, NewCodedError("FOTY0015", "fn:deep-equal cannot compare function items")
+	}
+	if IsNode(i2) || IsMap(i2) || IsArray(i2) {
+		return false, nil
+	}
+	return equalAtomic(i1, i2), nil
+}
+
+// deepEqualNode compares node names, attributes and children. comments are ignored.
+func deepEqualNode(n1, n2 *html.Node) bool {
+	if n1.Type != n2.Type {
+		return false
+	}
+
+	switch n1.Type {
+	case html.TextNode, html.CommentNode, html.DoctypeNode:
+		return n1.Data == n2.Data
+	case object.ProcessingInstructionNode:
+		return n1.Data == n2.Data && n1.Attr[0].Val == n2.Attr[0].Val
+	case html.ElementNode:
+		if n1.Data != n2.Data || n1.Namespace != n2.Namespace || len(n1.Attr) != len(n2.Attr) {
+			return false
+		}
+
+	outer:
+		for _, a1 := range n1.Attr {
+			for _, a2 := range n2.Attr {
+				if equalAttr(a1, a2) {
+					continue outer
+				}
+			}
+			return false
+		}
+	}
+
+	c1, c2 := deepEqualChildren(n1), deepEqualChildren(n2)
+	if len(c1) != len(c2) {
+		return false
+	}
+	for i := range c1 {
+		if !deepEqualNode(c1[i], c2[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func deepEqualChildren(n *html.Node) []*html.Node {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.CommentNode {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+func equalAttr(a1, a2 html.Attribute) bool {
+	return a1.Namespace == a2.Namespace && a1.Key == a2.Key && a1.Val == a2.Val
+}
+
+// equalAtomic compares two atomic values with eq.
+// values that are not comparable are not equal, and NaN is equal to NaN.
+func equalAtomic(a1, a2 object.Item) bool {
+	switch {
+	case IsNumeric(a1) && IsNumeric(a2):
+		if isNaN(a1) && isNaN(a2) {
+			return true
+		}
+	case IsString(a1) && IsString(a2):
+	case IsBoolean(a1) && IsBoolean(a2):
+	case IsDateTime(a1) && IsDateTime(a2) && a1.Type() == a2.Type():
+	case IsDuration(a1) && IsDuration(a2):
+	default:
+		return false
+	}
+
+	eq := IsEQ(a1, a2)
+	if IsError(eq) {
+		return false
+	}
+	return eq.(*object.Boolean).Value()
+}
+
+func isNaN(item object.Item) bool {
+	switch item := item.(type) {
+	case *object.Decimal:
+		return math.IsNaN(item.Value())
+	case *object.Double:
+		return math.IsNaN(item.Value())
+	}
+	return false
+}
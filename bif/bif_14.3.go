@@ -0,0 +1,45 @@
+package bif
+
+import "github.com/zzossig/rabbit/object"
+
+func fnZeroOrOne(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:zero-or-one")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:zero-or-one")
+	}
+
+	if n := len(UnwrapSeq(args[0])); n > 1 {
+		return NewCodedError("FORG0003", "fn:zero-or-one called with a sequence containing more than one item. got=%d", n)
+	}
+	return args[0]
+}
+
+func fnOneOrMore(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:one-or-more")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:one-or-more")
+	}
+
+	if len(UnwrapSeq(args[0])) == 0 {
+		return NewCodedError("FORG0004", "fn:one-or-more called with a sequence containing no items")
+	}
+	return args[0]
+}
+
+func fnExactlyOne(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:exactly-one")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:exactly-one")
+	}
+
+	if n := len(UnwrapSeq(args[0])); n != 1 {
+		return NewCodedError("FORG0005", "fn:exactly-one called with a sequence containing zero or more than one item. got=%d", n)
+	}
+	return args[0]
+}
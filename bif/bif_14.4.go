@@ -9,10 +9,10 @@ import (
 
 func fnCount(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:count")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:count")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:count")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:count")
 	}
 
 	if !IsSeq(args[0]) {
@@ -25,10 +25,10 @@ func fnCount(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnAvg(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:avg")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:avg")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:avg")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:avg")
 	}
 
 	if IsSeqEmpty(args[0]) {
@@ -36,9 +36,19 @@ func fnAvg(ctx *object.Context, args ...object.Item) object.Item {
 	}
 
 	sum := fnSum(nil, args[0])
+	if IsError(sum) {
+		return sum
+	}
 	cnt := fnCount(nil, args[0])
 	ty := sum.Type()
 
+	switch sum.(type) {
+	case *object.YearMonthDuration:
+		return opDivideYearMonthDuration(nil, sum, cnt)
+	case *object.DayTimeDuration:
+		return opDivideDayTimeDuration(nil, sum, cnt)
+	}
+
 	dsum := CastType(sum, object.DecimalType)
 	if IsError(dsum) {
 		return dsum
@@ -57,10 +67,10 @@ func fnAvg(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnMax(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:max")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:max")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:max")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:max")
 	}
 
 	if IsSeqEmpty(args[0]) {
@@ -70,10 +80,10 @@ func fnMax(ctx *object.Context, args ...object.Item) object.Item {
 		return NewSequence()
 	}
 	if !IsSeq(args[0]) && !IsArray(args[0]) {
-		if IsNumeric(args[0]) {
+		if IsNumeric(args[0]) || IsTemporal(args[0]) {
 			return args[0]
 		}
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	src := &object.Sequence{}
@@ -86,7 +96,7 @@ func fnMax(ctx *object.Context, args ...object.Item) object.Item {
 
 		for i, item := range src.Items {
 			if !IsNumeric(item) {
-				return NewError("unexpected argument type: %s", item.Type())
+				return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(item))
 			}
 
 			d := CastType(item, object.DecimalType)
@@ -104,12 +114,26 @@ func fnMax(ctx *object.Context, args ...object.Item) object.Item {
 
 		maxObj := NewDecimal(max)
 		return CastType(maxObj, ty)
+	case IsTemporal(src.Items[0]):
+		max := src.Items[0]
+
+		for _, item := range src.Items[1:] {
+			b := IsGT(item, max)
+			if IsError(b) {
+				return b
+			}
+			if b.(*object.Boolean).Value() {
+				max = item
+			}
+		}
+
+		return max
 	case IsString(src.Items[0]):
 		var max string
 
 		for i, item := range src.Items {
 			if !IsString(item) {
-				return NewError("unexpected argument type: %s", item.Type())
+				return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(item))
 			}
 
 			str := item.(*object.String)
@@ -126,7 +150,7 @@ func fnMax(ctx *object.Context, args ...object.Item) object.Item {
 	case IsBoolean(src.Items[0]):
 		for _, item := range src.Items {
 			if !IsBoolean(item) {
-				return NewError("unexpected argument type: %s", item.Type())
+				return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(item))
 			}
 
 			b := item.(*object.Boolean)
@@ -136,16 +160,16 @@ func fnMax(ctx *object.Context, args ...object.Item) object.Item {
 		}
 		return NewBoolean(false)
 	default:
-		return NewError("unexpected argument type: %s", src.Items[0].Type())
+		return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(src.Items[0]))
 	}
 }
 
 func fnMin(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:min")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:min")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:min")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:min")
 	}
 
 	if IsSeqEmpty(args[0]) {
@@ -155,10 +179,10 @@ func fnMin(ctx *object.Context, args ...object.Item) object.Item {
 		return NewSequence()
 	}
 	if !IsSeq(args[0]) && !IsArray(args[0]) {
-		if IsNumeric(args[0]) {
+		if IsNumeric(args[0]) || IsTemporal(args[0]) {
 			return args[0]
 		}
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	src := &object.Sequence{}
@@ -171,7 +195,7 @@ func fnMin(ctx *object.Context, args ...object.Item) object.Item {
 
 		for i, item := range src.Items {
 			if !IsNumeric(item) {
-				return NewError("unexpected argument type: %s", item.Type())
+				return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(item))
 			}
 
 			d := CastType(item, object.DecimalType)
@@ -189,12 +213,26 @@ func fnMin(ctx *object.Context, args ...object.Item) object.Item {
 
 		minObj := NewDecimal(min)
 		return CastType(minObj, ty)
+	case IsTemporal(src.Items[0]):
+		min := src.Items[0]
+
+		for _, item := range src.Items[1:] {
+			b := IsLT(item, min)
+			if IsError(b) {
+				return b
+			}
+			if b.(*object.Boolean).Value() {
+				min = item
+			}
+		}
+
+		return min
 	case IsString(src.Items[0]):
 		var min string
 
 		for i, item := range src.Items {
 			if !IsString(item) {
-				return NewError("unexpected argument type: %s", item.Type())
+				return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(item))
 			}
 
 			str := item.(*object.String)
@@ -211,7 +249,7 @@ func fnMin(ctx *object.Context, args ...object.Item) object.Item {
 	case IsBoolean(src.Items[0]):
 		for _, item := range src.Items {
 			if !IsBoolean(item) {
-				return NewError("unexpected argument type: %s", item.Type())
+				return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(item))
 			}
 
 			b := item.(*object.Boolean)
@@ -221,16 +259,16 @@ func fnMin(ctx *object.Context, args ...object.Item) object.Item {
 		}
 		return NewBoolean(true)
 	default:
-		return NewError("unexpected argument type: %s", src.Items[0].Type())
+		return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(src.Items[0]))
 	}
 }
 
 func fnSum(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:sum")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:sum")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:sum")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:sum")
 	}
 
 	if IsSeqEmpty(args[0]) {
@@ -240,18 +278,24 @@ func fnSum(ctx *object.Context, args ...object.Item) object.Item {
 		if IsNumeric(args[0]) {
 			return args[0]
 		}
-		return NewError("cannot match item type with required type")
+		if IsDuration(args[0]) {
+			return sumDurations([]object.Item{args[0]})
+		}
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	src := &object.Sequence{}
 	src.Items = UnwrapArr(args[0])
+	if len(src.Items) > 0 && IsDuration(src.Items[0]) {
+		return sumDurations(src.Items)
+	}
 
 	sum := 0.0
 	ty := object.IntegerType
 
 	for _, item := range src.Items {
 		if !IsNumeric(item) {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		if (ty == object.IntegerType || ty == object.DecimalType) && item.Type() == object.DoubleType {
 			ty = item.Type()
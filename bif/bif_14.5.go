@@ -0,0 +1,91 @@
+package bif
+
+import (
+	"strings"
+
+	"github.com/zzossig/rabbit/object"
+	"golang.org/x/net/html"
+)
+
+// fnID returns the elements whose id attribute is one of the ids in the argument strings, in document order.
+// The elements are looked up in the index of the context if it contains the node, otherwise the document is walked.
+func fnID(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:id")
+	}
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:id")
+	}
+
+	var node object.Node
+	if len(args) == 2 {
+		items := UnwrapSeq(args[1])
+		if len(items) != 1 {
+			return NewCodedError("XPTY0004", "fn:id: the second argument must be a single node")
+		}
+		n, ok := items[0].(object.Node)
+		if !ok {
+			return NewCodedError("XPTY0004", "fn:id: the second argument is not a node: %s", TypeName(items[0]))
+		}
+		node = n
+	} else {
+		if len(ctx.CNode) == 0 {
+			return NewCodedError("XPDY0002", "context node is undefined")
+		}
+		node = ctx.CNode[0]
+	}
+
+	ids := make(map[string]bool)
+	for _, item := range UnwrapSeq(args[0]) {
+		s, ok := item.(*object.String)
+		if !ok {
+			return NewCodedError("XPTY0004", "fn:id: the argument is not a string: %s", TypeName(item))
+		}
+		for _, id := range strings.Fields(s.Value()) {
+			ids[id] = true
+		}
+	}
+
+	root := object.TreeRoot(node)
+	if root.Type != html.DocumentNode {
+		return NewCodedError("FODC0001", "fn:id: the root of the tree is not a document node")
+	}
+
+	var nodes []object.Node
+	if ctx.Index.Contains(node) {
+		for id := range ids {
+			if n := ctx.Index.ID(id); n != nil {
+				nodes = append(nodes, n)
+			}
+		}
+		nodes = SortNodes(nodes, ctx)
+	} else {
+		nodes = elementsWithID(root, ids, nodes)
+	}
+
+	seq := &object.Sequence{}
+	for _, n := range nodes {
+		seq.Items = append(seq.Items, n)
+	}
+	return seq
+}
+
+// elementsWithID appends the first element for each of the ids in the tree n to nodes,
+// the found ids are removed from ids
+func elementsWithID(n *html.Node, ids map[string]bool, nodes []object.Node) []object.Node {
+	if n.Type == html.ElementNode {
+		for _, a := range n.Attr {
+			if a.Key == "id" && a.Namespace == "" && ids[a.Val] {
+				delete(ids, a.Val)
+				bn := &object.BaseNode{}
+				bn.SetTree(n)
+				nodes = append(nodes, bn)
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil && len(ids) > 0; c = c.NextSibling {
+		nodes = elementsWithID(c, ids, nodes)
+	}
+	return nodes
+}
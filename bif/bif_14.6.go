@@ -2,64 +2,321 @@ package bif
 
 import (
 	"bufio"
-	"net/http"
-	"os"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"path/filepath"
+	"strings"
 
+	"github.com/zzossig/rabbit/loader"
 	"github.com/zzossig/rabbit/object"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
 )
 
+// defaultLoader loads the documents if the context has no loader
+var defaultLoader = loader.New()
+
 func fnDoc(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:doc")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:doc")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:doc")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:doc")
+	}
+
+	if IsSeqEmpty(args[0]) {
+		return NewSequence()
+	}
+	uri, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	doc, e := Doc(ctx, uri)
+	if e != nil {
+		return e
 	}
+	return doc
+}
 
-	uri, ok := args[0].(*object.String)
-	if !ok {
-		return NewError("cannot match item type with required type")
+// Doc returns the document node of the uri
+// The document is loaded once in an evaluation, the following calls with the same uri return the same node
+func Doc(ctx *object.Context, uri string) (object.Node, *object.Error) {
+	if doc, ok := ctx.Document(uri); ok {
+		return doc, nil
 	}
 
-	docNode := &object.BaseNode{}
+	tree, e := LoadDoc(ctx, uri)
+	if e != nil {
+		return nil, e
+	}
 
-	if file, err := os.Open(uri.Value()); err == nil {
-		defer file.Close()
+	doc := &object.BaseNode{}
+	doc.SetTree(tree)
+	ctx.AddDocument(uri, doc)
+	return doc, nil
+}
 
-		buf := bufio.NewReader(file)
-		parsedHTML, err := html.Parse(buf)
-		if err != nil {
-			return NewError(err.Error())
+// SetDoc makes the document of the uri the context document
+func SetDoc(ctx *object.Context, uri string) *object.Error {
+	doc, e := Doc(ctx, uri)
+	if e != nil {
+		return e
+	}
+
+	ctx.Doc = doc
+	ctx.CNode = []object.Node{ctx.Doc}
+	ctx.BaseURI = uri
+	if ctx.DocumentLoader() == nil && !loader.IsHTTP(uri) {
+		if path, err := filepath.Abs(uri); err == nil {
+			ctx.BaseURI = path
 		}
-		parsedHTML.Type = html.DocumentNode
+	}
+	return nil
+}
 
-		docNode.SetTree(parsedHTML)
-		ctx.Doc = docNode
-		ctx.CNode = []object.Node{ctx.Doc}
+func fnDocAvailable(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:doc-available")
+	}
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:doc-available")
+	}
 
-		path, err := os.Getwd()
-		if err != nil {
-			return NewError(err.Error())
+	if IsSeqEmpty(args[0]) {
+		return NewBoolean(false)
+	}
+	uri, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	if _, e := Doc(ctx, uri); e != nil {
+		if e.Stops() {
+			return e
+		}
+		return NewBoolean(false)
+	}
+	return NewBoolean(true)
+}
+
+// LoadDoc loads the document at uri with the loader of the context and parses it
+func LoadDoc(ctx *object.Context, uri string) (*html.Node, *object.Error) {
+	r, e := openResource(ctx, uri)
+	if e != nil {
+		return nil, e
+	}
+	defer r.Close()
+
+	return ParseDoc(ctx, r, loader.ContentType(r))
+}
+
+// ParseDoc parses a html document, or a xml document if the context is in the xml mode
+// The error is raised with the code FODC0002
+// The document is decoded to utf-8 from the encoding label of the context,
+// or from the encoding detected with the byte order mark, the charset of contentType and the meta elements or the xml declaration
+func ParseDoc(ctx *object.Context, r io.Reader, contentType string) (*html.Node, *object.Error) {
+	var doc *html.Node
+	var name string
+	var err error
+	if ctx.XMLMode() {
+		doc, name, err = parseXML(r, contentType, ctx.EncodingLabel())
+	} else {
+		doc, name, err = parseHTML(r, contentType, ctx.EncodingLabel())
+	}
+	if err != nil {
+		if e := ctx.Guard.Check(); e != nil {
+			return nil, e
 		}
-		ctx.BaseURI = filepath.Join(path, uri.Value())
+		e := NewCodedError("FODC0002", "cannot parse document: %s", err.Error())
+		e.Err = err
+		return nil, e
 	}
 
-	if resp, err := http.Get(uri.Value()); err == nil {
-		defer resp.Body.Close()
+	ctx.SetDocumentEncoding(doc, name)
+	return doc, nil
+}
+
+// parseHTML parses a html document and returns the name of its encoding
+func parseHTML(r io.Reader, contentType, label string) (*html.Node, string, error) {
+	br := bufio.NewReader(r)
+
+	var e encoding.Encoding
+	var name string
+	if label != "" {
+		e, name = charset.Lookup(label)
+		if e == nil {
+			return nil, "", fmt.Errorf("unknown encoding: %s", label)
+		}
+	} else {
+		// the meta elements are looked for in the first 1024 bytes as in the html specification
+		head, _ := br.Peek(1024)
+		e, name, _ = charset.DetermineEncoding(head, contentType)
+	}
 
-		buf := bufio.NewReader(resp.Body)
-		parsedHTML, err := html.Parse(buf)
+	doc, err := html.Parse(transform.NewReader(br, unicode.BOMOverride(e.NewDecoder())))
+	if err != nil {
+		return nil, "", err
+	}
+	doc.Type = html.DocumentNode
+	return doc, name, nil
+}
+
+// parseXML parses a xml document into a tree of html nodes as described in the object package and returns the name of its encoding
+// The encoding is the label or the charset of contentType, otherwise it is detected from the byte order mark and the xml declaration
+// A CDATA section is text, the adjacent text is merged into one text node
+func parseXML(r io.Reader, contentType, label string) (*html.Node, string, error) {
+	br := bufio.NewReader(r)
+
+	if label == "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			label = params["charset"]
+		}
+	}
+
+	// decoded is set if the reader is decoded to utf-8 before the xml declaration is read
+	var src io.Reader = br
+	var decoded bool
+	name := "utf-8"
+	if label != "" {
+		e, n := charset.Lookup(label)
+		if e == nil {
+			return nil, "", fmt.Errorf("unknown encoding: %s", label)
+		}
+		src, name, decoded = transform.NewReader(br, unicode.BOMOverride(e.NewDecoder())), n, true
+	} else if bom, _ := br.Peek(2); len(bom) == 2 && (bom[0] == 0xFE && bom[1] == 0xFF || bom[0] == 0xFF && bom[1] == 0xFE) {
+		name = "utf-16be"
+		if bom[0] == 0xFF {
+			name = "utf-16le"
+		}
+		src, decoded = transform.NewReader(br, unicode.BOMOverride(encoding.Nop.NewDecoder())), true
+	} else if bom, _ := br.Peek(3); string(bom) == "\xEF\xBB\xBF" {
+		br.Discard(3)
+	}
+
+	d := xml.NewDecoder(src)
+	d.CharsetReader = func(l string, input io.Reader) (io.Reader, error) {
+		if decoded {
+			return input, nil
+		}
+		e, n := charset.Lookup(l)
+		if e == nil {
+			return nil, fmt.Errorf("unknown encoding: %s", l)
+		}
+		name = n
+		return transform.NewReader(input, e.NewDecoder()), nil
+	}
+
+	doc := &html.Node{Type: html.DocumentNode, Data: object.XMLDocument}
+	cur := doc
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return NewError(err.Error())
+			return nil, "", err
 		}
-		parsedHTML.Type = html.DocumentNode
 
-		docNode.SetTree(parsedHTML)
-		ctx.Doc = docNode
-		ctx.CNode = []object.Node{ctx.Doc}
-		ctx.BaseURI = uri.Value()
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &html.Node{Type: html.ElementNode, Data: tok.Name.Local, Namespace: tok.Name.Space}
+			for _, a := range tok.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					n.Attr = append(n.Attr, html.Attribute{Namespace: object.XMLNSNamespace, Key: a.Name.Local, Val: a.Value})
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					n.Attr = append(n.Attr, html.Attribute{Namespace: object.XMLNSNamespace, Val: a.Value})
+				default:
+					n.Attr = append(n.Attr, html.Attribute{Namespace: a.Name.Space, Key: a.Name.Local, Val: a.Value})
+				}
+			}
+			if cur == doc && hasElement(doc) {
+				return nil, "", errors.New("a xml document has only one root element")
+			}
+			cur.AppendChild(n)
+			cur = n
+		case xml.EndElement:
+			cur = cur.Parent
+		case xml.CharData:
+			if cur == doc {
+				if strings.TrimSpace(string(tok)) != "" {
+					return nil, "", errors.New("text is not allowed outside the root element")
+				}
+				continue
+			}
+			if c := cur.LastChild; c != nil && c.Type == html.TextNode {
+				c.Data += string(tok)
+			} else {
+				cur.AppendChild(&html.Node{Type: html.TextNode, Data: string(tok)})
+			}
+		case xml.Comment:
+			cur.AppendChild(&html.Node{Type: html.CommentNode, Data: string(tok)})
+		case xml.ProcInst:
+			// the xml declaration is not a processing instruction
+			if tok.Target != "xml" {
+				cur.AppendChild(object.NewProcessingInstruction(tok.Target, string(tok.Inst)))
+			}
+		}
 	}
-	return nil
+
+	if !hasElement(doc) {
+		return nil, "", errors.New("a xml document must have a root element")
+	}
+	return doc, name, nil
+}
+
+func hasElement(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return true
+		}
+	}
+	return false
+}
+
+// openResource opens the resource at uri with the loader of the context or the default loader
+// A failed load raises the code FODC0002, or the canceled error if the evaluation is canceled
+func openResource(ctx *object.Context, uri string) (io.ReadCloser, *object.Error) {
+	l := ctx.DocumentLoader()
+	if l == nil {
+		l = defaultLoader
+	}
+
+	r, err := l.Load(ctx.Guard.Context(), uri)
+	if err != nil {
+		if e := ctx.Guard.Check(); e != nil {
+			return nil, e
+		}
+		e := NewCodedError("FODC0002", "cannot retrieve resource: %s: %s", uri, err.Error())
+		e.Err = err
+		return nil, e
+	}
+	return r, nil
+}
+
+// readResource reads the resource at uri with the loader of the context
+func readResource(ctx *object.Context, uri string) (string, *object.Error) {
+	r, e := openResource(ctx, uri)
+	if e != nil {
+		return "", e
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(bufio.NewReader(r))
+	if err != nil {
+		if e := ctx.Guard.Check(); e != nil {
+			return "", e
+		}
+		e := NewCodedError("FODC0002", "cannot read resource: %s: %s", uri, err.Error())
+		e.Err = err
+		return "", e
+	}
+	return strings.TrimPrefix(string(b), "\uFEFF"), nil
 }
@@ -0,0 +1,563 @@
+package bif
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zzossig/rabbit/object"
+	"golang.org/x/net/html"
+)
+
+// serializeParams is the serialization parameters map of fn:serialize
+type serializeParams struct {
+	method        string
+	indent        bool
+	omitXMLDecl   bool
+	itemSeparator *string
+}
+
+var serializeMethods = []string{"xml", "html", "text", "json", "adaptive"}
+
+func fnSerialize(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:serialize")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:serialize")
+	}
+
+	params, e := serializeParamsArg(args[1:])
+	if e != nil {
+		return e
+	}
+
+	var sb strings.Builder
+	switch params.method {
+	case "json":
+		items := UnwrapSeq(args[0])
+		if len(items) > 1 {
+			return NewCodedError("SERE0023", "the json output method cannot serialize a sequence of more than one item")
+		}
+		var item object.Item = NewSequence()
+		if len(items) == 1 {
+			item = items[0]
+		}
+		e = writeJSON(&sb, item, params.indent, 0)
+	case "adaptive":
+		e = writeAdaptive(&sb, args[0], params)
+	default:
+		e = writeNormalized(&sb, args[0], params)
+	}
+	if e != nil {
+		return e
+	}
+
+	return NewString(sb.String())
+}
+
+func serializeParamsArg(args []object.Item) (*serializeParams, *object.Error) {
+	params := &serializeParams{method: "xml", omitXMLDecl: true}
+	if len(args) == 0 || IsSeqEmpty(args[0]) {
+		return params, nil
+	}
+
+	m, ok := args[0].(*object.Map)
+	if !ok {
+		return nil, NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=map(*)", TypeName(args[0]))
+	}
+
+	for _, pair := range m.Pairs {
+		key, ok := pair.Key.(*object.String)
+		if !ok {
+			continue
+		}
+
+		value := pair.Value
+		if items := UnwrapSeq(value); len(items) == 1 {
+			value = items[0]
+		}
+
+		switch key.Value() {
+		case "method":
+			s, ok := value.(*object.String)
+			if !ok || !contains(serializeMethods, s.Value()) {
+				return nil, NewCodedError("SEPM0017", "invalid serialization parameter method: %s", value.Inspect())
+			}
+			params.method = s.Value()
+		case "indent", "omit-xml-declaration":
+			b, ok := value.(*object.Boolean)
+			if !ok {
+				return nil, NewCodedError("SEPM0017", "invalid serialization parameter %s: %s", key.Value(), value.Inspect())
+			}
+			if key.Value() == "indent" {
+				params.indent = b.Value()
+			} else {
+				params.omitXMLDecl = b.Value()
+			}
+		case "item-separator":
+			s, ok := value.(*object.String)
+			if !ok {
+				return nil, NewCodedError("SEPM0017", "invalid serialization parameter item-separator: %s", value.Inspect())
+			}
+			sep := s.Value()
+			params.itemSeparator = &sep
+		}
+	}
+	return params, nil
+}
+
+// writeNormalized serializes the normalized sequence with the xml, html or text output method.
+// Arrays are flattened, and adjacent atomic values are separated by a single space
+// unless item-separator is given.
+func writeNormalized(sb *strings.Builder, arg object.Item, params *serializeParams) *object.Error {
+	items, e := normalizeItems(nil, arg)
+	if e != nil {
+		return e
+	}
+
+	if params.method == "xml" && !params.omitXMLDecl {
+		sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+		if params.indent {
+			sb.WriteString("\n")
+		}
+	}
+
+	for i, item := range items {
+		if i > 0 {
+			switch {
+			case params.itemSeparator != nil:
+				sb.WriteString(*params.itemSeparator)
+			case !IsNode(items[i-1]) && !IsNode(item):
+				sb.WriteString(" ")
+			}
+		}
+
+		n, ok := item.(*object.BaseNode)
+		if !ok {
+			s := CastType(item, object.StringType)
+			if IsError(s) {
+				return s.(*object.Error)
+			}
+			if params.method == "text" {
+				sb.WriteString(s.(*object.String).Value())
+			} else {
+				sb.WriteString(html.EscapeString(s.(*object.String).Value()))
+			}
+			continue
+		}
+
+		switch params.method {
+		case "xml":
+			writeXML(sb, n.Tree(), params.indent, 0)
+		case "html":
+			if e := writeHTML(sb, n.Tree(), params.indent, 0); e != nil {
+				return e
+			}
+		case "text":
+			if n.Tree().Type == html.TextNode {
+				sb.WriteString(n.Tree().Data)
+			} else if n.Tree().Type != html.CommentNode && n.Tree().Type != html.DoctypeNode {
+				sb.WriteString(textContent(n.Tree()))
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeItems(items []object.Item, arg object.Item) ([]object.Item, *object.Error) {
+	switch arg := arg.(type) {
+	case *object.Sequence:
+		for _, item := range arg.Items {
+			var e *object.Error
+			if items, e = normalizeItems(items, item); e != nil {
+				return nil, e
+			}
+		}
+	case *object.Array:
+		for _, item := range arg.Items {
+			var e *object.Error
+			if items, e = normalizeItems(items, item); e != nil {
+				return nil, e
+			}
+		}
+	case *object.Map, *object.FuncNamed, *object.FuncInline, *object.FuncPartial:
+		return nil, NewCodedError("SENR0001", "%s item cannot be serialized", TypeName(arg))
+	case *object.AttrNode:
+		return nil, NewCodedError("SENR0001", "an attribute node cannot be serialized: %s", arg.Key())
+	default:
+		items = append(items, arg)
+	}
+	return items, nil
+}
+
+// writeXML writes a node using the xml output method.
+// The content of an element is indented only if it has no text other than whitespace.
+func writeXML(sb *strings.Builder, n *html.Node, indent bool, depth int) {
+	switch n.Type {
+	case html.DocumentNode:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if indent && c != n.FirstChild {
+				sb.WriteString("\n")
+			}
+			writeXML(sb, c, indent, depth)
+		}
+	case html.TextNode:
+		sb.WriteString(escapeXML(n.Data, false))
+	case html.CommentNode:
+		sb.WriteString("<!--" + n.Data + "-->")
+	case html.DoctypeNode:
+		sb.WriteString("<!DOCTYPE " + n.Data + ">")
+	case object.ProcessingInstructionNode:
+		sb.WriteString("<?" + n.Data)
+		if n.Attr[0].Val != "" {
+			sb.WriteString(" " + n.Attr[0].Val)
+		}
+		sb.WriteString("?>")
+	case html.ElementNode:
+		name := qualifiedName(n, n.Namespace, n.Data, n.Data)
+		sb.WriteString("<" + name)
+		if n.Namespace == fnNS && (n.Parent == nil || n.Parent.Namespace != fnNS) {
+			sb.WriteString(` xmlns="` + fnNS + `"`)
+		}
+		for _, a := range n.Attr {
+			sb.WriteString(" " + xmlAttrName(n, a) + `="` + escapeXML(a.Val, true) + `"`)
+		}
+		if n.FirstChild == nil {
+			sb.WriteString("/>")
+			return
+		}
+		sb.WriteString(">")
+
+		elemOnly := indent && elementOnly(n)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if elemOnly {
+				if c.Type == html.TextNode {
+					continue
+				}
+				newline(sb, true, depth+1)
+			}
+			writeXML(sb, c, indent, depth+1)
+		}
+		if elemOnly {
+			newline(sb, true, depth)
+		}
+		sb.WriteString("</" + name + ">")
+	}
+}
+
+// writeHTML writes a node using the html output method, the node is rendered by html.Render.
+// With indent, the start and end tags of an element that has no text other than whitespace are written on their own lines.
+func writeHTML(sb *strings.Builder, n *html.Node, indent bool, depth int) *object.Error {
+	if !indent || (n.Type != html.DocumentNode && (n.Type != html.ElementNode || !elementOnly(n))) {
+		if err := html.Render(sb, n); err != nil {
+			return NewCodedError("SERE0001", err.Error())
+		}
+		return nil
+	}
+
+	if n.Type == html.ElementNode {
+		sb.WriteString("<" + n.Data)
+		for _, a := range n.Attr {
+			sb.WriteString(" " + attrName(a) + `="` + html.EscapeString(a.Val) + `"`)
+		}
+		sb.WriteString(">")
+	}
+
+	first := true
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			continue
+		}
+		if n.Type == html.ElementNode || !first {
+			newline(sb, true, depth+1)
+		}
+		first = false
+
+		childDepth := depth + 1
+		if n.Type == html.DocumentNode {
+			childDepth = depth
+		}
+		if e := writeHTML(sb, c, indent, childDepth); e != nil {
+			return e
+		}
+	}
+
+	if n.Type == html.ElementNode {
+		newline(sb, true, depth)
+		sb.WriteString("</" + n.Data + ">")
+	}
+	return nil
+}
+
+// elementOnly reports whether n has child elements and no text other than whitespace
+func elementOnly(n *html.Node) bool {
+	switch n.Data {
+	case "pre", "script", "style", "textarea":
+		return false
+	}
+
+	hasElem := false
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.ElementNode:
+			hasElem = true
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return false
+			}
+		}
+	}
+	return hasElem
+}
+
+// xmlAttrName returns the name of the attribute of the element n in the xml output
+// The namespace declarations of a xml document are written as xmlns attributes
+func xmlAttrName(n *html.Node, a html.Attribute) string {
+	if a.Namespace == object.XMLNSNamespace {
+		if a.Key == "" {
+			return "xmlns"
+		}
+		return "xmlns:" + a.Key
+	}
+	return qualifiedName(n, a.Namespace, a.Key, attrName(a))
+}
+
+func attrName(a html.Attribute) string {
+	if a.Namespace != "" {
+		return a.Namespace + ":" + a.Key
+	}
+	return a.Key
+}
+
+func escapeXML(s string, attr bool) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			sb.WriteString("&amp;")
+		case r == '<':
+			sb.WriteString("&lt;")
+		case r == '>':
+			sb.WriteString("&gt;")
+		case r == '"' && attr:
+			sb.WriteString("&quot;")
+		case (r == '\n' || r == '\t' || r == '\r') && attr:
+			sb.WriteString("&#x" + strconv.FormatInt(int64(r), 16) + ";")
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// writeJSON writes an item using the json output method
+func writeJSON(sb *strings.Builder, item object.Item, indent bool, depth int) *object.Error {
+	switch item := item.(type) {
+	case *object.Sequence:
+		switch len(item.Items) {
+		case 0:
+			sb.WriteString("null")
+		case 1:
+			return writeJSON(sb, item.Items[0], indent, depth)
+		default:
+			return NewCodedError("SERE0023", "the json output method cannot serialize a sequence of more than one item")
+		}
+	case *object.Map:
+		keys, pairs, e := sortedPairs(item)
+		if e != nil {
+			return e
+		}
+
+		sb.WriteString("{")
+		for i, k := range keys {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			newline(sb, indent, depth+1)
+			sb.WriteString(jsonString(k, false))
+			sb.WriteString(":")
+			if indent {
+				sb.WriteString(" ")
+			}
+			if e := writeJSON(sb, pairs[i].Value, indent, depth+1); e != nil {
+				return e
+			}
+		}
+		if len(keys) > 0 {
+			newline(sb, indent, depth)
+		}
+		sb.WriteString("}")
+	case *object.Array:
+		sb.WriteString("[")
+		for i, m := range item.Items {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			newline(sb, indent, depth+1)
+			if e := writeJSON(sb, m, indent, depth+1); e != nil {
+				return e
+			}
+		}
+		if len(item.Items) > 0 {
+			newline(sb, indent, depth)
+		}
+		sb.WriteString("]")
+	case *object.Boolean:
+		sb.WriteString(strconv.FormatBool(item.Value()))
+	case *object.Integer:
+		sb.WriteString(strconv.Itoa(item.Value()))
+	case *object.Decimal:
+		sb.WriteString(strconv.FormatFloat(item.Value(), 'f', -1, 64))
+	case *object.Double:
+		if math.IsNaN(item.Value()) || math.IsInf(item.Value(), 0) {
+			return NewCodedError("SERE0023", "the json output method cannot serialize %s", item.Inspect())
+		}
+		sb.WriteString(jsonNumber(item.Value()))
+	case *object.BaseNode:
+		var node strings.Builder
+		writeXML(&node, item.Tree(), false, 0)
+		sb.WriteString(jsonString(node.String(), false))
+	case *object.AttrNode:
+		return NewCodedError("SENR0001", "an attribute node cannot be serialized: %s", item.Key())
+	case *object.FuncNamed, *object.FuncInline, *object.FuncPartial:
+		return NewCodedError("SENR0001", "%s item cannot be serialized", TypeName(item))
+	default:
+		s := CastType(item, object.StringType)
+		if IsError(s) {
+			return s.(*object.Error)
+		}
+		sb.WriteString(jsonString(s.(*object.String).Value(), false))
+	}
+	return nil
+}
+
+// sortedPairs returns the keys of a map as strings in sorted order, so that the output is stable
+func sortedPairs(m *object.Map) ([]string, []object.Pair, *object.Error) {
+	var keys []string
+	byKey := make(map[string]object.Pair)
+	for _, pair := range m.Pairs {
+		s := CastType(pair.Key, object.StringType)
+		if IsError(s) {
+			return nil, nil, s.(*object.Error)
+		}
+		k := s.(*object.String).Value()
+		if _, ok := byKey[k]; ok {
+			return nil, nil, NewCodedError("SERE0022", "duplicate key in the serialized map: %s", k)
+		}
+		byKey[k] = pair
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]object.Pair, len(keys))
+	for i, k := range keys {
+		pairs[i] = byKey[k]
+	}
+	return keys, pairs, nil
+}
+
+// writeAdaptive writes items using the adaptive output method, items are separated by a newline by default
+func writeAdaptive(sb *strings.Builder, arg object.Item, params *serializeParams) *object.Error {
+	sep := "\n"
+	if params.itemSeparator != nil {
+		sep = *params.itemSeparator
+	}
+
+	for i, item := range UnwrapSeq(arg) {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+		if e := writeAdaptiveItem(sb, item); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func writeAdaptiveItem(sb *strings.Builder, item object.Item) *object.Error {
+	switch item := item.(type) {
+	case *object.Sequence:
+		sb.WriteString("(")
+		for i, m := range item.Items {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			if e := writeAdaptiveItem(sb, m); e != nil {
+				return e
+			}
+		}
+		sb.WriteString(")")
+	case *object.Map:
+		keys, pairs, e := sortedPairs(item)
+		if e != nil {
+			return e
+		}
+
+		sb.WriteString("map{")
+		for i := range keys {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			if e := writeAdaptiveItem(sb, pairs[i].Key); e != nil {
+				return e
+			}
+			sb.WriteString(":")
+			if e := writeAdaptiveItem(sb, pairs[i].Value); e != nil {
+				return e
+			}
+		}
+		sb.WriteString("}")
+	case *object.Array:
+		sb.WriteString("[")
+		for i, m := range item.Items {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			if e := writeAdaptiveItem(sb, m); e != nil {
+				return e
+			}
+		}
+		sb.WriteString("]")
+	case *object.String:
+		sb.WriteString(`"` + strings.ReplaceAll(item.Value(), `"`, `""`) + `"`)
+	case *object.Boolean:
+		sb.WriteString(strconv.FormatBool(item.Value()) + "()")
+	case *object.Integer, *object.Decimal:
+		sb.WriteString(CastType(item, object.StringType).(*object.String).Value())
+	case *object.Double:
+		sb.WriteString(adaptiveDouble(item.Value()))
+	case *object.BaseNode:
+		writeXML(sb, item.Tree(), false, 0)
+	case *object.AttrNode:
+		sb.WriteString(attrName(item.Attr()) + `="` + escapeXML(item.Text(), true) + `"`)
+	case *object.FuncNamed:
+		sb.WriteString(item.Inspect())
+	case *object.FuncInline, *object.FuncPartial:
+		sb.WriteString("(anonymous-function)#" + strconv.Itoa(FuncArity(item)))
+	default:
+		s := CastType(item, object.StringType)
+		if IsError(s) {
+			return s.(*object.Error)
+		}
+		sb.WriteString(string(item.Type()) + `("` + s.(*object.String).Value() + `")`)
+	}
+	return nil
+}
+
+// adaptiveDouble formats a double like fn:format-number($d, '0.0##########################e0')
+func adaptiveDouble(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "INF"
+	case math.IsInf(v, -1):
+		return "-INF"
+	}
+
+	df := object.NewDecimalFormat()
+	pics, _ := parsePicture("0.0##########################e0", df)
+	return formatNumber(v, pics, df)
+}
@@ -1,6 +1,10 @@
 package bif
 
-import "github.com/zzossig/rabbit/object"
+import (
+	"time"
+
+	"github.com/zzossig/rabbit/object"
+)
 
 func fnPosition(ctx *object.Context, args ...object.Item) object.Item {
 	return NewInteger(ctx.CPos)
@@ -9,3 +13,33 @@ func fnPosition(ctx *object.Context, args ...object.Item) object.Item {
 func fnLast(ctx *object.Context, args ...object.Item) object.Item {
 	return NewInteger(ctx.CSize)
 }
+
+func fnCurrentDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return NewDateTime(currentDateTime(ctx), true)
+}
+
+func fnCurrentDate(ctx *object.Context, args ...object.Item) object.Item {
+	return NewDate(currentDateTime(ctx), true)
+}
+
+func fnCurrentTime(ctx *object.Context, args ...object.Item) object.Item {
+	return NewTime(currentDateTime(ctx), true)
+}
+
+func fnImplicitTimezone(ctx *object.Context, args ...object.Item) object.Item {
+	_, offset := currentDateTime(ctx).Zone()
+	return NewDayTimeDuration(time.Duration(offset) * time.Second)
+}
+
+// currentDateTime returns the current dateTime of the context with a fixed timezone
+func currentDateTime(ctx *object.Context) time.Time {
+	var now time.Time
+	if ctx != nil {
+		now = ctx.CurrentDateTime()
+	} else {
+		now = time.Now()
+	}
+
+	_, offset := now.Zone()
+	return now.In(time.FixedZone("", offset))
+}
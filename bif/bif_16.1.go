@@ -0,0 +1,281 @@
+package bif
+
+import (
+	"strings"
+
+	"github.com/zzossig/rabbit/ast"
+	"github.com/zzossig/rabbit/object"
+)
+
+// funcPrefixes maps the namespace uri of the built-in functions to the prefix used in F
+var funcPrefixes = map[string]string{
+	"http://www.w3.org/2005/xpath-functions":       "fn",
+	"http://www.w3.org/2005/xpath-functions/math":  "math",
+	"http://www.w3.org/2005/xpath-functions/map":   "map",
+	"http://www.w3.org/2005/xpath-functions/array": "array",
+	"http://www.w3.org/2001/XMLSchema":             "xs",
+}
+
+// arities lists the numbers of arguments that the built-in functions accept, -1 means any greater number
+var arities = map[string][]int{
+	"fn:node-name":                   {0},
+	"fn:string":                      {0, 1},
+	"fn:data":                        {0, 1},
+	"fn:base-uri":                    {0, 1},
+	"fn:error":                       {0, 1, 2, 3},
+	"fn:trace":                       {1, 2},
+	"fn:abs":                         {1},
+	"fn:ceiling":                     {1},
+	"fn:floor":                       {1},
+	"fn:round":                       {1},
+	"fn:round-half-to-even":          {1},
+	"fn:number":                      {0, 1},
+	"fn:format-integer":              {2, 3},
+	"fn:format-number":               {2, 3},
+	"math:pi":                        {0},
+	"math:exp":                       {1},
+	"math:exp2":                      {1},
+	"math:log":                       {1},
+	"math:log2":                      {1},
+	"math:log10":                     {1},
+	"math:pow":                       {2},
+	"math:sqrt":                      {1},
+	"math:sin":                       {1},
+	"math:cos":                       {1},
+	"math:tan":                       {1},
+	"math:asin":                      {1},
+	"math:acos":                      {1},
+	"math:atan":                      {1},
+	"math:atan2":                     {2},
+	"fn:codepoints-to-string":        {1},
+	"fn:string-to-codepoints":        {1},
+	"fn:concat":                      {2, -1},
+	"fn:string-join":                 {1, 2},
+	"fn:substring":                   {2, 3},
+	"fn:string-length":               {0, 1},
+	"fn:normalize-space":             {0, 1},
+	"fn:upper-case":                  {1},
+	"fn:lower-case":                  {1},
+	"fn:contains":                    {2, 3},
+	"fn:starts-with":                 {2, 3},
+	"fn:ends-with":                   {2, 3},
+	"fn:substring-before":            {2, 3},
+	"fn:substring-after":             {2, 3},
+	"fn:matches":                     {2, 3},
+	"fn:replace":                     {3, 4},
+	"fn:tokenize":                    {1, 2, 3},
+	"fn:analyze-string":              {2, 3},
+	"fn:true":                        {0},
+	"fn:false":                       {0},
+	"fn:boolean":                     {1},
+	"fn:not":                         {1},
+	"fn:years-from-duration":         {1},
+	"fn:months-from-duration":        {1},
+	"fn:days-from-duration":          {1},
+	"fn:hours-from-duration":         {1},
+	"fn:minutes-from-duration":       {1},
+	"fn:seconds-from-duration":       {1},
+	"fn:dateTime":                    {2},
+	"fn:year-from-dateTime":          {1},
+	"fn:month-from-dateTime":         {1},
+	"fn:day-from-dateTime":           {1},
+	"fn:hours-from-dateTime":         {1},
+	"fn:minutes-from-dateTime":       {1},
+	"fn:seconds-from-dateTime":       {1},
+	"fn:timezone-from-dateTime":      {1},
+	"fn:year-from-date":              {1},
+	"fn:month-from-date":             {1},
+	"fn:day-from-date":               {1},
+	"fn:timezone-from-date":          {1},
+	"fn:hours-from-time":             {1},
+	"fn:minutes-from-time":           {1},
+	"fn:seconds-from-time":           {1},
+	"fn:timezone-from-time":          {1},
+	"fn:adjust-dateTime-to-timezone": {1, 2},
+	"fn:adjust-date-to-timezone":     {1, 2},
+	"fn:adjust-time-to-timezone":     {1, 2},
+	"fn:format-dateTime":             {2, 5},
+	"fn:format-date":                 {2, 5},
+	"fn:format-time":                 {2, 5},
+	"fn:name":                        {0, 1},
+	"fn:local-name":                  {0, 1},
+	"fn:namespace-uri":               {0, 1},
+	"fn:empty":                       {1},
+	"fn:exists":                      {1},
+	"fn:head":                        {1},
+	"fn:tail":                        {1},
+	"fn:insert-before":               {3},
+	"fn:remove":                      {2},
+	"fn:reverse":                     {1},
+	"fn:subsequence":                 {2, 3},
+	"fn:unordered":                   {1},
+	"fn:distinct-values":             {1, 2},
+	"fn:index-of":                    {2, 3},
+	"fn:deep-equal":                  {2, 3},
+	"fn:zero-or-one":                 {1},
+	"fn:one-or-more":                 {1},
+	"fn:exactly-one":                 {1},
+	"fn:count":                       {1},
+	"fn:avg":                         {1},
+	"fn:max":                         {1},
+	"fn:min":                         {1},
+	"fn:sum":                         {1},
+	"fn:id":                          {1, 2},
+	"fn:doc":                         {1},
+	"fn:doc-available":               {1},
+	"fn:serialize":                   {1, 2},
+	"fn:position":                    {0},
+	"fn:last":                        {0},
+	"fn:current-dateTime":            {0},
+	"fn:current-date":                {0},
+	"fn:current-time":                {0},
+	"fn:implicit-timezone":           {0},
+	"fn:function-name":               {1},
+	"fn:function-arity":              {1},
+	"fn:for-each":                    {2},
+	"fn:for-each-pair":               {3},
+	"fn:filter":                      {2},
+	"fn:fold-left":                   {3},
+	"fn:fold-right":                  {3},
+	"fn:sort":                        {1, 2, 3},
+	"fn:apply":                       {2},
+	"map:size":                       {1},
+	"map:keys":                       {1},
+	"map:contains":                   {2},
+	"map:get":                        {2},
+	"map:put":                        {3},
+	"map:entry":                      {2},
+	"map:remove":                     {2},
+	"map:merge":                      {1, 2},
+	"map:for-each":                   {2},
+	"array:size":                     {1},
+	"array:get":                      {2},
+	"array:put":                      {3},
+	"array:append":                   {2},
+	"array:subarray":                 {2, 3},
+	"array:remove":                   {2},
+	"array:insert-before":            {3},
+	"array:head":                     {1},
+	"array:tail":                     {1},
+	"array:reverse":                  {1},
+	"array:join":                     {1},
+	"array:for-each":                 {2},
+	"array:filter":                   {2},
+	"array:for-each-pair":            {3},
+	"array:sort":                     {1, 2, 3},
+	"array:flatten":                  {1},
+	"array:fold-left":                {3},
+	"array:fold-right":               {3},
+	"fn:parse-json":                  {1, 2},
+	"fn:json-doc":                    {1, 2},
+	"fn:json-to-xml":                 {1, 2},
+	"fn:xml-to-json":                 {1, 2},
+	"xs:integer":                     {1},
+	"xs:decimal":                     {1},
+	"xs:double":                      {1},
+	"xs:string":                      {1},
+	"xs:boolean":                     {1},
+	"xs:dateTime":                    {1},
+	"xs:date":                        {1},
+	"xs:time":                        {1},
+	"xs:duration":                    {1},
+	"xs:dayTimeDuration":             {1},
+	"xs:yearMonthDuration":           {1},
+	"fn:function-lookup":             {2},
+}
+
+func fnFunctionLookup(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:function-lookup")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:function-lookup")
+	}
+
+	name, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+	arity, ok := args[1].(*object.Integer)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	if strings.HasPrefix(name, "Q{") {
+		i := strings.Index(name, "}")
+		if i < 0 {
+			return NewCodedError("FORG0001", "invalid function name: %s", name)
+		}
+		prefix, ok := funcPrefix(ctx, name[2:i])
+		if !ok {
+			return NewSequence()
+		}
+		name = prefix + ":" + name[i+1:]
+	} else if !strings.Contains(name, ":") {
+		name = "fn:" + name
+	}
+
+	builtin, ok := Function(ctx, name)
+	if !ok || !HasArity(ctx, name, arity.Value()) {
+		return NewSequence()
+	}
+
+	fn := &object.FuncNamed{Num: arity.Value(), Func: &builtin}
+	fn.Name.SetValue(name)
+	return fn
+}
+
+func fnFunctionName(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:function-name")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:function-name")
+	}
+
+	switch f := args[0].(type) {
+	case *object.FuncNamed:
+		return NewString(funcName(f.Name))
+	case *object.FuncInline, *object.FuncPartial, *object.Map, *object.Array:
+		return NewSequence()
+	}
+	return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=function(*)", TypeName(args[0]))
+}
+
+func fnFunctionArity(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:function-arity")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:function-arity")
+	}
+
+	arity := FuncArity(args[0])
+	if arity < 0 {
+		return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=function(*)", TypeName(args[0]))
+	}
+	return NewInteger(arity)
+}
+
+// funcName returns the name of a built-in function with its prefix
+// funcPrefix returns the prefix of the namespace uri, the namespaces in the static context are used for user functions
+func funcPrefix(ctx *object.Context, uri string) (string, bool) {
+	if prefix, ok := funcPrefixes[uri]; ok {
+		return prefix, true
+	}
+	if ctx != nil {
+		for prefix, u := range ctx.Namespaces {
+			if u == uri {
+				return prefix, true
+			}
+		}
+	}
+	return "", false
+}
+
+func funcName(name ast.EQName) string {
+	if name.TypeID == 1 && name.Prefix() == "" {
+		return "fn:" + name.Value()
+	}
+	return name.Value()
+}
@@ -1,13 +1,19 @@
 package bif
 
-import "github.com/zzossig/rabbit/object"
+import (
+	"sort"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+const codepointCollation = "http://www.w3.org/2005/xpath-functions/collation/codepoint"
 
 func fnForEach(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:for-each")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:for-each")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: fn:for-each")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:for-each")
 	}
 
 	seq := &object.Sequence{}
@@ -25,7 +31,7 @@ func fnForEach(ctx *object.Context, args ...object.Item) object.Item {
 		f := *action.Func
 
 		if action.Num != 1 {
-			return NewError("wrong number of parameters. got=#%d, expected=#1", action.Num)
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=#%d, expected=#1", action.Num)
 		}
 
 		for _, item := range seq.Items {
@@ -33,20 +39,21 @@ func fnForEach(ctx *object.Context, args ...object.Item) object.Item {
 		}
 	case *object.FuncInline:
 		if len(action.PL.Params) != 1 {
-			return NewError("wrong number of parameters. got=%d, expected=1", len(action.PL.Params))
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=%d, expected=1", len(action.PL.Params))
 		}
 
-		enclosedCtx := object.NewEnclosedContext(ctx)
 		for _, item := range seq.Items {
-			enclosedCtx.Set(action.PL.Params[0].Value(), item)
-			a := action.Fn(action.Body, enclosedCtx)
+			a := CallFunc(ctx, action, item)
+			if IsError(a) {
+				return a
+			}
 			result.Items = append(result.Items, a)
 		}
 	case *object.FuncPartial:
 		f := *action.Func
 
 		if action.PCnt != 1 {
-			return NewError("wrong number of placeholder. got=%d, expected=1", action.PCnt)
+			return NewCodedError("XPTY0004", "wrong number of placeholder. got=%d, expected=1", action.PCnt)
 		}
 
 		for _, item := range seq.Items {
@@ -66,7 +73,7 @@ func fnForEach(ctx *object.Context, args ...object.Item) object.Item {
 				case object.VarrefType:
 					it, ok := ctx.Get(arg.Inspect())
 					if !ok {
-						return NewError("variable not defined: $%s", arg.Inspect())
+						return NewCodedError("XPST0008", "variable not defined: $%s", arg.Inspect())
 					}
 					a = append(a, it)
 				case object.PholderType:
@@ -78,7 +85,7 @@ func fnForEach(ctx *object.Context, args ...object.Item) object.Item {
 			result.Items = append(result.Items, f(ctx, a...))
 		}
 	default:
-		return NewError("not supported action type in fn:for-each. got %s", action.Type())
+		return NewCodedError("XPTY0004", "not supported action type in fn:for-each. got %s", TypeName(action))
 	}
 
 	return result
@@ -86,10 +93,10 @@ func fnForEach(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnForEachPair(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 3 {
-		return NewError("too few parameters for function call: fn:for-each-pair")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:for-each-pair")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:for-each-pair")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:for-each-pair")
 	}
 
 	var seq []object.Sequence
@@ -126,7 +133,7 @@ func fnForEachPair(ctx *object.Context, args ...object.Item) object.Item {
 		f := *action.Func
 
 		if action.Num != 2 {
-			return NewError("wrong number of parameters. got=#%d, expected=#2", action.Num)
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=#%d, expected=#2", action.Num)
 		}
 
 		for i := 0; i < minLen; i++ {
@@ -139,23 +146,21 @@ func fnForEachPair(ctx *object.Context, args ...object.Item) object.Item {
 		}
 	case *object.FuncInline:
 		if len(action.PL.Params) != 2 {
-			return NewError("wrong number of parameters. got=%d, expected=2", len(action.PL.Params))
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=%d, expected=2", len(action.PL.Params))
 		}
 
-		enclosedCtx := object.NewEnclosedContext(ctx)
 		for i := 0; i < minLen; i++ {
-			for j, param := range action.PL.Params {
-				enclosedCtx.Set(param.Value(), seq[j].Items[i])
+			a := CallFunc(ctx, action, seq[0].Items[i], seq[1].Items[i])
+			if IsError(a) {
+				return a
 			}
-
-			a := action.Fn(action.Body, enclosedCtx)
 			result.Items = append(result.Items, a)
 		}
 	case *object.FuncPartial:
 		f := *action.Func
 
 		if action.PCnt != 2 {
-			return NewError("wrong number of placeholder. got=%d, expected=2", action.PCnt)
+			return NewCodedError("XPTY0004", "wrong number of placeholder. got=%d, expected=2", action.PCnt)
 		}
 
 		for i := 0; i < minLen; i++ {
@@ -175,7 +180,7 @@ func fnForEachPair(ctx *object.Context, args ...object.Item) object.Item {
 				case object.VarrefType:
 					it, ok := ctx.Get(arg.Inspect())
 					if !ok {
-						return NewError("variable not defined: $%s", arg.Inspect())
+						return NewCodedError("XPST0008", "variable not defined: $%s", arg.Inspect())
 					}
 					a = append(a, it)
 				case object.PholderType:
@@ -187,7 +192,7 @@ func fnForEachPair(ctx *object.Context, args ...object.Item) object.Item {
 			result.Items = append(result.Items, f(ctx, a...))
 		}
 	default:
-		return NewError("not supported action type in for-each-pair. got %s", action.Type())
+		return NewCodedError("XPTY0004", "not supported action type in for-each-pair. got %s", TypeName(action))
 	}
 
 	return result
@@ -195,10 +200,10 @@ func fnForEachPair(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:filter")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:filter")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: fn:filter")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:filter")
 	}
 
 	seq := &object.Sequence{}
@@ -216,13 +221,13 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 		f := *action.Func
 
 		if action.Num != 1 {
-			return NewError("wrong number of parameters. got=#%d, expected=#1", action.Num)
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=#%d, expected=#1", action.Num)
 		}
 
 		for _, item := range seq.Items {
 			b := f(ctx, item)
 			if !IsBoolean(b) {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			bObj := b.(*object.Boolean)
 			if bObj.Value() {
@@ -231,13 +236,14 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 		}
 	case *object.FuncInline:
 		if len(action.PL.Params) != 1 {
-			return NewError("wrong number of parameters. got=%d, expected=1", len(action.PL.Params))
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=%d, expected=1", len(action.PL.Params))
 		}
 
-		enclosedCtx := object.NewEnclosedContext(ctx)
 		for _, item := range seq.Items {
-			enclosedCtx.Set(action.PL.Params[0].Value(), item)
-			a := action.Fn(action.Body, enclosedCtx)
+			a := CallFunc(ctx, action, item)
+			if IsError(a) {
+				return a
+			}
 			i := UnwrapSeq(a)
 			if len(i) == 1 {
 				if b, ok := i[0].(*object.Boolean); ok {
@@ -245,7 +251,7 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 						result.Items = append(result.Items, item)
 					}
 				} else {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 			}
 		}
@@ -253,7 +259,7 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 		f := *action.Func
 
 		if action.PCnt != 1 {
-			return NewError("wrong number of placeholder. got=%d, expected=1", action.PCnt)
+			return NewCodedError("XPTY0004", "wrong number of placeholder. got=%d, expected=1", action.PCnt)
 		}
 
 		for _, item := range seq.Items {
@@ -273,7 +279,7 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 				case object.VarrefType:
 					it, ok := ctx.Get(arg.Inspect())
 					if !ok {
-						return NewError("variable not defined: $%s", arg.Inspect())
+						return NewCodedError("XPST0008", "variable not defined: $%s", arg.Inspect())
 					}
 					a = append(a, it)
 				case object.PholderType:
@@ -284,7 +290,7 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 
 			b := f(ctx, a...)
 			if !IsBoolean(b) {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			bObj := b.(*object.Boolean)
 			if bObj.Value() {
@@ -292,8 +298,184 @@ func fnFilter(ctx *object.Context, args ...object.Item) object.Item {
 			}
 		}
 	default:
-		return NewError("not supported action type in fn:filter. got %s", action.Type())
+		return NewCodedError("XPTY0004", "not supported action type in fn:filter. got %s", TypeName(action))
 	}
 
 	return result
 }
+
+func fnFoldLeft(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 3 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:fold-left")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:fold-left")
+	}
+
+	acc := args[1]
+	for _, item := range UnwrapSeq(args[0]) {
+		acc = CallFunc(ctx, args[2], acc, item)
+		if IsError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func fnFoldRight(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 3 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:fold-right")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:fold-right")
+	}
+
+	acc := args[1]
+	items := UnwrapSeq(args[0])
+	for i := len(items) - 1; i >= 0; i-- {
+		acc = CallFunc(ctx, args[2], items[i], acc)
+		if IsError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func fnSort(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:sort")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:sort")
+	}
+
+	var key object.Item
+	if len(args) > 1 {
+		if e := collationArg(args[1]); e != nil {
+			return e
+		}
+	}
+	if len(args) > 2 {
+		key = args[2]
+	}
+
+	items, err := sortItems(ctx, UnwrapSeq(args[0]), key)
+	if err != nil {
+		return err
+	}
+	return &object.Sequence{Items: items}
+}
+
+func fnApply(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:apply")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:apply")
+	}
+
+	arr, ok := args[1].(*object.Array)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+	if arity := FuncArity(args[0]); arity >= 0 && arity != len(arr.Items) {
+		return NewCodedError("FOAP0001", "wrong number of argument. got=%d, want=%d", len(arr.Items), arity)
+	}
+
+	return CallFunc(ctx, args[0], arr.Items...)
+}
+
+// collationArg checks the collation argument, only the codepoint collation is supported
+func collationArg(item object.Item) object.Item {
+	c, e := stringArg(item)
+	if e != nil {
+		return e
+	}
+	if c != "" && c != codepointCollation {
+		return NewCodedError("FOCH0002", "collation is not supported: %s", c)
+	}
+	return nil
+}
+
+// sortItems sorts items in the order of their sort keys.
+// The sort key is the atomized value of the item or the result of calling key with the item.
+func sortItems(ctx *object.Context, items []object.Item, key object.Item) ([]object.Item, object.Item) {
+	keys := make([][]object.Item, len(items))
+	for i, item := range items {
+		k := item
+		if key != nil {
+			k = CallFunc(ctx, key, item)
+			if IsError(k) {
+				return nil, k
+			}
+		}
+		keys[i] = atomize(k)
+	}
+
+	idx := make([]int, len(items))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	var err object.Item
+	sort.SliceStable(idx, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		c, e := compareKeys(keys[idx[i]], keys[idx[j]])
+		if e != nil {
+			err = e
+		}
+		return c < 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := make([]object.Item, len(items))
+	for i, j := range idx {
+		sorted[i] = items[j]
+	}
+	return sorted, nil
+}
+
+// compareKeys compares two sort keys item by item, a shorter key sorts first
+func compareKeys(k1, k2 []object.Item) (int, object.Item) {
+	for i := 0; i < len(k1) && i < len(k2); i++ {
+		eq := IsEQ(k1[i], k2[i])
+		if IsError(eq) {
+			return 0, eq
+		}
+		if eq.(*object.Boolean).Value() {
+			continue
+		}
+
+		lt := IsLT(k1[i], k2[i])
+		if IsError(lt) {
+			return 0, lt
+		}
+		if lt.(*object.Boolean).Value() {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return len(k1) - len(k2), nil
+}
+
+// atomize returns the atomized value of an item, nodes are atomized to their string value
+func atomize(item object.Item) []object.Item {
+	var items []object.Item
+	for _, it := range UnwrapSeq(item) {
+		switch it := it.(type) {
+		case *object.Array:
+			for _, m := range it.Items {
+				items = append(items, atomize(m)...)
+			}
+		case *object.BaseNode, *object.AttrNode:
+			items = append(items, CastType(it, object.StringType))
+		default:
+			items = append(items, it)
+		}
+	}
+	return items
+}
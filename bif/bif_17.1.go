@@ -4,10 +4,10 @@ import "github.com/zzossig/rabbit/object"
 
 func mapSize(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: map:size")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:size")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: map:size")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:size")
 	}
 
 	var m *object.Map
@@ -18,16 +18,16 @@ func mapSize(ctx *object.Context, args ...object.Item) object.Item {
 	case *object.Sequence:
 		items := UnwrapSeq(arg)
 		if len(items) != 1 {
-			NewError("wrong number of arguments. got=%d, expected=1", len(items))
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, expected=1", len(items))
 		}
 
 		item, ok := items[0].(*object.Map)
 		if !ok {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		m = item
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	return NewInteger(len(m.Pairs))
@@ -35,10 +35,10 @@ func mapSize(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapKeys(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: map:keys")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:keys")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: map:keys")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:keys")
 	}
 
 	var m *object.Map
@@ -49,16 +49,16 @@ func mapKeys(ctx *object.Context, args ...object.Item) object.Item {
 	case *object.Sequence:
 		items := UnwrapSeq(arg)
 		if len(items) != 1 {
-			NewError("wrong number of arguments. got=%d, expected=1", len(items))
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, expected=1", len(items))
 		}
 
 		item, ok := items[0].(*object.Map)
 		if !ok {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		m = item
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	keys := &object.Sequence{}
@@ -71,14 +71,14 @@ func mapKeys(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapContains(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: map:contains")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:contains")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: map:contains")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:contains")
 	}
 
 	if !IsMap(args[0]) || !IsAnyAtomic(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	m := args[0].(*object.Map)
@@ -123,14 +123,14 @@ func mapContains(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapGet(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: map:get")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:get")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: map:get")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:get")
 	}
 
 	if !IsMap(args[0]) || !IsAnyAtomic(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	m := args[0].(*object.Map)
@@ -167,14 +167,14 @@ func mapGet(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapPut(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 3 {
-		return NewError("too few parameters for function call: map:put")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:put")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: map:put")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:put")
 	}
 
 	if !IsMap(args[0]) || !IsAnyAtomic(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	m := args[0].(*object.Map)
@@ -186,14 +186,14 @@ func mapPut(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapEntry(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: map:entry")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:entry")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: map:entry")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:entry")
 	}
 
 	if !IsAnyAtomic(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	pairs := make(map[object.HashKey]object.Pair)
@@ -205,17 +205,17 @@ func mapEntry(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapRemove(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: map:remove")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:remove")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: map:remove")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:remove")
 	}
 
 	if !IsMap(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if !IsAnyAtomic(args[1]) && !IsSeq(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	m := args[0].(*object.Map)
@@ -237,14 +237,14 @@ func mapRemove(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapMerge(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: map:merge")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:merge")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: map:merge")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:merge")
 	}
 
 	if !IsSeq(args[0]) && !IsMap(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if IsSeqEmpty(args[0]) {
 		return &object.Map{}
@@ -278,7 +278,7 @@ func mapMerge(ctx *object.Context, args ...object.Item) object.Item {
 
 	for _, m := range seq.Items {
 		if m.Type() != object.MapType {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		m := m.(*object.Map)
 		src = append(src, m)
@@ -289,7 +289,7 @@ func mapMerge(ctx *object.Context, args ...object.Item) object.Item {
 		for _, m := range src {
 			for key, pair := range m.Pairs {
 				if _, ok := result.Pairs[key]; ok {
-					return NewError("duplicate keys are rejected")
+					return NewCodedError("FOJS0003", "duplicate keys are rejected")
 				}
 				result.Pairs[key] = pair
 			}
@@ -329,7 +329,7 @@ func mapMerge(ctx *object.Context, args ...object.Item) object.Item {
 			}
 		}
 	default:
-		return NewError("invalid duplicates option: %q", option)
+		return NewCodedError("FOJS0005", "invalid duplicates option: %q", option)
 	}
 
 	return result
@@ -337,14 +337,14 @@ func mapMerge(ctx *object.Context, args ...object.Item) object.Item {
 
 func mapForEach(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: map:for-each")
+		return NewCodedError("XPST0017", "too few parameters for function call: map:for-each")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: map:for-each")
+		return NewCodedError("XPST0017", "too many parameters for function call: map:for-each")
 	}
 
 	if !IsMap(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	m := args[0].(*object.Map)
@@ -355,7 +355,7 @@ func mapForEach(ctx *object.Context, args ...object.Item) object.Item {
 		f := *action.Func
 
 		if action.Num != 2 {
-			return NewError("wrong number of parameters. got=#%d, expected=#2", action.Num)
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=#%d, expected=#2", action.Num)
 		}
 
 		for _, pair := range m.Pairs {
@@ -363,21 +363,21 @@ func mapForEach(ctx *object.Context, args ...object.Item) object.Item {
 		}
 	case *object.FuncInline:
 		if len(action.PL.Params) != 2 {
-			return NewError("wrong number of parameters. got=%d, expected=2", len(action.PL.Params))
+			return NewCodedError("XPTY0004", "wrong number of parameters. got=%d, expected=2", len(action.PL.Params))
 		}
 
-		enclosedCtx := object.NewEnclosedContext(ctx)
 		for _, pair := range m.Pairs {
-			enclosedCtx.Set(action.PL.Params[0].Value(), pair.Key)
-			enclosedCtx.Set(action.PL.Params[1].Value(), pair.Value)
-			a := action.Fn(action.Body, enclosedCtx)
+			a := CallFunc(ctx, action, pair.Key, pair.Value)
+			if IsError(a) {
+				return a
+			}
 			result.Items = append(result.Items, a)
 		}
 	case *object.FuncPartial:
 		f := *action.Func
 
 		if action.PCnt != 2 {
-			return NewError("wrong number of placeholder. got=%d, expected=2", action.PCnt)
+			return NewCodedError("XPTY0004", "wrong number of placeholder. got=%d, expected=2", action.PCnt)
 		}
 
 		for _, pair := range m.Pairs {
@@ -397,7 +397,7 @@ func mapForEach(ctx *object.Context, args ...object.Item) object.Item {
 				case object.VarrefType:
 					it, ok := ctx.Get(arg.Inspect())
 					if !ok {
-						return NewError("variable not defined: $%s", arg.Inspect())
+						return NewCodedError("XPST0008", "variable not defined: $%s", arg.Inspect())
 					}
 					a = append(a, it)
 				case object.PholderType:
@@ -414,7 +414,7 @@ func mapForEach(ctx *object.Context, args ...object.Item) object.Item {
 			result.Items = append(result.Items, f(ctx, a...))
 		}
 	default:
-		return NewError("not supported action type in fn:for-each. got %s", action.Type())
+		return NewCodedError("XPTY0004", "not supported action type in fn:for-each. got %s", TypeName(action))
 	}
 
 	return result
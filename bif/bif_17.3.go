@@ -4,14 +4,14 @@ import "github.com/zzossig/rabbit/object"
 
 func arrSize(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: arr:size")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:size")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: arr:size")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:size")
 	}
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arr := args[0].(*object.Array)
@@ -20,14 +20,14 @@ func arrSize(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrGet(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: arr:get")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:get")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: arr:get")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:get")
 	}
 
 	if !IsArray(args[0]) || args[1].Type() != object.IntegerType {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arr := args[0].(*object.Array)
@@ -35,7 +35,7 @@ func arrGet(ctx *object.Context, args ...object.Item) object.Item {
 	idx := pos.Value() - 1
 
 	if idx > len(arr.Items)-1 || idx < 0 {
-		return NewError("index out of range")
+		return NewCodedError("FOAY0001", "index out of range")
 	}
 
 	return arr.Items[idx]
@@ -43,14 +43,14 @@ func arrGet(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrPut(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 3 {
-		return NewError("too few parameters for function call: arr:put")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:put")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: arr:put")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:put")
 	}
 
 	if !IsArray(args[0]) || args[1].Type() != object.IntegerType {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arr := args[0].(*object.Array)
@@ -59,7 +59,7 @@ func arrPut(ctx *object.Context, args ...object.Item) object.Item {
 	idx := pos.Value() - 1
 
 	if idx > len(arr.Items)-1 || idx < 0 {
-		return NewError("index out of range")
+		return NewCodedError("FOAY0001", "index out of range")
 	}
 
 	arr.Items[idx] = mem
@@ -68,14 +68,14 @@ func arrPut(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrAppend(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: arr:append")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:append")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: arr:append")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:append")
 	}
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arr := args[0].(*object.Array)
@@ -86,10 +86,10 @@ func arrAppend(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrSubarray(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: arr:subarray")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:subarray")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: arr:subarray")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:subarray")
 	}
 
 	var arr *object.Array
@@ -97,7 +97,7 @@ func arrSubarray(ctx *object.Context, args ...object.Item) object.Item {
 	var length int
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	arr = args[0].(*object.Array)
 
@@ -105,21 +105,21 @@ func arrSubarray(ctx *object.Context, args ...object.Item) object.Item {
 		arg1, ok1 := args[1].(*object.Integer)
 		arg2, ok2 := args[2].(*object.Integer)
 		if !ok1 || !ok2 {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		start = arg1.Value() - 1
 		length = arg2.Value()
 	} else {
 		arg1, ok := args[1].(*object.Integer)
 		if !ok {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		start = arg1.Value() - 1
 		length = len(arr.Items) - start
 	}
 
 	if start < 0 || length < 0 || start+length > len(arr.Items) {
-		return NewError("index out of range")
+		return NewCodedError("FOAY0001", "index out of range")
 	}
 
 	arr.Items = arr.Items[start : start+length]
@@ -129,14 +129,14 @@ func arrSubarray(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrRemove(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: arr:remove")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:remove")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: arr:remove")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:remove")
 	}
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	var positions []int
@@ -146,13 +146,13 @@ func arrRemove(ctx *object.Context, args ...object.Item) object.Item {
 			if i, ok := item.(*object.Integer); ok {
 				positions = append(positions, i.Value())
 			} else {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 		}
 	case *object.Integer:
 		positions = append(positions, arg.Value())
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arr := args[0].(*object.Array)
@@ -160,7 +160,7 @@ func arrRemove(ctx *object.Context, args ...object.Item) object.Item {
 	for _, pos := range positions {
 		idx := pos - 1
 		if idx < 0 || idx > len(arr.Items)-1 {
-			return NewError("index out of range")
+			return NewCodedError("FOAY0001", "index out of range")
 		}
 
 		arr.Items[idx] = nil
@@ -179,14 +179,14 @@ func arrRemove(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrInsertBefore(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 3 {
-		return NewError("too few parameters for function call: arr:insert-before")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:insert-before")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: arr:insert-before")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:insert-before")
 	}
 
 	if !IsArray(args[0]) || args[1].Type() != object.IntegerType {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arr := args[0].(*object.Array)
@@ -195,7 +195,7 @@ func arrInsertBefore(ctx *object.Context, args ...object.Item) object.Item {
 	idx := pos.Value() - 1
 
 	if idx > len(arr.Items) || idx < 0 {
-		return NewError("index out of range")
+		return NewCodedError("FOAY0001", "index out of range")
 	}
 
 	arr.Items = append(arr.Items[:idx], append([]object.Item{mem}, arr.Items[idx:]...)...)
@@ -204,17 +204,17 @@ func arrInsertBefore(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrHead(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: arr:head")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:head")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: arr:head")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:head")
 	}
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if IsArrayEmpty(args[0]) {
-		return NewError("index our of range")
+		return NewCodedError("FOAY0001", "index out of range")
 	}
 
 	arr := args[0].(*object.Array)
@@ -223,17 +223,17 @@ func arrHead(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrTail(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: arr:tail")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:tail")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: arr:tail")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:tail")
 	}
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if IsArrayEmpty(args[0]) {
-		return NewError("index our of range")
+		return NewCodedError("FOAY0001", "index out of range")
 	}
 
 	return arrRemove(nil, args[0], NewInteger(1))
@@ -241,14 +241,14 @@ func arrTail(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrReverse(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: arr:reverse")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:reverse")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: arr:reverse")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:reverse")
 	}
 
 	if !IsArray(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if IsArrayEmpty(args[0]) {
 		return args[0]
@@ -265,17 +265,17 @@ func arrReverse(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrJoin(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: arr:join")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:join")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: arr:join")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:join")
 	}
 
 	if IsArray(args[0]) {
 		return args[0]
 	}
 	if !IsSeq(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	result := &object.Array{}
@@ -289,7 +289,7 @@ func arrJoin(ctx *object.Context, args ...object.Item) object.Item {
 		if arr, ok := item.(*object.Array); ok {
 			result.Items = append(result.Items, arr.Items...)
 		} else {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	}
 
@@ -298,10 +298,10 @@ func arrJoin(ctx *object.Context, args ...object.Item) object.Item {
 
 func arrFlatten(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: arr:flatten")
+		return NewCodedError("XPST0017", "too few parameters for function call: arr:flatten")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: arr:flatten")
+		return NewCodedError("XPST0017", "too many parameters for function call: arr:flatten")
 	}
 
 	if IsSeqEmpty(args[0]) || IsArrayEmpty(args[0]) {
@@ -313,17 +313,158 @@ func arrFlatten(ctx *object.Context, args ...object.Item) object.Item {
 }
 
 func arrFilter(ctx *object.Context, args ...object.Item) object.Item {
-	return nil
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: array:filter")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: array:filter")
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	result := &object.Array{}
+	for _, item := range arr.Items {
+		b := UnwrapSeq(CallFunc(ctx, args[1], item))
+		if len(b) == 1 && IsError(b[0]) {
+			return b[0]
+		}
+		if len(b) != 1 || !IsBoolean(b[0]) {
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
+		}
+		if b[0].(*object.Boolean).Value() {
+			result.Items = append(result.Items, item)
+		}
+	}
+
+	return result
 }
 
 func arrSort(ctx *object.Context, args ...object.Item) object.Item {
-	return nil
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: array:sort")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: array:sort")
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	var key object.Item
+	if len(args) > 1 {
+		if e := collationArg(args[1]); e != nil {
+			return e
+		}
+	}
+	if len(args) > 2 {
+		key = args[2]
+	}
+
+	items, err := sortItems(ctx, arr.Items, key)
+	if err != nil {
+		return err
+	}
+	return &object.Array{Items: items}
 }
 
 func arrForEach(ctx *object.Context, args ...object.Item) object.Item {
-	return nil
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: array:for-each")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: array:for-each")
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	result := &object.Array{}
+	for _, item := range arr.Items {
+		r := CallFunc(ctx, args[1], item)
+		if IsError(r) {
+			return r
+		}
+		result.Items = append(result.Items, r)
+	}
+
+	return result
 }
 
 func arrForEachPair(ctx *object.Context, args ...object.Item) object.Item {
-	return nil
+	if len(args) < 3 {
+		return NewCodedError("XPST0017", "too few parameters for function call: array:for-each-pair")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: array:for-each-pair")
+	}
+
+	arr1, ok1 := args[0].(*object.Array)
+	arr2, ok2 := args[1].(*object.Array)
+	if !ok1 || !ok2 {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	result := &object.Array{}
+	for i := 0; i < len(arr1.Items) && i < len(arr2.Items); i++ {
+		r := CallFunc(ctx, args[2], arr1.Items[i], arr2.Items[i])
+		if IsError(r) {
+			return r
+		}
+		result.Items = append(result.Items, r)
+	}
+
+	return result
+}
+
+func arrFoldLeft(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 3 {
+		return NewCodedError("XPST0017", "too few parameters for function call: array:fold-left")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: array:fold-left")
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	acc := args[1]
+	for _, item := range arr.Items {
+		acc = CallFunc(ctx, args[2], acc, item)
+		if IsError(acc) {
+			return acc
+		}
+	}
+	return acc
+}
+
+func arrFoldRight(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 3 {
+		return NewCodedError("XPST0017", "too few parameters for function call: array:fold-right")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: array:fold-right")
+	}
+
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	acc := args[1]
+	for i := len(arr.Items) - 1; i >= 0; i-- {
+		acc = CallFunc(ctx, args[2], arr.Items[i], acc)
+		if IsError(acc) {
+			return acc
+		}
+	}
+	return acc
 }
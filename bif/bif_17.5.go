@@ -0,0 +1,880 @@
+package bif
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zzossig/rabbit/object"
+	"golang.org/x/net/html"
+)
+
+// jsonOptions is the options map of fn:parse-json, fn:json-doc, fn:json-to-xml and fn:xml-to-json
+type jsonOptions struct {
+	liberal    bool
+	duplicates string
+	escape     bool
+	fallback   object.Item
+	indent     bool
+}
+
+// jsonValue is a parsed JSON value before it is converted to items or nodes
+type jsonValue struct {
+	kind    string
+	str     string
+	escaped bool
+	num     float64
+	raw     string
+	boolean bool
+	keys    []jsonKey
+	members []*jsonValue
+}
+
+type jsonKey struct {
+	str     string
+	escaped bool
+}
+
+func fnParseJSON(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:parse-json")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:parse-json")
+	}
+
+	if IsSeqEmpty(args[0]) {
+		return NewSequence()
+	}
+	input, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	return parseJSON(ctx, input, args[1:], "use-first", "reject", "use-first", "use-last")
+}
+
+func fnJSONDoc(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:json-doc")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:json-doc")
+	}
+
+	if IsSeqEmpty(args[0]) {
+		return NewSequence()
+	}
+	href, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	input, e := readResource(ctx, href)
+	if e != nil {
+		return e
+	}
+
+	return parseJSON(ctx, input, args[1:], "use-first", "reject", "use-first", "use-last")
+}
+
+func fnJSONToXML(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:json-to-xml")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:json-to-xml")
+	}
+
+	if IsSeqEmpty(args[0]) {
+		return NewSequence()
+	}
+	input, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	opts, e := jsonOptionsArg(args[1:], "retain", "reject", "use-first", "retain")
+	if e != nil {
+		return e
+	}
+
+	v, e := newJSONParser(ctx, input, opts).parse()
+	if e != nil {
+		return e
+	}
+
+	root, e := jsonToXML(v, opts)
+	if e != nil {
+		return e
+	}
+
+	doc := &html.Node{Type: html.DocumentNode}
+	doc.AppendChild(root)
+
+	node := &object.BaseNode{}
+	node.SetTree(doc)
+	return node
+}
+
+func fnXMLToJSON(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:xml-to-json")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:xml-to-json")
+	}
+
+	items := UnwrapSeq(args[0])
+	if len(items) == 0 {
+		return NewSequence()
+	}
+	if len(items) > 1 {
+		return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(items))
+	}
+
+	n, ok := items[0].(*object.BaseNode)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=node()", TypeName(items[0]))
+	}
+
+	opts, e := jsonOptionsArg(args[1:], "", "")
+	if e != nil {
+		return e
+	}
+
+	tree := n.Tree()
+	if tree.Type == html.DocumentNode {
+		var root *html.Node
+		for c := tree.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				root = c
+				break
+			}
+		}
+		if root == nil {
+			return NewCodedError("FOJS0006", "fn:xml-to-json: the document node has no element child")
+		}
+		tree = root
+	}
+
+	var sb strings.Builder
+	if e := xmlToJSON(tree, &sb, opts.indent, 0, false); e != nil {
+		return e
+	}
+	return NewString(sb.String())
+}
+
+func parseJSON(ctx *object.Context, input string, options []object.Item, dup string, allowed ...string) object.Item {
+	opts, e := jsonOptionsArg(options, dup, allowed...)
+	if e != nil {
+		return e
+	}
+
+	v, e := newJSONParser(ctx, input, opts).parse()
+	if e != nil {
+		return e
+	}
+
+	return jsonToItem(v, opts)
+}
+
+// jsonOptionsArg reads the options map, dup is the default value of the duplicates option
+func jsonOptionsArg(args []object.Item, dup string, allowed ...string) (*jsonOptions, *object.Error) {
+	opts := &jsonOptions{duplicates: dup}
+	if len(args) == 0 || IsSeqEmpty(args[0]) {
+		return opts, nil
+	}
+
+	m, ok := args[0].(*object.Map)
+	if !ok {
+		return nil, NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=map(*)", TypeName(args[0]))
+	}
+
+	for _, pair := range m.Pairs {
+		key, ok := pair.Key.(*object.String)
+		if !ok {
+			continue
+		}
+
+		value := pair.Value
+		if items := UnwrapSeq(value); len(items) == 1 {
+			value = items[0]
+		}
+
+		switch key.Value() {
+		case "liberal", "escape", "validate", "indent":
+			b, ok := value.(*object.Boolean)
+			if !ok {
+				return nil, NewCodedError("FOJS0005", "invalid option %s: %s", key.Value(), value.Inspect())
+			}
+			switch key.Value() {
+			case "liberal":
+				opts.liberal = b.Value()
+			case "escape":
+				opts.escape = b.Value()
+			case "indent":
+				opts.indent = b.Value()
+			}
+		case "duplicates":
+			s, ok := value.(*object.String)
+			if !ok || !contains(allowed, s.Value()) {
+				return nil, NewCodedError("FOJS0005", "invalid option duplicates: %s", value.Inspect())
+			}
+			opts.duplicates = s.Value()
+		case "fallback":
+			if FuncArity(value) != 1 {
+				return nil, NewCodedError("FOJS0005", "invalid option fallback: %s", value.Inspect())
+			}
+			opts.fallback = value
+		}
+	}
+
+	if opts.escape && opts.fallback != nil {
+		return nil, NewCodedError("FOJS0005", "the escape and fallback options cannot be used together")
+	}
+	return opts, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+type jsonParser struct {
+	ctx   *object.Context
+	input string
+	pos   int
+	opts  *jsonOptions
+}
+
+func newJSONParser(ctx *object.Context, input string, opts *jsonOptions) *jsonParser {
+	return &jsonParser{ctx: ctx, input: input, opts: opts}
+}
+
+func (p *jsonParser) parse() (*jsonValue, *object.Error) {
+	v, e := p.parseValue()
+	if e != nil {
+		return nil, e
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.error("unexpected character %q", p.input[p.pos])
+	}
+	return v, nil
+}
+
+func (p *jsonParser) error(format string, a ...interface{}) *object.Error {
+	return NewCodedError("FOJS0001", "invalid JSON: %s at position %d", fmt.Sprintf(format, a...), p.pos)
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.input) {
+		switch p.input[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) parseValue() (*jsonValue, *object.Error) {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, p.error("unexpected end of input")
+	}
+
+	switch c := p.input[p.pos]; {
+	case c == '{':
+		return p.parseObject()
+	case c == '[':
+		return p.parseArray()
+	case c == '"' || (c == '\'' && p.opts.liberal):
+		s, escaped, e := p.parseString()
+		if e != nil {
+			return nil, e
+		}
+		return &jsonValue{kind: "string", str: s, escaped: escaped}, nil
+	case c == '-' || (c >= '0' && c <= '9') || (c == '+' && p.opts.liberal):
+		return p.parseNumber()
+	}
+
+	for _, lit := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(p.input[p.pos:], lit) {
+			p.pos += len(lit)
+			if lit == "null" {
+				return &jsonValue{kind: "null"}, nil
+			}
+			return &jsonValue{kind: "boolean", boolean: lit == "true"}, nil
+		}
+	}
+	return nil, p.error("unexpected character %q", p.input[p.pos])
+}
+
+func (p *jsonParser) parseObject() (*jsonValue, *object.Error) {
+	v := &jsonValue{kind: "map"}
+	p.pos++
+
+	for {
+		p.skipSpace()
+		if p.pos < len(p.input) && p.input[p.pos] == '}' && (len(v.keys) == 0 || p.opts.liberal) {
+			p.pos++
+			return v, nil
+		}
+		if p.pos >= len(p.input) || (p.input[p.pos] != '"' && !(p.input[p.pos] == '\'' && p.opts.liberal)) {
+			return nil, p.error("expected a string key")
+		}
+
+		key, escaped, e := p.parseString()
+		if e != nil {
+			return nil, e
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ':' {
+			return nil, p.error("expected ':'")
+		}
+		p.pos++
+
+		member, e := p.parseValue()
+		if e != nil {
+			return nil, e
+		}
+		v.keys = append(v.keys, jsonKey{str: key, escaped: escaped})
+		v.members = append(v.members, member)
+
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return nil, p.error("unexpected end of input")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return v, nil
+		default:
+			return nil, p.error("expected ',' or '}'")
+		}
+	}
+}
+
+func (p *jsonParser) parseArray() (*jsonValue, *object.Error) {
+	v := &jsonValue{kind: "array"}
+	p.pos++
+
+	for {
+		p.skipSpace()
+		if p.pos < len(p.input) && p.input[p.pos] == ']' && (len(v.members) == 0 || p.opts.liberal) {
+			p.pos++
+			return v, nil
+		}
+
+		member, e := p.parseValue()
+		if e != nil {
+			return nil, e
+		}
+		v.members = append(v.members, member)
+
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return nil, p.error("unexpected end of input")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return v, nil
+		default:
+			return nil, p.error("expected ',' or ']'")
+		}
+	}
+}
+
+func (p *jsonParser) parseNumber() (*jsonValue, *object.Error) {
+	start := p.pos
+	if p.input[p.pos] == '-' || p.input[p.pos] == '+' {
+		p.pos++
+	}
+
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+
+	intStart := p.pos
+	if n := digits(); n == 0 || (n > 1 && p.input[intStart] == '0' && !p.opts.liberal) {
+		return nil, p.error("invalid number")
+	}
+	if p.pos < len(p.input) && p.input[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			return nil, p.error("invalid number")
+		}
+	}
+	if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return nil, p.error("invalid number")
+		}
+	}
+
+	raw := p.input[start:p.pos]
+	num, err := strconv.ParseFloat(strings.TrimPrefix(raw, "+"), 64)
+	if err != nil && !math.IsInf(num, 0) {
+		return nil, p.error("invalid number")
+	}
+	return &jsonValue{kind: "number", num: num, raw: raw}, nil
+}
+
+// parseString returns the unescaped string, or the string with JSON escape sequences if the escape option is set.
+// escaped is true if the returned string contains escape sequences.
+func (p *jsonParser) parseString() (string, bool, *object.Error) {
+	quote := p.input[p.pos]
+	p.pos++
+
+	var sb strings.Builder
+	escaped := false
+
+	for {
+		if p.pos >= len(p.input) {
+			return "", false, p.error("unterminated string")
+		}
+
+		c := p.input[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return sb.String(), escaped, nil
+		case c < 0x20 && !p.opts.liberal:
+			return "", false, p.error("control character in string")
+		case c == '\\':
+			r, seq, e := p.parseEscape()
+			if e != nil {
+				return "", false, e
+			}
+			s, esc, e := p.char(r, seq)
+			if e != nil {
+				return "", false, e
+			}
+			sb.WriteString(s)
+			escaped = escaped || esc
+		default:
+			r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+			p.pos += size
+			s, esc, e := p.char(r, string(r))
+			if e != nil {
+				return "", false, e
+			}
+			sb.WriteString(s)
+			escaped = escaped || esc
+		}
+	}
+}
+
+// parseEscape returns the character of an escape sequence and the sequence itself
+func (p *jsonParser) parseEscape() (rune, string, *object.Error) {
+	start := p.pos
+	p.pos++
+	if p.pos >= len(p.input) {
+		return 0, "", p.error("unterminated string")
+	}
+
+	c := p.input[p.pos]
+	p.pos++
+	switch c {
+	case '"', '\\', '/', '\'':
+		if c == '\'' && !p.opts.liberal {
+			break
+		}
+		return rune(c), p.input[start:p.pos], nil
+	case 'b':
+		return '\b', `\b`, nil
+	case 'f':
+		return '\f', `\f`, nil
+	case 'n':
+		return '\n', `\n`, nil
+	case 'r':
+		return '\r', `\r`, nil
+	case 't':
+		return '\t', `\t`, nil
+	case 'u':
+		r, ok := p.hex4()
+		if !ok {
+			return 0, "", p.error("invalid unicode escape")
+		}
+		if r >= 0xD800 && r < 0xDC00 && strings.HasPrefix(p.input[p.pos:], `\u`) {
+			save := p.pos
+			p.pos += 2
+			if lo, ok := p.hex4(); ok && lo >= 0xDC00 && lo < 0xE000 {
+				return (r-0xD800)<<10 + (lo - 0xDC00) + 0x10000, p.input[start:p.pos], nil
+			}
+			p.pos = save
+		}
+		return r, p.input[start:p.pos], nil
+	}
+
+	if p.opts.liberal {
+		return rune(c), p.input[start:p.pos], nil
+	}
+	p.pos = start
+	return 0, "", p.error("invalid escape sequence")
+}
+
+func (p *jsonParser) hex4() (rune, bool) {
+	if p.pos+4 > len(p.input) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(p.input[p.pos:p.pos+4], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	p.pos += 4
+	return rune(n), true
+}
+
+// char converts a character of a JSON string according to the escape and fallback options.
+// seq is the character as it appears in the input.
+func (p *jsonParser) char(r rune, seq string) (string, bool, *object.Error) {
+	if p.opts.escape {
+		switch {
+		case r == '\\':
+			return `\\`, true, nil
+		case r == '\b', r == '\f', r == '\n', r == '\r', r == '\t':
+			return strconv.QuoteRune(r)[1:3], true, nil
+		case !isXMLChar(r) || (r >= 0x7F && r <= 0x9F):
+			return fmt.Sprintf(`\u%04X`, r), true, nil
+		}
+		return string(r), false, nil
+	}
+
+	if isXMLChar(r) {
+		return string(r), false, nil
+	}
+
+	if p.opts.fallback == nil {
+		return "\uFFFD", false, nil
+	}
+	if !strings.HasPrefix(seq, `\u`) {
+		seq = fmt.Sprintf(`\u%04X`, r)
+	}
+
+	s, e := stringArg(CallFunc(p.ctx, p.opts.fallback, NewString(seq)))
+	if e != nil {
+		return "", false, e
+	}
+	return s, false, nil
+}
+
+func isXMLChar(r rune) bool {
+	switch {
+	case r == '\t', r == '\n', r == '\r':
+		return true
+	case r >= 0x20 && r <= 0xD7FF:
+		return true
+	case r >= 0xE000 && r <= 0xFFFD:
+		return true
+	case r >= 0x10000 && r <= 0x10FFFF:
+		return true
+	}
+	return false
+}
+
+func jsonToItem(v *jsonValue, opts *jsonOptions) object.Item {
+	switch v.kind {
+	case "map":
+		pairs := make(map[object.HashKey]object.Pair)
+		for i, k := range v.keys {
+			key := NewString(k.str)
+			hashed := key.HashKey()
+
+			if _, ok := pairs[hashed]; ok {
+				switch opts.duplicates {
+				case "reject":
+					return NewCodedError("FOJS0003", "duplicate key in JSON object: %s", k.str)
+				case "use-first":
+					continue
+				}
+			}
+
+			value := jsonToItem(v.members[i], opts)
+			if IsError(value) {
+				return value
+			}
+			pairs[hashed] = object.Pair{Key: key, Value: value}
+		}
+		return &object.Map{Pairs: pairs}
+	case "array":
+		arr := &object.Array{}
+		for _, m := range v.members {
+			value := jsonToItem(m, opts)
+			if IsError(value) {
+				return value
+			}
+			arr.Items = append(arr.Items, value)
+		}
+		return arr
+	case "string":
+		return NewString(v.str)
+	case "number":
+		return NewDouble(v.num)
+	case "boolean":
+		return NewBoolean(v.boolean)
+	}
+	return NewSequence()
+}
+
+func jsonToXML(v *jsonValue, opts *jsonOptions) (*html.Node, *object.Error) {
+	n := newFnElement(v.kind)
+
+	switch v.kind {
+	case "map":
+		seen := make(map[string]bool)
+		for i, k := range v.keys {
+			if seen[k.str] {
+				switch opts.duplicates {
+				case "reject":
+					return nil, NewCodedError("FOJS0003", "duplicate key in JSON object: %s", k.str)
+				case "use-first":
+					continue
+				}
+			}
+			seen[k.str] = true
+
+			child, e := jsonToXML(v.members[i], opts)
+			if e != nil {
+				return nil, e
+			}
+			child.Attr = append(child.Attr, html.Attribute{Key: "key", Val: k.str})
+			if k.escaped {
+				child.Attr = append(child.Attr, html.Attribute{Key: "escaped-key", Val: "true"})
+			}
+			n.AppendChild(child)
+		}
+	case "array":
+		for _, m := range v.members {
+			child, e := jsonToXML(m, opts)
+			if e != nil {
+				return nil, e
+			}
+			n.AppendChild(child)
+		}
+	case "string":
+		if v.escaped {
+			n.Attr = append(n.Attr, html.Attribute{Key: "escaped", Val: "true"})
+		}
+		appendText(n, v.str)
+	case "number":
+		appendText(n, v.raw)
+	case "boolean":
+		appendText(n, strconv.FormatBool(v.boolean))
+	}
+
+	return n, nil
+}
+
+// xmlToJSON writes the JSON representation of an element produced by fn:json-to-xml.
+// elements without a namespace are accepted as well since the html parser does not assign the namespace.
+func xmlToJSON(n *html.Node, sb *strings.Builder, indent bool, depth int, inMap bool) *object.Error {
+	if n.Namespace != fnNS && n.Namespace != "" {
+		return NewCodedError("FOJS0006", "fn:xml-to-json: element is not in the namespace %s: %s", fnNS, n.Data)
+	}
+
+	_, hasKey := attrValue(n, "key")
+	if hasKey != inMap {
+		if inMap {
+			return NewCodedError("FOJS0006", "fn:xml-to-json: a child of map must have a key attribute: %s", n.Data)
+		}
+		return NewCodedError("FOJS0006", "fn:xml-to-json: key attribute is not allowed here: %s", n.Data)
+	}
+
+	switch n.Data {
+	case "map", "array":
+		open, close := "[", "]"
+		if n.Data == "map" {
+			open, close = "{", "}"
+		}
+
+		children, e := jsonChildren(n)
+		if e != nil {
+			return e
+		}
+
+		sb.WriteString(open)
+		seen := make(map[string]bool)
+		for i, c := range children {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			newline(sb, indent, depth+1)
+
+			if n.Data == "map" {
+				k, _ := attrValue(c, "key")
+				esc, _ := attrValue(c, "escaped-key")
+				k = jsonString(k, isTrue(esc))
+				if seen[k] {
+					return NewCodedError("FOJS0006", "fn:xml-to-json: duplicate key: %s", k)
+				}
+				seen[k] = true
+
+				sb.WriteString(k)
+				sb.WriteString(":")
+				if indent {
+					sb.WriteString(" ")
+				}
+			}
+
+			if e := xmlToJSON(c, sb, indent, depth+1, n.Data == "map"); e != nil {
+				return e
+			}
+		}
+		if len(children) > 0 {
+			newline(sb, indent, depth)
+		}
+		sb.WriteString(close)
+	case "string":
+		esc, _ := attrValue(n, "escaped")
+		sb.WriteString(jsonString(textContent(n), isTrue(esc)))
+	case "number":
+		v, err := strconv.ParseFloat(strings.TrimSpace(textContent(n)), 64)
+		if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+			return NewCodedError("FOJS0006", "fn:xml-to-json: invalid number: %s", textContent(n))
+		}
+		sb.WriteString(jsonNumber(v))
+	case "boolean":
+		switch strings.TrimSpace(textContent(n)) {
+		case "true", "1":
+			sb.WriteString("true")
+		case "false", "0":
+			sb.WriteString("false")
+		default:
+			return NewCodedError("FOJS0006", "fn:xml-to-json: invalid boolean: %s", textContent(n))
+		}
+	case "null":
+		if strings.TrimSpace(textContent(n)) != "" {
+			return NewCodedError("FOJS0006", "fn:xml-to-json: null element must be empty")
+		}
+		sb.WriteString("null")
+	default:
+		return NewCodedError("FOJS0006", "fn:xml-to-json: unexpected element: %s", n.Data)
+	}
+
+	return nil
+}
+
+// jsonChildren returns the element children of map or array, whitespace and comments are ignored
+func jsonChildren(n *html.Node) ([]*html.Node, *object.Error) {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.ElementNode:
+			children = append(children, c)
+		case html.TextNode:
+			if strings.TrimSpace(c.Data) != "" {
+				return nil, NewCodedError("FOJS0006", "fn:xml-to-json: unexpected text in %s: %s", n.Data, c.Data)
+			}
+		}
+	}
+	return children, nil
+}
+
+func attrValue(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key && a.Namespace == "" {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch c.Type {
+		case html.TextNode:
+			sb.WriteString(c.Data)
+		case html.ElementNode:
+			sb.WriteString(textContent(c))
+		}
+	}
+	return sb.String()
+}
+
+func isTrue(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "true" || s == "1"
+}
+
+func newline(sb *strings.Builder, indent bool, depth int) {
+	if indent {
+		sb.WriteString("\n")
+		sb.WriteString(strings.Repeat("  ", depth))
+	}
+}
+
+// jsonString quotes s as a JSON string.
+// if escaped is true, the escape sequences in s are kept as they are.
+func jsonString(s string, escaped bool) string {
+	var sb strings.Builder
+	sb.WriteString(`"`)
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case escaped && r == '\\' && i+1 < len(s):
+			n := 2
+			if s[i+1] == 'u' && i+6 <= len(s) {
+				n = 6
+			}
+			sb.WriteString(s[i : i+n])
+			i += n
+			continue
+		case r == '"':
+			sb.WriteString(`\"`)
+		case r == '\\':
+			sb.WriteString(`\\`)
+		case r == '\b':
+			sb.WriteString(`\b`)
+		case r == '\f':
+			sb.WriteString(`\f`)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r < 0x20 || (r >= 0x7F && r <= 0x9F):
+			fmt.Fprintf(&sb, `\u%04X`, r)
+		default:
+			sb.WriteRune(r)
+		}
+		i += size
+	}
+
+	sb.WriteString(`"`)
+	return sb.String()
+}
+
+// jsonNumber formats a number like the xs:double to xs:string cast
+func jsonNumber(v float64) string {
+	if abs := math.Abs(v); abs == 0 || (abs >= 1e-6 && abs < 1e6) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'E', -1, 64)
+}
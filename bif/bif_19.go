@@ -1,53 +1,288 @@
 package bif
 
-import "github.com/zzossig/rabbit/object"
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zzossig/rabbit/object"
+)
 
 func xsInteger(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: xs:integer")
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:integer")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: xs:integer")
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:integer")
 	}
 	return CastType(args[0], object.IntegerType)
 }
 
 func xsDecimal(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: xs:decimal")
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:decimal")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: xs:decimal")
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:decimal")
 	}
 	return CastType(args[0], object.DecimalType)
 }
 
 func xsDouble(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: xs:double")
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:double")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: xs:double")
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:double")
 	}
 	return CastType(args[0], object.DoubleType)
 }
 
 func xsString(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: xs:string")
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:string")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: xs:string")
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:string")
 	}
 	return CastType(args[0], object.StringType)
 }
 
 func xsBoolean(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: xs:boolean")
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:boolean")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: xs:boolean")
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:boolean")
 	}
 	return CastType(args[0], object.BooleanType)
 }
+
+func xsDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:dateTime")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:dateTime")
+	}
+	return CastType(args[0], object.DateTimeType)
+}
+
+func xsDate(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:date")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:date")
+	}
+	return CastType(args[0], object.DateType)
+}
+
+func xsTime(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:time")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:time")
+	}
+	return CastType(args[0], object.TimeType)
+}
+
+func xsDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:duration")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:duration")
+	}
+	return CastType(args[0], object.DurationType)
+}
+
+func xsDayTimeDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:dayTimeDuration")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:dayTimeDuration")
+	}
+	return CastType(args[0], object.DayTimeDurationType)
+}
+
+func xsYearMonthDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: xs:yearMonthDuration")
+	}
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: xs:yearMonthDuration")
+	}
+	return CastType(args[0], object.YearMonthDurationType)
+}
+
+var (
+	tzPattern       = `(Z|[+-]\d{2}:\d{2})?`
+	dateTimePattern = regexp.MustCompile(`^(-?\d{4,})-(\d{2})-(\d{2})T(\d{2}):(\d{2}):(\d{2})(\.\d+)?` + tzPattern + `$`)
+	datePattern     = regexp.MustCompile(`^(-?\d{4,})-(\d{2})-(\d{2})` + tzPattern + `$`)
+	timePattern     = regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2})(\.\d+)?` + tzPattern + `$`)
+	durationPattern = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+)
+
+// castString converts the lexical form of a date, time or duration to the item of the type.
+// It returns nil if the string is not a valid lexical form and an error if a duration overflows.
+func castString(s string, ty object.Type) object.Item {
+	s = strings.TrimSpace(s)
+
+	switch ty {
+	case object.DateTimeType:
+		m := dateTimePattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil
+		}
+		t, tz, ok := buildTime(m[1], m[2], m[3], m[4], m[5], m[6], m[7], m[8])
+		if !ok {
+			return nil
+		}
+		return NewDateTime(t, tz)
+	case object.DateType:
+		m := datePattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil
+		}
+		t, tz, ok := buildTime(m[1], m[2], m[3], "00", "00", "00", "", m[4])
+		if !ok {
+			return nil
+		}
+		return NewDate(t, tz)
+	case object.TimeType:
+		m := timePattern.FindStringSubmatch(s)
+		if m == nil {
+			return nil
+		}
+		t, tz, ok := buildTime("1972", "12", "31", m[1], m[2], m[3], m[4], m[5])
+		if !ok {
+			return nil
+		}
+		return NewTime(t, tz)
+	case object.DurationType, object.DayTimeDurationType, object.YearMonthDurationType:
+		m := durationPattern.FindStringSubmatch(s)
+		if m == nil || s == "P" || s == "-P" || strings.HasSuffix(s, "T") {
+			return nil
+		}
+
+		months, dur, ok := durationValue(m[2], m[3], m[4], m[5], m[6], m[7])
+		if !ok {
+			return NewCodedError("FODT0002", "duration overflow: %s", s)
+		}
+		if m[1] == "-" {
+			months = -months
+			dur = -dur
+		}
+
+		switch ty {
+		case object.DayTimeDurationType:
+			if m[2] != "" || m[3] != "" {
+				return nil
+			}
+			return NewDayTimeDuration(dur)
+		case object.YearMonthDurationType:
+			if m[4] != "" || strings.Contains(s, "T") {
+				return nil
+			}
+			return NewYearMonthDuration(months)
+		}
+		return NewDuration(months, dur)
+	}
+
+	return nil
+}
+
+// durationValue returns the months and the day-time part of the components of a duration lexical form
+// ok is false if they do not fit in an int and a time.Duration
+func durationValue(years, months, days, hours, minutes, seconds string) (int, time.Duration, bool) {
+	y, ok1 := durationComponent(years, 12)
+	mo, ok2 := durationComponent(months, 1)
+	ym, ok3 := addInt64(y, mo)
+	if !ok1 || !ok2 || !ok3 {
+		return 0, 0, false
+	}
+
+	var dur int64
+	for _, c := range []struct {
+		s    string
+		unit time.Duration
+	}{{days, 24 * time.Hour}, {hours, time.Hour}, {minutes, time.Minute}} {
+		n, ok := durationComponent(c.s, int64(c.unit))
+		if !ok {
+			return 0, 0, false
+		}
+		if dur, ok = addInt64(dur, n); !ok {
+			return 0, 0, false
+		}
+	}
+
+	secs, _ := strconv.ParseFloat(seconds, 64)
+	ns := math.Round(secs * 1e9)
+	if ns >= math.MaxInt64 {
+		return 0, 0, false
+	}
+	dur, ok := addInt64(dur, int64(ns))
+	return int(ym), time.Duration(dur), ok
+}
+
+// durationComponent returns the number in s multiplied by unit, an empty s is zero
+func durationComponent(s string, unit int64) (int64, bool) {
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n > math.MaxInt64/unit {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+// buildTime validates the components of a date/time lexical form and builds the time.Time
+func buildTime(year, month, day, hour, min, sec, frac, zone string) (time.Time, bool, bool) {
+	y, mo, d := atoi(year), atoi(month), atoi(day)
+	h, mi, s := atoi(hour), atoi(min), atoi(sec)
+
+	if mo < 1 || mo > 12 || d < 1 || d > daysIn(y, time.Month(mo)) {
+		return time.Time{}, false, false
+	}
+	if mi > 59 || s > 59 || h > 24 || (h == 24 && (mi != 0 || s != 0 || strings.Trim(frac, ".0") != "")) {
+		return time.Time{}, false, false
+	}
+
+	var ns int
+	if frac != "" {
+		f, _ := strconv.ParseFloat("0"+frac, 64)
+		ns = int(math.Round(f * 1e9))
+	}
+
+	loc := time.UTC
+	tz := zone != ""
+	if tz && zone != "Z" {
+		zh, zm := atoi(zone[1:3]), atoi(zone[4:6])
+		if zh > 14 || zm > 59 || (zh == 14 && zm != 0) {
+			return time.Time{}, false, false
+		}
+		offset := zh*3600 + zm*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+
+	// 24:00:00 is the first instant of the following day
+	return time.Date(y, time.Month(mo), d, h, mi, s, ns, loc), tz, true
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func atoi(s string) int {
+	i, _ := strconv.Atoi(s)
+	return i
+}
@@ -8,7 +8,7 @@ import (
 
 func fnNodeName(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 0 {
-		return NewError("too many parameters for function call: fn:node-name")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:node-name")
 	}
 
 	seq := &object.Sequence{}
@@ -25,7 +25,7 @@ func fnNodeName(ctx *object.Context, args ...object.Item) object.Item {
 // include whitespace
 func fnString(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:string")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:string")
 	}
 
 	if len(args) == 1 {
@@ -43,13 +43,13 @@ func fnString(ctx *object.Context, args ...object.Item) object.Item {
 		return seq
 	}
 
-	return NewError("context node is undefined")
+	return NewCodedError("XPDY0002", "context node is undefined")
 }
 
 // exclude whitespace
 func fnData(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:data")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:data")
 	}
 
 	if len(args) == 1 {
@@ -74,15 +74,40 @@ func fnData(ctx *object.Context, args ...object.Item) object.Item {
 		return seq
 	}
 
-	return NewError("context node is undefined")
+	return NewCodedError("XPDY0002", "context node is undefined")
 }
 
+// fnBaseURI returns the uri that the document of the node is loaded from,
+// the base uri of the context is returned for the other nodes and if the argument is omitted
 func fnBaseURI(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 1 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:base-uri")
+	}
+	if len(args) == 1 {
+		items := UnwrapSeq(args[0])
+		switch {
+		case len(items) == 0:
+			return NewSequence()
+		case len(items) > 1:
+			return NewCodedError("XPTY0004", "fn:base-uri: a sequence of more than one item is not allowed as the argument")
+		}
+
+		n, ok := items[0].(object.Node)
+		if !ok {
+			return NewCodedError("XPTY0004", "fn:base-uri: the argument is not a node: %s", TypeName(items[0]))
+		}
+		if ctx.Doc == nil || object.TreeRoot(n) != object.TreeRoot(ctx.Doc) {
+			if uri, ok := ctx.DocumentURI(n); ok {
+				return NewString(uri)
+			}
+		}
+	}
 	return NewString(ctx.BaseURI)
 }
 
 func collectText(texts []string, n object.Node) []string {
-	if n.Type() == object.ElementNodeType || n.Type() == object.AttributeNodeType {
+	switch n.Type() {
+	case object.ElementNodeType, object.AttributeNodeType, object.NamespaceNodeType, object.PINodeType:
 		texts = append(texts, n.Text())
 	}
 	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
@@ -0,0 +1,152 @@
+package bif
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zzossig/rabbit/ast"
+	"github.com/zzossig/rabbit/object"
+)
+
+// ErrNamespace is the namespace of the error codes, it is bound to the prefix err
+const ErrNamespace = "http://www.w3.org/2005/xqt-errors"
+
+func fnError(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:error")
+	}
+
+	e := NewCodedError("FOER0000", "error raised by fn:error")
+	if len(args) == 0 || IsSeqEmpty(args[0]) {
+		return e
+	}
+
+	code, ok := UnwrapSeq(args[0])[0].(*object.String)
+	if !ok || len(UnwrapSeq(args[0])) > 1 {
+		return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=xs:QName?", TypeName(args[0]))
+	}
+	e.Code = errorCode(code.Value())
+
+	if len(args) > 1 {
+		desc := UnwrapSeq(args[1])
+		if len(desc) != 1 || desc[0].Type() != object.StringType {
+			return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=xs:string", TypeName(args[1]))
+		}
+		e.Message = desc[0].(*object.String).Value()
+	}
+	if len(args) > 2 {
+		e.Value = args[2]
+	}
+
+	return e
+}
+
+func fnTrace(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:trace")
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:trace")
+	}
+
+	var label string
+	if len(args) == 2 {
+		items := UnwrapSeq(args[1])
+		if len(items) > 1 || len(items) == 1 && items[0].Type() != object.StringType {
+			return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=xs:string?", TypeName(args[1]))
+		}
+		if len(items) == 1 {
+			label = items[0].(*object.String).Value()
+		}
+	}
+
+	sep := ", "
+	var sb strings.Builder
+	if e := writeAdaptive(&sb, args[0], &serializeParams{itemSeparator: &sep}); e != nil {
+		return e
+	}
+
+	if trace := ctx.TraceFunc(); trace != nil {
+		trace(label, sb.String())
+	} else if label != "" {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", label, sb.String())
+	} else {
+		fmt.Fprintln(os.Stderr, sb.String())
+	}
+
+	return args[0]
+}
+
+// errorCode normalizes the code of fn:error, a code in the error namespace is written with the prefix err
+func errorCode(code string) string {
+	if strings.HasPrefix(code, "Q{"+ErrNamespace+"}") {
+		return "err:" + strings.TrimPrefix(code, "Q{"+ErrNamespace+"}")
+	}
+	return code
+}
+
+// errorCodeName splits the error code into the namespace uri and the local name.
+// The prefix of the code is resolved like the prefixes in name tests, an unbound prefix is kept in the local name.
+func errorCodeName(ctx *object.Context, code string) (string, string) {
+	if strings.HasPrefix(code, "Q{") {
+		if i := strings.Index(code, "}"); i > 0 {
+			return code[2:i], code[i+1:]
+		}
+	}
+	if i := strings.Index(code, ":"); i > 0 {
+		if uri, ok := errorNamespaceURI(ctx, code[:i]); ok {
+			return uri, code[i+1:]
+		}
+	}
+	return "", code
+}
+
+func errorNamespaceURI(ctx *object.Context, prefix string) (string, bool) {
+	if prefix == "err" {
+		return ErrNamespace, true
+	}
+	return NamespaceURI(ctx, prefix)
+}
+
+// IsErrorMatch checks if the error code matches the name test of a catch clause.
+// An error without a code is matched only by the wildcard *.
+func IsErrorMatch(code string, t *ast.NameTest, ctx *object.Context) bool {
+	if t.TypeID == 2 && t.Wildcard.TypeID == 1 {
+		return true
+	}
+	if code == "" {
+		return false
+	}
+
+	uri, local := errorCodeName(ctx, code)
+	switch t.TypeID {
+	case 1:
+		if t.EQName.TypeID == 2 {
+			qn := t.EQName.URIQualifiedName
+			return local == qn.NCName.Value() && uri == qn.BracedURILiteral.URI()
+		}
+
+		qn := t.EQName.QName
+		if qn.Prefix() == "" {
+			return uri == "" && local == qn.Local()
+		}
+		if testURI, ok := errorNamespaceURI(ctx, qn.Prefix()); ok {
+			return local == qn.Local() && uri == testURI
+		}
+		return code == qn.Value()
+	case 2:
+		switch t.Wildcard.TypeID {
+		case 2:
+			if testURI, ok := errorNamespaceURI(ctx, t.Wildcard.NCName.Value()); ok {
+				return uri == testURI
+			}
+			return strings.HasPrefix(code, t.Wildcard.NCName.Value()+":")
+		case 3:
+			return local == t.Wildcard.NCName.Value()
+		case 4:
+			return uri == t.Wildcard.BracedURILiteral.URI()
+		}
+	}
+	return false
+}
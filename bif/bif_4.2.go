@@ -49,7 +49,7 @@ func numericAdd(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(leftVal + rightVal)
 	}
 
-	return NewError("cannot add types: %s, %s", arg1.Type(), arg2.Type())
+	return NewCodedError("XPTY0004", "cannot add types: %s, %s", TypeName(arg1), TypeName(arg2))
 }
 
 func numericSubtract(ctx *object.Context, args ...object.Item) object.Item {
@@ -95,7 +95,7 @@ func numericSubtract(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(leftVal - rightVal)
 	}
 
-	return NewError("cannot subtract types: %s, %s", arg1.Type(), arg2.Type())
+	return NewCodedError("XPTY0004", "cannot subtract types: %s, %s", TypeName(arg1), TypeName(arg2))
 }
 
 func numericMultiply(ctx *object.Context, args ...object.Item) object.Item {
@@ -141,13 +141,17 @@ func numericMultiply(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(leftVal * rightVal)
 	}
 
-	return NewError("cannot add multiply: %s, %s", arg1.Type(), arg2.Type())
+	return NewCodedError("XPTY0004", "cannot add multiply: %s, %s", TypeName(arg1), TypeName(arg2))
 }
 
 func numericDivide(ctx *object.Context, args ...object.Item) object.Item {
 	arg1 := args[0]
 	arg2 := args[1]
 
+	if arg1.Type() != object.DoubleType && isDecimalZero(arg2) {
+		return NewCodedError("FOAR0001", "division by zero")
+	}
+
 	switch {
 	case arg1.Type() == object.IntegerType && arg2.Type() == object.IntegerType:
 		leftVal := arg1.(*object.Integer).Value()
@@ -187,13 +191,17 @@ func numericDivide(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(leftVal / rightVal)
 	}
 
-	return NewError("cannot divide types: %s, %s", arg1.Type(), arg2.Type())
+	return NewCodedError("XPTY0004", "cannot divide types: %s, %s", TypeName(arg1), TypeName(arg2))
 }
 
 func numericIntegerDivide(ctx *object.Context, args ...object.Item) object.Item {
 	arg1 := args[0]
 	arg2 := args[1]
 
+	if d, ok := arg2.(*object.Double); isDecimalZero(arg2) || ok && d.Value() == 0 {
+		return NewCodedError("FOAR0001", "division by zero")
+	}
+
 	switch {
 	case arg1.Type() == object.IntegerType && arg2.Type() == object.IntegerType:
 		leftVal := arg1.(*object.Integer).Value()
@@ -233,13 +241,17 @@ func numericIntegerDivide(ctx *object.Context, args ...object.Item) object.Item
 		return NewInteger(int(leftVal / rightVal))
 	}
 
-	return NewError("cannot integer divide types: %s, %s", arg1.Type(), arg2.Type())
+	return NewCodedError("XPTY0004", "cannot integer divide types: %s, %s", TypeName(arg1), TypeName(arg2))
 }
 
 func numericMod(ctx *object.Context, args ...object.Item) object.Item {
 	arg1 := args[0]
 	arg2 := args[1]
 
+	if arg1.Type() != object.DoubleType && isDecimalZero(arg2) {
+		return NewCodedError("FOAR0001", "division by zero")
+	}
+
 	switch {
 	case arg1.Type() == object.IntegerType && arg2.Type() == object.IntegerType:
 		leftVal := arg1.(*object.Integer).Value()
@@ -279,7 +291,7 @@ func numericMod(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(math.Mod(leftVal, rightVal))
 	}
 
-	return NewError("cannot mod types: %s, %s", arg1.Type(), arg2.Type())
+	return NewCodedError("XPTY0004", "cannot mod types: %s, %s", TypeName(arg1), TypeName(arg2))
 }
 
 func numericUnaryPlus(ctx *object.Context, args ...object.Item) object.Item {
@@ -297,7 +309,7 @@ func numericUnaryPlus(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(rightVal)
 	}
 
-	return NewError("cannot unary plus in type: %s", arg.Type())
+	return NewCodedError("XPTY0004", "cannot unary plus in type: %s", TypeName(arg))
 }
 
 func numericUnaryMinus(ctx *object.Context, args ...object.Item) object.Item {
@@ -315,5 +327,16 @@ func numericUnaryMinus(ctx *object.Context, args ...object.Item) object.Item {
 		return NewDouble(-1 * rightVal)
 	}
 
-	return NewError("cannot unary minus in type: %s", arg.Type())
+	return NewCodedError("XPTY0004", "cannot unary minus in type: %s", TypeName(arg))
+}
+
+// isDecimalZero checks if the item is an integer or decimal zero, the division of them by zero is an error(FOAR0001)
+func isDecimalZero(item object.Item) bool {
+	switch item := item.(type) {
+	case *object.Integer:
+		return item.Value() == 0
+	case *object.Decimal:
+		return item.Value() == 0
+	}
+	return false
 }
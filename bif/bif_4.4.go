@@ -8,115 +8,105 @@ import (
 
 func fnAbs(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:abs")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:abs")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:abs")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:abs")
 	}
 
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		v := arg.Value()
 		if v < 0 {
-			arg.SetValue(-v)
+			return NewInteger(-v)
 		}
 		return arg
 	case *object.Decimal:
-		arg.SetValue(math.Abs(arg.Value()))
-		return arg
+		return NewDecimal(math.Abs(arg.Value()))
 	case *object.Double:
-		arg.SetValue(math.Abs(arg.Value()))
-		return arg
+		return NewDouble(math.Abs(arg.Value()))
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
 
 func fnCeiling(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:ceiling")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:ceiling")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:ceiling")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:ceiling")
 	}
 
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		return arg
 	case *object.Decimal:
-		arg.SetValue(math.Ceil(arg.Value()))
-		return arg
+		return NewDecimal(math.Ceil(arg.Value()))
 	case *object.Double:
-		arg.SetValue(math.Ceil(arg.Value()))
-		return arg
+		return NewDouble(math.Ceil(arg.Value()))
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
 
 func fnFloor(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:floor")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:floor")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:floor")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:floor")
 	}
 
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		return arg
 	case *object.Decimal:
-		arg.SetValue(math.Floor(arg.Value()))
-		return arg
+		return NewDecimal(math.Floor(arg.Value()))
 	case *object.Double:
-		arg.SetValue(math.Floor(arg.Value()))
-		return arg
+		return NewDouble(math.Floor(arg.Value()))
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
 
 func fnRound(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:round")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:round")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:round")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:round")
 	}
 
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		return arg
 	case *object.Decimal:
-		arg.SetValue(math.Round(arg.Value()))
-		return arg
+		return NewDecimal(math.Round(arg.Value()))
 	case *object.Double:
-		arg.SetValue(math.Round(arg.Value()))
-		return arg
+		return NewDouble(math.Round(arg.Value()))
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
 
 // round-half-to-even
 func fnRoundHTE(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:round-half-to-even")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:round-half-to-even")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:round-half-to-even")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:round-half-to-even")
 	}
 
 	switch arg := args[0].(type) {
 	case *object.Integer:
 		return arg
 	case *object.Decimal:
-		arg.SetValue(math.RoundToEven(arg.Value()))
-		return arg
+		return NewDecimal(math.RoundToEven(arg.Value()))
 	case *object.Double:
-		arg.SetValue(math.RoundToEven(arg.Value()))
-		return arg
+		return NewDouble(math.RoundToEven(arg.Value()))
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
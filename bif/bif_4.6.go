@@ -0,0 +1,406 @@
+package bif
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+var integerModifier = regexp.MustCompile(`^([co](\(.+\))?)?[at]?$`)
+
+var (
+	wordOnes = []string{
+		"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen",
+	}
+	wordTens   = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	wordScales = []string{"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion"}
+	wordOrds   = map[string]string{
+		"one": "first", "two": "second", "three": "third", "five": "fifth",
+		"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+	}
+)
+
+func fnFormatInteger(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:format-integer")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:format-integer")
+	}
+
+	arg := args[0]
+	if seq, ok := arg.(*object.Sequence); ok {
+		switch len(seq.Items) {
+		case 0:
+			return NewString("")
+		case 1:
+			arg = seq.Items[0]
+		default:
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(seq.Items))
+		}
+	}
+	if IsString(arg) || IsNode(arg) {
+		arg = CastType(arg, object.IntegerType)
+		if IsError(arg) {
+			return arg
+		}
+	}
+	n, ok := arg.(*object.Integer)
+	if !ok {
+		return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=%s", TypeName(arg), object.IntegerType)
+	}
+
+	picture, e := stringArg(args[1])
+	if e != nil {
+		return e
+	}
+
+	primary, modifier := picture, ""
+	if i := strings.LastIndex(picture, ";"); i >= 0 {
+		primary, modifier = picture[:i], picture[i+1:]
+	}
+	if primary == "" {
+		return NewCodedError("FODF1310", "invalid picture string for fn:format-integer: %q", picture)
+	}
+	if !integerModifier.MatchString(modifier) {
+		return NewCodedError("FODF1310", "invalid format modifier for fn:format-integer: %q", modifier)
+	}
+
+	s, e := formatNumbering(n.Value(), primary, strings.HasPrefix(modifier, "o"))
+	if e != nil {
+		return e
+	}
+	return NewString(s)
+}
+
+// digitPattern is a parsed decimal digit pattern such as 001 or #,##0
+type digitPattern struct {
+	zero      rune
+	mandatory int
+	digits    int
+	groups    []int
+	seps      []rune
+	regular   int
+}
+
+// formatNumbering formats an integer using a primary format token of fn:format-integer.
+// It is also used for the numeric components of fn:format-dateTime.
+func formatNumbering(n int, token string, ordinal bool) (string, *object.Error) {
+	if n < 0 {
+		s, e := formatNumbering(-n, token, ordinal)
+		return "-" + s, e
+	}
+
+	switch token {
+	case "A", "a":
+		if n == 0 {
+			break
+		}
+		s := alphabetic(n)
+		if token == "A" {
+			s = strings.ToUpper(s)
+		}
+		return s, nil
+	case "I", "i":
+		if n == 0 || n >= 4000 {
+			break
+		}
+		s := roman(n)
+		if token == "i" {
+			s = strings.ToLower(s)
+		}
+		return s, nil
+	case "W", "w", "Ww":
+		s := words(n)
+		if ordinal {
+			s = ordinalWords(s)
+		}
+		return caseWords(s, token), nil
+	default:
+		dp, ok, e := parseDigitPattern(token)
+		if e != nil {
+			return "", e
+		}
+		if ok {
+			s := dp.format(n, dp.mandatory)
+			if ordinal {
+				s += ordinalSuffix(n)
+			}
+			return s, nil
+		}
+	}
+
+	// an unsupported numbering sequence falls back to the format token 1
+	s := strconv.Itoa(n)
+	if ordinal {
+		s += ordinalSuffix(n)
+	}
+	return s, nil
+}
+
+// parseDigitPattern parses a decimal digit pattern.
+// ok is false if the token is not a decimal digit pattern.
+func parseDigitPattern(token string) (*digitPattern, bool, *object.Error) {
+	dp := &digitPattern{}
+	runes := []rune(token)
+
+	for _, r := range runes {
+		switch {
+		case unicode.IsDigit(r):
+			z := zeroDigit(r)
+			if dp.zero != 0 && dp.zero != z {
+				return nil, false, NewCodedError("FODF1310", "invalid decimal digit pattern: %q", token)
+			}
+			dp.zero = z
+		case r == '#':
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			return nil, false, nil
+		}
+	}
+	if dp.zero == 0 {
+		return nil, false, nil
+	}
+
+	count := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		r := runes[i]
+		switch {
+		case unicode.IsDigit(r):
+			dp.mandatory++
+			count++
+		case r == '#':
+			count++
+		default:
+			if i == 0 || i == len(runes)-1 || !isPatternDigit(runes[i-1]) || !isPatternDigit(runes[i+1]) {
+				return nil, false, NewCodedError("FODF1310", "invalid decimal digit pattern: %q", token)
+			}
+			dp.groups = append(dp.groups, count)
+			dp.seps = append(dp.seps, r)
+		}
+	}
+	dp.digits = count
+
+	// a mandatory digit followed by an optional digit such as 0# is not allowed
+	seenDigit := false
+	for _, r := range runes {
+		if unicode.IsDigit(r) {
+			seenDigit = true
+		} else if r == '#' && seenDigit {
+			return nil, false, NewCodedError("FODF1310", "invalid decimal digit pattern: %q", token)
+		}
+	}
+
+	dp.regular = regularGrouping(dp.groups, dp.seps)
+	return dp, true, nil
+}
+
+// format converts n to the digits of the pattern, padded to at least min digits
+func (dp *digitPattern) format(n int, min int) string {
+	s := strconv.Itoa(n)
+	for len(s) < min {
+		s = "0" + s
+	}
+	return dp.group(translateDigits(s, dp.zero))
+}
+
+// group inserts the grouping separators of the pattern into a digit string
+func (dp *digitPattern) group(s string) string {
+	if len(dp.groups) == 0 {
+		return s
+	}
+
+	digits := []rune(s)
+	var sb []rune
+	for i := range digits {
+		pos := len(digits) - i
+		if i > 0 {
+			if dp.regular > 0 && pos%dp.regular == 0 {
+				sb = append(sb, dp.seps[0])
+			} else if dp.regular == 0 {
+				for j, g := range dp.groups {
+					if g == pos {
+						sb = append(sb, dp.seps[j])
+					}
+				}
+			}
+		}
+		sb = append(sb, digits[i])
+	}
+	return string(sb)
+}
+
+// regularGrouping returns the interval of the grouping separators if they are
+// the same character and placed at regular intervals, otherwise it returns 0
+func regularGrouping(groups []int, seps []rune) int {
+	if len(groups) == 0 {
+		return 0
+	}
+	for i := range groups {
+		if seps[i] != seps[0] || groups[i] != groups[0]*(i+1) {
+			return 0
+		}
+	}
+	return groups[0]
+}
+
+func isPatternDigit(r rune) bool {
+	return r == '#' || unicode.IsDigit(r)
+}
+
+// zeroDigit returns the zero digit of the decimal digit family that r belongs to
+func zeroDigit(r rune) rune {
+	z := r
+	for r-z < 9 && unicode.IsDigit(z-1) {
+		z--
+	}
+	return z
+}
+
+// translateDigits translates ascii digits to the decimal digit family that starts with zero
+func translateDigits(s string, zero rune) string {
+	if zero == '0' {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return zero + r - '0'
+		}
+		return r
+	}, s)
+}
+
+func alphabetic(n int) string {
+	var s []byte
+	for n > 0 {
+		n--
+		s = append([]byte{byte('a' + n%26)}, s...)
+		n /= 26
+	}
+	return string(s)
+}
+
+func roman(n int) string {
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+
+	var sb strings.Builder
+	for i, v := range values {
+		for n >= v {
+			sb.WriteString(symbols[i])
+			n -= v
+		}
+	}
+	return sb.String()
+}
+
+func words(n int) string {
+	if n == 0 {
+		return wordOnes[0]
+	}
+
+	var chunks []int
+	for ; n > 0; n /= 1000 {
+		chunks = append(chunks, n%1000)
+	}
+
+	var parts []string
+	for i := len(chunks) - 1; i >= 0; i-- {
+		c := chunks[i]
+		if c == 0 {
+			continue
+		}
+		if i == 0 && c < 100 && len(parts) > 0 {
+			parts = append(parts, "and")
+		}
+		parts = append(parts, hundredWords(c))
+		if wordScales[i] != "" {
+			parts = append(parts, wordScales[i])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func hundredWords(n int) string {
+	var parts []string
+	if h := n / 100; h > 0 {
+		parts = append(parts, wordOnes[h], "hundred")
+		if n%100 > 0 {
+			parts = append(parts, "and")
+		}
+	}
+
+	switch r := n % 100; {
+	case r == 0:
+	case r < 20:
+		parts = append(parts, wordOnes[r])
+	case r%10 == 0:
+		parts = append(parts, wordTens[r/10])
+	default:
+		parts = append(parts, wordTens[r/10]+"-"+wordOnes[r%10])
+	}
+	return strings.Join(parts, " ")
+}
+
+func ordinalWords(s string) string {
+	i := strings.LastIndexAny(s, " -") + 1
+	last := s[i:]
+
+	switch {
+	case wordOrds[last] != "":
+		last = wordOrds[last]
+	case strings.HasSuffix(last, "y"):
+		last = last[:len(last)-1] + "ieth"
+	default:
+		last += "th"
+	}
+	return s[:i] + last
+}
+
+func ordinalSuffix(n int) string {
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
+
+// caseWords changes the case of words according to the format token W, w or Ww
+func caseWords(s string, token string) string {
+	switch token {
+	case "W":
+		return strings.ToUpper(s)
+	case "Ww":
+		fields := strings.Split(s, " ")
+		for i, f := range fields {
+			if f != "and" {
+				fields[i] = titleCase(f)
+			}
+		}
+		return strings.Join(fields, " ")
+	}
+	return s
+}
+
+// titleCase upper-cases the first letter of every hyphenated part of s
+func titleCase(s string) string {
+	parts := strings.Split(s, "-")
+	for i, p := range parts {
+		if p != "" {
+			r := []rune(p)
+			r[0] = unicode.ToUpper(r[0])
+			parts[i] = string(r)
+		}
+	}
+	return strings.Join(parts, "-")
+}
@@ -0,0 +1,356 @@
+package bif
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+// subPicture is a parsed sub-picture of fn:format-number
+// https://www.w3.org/TR/xpath-functions-31/#analyzing-picture-string
+type subPicture struct {
+	prefix     string
+	suffix     string
+	minInt     int
+	intGroups  []int
+	regular    int
+	minFrac    int
+	maxFrac    int
+	fracGroups []int
+	hasExp     bool
+	minExp     int
+	percent    bool
+	permille   bool
+}
+
+func fnFormatNumber(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:format-number")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:format-number")
+	}
+
+	v, e := numberArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	picture, e := stringArg(args[1])
+	if e != nil {
+		return e
+	}
+
+	name := ""
+	if len(args) == 3 {
+		name, e = stringArg(args[2])
+		if e != nil {
+			return e
+		}
+	}
+
+	df, ok := ctx.DecimalFormat(name)
+	if !ok {
+		return NewCodedError("FODF1280", "decimal format %q is not defined", name)
+	}
+
+	pics, e := parsePicture(picture, df)
+	if e != nil {
+		return e
+	}
+	return NewString(formatNumber(v, pics, df))
+}
+
+// numberArg converts the first argument of fn:format-number to float64.
+// An empty sequence is treated as NaN.
+func numberArg(item object.Item) (float64, *object.Error) {
+	switch item := item.(type) {
+	case *object.Integer:
+		return float64(item.Value()), nil
+	case *object.Decimal:
+		return item.Value(), nil
+	case *object.Double:
+		return item.Value(), nil
+	case *object.BaseNode, *object.AttrNode:
+		d := CastType(item, object.DoubleType)
+		if IsError(d) {
+			return 0, d.(*object.Error)
+		}
+		return d.(*object.Double).Value(), nil
+	case *object.Sequence:
+		switch len(item.Items) {
+		case 0:
+			return math.NaN(), nil
+		case 1:
+			return numberArg(item.Items[0])
+		default:
+			return 0, NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(item.Items))
+		}
+	}
+	return 0, NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=xs:numeric", TypeName(item))
+}
+
+func parsePicture(picture string, df *object.DecimalFormat) ([]*subPicture, *object.Error) {
+	parts := strings.Split(picture, string(df.PatternSeparator))
+	if len(parts) > 2 {
+		return nil, NewCodedError("FODF1310", "invalid picture string for fn:format-number: %q", picture)
+	}
+
+	var pics []*subPicture
+	for _, part := range parts {
+		sp, ok := parseSubPicture([]rune(part), df)
+		if !ok {
+			return nil, NewCodedError("FODF1310", "invalid picture string for fn:format-number: %q", picture)
+		}
+		pics = append(pics, sp)
+	}
+	return pics, nil
+}
+
+func parseSubPicture(pic []rune, df *object.DecimalFormat) (*subPicture, bool) {
+	isDigit := func(r rune) bool { return r >= df.ZeroDigit && r <= df.ZeroDigit+9 }
+	isMantissa := func(r rune) bool {
+		return isDigit(r) || r == df.Digit || r == df.DecimalSeparator || r == df.GroupingSeparator
+	}
+
+	// the exponent separator is active only if it is surrounded by active characters
+	first, last := -1, -1
+	for i, r := range pic {
+		if isMantissa(r) {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 {
+		return nil, false
+	}
+
+	sp := &subPicture{prefix: string(pic[:first]), suffix: string(pic[last+1:])}
+	mantissa := pic[first : last+1]
+
+	for _, r := range pic {
+		switch r {
+		case df.Percent:
+			if sp.percent || sp.permille {
+				return nil, false
+			}
+			sp.percent = true
+		case df.PerMille:
+			if sp.percent || sp.permille {
+				return nil, false
+			}
+			sp.permille = true
+		}
+	}
+
+	var exp []rune
+	for i, r := range mantissa {
+		if !isMantissa(r) {
+			if r != df.ExponentSeparator || sp.hasExp {
+				return nil, false
+			}
+			sp.hasExp = true
+			exp = mantissa[i+1:]
+			mantissa = mantissa[:i]
+			break
+		}
+	}
+	for _, r := range exp {
+		if !isDigit(r) {
+			return nil, false
+		}
+		sp.minExp++
+	}
+
+	intPart, fracPart := mantissa, []rune{}
+	for i, r := range mantissa {
+		if r == df.DecimalSeparator {
+			intPart, fracPart = mantissa[:i], mantissa[i+1:]
+			break
+		}
+	}
+	for _, r := range fracPart {
+		if r == df.DecimalSeparator {
+			return nil, false
+		}
+	}
+
+	digits := 0
+	for i, r := range intPart {
+		switch {
+		case isDigit(r):
+			sp.minInt++
+			digits++
+		case r == df.Digit:
+			if sp.minInt > 0 {
+				return nil, false
+			}
+			digits++
+		case r == df.GroupingSeparator:
+			if i == len(intPart)-1 || intPart[i+1] == df.GroupingSeparator {
+				return nil, false
+			}
+		}
+	}
+	count := 0
+	for i := len(intPart) - 1; i >= 0; i-- {
+		if intPart[i] == df.GroupingSeparator {
+			sp.intGroups = append(sp.intGroups, count)
+		} else {
+			count++
+		}
+	}
+
+	for i, r := range fracPart {
+		switch {
+		case isDigit(r):
+			if sp.maxFrac > sp.minFrac {
+				return nil, false
+			}
+			sp.minFrac++
+			sp.maxFrac++
+			digits++
+		case r == df.Digit:
+			sp.maxFrac++
+			digits++
+		case r == df.GroupingSeparator:
+			if i == 0 {
+				return nil, false
+			}
+			sp.fracGroups = append(sp.fracGroups, sp.maxFrac)
+		}
+	}
+	if digits == 0 {
+		return nil, false
+	}
+
+	seps := make([]rune, len(sp.intGroups))
+	for i := range seps {
+		seps[i] = df.GroupingSeparator
+	}
+	sp.regular = regularGrouping(sp.intGroups, seps)
+
+	if sp.minInt == 0 && sp.maxFrac == 0 {
+		if sp.hasExp {
+			sp.minFrac = 1
+			sp.maxFrac = 1
+		} else {
+			sp.minInt = 1
+		}
+	}
+	return sp, true
+}
+
+func formatNumber(v float64, pics []*subPicture, df *object.DecimalFormat) string {
+	if math.IsNaN(v) {
+		return df.NaN
+	}
+
+	sp := pics[0]
+	prefix := sp.prefix
+	if v < 0 || (v == 0 && math.Signbit(v)) {
+		v = -v
+		if len(pics) > 1 {
+			sp = pics[1]
+			prefix = sp.prefix
+		} else {
+			prefix = string(df.MinusSign) + prefix
+		}
+	}
+
+	if sp.percent {
+		v *= 100
+	} else if sp.permille {
+		v *= 1000
+	}
+	if math.IsInf(v, 0) {
+		return prefix + df.Infinity + sp.suffix
+	}
+
+	exp := 0
+	if sp.hasExp && v != 0 {
+		exp = int(math.Floor(math.Log10(v))) + 1 - sp.minInt
+		v /= math.Pow10(exp)
+	}
+
+	intDigits, fracDigits := roundDigits(v, sp)
+	if sp.hasExp && len(strings.TrimLeft(intDigits, "0")) > sp.minInt {
+		exp++
+		intDigits, fracDigits = roundDigits(v/10, sp)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	sb.WriteString(groupDigits(intDigits, sp, df))
+	if fracDigits != "" {
+		sb.WriteRune(df.DecimalSeparator)
+		for i, r := range []rune(translateDigits(fracDigits, df.ZeroDigit)) {
+			for _, g := range sp.fracGroups {
+				if g == i && i > 0 {
+					sb.WriteRune(df.GroupingSeparator)
+				}
+			}
+			sb.WriteRune(r)
+		}
+	}
+	if sp.hasExp {
+		sb.WriteRune(df.ExponentSeparator)
+		if exp < 0 {
+			sb.WriteRune(df.MinusSign)
+			exp = -exp
+		}
+		e := strconv.Itoa(exp)
+		for len(e) < sp.minExp {
+			e = "0" + e
+		}
+		sb.WriteString(translateDigits(e, df.ZeroDigit))
+	}
+	sb.WriteString(sp.suffix)
+	return sb.String()
+}
+
+// roundDigits rounds v to the maximum fractional digits of the sub-picture and
+// returns the integer and fractional digits padded to their minimum size
+func roundDigits(v float64, sp *subPicture) (string, string) {
+	s := strconv.FormatFloat(v, 'f', sp.maxFrac, 64)
+	intDigits, fracDigits := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intDigits, fracDigits = s[:i], s[i+1:]
+	}
+
+	intDigits = strings.TrimLeft(intDigits, "0")
+	for len(intDigits) < sp.minInt {
+		intDigits = "0" + intDigits
+	}
+	for len(fracDigits) > sp.minFrac && fracDigits[len(fracDigits)-1] == '0' {
+		fracDigits = fracDigits[:len(fracDigits)-1]
+	}
+	if intDigits == "" && fracDigits == "" {
+		intDigits = "0"
+	}
+	return intDigits, fracDigits
+}
+
+func groupDigits(digits string, sp *subPicture, df *object.DecimalFormat) string {
+	var sb strings.Builder
+	for i, r := range []rune(translateDigits(digits, df.ZeroDigit)) {
+		pos := len(digits) - i
+		if i > 0 {
+			if sp.regular > 0 && pos%sp.regular == 0 {
+				sb.WriteRune(df.GroupingSeparator)
+			} else if sp.regular == 0 {
+				for _, g := range sp.intGroups {
+					if g == pos {
+						sb.WriteRune(df.GroupingSeparator)
+					}
+				}
+			}
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -8,25 +8,25 @@ import (
 
 func mathPI(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 0 {
-		return NewError("too many parameters for function call: math:pi")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:pi")
 	}
 	return NewDouble(math.Pi)
 }
 
 func mathExp(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:exp")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:exp")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:exp")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:exp")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	dbl := d.(*object.Double)
 	dbl.SetValue(math.Exp(dbl.Value()))
@@ -35,18 +35,18 @@ func mathExp(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathExp2(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:exp2")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:exp2")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:exp2")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:exp2")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	dbl := d.(*object.Double)
 	dbl.SetValue(math.Exp2(dbl.Value()))
@@ -55,18 +55,18 @@ func mathExp2(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathLog(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:log")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:log")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:log")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:log")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	dbl := d.(*object.Double)
 	dbl.SetValue(math.Log(dbl.Value()))
@@ -75,18 +75,18 @@ func mathLog(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathLog2(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:log2")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:log2")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:log2")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:log2")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	dbl := d.(*object.Double)
 	dbl.SetValue(math.Log2(dbl.Value()))
@@ -95,18 +95,18 @@ func mathLog2(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathLog10(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:log10")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:log10")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:log10")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:log10")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -116,19 +116,19 @@ func mathLog10(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathPow(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: math:pow")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:pow")
 	}
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: math:pow")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:pow")
 	}
 	if !IsNumeric(args[0]) || !IsNumeric(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d1 := CastType(args[0], object.DoubleType)
 	d2 := CastType(args[1], object.DoubleType)
 	if IsError(d1) || IsError(d2) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl1 := d1.(*object.Double)
@@ -138,18 +138,18 @@ func mathPow(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathSqrt(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:sqrt")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:sqrt")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:sqrt")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:sqrt")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -159,18 +159,18 @@ func mathSqrt(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathSin(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:sin")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:sin")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:sin")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:sin")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -180,18 +180,18 @@ func mathSin(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathCos(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:cos")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:cos")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:cos")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:cos")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -201,18 +201,18 @@ func mathCos(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathTan(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:tan")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:tan")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:tan")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:tan")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -222,18 +222,18 @@ func mathTan(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathAsin(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:asin")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:asin")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:asin")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:asin")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -243,18 +243,18 @@ func mathAsin(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathAcos(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:acos")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:acos")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:acos")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:acos")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -264,18 +264,18 @@ func mathAcos(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathAtan(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: math:atan")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:atan")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: math:atan")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:atan")
 	}
 	if !IsNumeric(args[0]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d := CastType(args[0], object.DoubleType)
 	if IsError(d) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl := d.(*object.Double)
@@ -285,19 +285,19 @@ func mathAtan(ctx *object.Context, args ...object.Item) object.Item {
 
 func mathAtan2(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: math:atan2")
+		return NewCodedError("XPST0017", "too many parameters for function call: math:atan2")
 	}
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: math:atan2")
+		return NewCodedError("XPST0017", "too few parameters for function call: math:atan2")
 	}
 	if !IsNumeric(args[0]) || !IsNumeric(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	d1 := CastType(args[0], object.DoubleType)
 	d2 := CastType(args[1], object.DoubleType)
 	if IsError(d1) || IsError(d2) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	dbl1 := d1.(*object.Double)
@@ -8,7 +8,7 @@ import (
 
 func fnNumber(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:number")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:number")
 	}
 
 	if len(args) == 1 {
@@ -32,5 +32,5 @@ func fnNumber(ctx *object.Context, args ...object.Item) object.Item {
 		return seq
 	}
 
-	return NewError("context node is undefined")
+	return NewCodedError("XPDY0002", "context node is undefined")
 }
@@ -8,10 +8,10 @@ import (
 
 func fnCodepointsToString(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:codepoints-to-string")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:codepoints-to-string")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:codepoints-to-string")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:codepoints-to-string")
 	}
 
 	if IsSeqEmpty(args[0]) || IsArrayEmpty(args[0]) {
@@ -27,7 +27,7 @@ func fnCodepointsToString(ctx *object.Context, args ...object.Item) object.Item
 		seq.Items = arg.Items
 	case *object.Integer:
 		if arg.Value() == 0 {
-			return NewError("not allowed value in fn:codepoints-to-string: 0")
+			return NewCodedError("FOCH0001", "not allowed value in fn:codepoints-to-string: 0")
 		}
 		seq.Items = append(seq.Items, arg)
 	default:
@@ -41,7 +41,7 @@ func fnCodepointsToString(ctx *object.Context, args ...object.Item) object.Item
 		case *object.Integer:
 			sb.WriteRune(rune(item.Value()))
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	}
 
@@ -50,10 +50,10 @@ func fnCodepointsToString(ctx *object.Context, args ...object.Item) object.Item
 
 func fnStringToCodepoints(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:string-to-codepoints")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:string-to-codepoints")
 	}
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:string-to-codepoints")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:string-to-codepoints")
 	}
 
 	if IsSeqEmpty(args[0]) || IsArrayEmpty(args[0]) {
@@ -65,18 +65,18 @@ func fnStringToCodepoints(ctx *object.Context, args ...object.Item) object.Item
 	switch arg := args[0].(type) {
 	case *object.Sequence:
 		if len(arg.Items) > 1 {
-			return NewError("too many items in the sequence")
+			return NewCodedError("XPTY0004", "too many items in the sequence")
 		}
 		return fnStringToCodepoints(ctx, arg.Items[0])
 	case *object.Array:
 		if len(arg.Items) > 1 {
-			return NewError("too many items in the array")
+			return NewCodedError("XPTY0004", "too many items in the array")
 		}
 		return fnStringToCodepoints(ctx, arg.Items[0])
 	case *object.String:
 		str = arg.Value()
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	result := &object.Sequence{}
@@ -8,7 +8,7 @@ import (
 
 func fnConcat(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:concat")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:concat")
 	}
 
 	seq := &object.Sequence{}
@@ -18,10 +18,10 @@ func fnConcat(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnStringJoin(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) == 0 {
-		return NewError("too few parameters for function call: fn:string-join")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:string-join")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: fn:string-join")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:string-join")
 	}
 
 	if len(args) == 1 {
@@ -47,7 +47,7 @@ func fnStringJoin(ctx *object.Context, args ...object.Item) object.Item {
 	}
 
 	if args[1].Type() != object.StringType {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if !IsSeq(args[0]) {
 		return CastType(args[0], object.StringType)
@@ -71,16 +71,16 @@ func fnStringJoin(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnSubstring(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:substring")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:substring")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:substring")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:substring")
 	}
 
 	if len(args) == 2 {
 		d := fnRound(nil, args[1])
 		if IsError(d) {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 
 		start := CastType(d, object.DoubleType)
@@ -108,7 +108,7 @@ func fnSubstring(ctx *object.Context, args ...object.Item) object.Item {
 	d1 := fnRound(nil, args[1])
 	d2 := fnRound(nil, args[2])
 	if IsError(d1) || IsError(d2) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 	if IsSeqEmpty(args[0]) {
 		return NewString("")
@@ -153,7 +153,7 @@ func fnSubstring(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnStringLength(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:string-length")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:string-length")
 	}
 
 	if len(args) == 1 {
@@ -163,7 +163,7 @@ func fnStringLength(ctx *object.Context, args ...object.Item) object.Item {
 
 		str, ok := args[0].(*object.String)
 		if !ok {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		return NewInteger(len(str.Value()))
 	}
@@ -178,7 +178,7 @@ func fnStringLength(ctx *object.Context, args ...object.Item) object.Item {
 	for _, item := range seqObj.Items {
 		item, ok := item.(*object.String)
 		if !ok {
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 		result.Items = append(result.Items, NewInteger(len(item.Value())))
 	}
@@ -188,7 +188,7 @@ func fnStringLength(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnNormalizeSpace(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("wrong number of arguments. got=%d, expected=0 or 1", len(args))
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, expected=0 or 1", len(args))
 	}
 
 	if len(args) == 1 {
@@ -215,12 +215,12 @@ func fnNormalizeSpace(ctx *object.Context, args ...object.Item) object.Item {
 		return seq
 	}
 
-	return NewError("context node is undefined")
+	return NewCodedError("XPDY0002", "context node is undefined")
 }
 
 func fnUpperCase(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1", len(args))
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	arg := args[0]
@@ -235,17 +235,17 @@ func fnUpperCase(ctx *object.Context, args ...object.Item) object.Item {
 	}
 	if seq, ok := arg.(*object.Sequence); ok {
 		if len(seq.Items) != 1 {
-			return NewError("wrong number of sequence items. got=%d, want=1", len(args))
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(args))
 		}
 		return fnUpperCase(ctx, seq.Items[0])
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
 
 func fnLowerCase(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) != 1 {
-		return NewError("wrong number of arguments. got=%d, want=1", len(args))
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	arg := args[0]
@@ -260,10 +260,10 @@ func fnLowerCase(ctx *object.Context, args ...object.Item) object.Item {
 	}
 	if seq, ok := arg.(*object.Sequence); ok {
 		if len(seq.Items) != 1 {
-			return NewError("wrong number of sequence items. got=%d, want=1", len(args))
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(args))
 		}
 		return fnLowerCase(ctx, seq.Items[0])
 	}
 
-	return NewError("cannot match item type with required type")
+	return NewCodedError("XPTY0004", "cannot match item type with required type")
 }
@@ -8,10 +8,10 @@ import (
 
 func fnContains(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:contains")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:contains")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:contains")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:contains")
 	}
 
 	var s string
@@ -31,12 +31,12 @@ func fnContains(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	case object.SequenceType:
 		seq := args[0].(*object.Sequence)
@@ -46,7 +46,7 @@ func fnContains(ctx *object.Context, args ...object.Item) object.Item {
 		if len(seq.Items) == 1 {
 			seqItem, ok := seq.Items[0].(*object.String)
 			if !ok {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			s = seqItem.Value()
 		}
@@ -62,15 +62,15 @@ func fnContains(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	isContain := strings.Contains(s, ss)
@@ -79,10 +79,10 @@ func fnContains(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnStartsWith(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:starts-with")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:starts-with")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:starts-with")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:starts-with")
 	}
 
 	var s string
@@ -102,12 +102,12 @@ func fnStartsWith(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	case object.SequenceType:
 		seq := args[0].(*object.Sequence)
@@ -117,7 +117,7 @@ func fnStartsWith(ctx *object.Context, args ...object.Item) object.Item {
 		if len(seq.Items) == 1 {
 			seqItem, ok := seq.Items[0].(*object.String)
 			if !ok {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			s = seqItem.Value()
 		}
@@ -133,15 +133,15 @@ func fnStartsWith(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	isSW := strings.HasPrefix(s, ss)
@@ -150,10 +150,10 @@ func fnStartsWith(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnEndsWith(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:ends-with")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:ends-with")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:ends-with")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:ends-with")
 	}
 
 	var s string
@@ -173,12 +173,12 @@ func fnEndsWith(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	case object.SequenceType:
 		seq := args[0].(*object.Sequence)
@@ -188,7 +188,7 @@ func fnEndsWith(ctx *object.Context, args ...object.Item) object.Item {
 		if len(seq.Items) == 1 {
 			seqItem, ok := seq.Items[0].(*object.String)
 			if !ok {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			s = seqItem.Value()
 		}
@@ -204,15 +204,15 @@ func fnEndsWith(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	isEW := strings.HasSuffix(s, ss)
@@ -220,10 +220,10 @@ func fnEndsWith(ctx *object.Context, args ...object.Item) object.Item {
 }
 func fnSubstringBefore(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:substring-before")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:substring-before")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:substring-before")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:substring-before")
 	}
 
 	var s string
@@ -243,12 +243,12 @@ func fnSubstringBefore(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	case object.SequenceType:
 		seq := args[0].(*object.Sequence)
@@ -258,7 +258,7 @@ func fnSubstringBefore(ctx *object.Context, args ...object.Item) object.Item {
 		if len(seq.Items) == 1 {
 			seqItem, ok := seq.Items[0].(*object.String)
 			if !ok {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			s = seqItem.Value()
 		}
@@ -274,15 +274,15 @@ func fnSubstringBefore(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	str := strings.TrimRight(s, ss)
@@ -294,10 +294,10 @@ func fnSubstringBefore(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnSubstringAfter(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: fn:substring-after")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:substring-after")
 	}
 	if len(args) > 3 {
-		return NewError("too many parameters for function call: fn:substring-after")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:substring-after")
 	}
 
 	var s string
@@ -317,12 +317,12 @@ func fnSubstringAfter(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	case object.SequenceType:
 		seq := args[0].(*object.Sequence)
@@ -332,7 +332,7 @@ func fnSubstringAfter(ctx *object.Context, args ...object.Item) object.Item {
 		if len(seq.Items) == 1 {
 			seqItem, ok := seq.Items[0].(*object.String)
 			if !ok {
-				return NewError("cannot match item type with required type")
+				return NewCodedError("XPTY0004", "cannot match item type with required type")
 			}
 			s = seqItem.Value()
 		}
@@ -348,15 +348,15 @@ func fnSubstringAfter(ctx *object.Context, args ...object.Item) object.Item {
 			if len(seq.Items) == 1 {
 				seqItem, ok := seq.Items[0].(*object.String)
 				if !ok {
-					return NewError("cannot match item type with required type")
+					return NewCodedError("XPTY0004", "cannot match item type with required type")
 				}
 				ss = seqItem.Value()
 			}
 		default:
-			return NewError("cannot match item type with required type")
+			return NewCodedError("XPTY0004", "cannot match item type with required type")
 		}
 	default:
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	str := strings.TrimLeft(s, ss)
@@ -0,0 +1,1389 @@
+package bif
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/util"
+	"golang.org/x/net/html"
+)
+
+// fnNS is the namespace of the elements produced by fn:analyze-string and fn:json-to-xml
+const fnNS = "http://www.w3.org/2005/xpath-functions"
+
+func fnMatches(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:matches")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:matches")
+	}
+
+	input, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+	re, e := regexFromArgs(ctx, args[1:])
+	if e != nil {
+		return e
+	}
+
+	matched := re.MatchString(input)
+	if re.err != nil {
+		return re.err
+	}
+	return NewBoolean(matched)
+}
+
+func fnReplace(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 3 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:replace")
+	}
+	if len(args) > 4 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:replace")
+	}
+
+	input, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+	replacement, e := stringArg(args[2])
+	if e != nil {
+		return e
+	}
+
+	rargs := []object.Item{args[1]}
+	flags := ""
+	if len(args) == 4 {
+		rargs = append(rargs, args[3])
+		flags, e = stringArg(args[3])
+		if e != nil {
+			return e
+		}
+	}
+
+	re, e := regexFromArgs(ctx, rargs)
+	if e != nil {
+		return e
+	}
+	if re.MatchString("") {
+		return NewCodedError("FORX0003", "the regular expression matches a zero-length string")
+	}
+
+	var repl string
+	if strings.Contains(flags, "q") {
+		repl = strings.ReplaceAll(replacement, "$", "$$")
+	} else {
+		repl, e = translateReplacement(replacement, re.NumSubexp())
+		if e != nil {
+			return e
+		}
+	}
+
+	replaced := re.ReplaceAllString(input, repl)
+	if re.err != nil {
+		return re.err
+	}
+	return NewString(replaced)
+}
+
+func fnTokenize(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:tokenize")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:tokenize")
+	}
+
+	input, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+
+	seq := &object.Sequence{}
+
+	if len(args) == 1 {
+		for _, token := range strings.Fields(input) {
+			seq.Items = append(seq.Items, NewString(token))
+		}
+		return seq
+	}
+
+	re, e := regexFromArgs(ctx, args[1:])
+	if e != nil {
+		return e
+	}
+	if re.MatchString("") {
+		return NewCodedError("FORX0003", "the regular expression matches a zero-length string")
+	}
+	if input == "" {
+		return seq
+	}
+
+	tokens := re.Split(input, -1)
+	if re.err != nil {
+		return re.err
+	}
+	for _, token := range tokens {
+		seq.Items = append(seq.Items, NewString(token))
+	}
+	return seq
+}
+
+func fnAnalyzeString(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:analyze-string")
+	}
+	if len(args) > 3 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:analyze-string")
+	}
+
+	input, e := stringArg(args[0])
+	if e != nil {
+		return e
+	}
+	re, e := regexFromArgs(ctx, args[1:])
+	if e != nil {
+		return e
+	}
+	if re.MatchString("") {
+		return NewCodedError("FORX0003", "the regular expression matches a zero-length string")
+	}
+
+	parents, err := re.captureParents()
+	if err != nil {
+		return NewCodedError("FORX0002", "invalid regular expression: %s", err.Error())
+	}
+
+	matches := re.FindAllStringSubmatchIndex(input, -1)
+	if re.err != nil {
+		return re.err
+	}
+
+	root := newFnElement("analyze-string-result")
+
+	pos := 0
+	for _, m := range matches {
+		if m[0] > pos {
+			nonMatch := newFnElement("non-match")
+			appendText(nonMatch, input[pos:m[0]])
+			root.AppendChild(nonMatch)
+		}
+
+		match := newFnElement("match")
+		appendGroups(match, input, m, parents, 0, m[0], m[1])
+		root.AppendChild(match)
+
+		pos = m[1]
+	}
+	if pos < len(input) {
+		nonMatch := newFnElement("non-match")
+		appendText(nonMatch, input[pos:])
+		root.AppendChild(nonMatch)
+	}
+
+	node := &object.BaseNode{}
+	node.SetTree(root)
+	return node
+}
+
+// appendGroups fills the parent element with the text from start to end
+// wrapping every captured group whose enclosing group is the given group in a fn:group element
+func appendGroups(parent *html.Node, input string, m []int, parents []int, group, start, end int) {
+	pos := start
+	for i := 1; i < len(parents); i++ {
+		if parents[i] != group {
+			continue
+		}
+
+		gs, ge := m[2*i], m[2*i+1]
+		if gs < 0 || gs < pos || ge > end {
+			continue
+		}
+
+		appendText(parent, input[pos:gs])
+
+		elem := newFnElement("group")
+		elem.Attr = append(elem.Attr, html.Attribute{Key: "nr", Val: strconv.Itoa(i)})
+		appendGroups(elem, input, m, parents, i, gs, ge)
+		parent.AppendChild(elem)
+
+		pos = ge
+	}
+	appendText(parent, input[pos:end])
+}
+
+// captureParents returns, for every capturing group, the index of the group enclosing it.
+// Groups that are not nested in another group have the parent 0.
+func captureParents(expr string) ([]int, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make([]int, re.MaxCap()+1)
+
+	var walk func(re *syntax.Regexp, group int)
+	walk = func(re *syntax.Regexp, group int) {
+		if re.Op == syntax.OpCapture {
+			parents[re.Cap] = group
+			group = re.Cap
+		}
+		for _, sub := range re.Sub {
+			walk(sub, group)
+		}
+	}
+	walk(re, 0)
+
+	return parents, nil
+}
+
+func newFnElement(local string) *html.Node {
+	return &html.Node{Type: html.ElementNode, Data: local, Namespace: fnNS}
+}
+
+func appendText(parent *html.Node, text string) {
+	if text != "" {
+		parent.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+	}
+}
+
+// regexFromArgs compiles the pattern in args[0] with the optional flags in args[1]
+// The returned expression is polled for the cancellation of the evaluation while it backtracks
+func regexFromArgs(ctx *object.Context, args []object.Item) (*Regex, *object.Error) {
+	pattern, e := stringArg(args[0])
+	if e != nil {
+		return nil, e
+	}
+
+	flags := ""
+	if len(args) > 1 {
+		flags, e = stringArg(args[1])
+		if e != nil {
+			return nil, e
+		}
+	}
+
+	re, e := CompileRegex(pattern, flags)
+	if e != nil {
+		return nil, e
+	}
+	if ctx == nil {
+		return re, nil
+	}
+	return &Regex{re: re.re, bt: re.bt, guard: ctx.Guard}, nil
+}
+
+// regexCache holds the recently compiled regular expressions
+var regexCache = util.NewCache(regexCacheSize)
+
+const regexCacheSize = 256
+
+// CompileRegex translates an XPath regular expression into the Go regexp syntax and compiles it.
+// Compiled expressions are cached since the regex functions are usually called once per node.
+func CompileRegex(pattern, flags string) (*Regex, *object.Error) {
+	key := flags + "/" + pattern
+
+	if re, ok := regexCache.Get(key); ok {
+		return re.(*Regex), nil
+	}
+
+	expr, backrefs, e := translateRegex(pattern, flags)
+	if e != nil {
+		return nil, e
+	}
+
+	re := &Regex{}
+	var err error
+	if backrefs {
+		re.bt, err = newBacktracker(expr)
+	} else {
+		re.re, err = regexp.Compile(expr)
+	}
+	if err != nil {
+		return nil, NewCodedError("FORX0002", "invalid regular expression: %s", err.Error())
+	}
+
+	regexCache.Add(key, re)
+
+	return re, nil
+}
+
+// Regex is a compiled XPath regular expression
+// The regexp package has no back-references, so an expression that has them is matched by a backtracker
+// A backtracker can take exponential time, so it is stopped by the guard or after btMaxSteps steps and err is set
+type Regex struct {
+	re    *regexp.Regexp
+	bt    *backtracker
+	guard *object.Guard
+	err   *object.Error
+}
+
+// MatchString reports whether s contains a match of the expression
+func (r *Regex) MatchString(s string) bool {
+	if r.re != nil {
+		return r.re.MatchString(s)
+	}
+	return len(r.findAll(s, 1)) > 0
+}
+
+func (r *Regex) findAll(s string, n int) [][]int {
+	var matches [][]int
+	matches, r.err = r.bt.findAll(s, n, r.guard)
+	return matches
+}
+
+// NumSubexp returns the number of capturing groups
+func (r *Regex) NumSubexp() int {
+	if r.re != nil {
+		return r.re.NumSubexp()
+	}
+	return r.bt.ncap
+}
+
+// FindAllStringSubmatchIndex returns the indexes of the matches in s and of their groups as regexp.Regexp does
+func (r *Regex) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	if r.re != nil {
+		return r.re.FindAllStringSubmatchIndex(s, n)
+	}
+	return r.findAll(s, n)
+}
+
+// ReplaceAllString replaces the matches in src with the template, ${N} in the template is the text of the group N and $$ is $
+func (r *Regex) ReplaceAllString(src, template string) string {
+	if r.re != nil {
+		return r.re.ReplaceAllString(src, template)
+	}
+
+	var sb strings.Builder
+	pos := 0
+	for _, m := range r.findAll(src, -1) {
+		sb.WriteString(src[pos:m[0]])
+		for i := 0; i < len(template); i++ {
+			if template[i] != '$' || i+1 >= len(template) {
+				sb.WriteByte(template[i])
+				continue
+			}
+			if template[i+1] == '$' {
+				sb.WriteByte('$')
+				i++
+				continue
+			}
+			end := strings.IndexByte(template[i:], '}')
+			if template[i+1] != '{' || end < 0 {
+				sb.WriteByte(template[i])
+				continue
+			}
+			if g, err := strconv.Atoi(template[i+2 : i+end]); err == nil && 2*g+1 < len(m) && m[2*g] >= 0 {
+				sb.WriteString(src[m[2*g]:m[2*g+1]])
+			}
+			i += end
+		}
+		pos = m[1]
+	}
+	sb.WriteString(src[pos:])
+	return sb.String()
+}
+
+// Split slices s into the substrings between the matches as regexp.Regexp does
+func (r *Regex) Split(s string, n int) []string {
+	if r.re != nil {
+		return r.re.Split(s, n)
+	}
+	if len(s) == 0 {
+		return []string{""}
+	}
+
+	var strs []string
+	beg, end := 0, 0
+	for _, m := range r.findAll(s, n) {
+		if n > 0 && len(strs) == n-1 {
+			break
+		}
+		end = m[0]
+		if m[1] != 0 {
+			strs = append(strs, s[beg:end])
+		}
+		beg = m[1]
+	}
+	if end != len(s) {
+		strs = append(strs, s[beg:])
+	}
+	return strs
+}
+
+// captureParents returns, for every capturing group, the index of the group enclosing it
+func (r *Regex) captureParents() ([]int, error) {
+	if r.re != nil {
+		return captureParents(r.re.String())
+	}
+	return r.bt.parents, nil
+}
+
+// backrefPrefix is the name prefix of the empty groups that stand for the back-references in a translated expression
+const backrefPrefix = "backref"
+
+// opBackref is the op of a back-reference in the tree of a backtracker, the regexp/syntax package has no such op
+const opBackref syntax.Op = 255
+
+// backtracker matches a regular expression that has back-references
+// It walks the syntax tree of the expression and tries the alternatives in the order the regexp package prefers them,
+// so the matches are the same as the ones of regexp.Regexp apart from the back-references
+type backtracker struct {
+	prog    *btNode
+	ncap    int
+	parents []int
+}
+
+type btNode struct {
+	op     syntax.Op
+	runes  []rune
+	fold   bool
+	greedy bool
+	min    int
+	max    int
+	group  int
+	sub    []*btNode
+}
+
+func newBacktracker(expr string) (*backtracker, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	bt := &backtracker{parents: []int{0}}
+	prog, err := bt.compile(re, 0)
+	if err != nil {
+		return nil, err
+	}
+	bt.prog = prog
+	return bt, nil
+}
+
+// compile converts the syntax tree into the tree of the backtracker
+// The groups are numbered again since the groups of the back-references are not capturing groups
+func (bt *backtracker) compile(re *syntax.Regexp, group int) (*btNode, error) {
+	n := &btNode{
+		op:     re.Op,
+		runes:  re.Rune,
+		fold:   re.Flags&syntax.FoldCase != 0,
+		greedy: re.Flags&syntax.NonGreedy == 0,
+		min:    re.Min,
+		max:    re.Max,
+	}
+
+	switch re.Op {
+	case syntax.OpNoMatch, syntax.OpEmptyMatch, syntax.OpLiteral, syntax.OpCharClass, syntax.OpAnyCharNotNL, syntax.OpAnyChar,
+		syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText, syntax.OpConcat, syntax.OpAlternate:
+	case syntax.OpCapture:
+		if strings.HasPrefix(re.Name, backrefPrefix) {
+			ref, err := strconv.Atoi(re.Name[len(backrefPrefix):])
+			if err != nil {
+				return nil, err
+			}
+			n.op = opBackref
+			n.group = ref
+			return n, nil
+		}
+		bt.ncap++
+		n.group = bt.ncap
+		bt.parents = append(bt.parents, group)
+		group = n.group
+	case syntax.OpStar:
+		n.op, n.min, n.max = syntax.OpRepeat, 0, -1
+	case syntax.OpPlus:
+		n.op, n.min, n.max = syntax.OpRepeat, 1, -1
+	case syntax.OpQuest:
+		n.op, n.min, n.max = syntax.OpRepeat, 0, 1
+	case syntax.OpRepeat:
+	default:
+		return nil, fmt.Errorf("unsupported expression: %s", re)
+	}
+
+	for _, sub := range re.Sub {
+		s, err := bt.compile(sub, group)
+		if err != nil {
+			return nil, err
+		}
+		n.sub = append(n.sub, s)
+	}
+	return n, nil
+}
+
+// btMaxSteps is the number of steps after which a backtracker gives up a match
+const btMaxSteps = 1 << 22
+
+// findAll returns the indexes of the matches in s and of their groups, at most n matches if n >= 0
+// An empty match right after the previous match is skipped as regexp.Regexp does
+// It returns an error if the guard stops the evaluation or the matches take more than btMaxSteps steps
+func (bt *backtracker) findAll(s string, n int, guard *object.Guard) ([][]int, *object.Error) {
+	var matches [][]int
+	prevEnd := -1
+	bm := &btMatcher{input: s, caps: make([]int, 2*bt.ncap+2), guard: guard}
+
+	for pos := 0; (n < 0 || len(matches) < n) && pos <= len(s); {
+		m := bt.find(bm, pos)
+		if bm.err != nil {
+			return nil, bm.err
+		}
+		if m == nil {
+			break
+		}
+
+		accept := true
+		if m[1] == pos {
+			if m[0] == prevEnd {
+				accept = false
+			}
+			if pos < len(s) {
+				_, w := utf8.DecodeRuneInString(s[pos:])
+				pos += w
+			} else {
+				pos++
+			}
+		} else {
+			pos = m[1]
+		}
+		prevEnd = m[1]
+
+		if accept {
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// find returns the leftmost match that starts at pos or after it
+func (bt *backtracker) find(m *btMatcher, pos int) []int {
+	for start := pos; ; {
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+
+		if m.match(bt.prog, start, func(end int) bool {
+			m.caps[0], m.caps[1] = start, end
+			return true
+		}) {
+			return append([]int(nil), m.caps...)
+		}
+
+		if m.err != nil || start >= len(m.input) {
+			return nil
+		}
+		_, w := utf8.DecodeRuneInString(m.input[start:])
+		start += w
+	}
+}
+
+type btMatcher struct {
+	input string
+	caps  []int
+	guard *object.Guard
+	steps int
+	err   *object.Error
+}
+
+// step counts a step of the match and reports whether the match can go on
+func (m *btMatcher) step() bool {
+	if m.err != nil {
+		return false
+	}
+	m.steps++
+	if m.steps > btMaxSteps {
+		m.err = NewCodedError("FORX0002", "the regular expression takes more than %d steps to match", btMaxSteps)
+		return false
+	}
+	m.err = m.guard.Poll(m.steps)
+	return m.err == nil
+}
+
+// match matches the node at i and calls k with the end of the match, it backtracks to the next alternative if k returns false
+func (m *btMatcher) match(n *btNode, i int, k func(int) bool) bool {
+	if !m.step() {
+		return false
+	}
+
+	switch n.op {
+	case syntax.OpEmptyMatch:
+		return k(i)
+	case syntax.OpLiteral:
+		for _, r := range n.runes {
+			c, w := utf8.DecodeRuneInString(m.input[i:])
+			if w == 0 || c != r && !(n.fold && strings.EqualFold(string(c), string(r))) {
+				return false
+			}
+			i += w
+		}
+		return k(i)
+	case syntax.OpCharClass:
+		c, w := utf8.DecodeRuneInString(m.input[i:])
+		if w == 0 {
+			return false
+		}
+		for j := 0; j+1 < len(n.runes); j += 2 {
+			if c >= n.runes[j] && c <= n.runes[j+1] {
+				return k(i + w)
+			}
+		}
+		return false
+	case syntax.OpAnyCharNotNL:
+		c, w := utf8.DecodeRuneInString(m.input[i:])
+		return w > 0 && c != '\n' && k(i+w)
+	case syntax.OpAnyChar:
+		_, w := utf8.DecodeRuneInString(m.input[i:])
+		return w > 0 && k(i+w)
+	case syntax.OpBeginLine:
+		return (i == 0 || m.input[i-1] == '\n') && k(i)
+	case syntax.OpEndLine:
+		return (i == len(m.input) || m.input[i] == '\n') && k(i)
+	case syntax.OpBeginText:
+		return i == 0 && k(i)
+	case syntax.OpEndText:
+		return i == len(m.input) && k(i)
+	case syntax.OpCapture:
+		return m.match(n.sub[0], i, func(j int) bool {
+			start, end := m.caps[2*n.group], m.caps[2*n.group+1]
+			m.caps[2*n.group], m.caps[2*n.group+1] = i, j
+			if k(j) {
+				return true
+			}
+			m.caps[2*n.group], m.caps[2*n.group+1] = start, end
+			return false
+		})
+	case opBackref:
+		// a group that has not matched is matched as the empty string
+		start, end := m.caps[2*n.group], m.caps[2*n.group+1]
+		if start < 0 {
+			return k(i)
+		}
+		for _, r := range m.input[start:end] {
+			c, w := utf8.DecodeRuneInString(m.input[i:])
+			if w == 0 || c != r && !(n.fold && strings.EqualFold(string(c), string(r))) {
+				return false
+			}
+			i += w
+		}
+		return k(i)
+	case syntax.OpConcat:
+		return m.concat(n.sub, i, k)
+	case syntax.OpAlternate:
+		for _, sub := range n.sub {
+			if m.match(sub, i, k) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpRepeat:
+		return m.repeat(n, 0, i, k)
+	}
+	return false
+}
+
+func (m *btMatcher) concat(subs []*btNode, i int, k func(int) bool) bool {
+	if len(subs) == 0 {
+		return k(i)
+	}
+	return m.match(subs[0], i, func(j int) bool {
+		return m.concat(subs[1:], j, k)
+	})
+}
+
+// repeat matches the sub node of a repetition that is already matched count times
+func (m *btMatcher) repeat(n *btNode, count, i int, k func(int) bool) bool {
+	more := func() bool {
+		if n.max >= 0 && count >= n.max {
+			return false
+		}
+		return m.match(n.sub[0], i, func(j int) bool {
+			// an empty iteration cannot make progress once the minimum is reached,
+			// it is matched only as the first iteration and the repetition stops after it as regexp.Regexp does
+			if j == i && count >= n.min {
+				return count == 0 && k(j)
+			}
+			return m.repeat(n, count+1, j, k)
+		})
+	}
+
+	if n.greedy {
+		return more() || count >= n.min && k(i)
+	}
+	return count >= n.min && k(i) || more()
+}
+
+// TranslateRegex converts a regular expression in the XPath dialect
+// (XML Schema regular expressions with the XPath extensions) into an equivalent Go expression
+// A back-reference \N is translated into an empty group named with backrefPrefix and N, which the regexp package matches as the empty string
+func TranslateRegex(pattern, flags string) (string, *object.Error) {
+	expr, _, e := translateRegex(pattern, flags)
+	return expr, e
+}
+
+// translateRegex translates the pattern and reports whether it has back-references
+func translateRegex(pattern, flags string) (string, bool, *object.Error) {
+	t := &regexTranslator{src: []rune(pattern)}
+
+	for _, f := range flags {
+		switch f {
+		case 's':
+			t.dotAll = true
+		case 'm':
+			t.multiLine = true
+		case 'i':
+			t.caseless = true
+		case 'x':
+			t.extended = true
+		case 'q':
+			t.quote = true
+		default:
+			return "", false, NewCodedError("FORX0001", "invalid regular expression flags: %s", flags)
+		}
+	}
+
+	var sb strings.Builder
+	if t.multiLine || t.caseless {
+		sb.WriteString("(?")
+		if t.multiLine {
+			sb.WriteString("m")
+		}
+		if t.caseless {
+			sb.WriteString("i")
+		}
+		sb.WriteString(")")
+	}
+
+	if t.quote {
+		sb.WriteString(regexp.QuoteMeta(pattern))
+		return sb.String(), false, nil
+	}
+
+	if e := t.translate(&sb); e != nil {
+		return "", false, e
+	}
+	return sb.String(), t.backrefs, nil
+}
+
+type regexTranslator struct {
+	src       []rune
+	pos       int
+	groups    int
+	open      []int
+	backrefs  bool
+	dotAll    bool
+	multiLine bool
+	caseless  bool
+	extended  bool
+	quote     bool
+}
+
+func (t *regexTranslator) translate(sb *strings.Builder) *object.Error {
+	for t.pos < len(t.src) {
+		ch := t.src[t.pos]
+
+		if t.extended && isRegexSpace(ch) {
+			t.pos++
+			continue
+		}
+
+		switch ch {
+		case '\\':
+			if t.pos+1 < len(t.src) && t.src[t.pos+1] >= '1' && t.src[t.pos+1] <= '9' {
+				n, e := t.parseBackref()
+				if e != nil {
+					return e
+				}
+				// the back-reference is an empty named group that the backtracker matches
+				sb.WriteString("(?P<" + backrefPrefix + strconv.Itoa(n) + ">)")
+				t.backrefs = true
+				continue
+			}
+			r, set, e := t.parseEscape()
+			if e != nil {
+				return e
+			}
+			if set != nil {
+				writeRuneSet(sb, set)
+			} else {
+				sb.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		case '[':
+			set, e := t.parseClass()
+			if e != nil {
+				return e
+			}
+			writeRuneSet(sb, set)
+		case '.':
+			if t.dotAll {
+				sb.WriteString(`(?s:.)`)
+			} else {
+				sb.WriteString(`[^\n\r]`)
+			}
+			t.pos++
+		case '(':
+			if t.pos+1 < len(t.src) && t.src[t.pos+1] == '?' {
+				if t.pos+2 < len(t.src) && t.src[t.pos+2] == ':' {
+					t.open = append(t.open, 0)
+					sb.WriteString("(?:")
+					t.pos += 3
+					continue
+				}
+				return t.errorf("only non-capturing groups (?: are allowed after (?")
+			}
+			t.groups++
+			t.open = append(t.open, t.groups)
+			sb.WriteRune(ch)
+			t.pos++
+		case ')':
+			if len(t.open) > 0 {
+				t.open = t.open[:len(t.open)-1]
+			}
+			sb.WriteRune(ch)
+			t.pos++
+		case '|', '^', '$', '*', '+', '?':
+			sb.WriteRune(ch)
+			t.pos++
+		case '{':
+			q, e := t.parseQuantifier()
+			if e != nil {
+				return e
+			}
+			sb.WriteString(q)
+		case ']', '}':
+			return t.errorf("unescaped %q", ch)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(ch)))
+			t.pos++
+		}
+	}
+	return nil
+}
+
+// parseBackref reads a back-reference \N, the digits are read as long as they form the number of a closed group
+func (t *regexTranslator) parseBackref() (int, *object.Error) {
+	n := int(t.src[t.pos+1] - '0')
+	if !t.closed(n) {
+		return 0, t.errorf("back-reference to a group that is not closed: \\%d", n)
+	}
+	t.pos += 2
+
+	for t.pos < len(t.src) && t.src[t.pos] >= '0' && t.src[t.pos] <= '9' && t.closed(n*10+int(t.src[t.pos]-'0')) {
+		n = n*10 + int(t.src[t.pos]-'0')
+		t.pos++
+	}
+	return n, nil
+}
+
+// closed reports whether the closing parenthesis of the capturing group n is already read
+func (t *regexTranslator) closed(n int) bool {
+	if n > t.groups {
+		return false
+	}
+	for _, g := range t.open {
+		if g == n {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQuantifier reads {n}, {n,} or {n,m}
+func (t *regexTranslator) parseQuantifier() (string, *object.Error) {
+	start := t.pos
+	var sb strings.Builder
+	sb.WriteRune('{')
+	t.pos++
+
+	digits := 0
+	comma := false
+	for ; t.pos < len(t.src); t.pos++ {
+		ch := t.src[t.pos]
+		switch {
+		case t.extended && isRegexSpace(ch):
+			continue
+		case ch >= '0' && ch <= '9':
+			digits++
+		case ch == ',' && !comma && digits > 0:
+			comma = true
+		case ch == '}' && digits > 0:
+			sb.WriteRune(ch)
+			t.pos++
+			return sb.String(), nil
+		default:
+			t.pos = start
+			return "", t.errorf("invalid quantifier")
+		}
+		sb.WriteRune(ch)
+	}
+
+	t.pos = start
+	return "", t.errorf("unterminated quantifier")
+}
+
+// parseClass reads a character class expression including negation and subtraction
+func (t *regexTranslator) parseClass() ([]runeRange, *object.Error) {
+	start := t.pos
+	t.pos++ // '['
+
+	negate := false
+	if t.pos < len(t.src) && t.src[t.pos] == '^' {
+		negate = true
+		t.pos++
+	}
+
+	var set []runeRange
+	var sub []runeRange
+	count := 0
+
+	for {
+		if t.pos >= len(t.src) {
+			t.pos = start
+			return nil, t.errorf("unterminated character class")
+		}
+
+		ch := t.src[t.pos]
+		if ch == ']' {
+			if count == 0 {
+				return nil, t.errorf("empty character class")
+			}
+			t.pos++
+			break
+		}
+		if ch == '-' && t.pos+1 < len(t.src) && t.src[t.pos+1] == '[' {
+			if count == 0 {
+				return nil, t.errorf("empty character class")
+			}
+			t.pos++
+			s, e := t.parseClass()
+			if e != nil {
+				return nil, e
+			}
+			if t.pos >= len(t.src) || t.src[t.pos] != ']' {
+				return nil, t.errorf("character class subtraction must be the last part of a class")
+			}
+			sub = s
+			t.pos++
+			break
+		}
+		if ch == '[' {
+			return nil, t.errorf("unescaped '[' in character class")
+		}
+
+		lo, s, e := t.parseClassChar()
+		if e != nil {
+			return nil, e
+		}
+		count++
+		if s != nil {
+			set = append(set, s...)
+			continue
+		}
+
+		if t.pos+1 < len(t.src) && t.src[t.pos] == '-' && t.src[t.pos+1] != ']' && t.src[t.pos+1] != '[' {
+			t.pos++
+			hi, s, e := t.parseClassChar()
+			if e != nil {
+				return nil, e
+			}
+			if s != nil {
+				return nil, t.errorf("multi-character escape cannot be a range bound")
+			}
+			if hi < lo {
+				return nil, t.errorf("invalid character range %q-%q", lo, hi)
+			}
+			set = append(set, runeRange{lo, hi})
+			continue
+		}
+		set = append(set, runeRange{lo, lo})
+	}
+
+	set = normalizeRanges(set)
+	if negate {
+		set = complementRanges(set)
+	}
+	if sub != nil {
+		set = subtractRanges(set, sub)
+	}
+	return set, nil
+}
+
+func (t *regexTranslator) parseClassChar() (rune, []runeRange, *object.Error) {
+	if t.src[t.pos] == '\\' {
+		return t.parseEscape()
+	}
+	ch := t.src[t.pos]
+	t.pos++
+	return ch, nil, nil
+}
+
+// parseEscape reads an escape sequence. Single character escapes return the character,
+// the others return the set of characters they stand for.
+func (t *regexTranslator) parseEscape() (rune, []runeRange, *object.Error) {
+	if t.pos+1 >= len(t.src) {
+		return 0, nil, t.errorf("trailing backslash")
+	}
+	ch := t.src[t.pos+1]
+	t.pos += 2
+
+	switch ch {
+	case 'n':
+		return '\n', nil, nil
+	case 'r':
+		return '\r', nil, nil
+	case 't':
+		return '\t', nil, nil
+	case '\\', '|', '.', '-', '^', '?', '*', '+', '{', '}', '(', ')', '[', ']', '$':
+		return ch, nil, nil
+	case 's':
+		return 0, spaceRanges, nil
+	case 'S':
+		return 0, complementRanges(spaceRanges), nil
+	case 'i':
+		return 0, nameStartRanges, nil
+	case 'I':
+		return 0, complementRanges(nameStartRanges), nil
+	case 'c':
+		return 0, nameRanges, nil
+	case 'C':
+		return 0, complementRanges(nameRanges), nil
+	case 'd':
+		return 0, tableRanges(unicode.Nd), nil
+	case 'D':
+		return 0, complementRanges(tableRanges(unicode.Nd)), nil
+	case 'w':
+		return 0, complementRanges(wordComplement()), nil
+	case 'W':
+		return 0, wordComplement(), nil
+	case 'p', 'P':
+		if t.pos >= len(t.src) || t.src[t.pos] != '{' {
+			return 0, nil, t.errorf("expected '{' after \\%c", ch)
+		}
+		end := t.pos
+		for end < len(t.src) && t.src[end] != '}' {
+			end++
+		}
+		if end >= len(t.src) {
+			return 0, nil, t.errorf("unterminated \\%c{", ch)
+		}
+		name := string(t.src[t.pos+1 : end])
+		t.pos = end + 1
+
+		set, ok := propertyRanges(name)
+		if !ok {
+			return 0, nil, t.errorf("unknown character category or block: %s", name)
+		}
+		if ch == 'P' {
+			set = complementRanges(set)
+		}
+		return 0, set, nil
+	}
+
+	return 0, nil, t.errorf("invalid escape \\%c", ch)
+}
+
+func (t *regexTranslator) errorf(format string, a ...interface{}) *object.Error {
+	return NewCodedError("FORX0002", "invalid regular expression: %s at position %d in %q", fmt.Sprintf(format, a...), t.pos, string(t.src))
+}
+
+// translateReplacement converts $N group references in a fn:replace replacement string into ${N}
+func translateReplacement(rep string, groups int) (string, *object.Error) {
+	var sb strings.Builder
+
+	for i := 0; i < len(rep); i++ {
+		switch rep[i] {
+		case '\\':
+			if i+1 >= len(rep) || (rep[i+1] != '\\' && rep[i+1] != '$') {
+				return "", NewCodedError("FORX0004", "invalid replacement string: %s", rep)
+			}
+			i++
+			if rep[i] == '$' {
+				sb.WriteString("$$")
+			} else {
+				sb.WriteByte('\\')
+			}
+		case '$':
+			if i+1 >= len(rep) || rep[i+1] < '0' || rep[i+1] > '9' {
+				return "", NewCodedError("FORX0004", "invalid replacement string: %s", rep)
+			}
+			i++
+			n := int(rep[i] - '0')
+			for i+1 < len(rep) && rep[i+1] >= '0' && rep[i+1] <= '9' && n*10+int(rep[i+1]-'0') <= groups {
+				i++
+				n = n*10 + int(rep[i]-'0')
+			}
+			if n <= groups {
+				sb.WriteString("${" + strconv.Itoa(n) + "}")
+			}
+		default:
+			sb.WriteByte(rep[i])
+		}
+	}
+
+	return sb.String(), nil
+}
+
+func isRegexSpace(ch rune) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
+type runeRange struct {
+	lo rune
+	hi rune
+}
+
+var spaceRanges = []runeRange{{'\t', '\n'}, {'\r', '\r'}, {' ', ' '}}
+
+// NameStartChar and NameChar from XML 1.0
+var nameStartRanges = []runeRange{
+	{':', ':'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'},
+	{0xC0, 0xD6}, {0xD8, 0xF6}, {0xF8, 0x2FF}, {0x370, 0x37D}, {0x37F, 0x1FFF},
+	{0x200C, 0x200D}, {0x2070, 0x218F}, {0x2C00, 0x2FEF}, {0x3001, 0xD7FF},
+	{0xF900, 0xFDCF}, {0xFDF0, 0xFFFD}, {0x10000, 0xEFFFF},
+}
+
+var nameRanges = normalizeRanges(append([]runeRange{
+	{'-', '.'}, {'0', '9'}, {0xB7, 0xB7}, {0x300, 0x36F}, {0x203F, 0x2040},
+}, nameStartRanges...))
+
+// wordComplement returns the characters that \w excludes: punctuation, separators and others
+func wordComplement() []runeRange {
+	var set []runeRange
+	for _, cat := range []string{"P", "Z", "C"} {
+		set = append(set, tableRanges(unicode.Categories[cat])...)
+	}
+	set = append(set, unassignedRanges()...)
+	return normalizeRanges(set)
+}
+
+func unassignedRanges() []runeRange {
+	var set []runeRange
+	for _, cat := range []string{"L", "M", "N", "P", "S", "Z", "C"} {
+		set = append(set, tableRanges(unicode.Categories[cat])...)
+	}
+	return complementRanges(normalizeRanges(set))
+}
+
+// propertyRanges resolves the name in \p{name} to a general category or a block
+func propertyRanges(name string) ([]runeRange, bool) {
+	if strings.HasPrefix(name, "Is") {
+		r, ok := unicodeBlocks[name[2:]]
+		if !ok {
+			return nil, false
+		}
+		return []runeRange{r}, true
+	}
+	if name == "Cn" {
+		return unassignedRanges(), true
+	}
+	if name == "C" {
+		return normalizeRanges(append(tableRanges(unicode.C), unassignedRanges()...)), true
+	}
+	if t, ok := unicode.Categories[name]; ok {
+		return tableRanges(t), true
+	}
+	return nil, false
+}
+
+func tableRanges(t *unicode.RangeTable) []runeRange {
+	var set []runeRange
+	for _, r := range t.R16 {
+		lo, hi, stride := rune(r.Lo), rune(r.Hi), rune(r.Stride)
+		if stride == 1 {
+			set = append(set, runeRange{lo, hi})
+			continue
+		}
+		for c := lo; c <= hi; c += stride {
+			set = append(set, runeRange{c, c})
+		}
+	}
+	for _, r := range t.R32 {
+		lo, hi, stride := rune(r.Lo), rune(r.Hi), rune(r.Stride)
+		if stride == 1 {
+			set = append(set, runeRange{lo, hi})
+			continue
+		}
+		for c := lo; c <= hi; c += stride {
+			set = append(set, runeRange{c, c})
+		}
+	}
+	return normalizeRanges(set)
+}
+
+// normalizeRanges sorts the ranges and merges the overlapping or adjacent ones
+func normalizeRanges(set []runeRange) []runeRange {
+	if len(set) == 0 {
+		return []runeRange{}
+	}
+
+	sorted := make([]runeRange, len(set))
+	copy(sorted, set)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].lo < sorted[j].lo })
+
+	result := []runeRange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &result[len(result)-1]
+		if r.lo <= last.hi+1 {
+			if r.hi > last.hi {
+				last.hi = r.hi
+			}
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// complementRanges expects normalized ranges
+func complementRanges(set []runeRange) []runeRange {
+	result := []runeRange{}
+	next := rune(0)
+	for _, r := range set {
+		if r.lo > next {
+			result = append(result, runeRange{next, r.lo - 1})
+		}
+		next = r.hi + 1
+	}
+	if next <= unicode.MaxRune {
+		result = append(result, runeRange{next, unicode.MaxRune})
+	}
+	return result
+}
+
+func subtractRanges(set, sub []runeRange) []runeRange {
+	keep := complementRanges(normalizeRanges(sub))
+	result := []runeRange{}
+
+	i, j := 0, 0
+	for i < len(set) && j < len(keep) {
+		lo, hi := set[i].lo, set[i].hi
+		if keep[j].lo > lo {
+			lo = keep[j].lo
+		}
+		if keep[j].hi < hi {
+			hi = keep[j].hi
+		}
+		if lo <= hi {
+			result = append(result, runeRange{lo, hi})
+		}
+		if set[i].hi < keep[j].hi {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+func writeRuneSet(sb *strings.Builder, set []runeRange) {
+	if len(set) == 0 {
+		// matches nothing
+		sb.WriteString(`[^\x00-\x{10FFFF}]`)
+		return
+	}
+
+	sb.WriteRune('[')
+	for _, r := range set {
+		fmt.Fprintf(sb, `\x{%X}`, r.lo)
+		if r.hi != r.lo {
+			fmt.Fprintf(sb, `-\x{%X}`, r.hi)
+		}
+	}
+	sb.WriteRune(']')
+}
+
+// unicodeBlocks are the block names usable with \p{IsXxx}
+var unicodeBlocks = map[string]runeRange{
+	"BasicLatin":                          {0x0000, 0x007F},
+	"Latin-1Supplement":                   {0x0080, 0x00FF},
+	"LatinExtended-A":                     {0x0100, 0x017F},
+	"LatinExtended-B":                     {0x0180, 0x024F},
+	"IPAExtensions":                       {0x0250, 0x02AF},
+	"SpacingModifierLetters":              {0x02B0, 0x02FF},
+	"CombiningDiacriticalMarks":           {0x0300, 0x036F},
+	"Greek":                               {0x0370, 0x03FF},
+	"GreekandCoptic":                      {0x0370, 0x03FF},
+	"Cyrillic":                            {0x0400, 0x04FF},
+	"CyrillicSupplement":                  {0x0500, 0x052F},
+	"Armenian":                            {0x0530, 0x058F},
+	"Hebrew":                              {0x0590, 0x05FF},
+	"Arabic":                              {0x0600, 0x06FF},
+	"Syriac":                              {0x0700, 0x074F},
+	"Thaana":                              {0x0780, 0x07BF},
+	"Devanagari":                          {0x0900, 0x097F},
+	"Bengali":                             {0x0980, 0x09FF},
+	"Gurmukhi":                            {0x0A00, 0x0A7F},
+	"Gujarati":                            {0x0A80, 0x0AFF},
+	"Oriya":                               {0x0B00, 0x0B7F},
+	"Tamil":                               {0x0B80, 0x0BFF},
+	"Telugu":                              {0x0C00, 0x0C7F},
+	"Kannada":                             {0x0C80, 0x0CFF},
+	"Malayalam":                           {0x0D00, 0x0D7F},
+	"Sinhala":                             {0x0D80, 0x0DFF},
+	"Thai":                                {0x0E00, 0x0E7F},
+	"Lao":                                 {0x0E80, 0x0EFF},
+	"Tibetan":                             {0x0F00, 0x0FFF},
+	"Myanmar":                             {0x1000, 0x109F},
+	"Georgian":                            {0x10A0, 0x10FF},
+	"HangulJamo":                          {0x1100, 0x11FF},
+	"Ethiopic":                            {0x1200, 0x137F},
+	"Cherokee":                            {0x13A0, 0x13FF},
+	"UnifiedCanadianAboriginalSyllabics":  {0x1400, 0x167F},
+	"Ogham":                               {0x1680, 0x169F},
+	"Runic":                               {0x16A0, 0x16FF},
+	"Khmer":                               {0x1780, 0x17FF},
+	"Mongolian":                           {0x1800, 0x18AF},
+	"LatinExtendedAdditional":             {0x1E00, 0x1EFF},
+	"GreekExtended":                       {0x1F00, 0x1FFF},
+	"GeneralPunctuation":                  {0x2000, 0x206F},
+	"SuperscriptsandSubscripts":           {0x2070, 0x209F},
+	"CurrencySymbols":                     {0x20A0, 0x20CF},
+	"CombiningMarksforSymbols":            {0x20D0, 0x20FF},
+	"CombiningDiacriticalMarksforSymbols": {0x20D0, 0x20FF},
+	"LetterlikeSymbols":                   {0x2100, 0x214F},
+	"NumberForms":                         {0x2150, 0x218F},
+	"Arrows":                              {0x2190, 0x21FF},
+	"MathematicalOperators":               {0x2200, 0x22FF},
+	"MiscellaneousTechnical":              {0x2300, 0x23FF},
+	"ControlPictures":                     {0x2400, 0x243F},
+	"OpticalCharacterRecognition":         {0x2440, 0x245F},
+	"EnclosedAlphanumerics":               {0x2460, 0x24FF},
+	"BoxDrawing":                          {0x2500, 0x257F},
+	"BlockElements":                       {0x2580, 0x259F},
+	"GeometricShapes":                     {0x25A0, 0x25FF},
+	"MiscellaneousSymbols":                {0x2600, 0x26FF},
+	"Dingbats":                            {0x2700, 0x27BF},
+	"BraillePatterns":                     {0x2800, 0x28FF},
+	"CJKRadicalsSupplement":               {0x2E80, 0x2EFF},
+	"KangxiRadicals":                      {0x2F00, 0x2FDF},
+	"IdeographicDescriptionCharacters":    {0x2FF0, 0x2FFF},
+	"CJKSymbolsandPunctuation":            {0x3000, 0x303F},
+	"Hiragana":                            {0x3040, 0x309F},
+	"Katakana":                            {0x30A0, 0x30FF},
+	"Bopomofo":                            {0x3100, 0x312F},
+	"HangulCompatibilityJamo":             {0x3130, 0x318F},
+	"Kanbun":                              {0x3190, 0x319F},
+	"BopomofoExtended":                    {0x31A0, 0x31BF},
+	"EnclosedCJKLettersandMonths":         {0x3200, 0x32FF},
+	"CJKCompatibility":                    {0x3300, 0x33FF},
+	"CJKUnifiedIdeographsExtensionA":      {0x3400, 0x4DBF},
+	"CJKUnifiedIdeographs":                {0x4E00, 0x9FFF},
+	"YiSyllables":                         {0xA000, 0xA48F},
+	"YiRadicals":                          {0xA490, 0xA4CF},
+	"HangulSyllables":                     {0xAC00, 0xD7AF},
+	"PrivateUse":                          {0xE000, 0xF8FF},
+	"CJKCompatibilityIdeographs":          {0xF900, 0xFAFF},
+	"AlphabeticPresentationForms":         {0xFB00, 0xFB4F},
+	"ArabicPresentationForms-A":           {0xFB50, 0xFDFF},
+	"CombiningHalfMarks":                  {0xFE20, 0xFE2F},
+	"CJKCompatibilityForms":               {0xFE30, 0xFE4F},
+	"SmallFormVariants":                   {0xFE50, 0xFE6F},
+	"ArabicPresentationForms-B":           {0xFE70, 0xFEFF},
+	"HalfwidthandFullwidthForms":          {0xFF00, 0xFFEF},
+	"Specials":                            {0xFFF0, 0xFFFF},
+}
@@ -12,10 +12,10 @@ func fnFalse(ctx *object.Context, args ...object.Item) object.Item {
 
 func fnBoolean(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) > 1 {
-		return NewError("too many parameters for function call: fn:boolean")
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:boolean")
 	}
 	if len(args) < 1 {
-		return NewError("too few parameters for function call: fn:boolean")
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:boolean")
 	}
 
 	switch arg := args[0].(type) {
@@ -30,7 +30,7 @@ func fnBoolean(ctx *object.Context, args ...object.Item) object.Item {
 			return fnBoolean(nil, arg.Items[0])
 		}
 		if len(arg.Items) > 1 {
-			return NewError("too many items supplied for effective boolean value")
+			return NewCodedError("FORG0006", "too many items supplied for effective boolean value")
 		}
 	case *object.Boolean:
 		return arg
@@ -60,7 +60,7 @@ func fnBoolean(ctx *object.Context, args ...object.Item) object.Item {
 		return NewBoolean(true)
 	}
 
-	return NewError("unexpected argument type: %s", args[0].Type())
+	return NewCodedError("FORG0006", "unexpected argument type: %s", TypeName(args[0]))
 }
 
 func fnNot(ctx *object.Context, args ...object.Item) object.Item {
@@ -76,13 +76,13 @@ func fnNot(ctx *object.Context, args ...object.Item) object.Item {
 
 func opBooleanEqual(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: op:boolean-equal")
+		return NewCodedError("XPST0017", "too few parameters for function call: op:boolean-equal")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: op:boolean-equal")
+		return NewCodedError("XPST0017", "too many parameters for function call: op:boolean-equal")
 	}
 	if !IsBoolean(args[0]) || !IsBoolean(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arg1 := args[0].(*object.Boolean)
@@ -93,13 +93,13 @@ func opBooleanEqual(ctx *object.Context, args ...object.Item) object.Item {
 
 func opBooleanLessThan(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: op:boolean-equal")
+		return NewCodedError("XPST0017", "too few parameters for function call: op:boolean-equal")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: op:boolean-equal")
+		return NewCodedError("XPST0017", "too many parameters for function call: op:boolean-equal")
 	}
 	if !IsBoolean(args[0]) || !IsBoolean(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arg1 := args[0].(*object.Boolean)
@@ -110,13 +110,13 @@ func opBooleanLessThan(ctx *object.Context, args ...object.Item) object.Item {
 
 func opBooleanGreaterThan(ctx *object.Context, args ...object.Item) object.Item {
 	if len(args) < 2 {
-		return NewError("too few parameters for function call: op:boolean-equal")
+		return NewCodedError("XPST0017", "too few parameters for function call: op:boolean-equal")
 	}
 	if len(args) > 2 {
-		return NewError("too many parameters for function call: op:boolean-equal")
+		return NewCodedError("XPST0017", "too many parameters for function call: op:boolean-equal")
 	}
 	if !IsBoolean(args[0]) || !IsBoolean(args[1]) {
-		return NewError("cannot match item type with required type")
+		return NewCodedError("XPTY0004", "cannot match item type with required type")
 	}
 
 	arg1 := args[0].(*object.Boolean)
@@ -0,0 +1,307 @@
+package bif
+
+import (
+	"math"
+	"time"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+func fnYearsFromDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], object.DurationType)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	d := arg.(object.DurationItem)
+	return NewInteger(d.Months() / 12)
+}
+
+func fnMonthsFromDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], object.DurationType)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	d := arg.(object.DurationItem)
+	return NewInteger(d.Months() % 12)
+}
+
+func fnDaysFromDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], object.DurationType)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	d := arg.(object.DurationItem)
+	return NewInteger(int(d.Duration() / (24 * time.Hour)))
+}
+
+func fnHoursFromDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], object.DurationType)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	d := arg.(object.DurationItem)
+	return NewInteger(int(d.Duration() % (24 * time.Hour) / time.Hour))
+}
+
+func fnMinutesFromDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], object.DurationType)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	d := arg.(object.DurationItem)
+	return NewInteger(int(d.Duration() % time.Hour / time.Minute))
+}
+
+func fnSecondsFromDuration(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], object.DurationType)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	d := arg.(object.DurationItem)
+	return NewDecimal((d.Duration() % time.Minute).Seconds())
+}
+
+func opAddYearMonthDurations(ctx *object.Context, args ...object.Item) object.Item {
+	l := args[0].(*object.YearMonthDuration)
+	r := args[1].(*object.YearMonthDuration)
+	months, ok := addInt64(int64(l.Months()), int64(r.Months()))
+	if !ok {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	return NewYearMonthDuration(int(months))
+}
+
+func opSubtractYearMonthDurations(ctx *object.Context, args ...object.Item) object.Item {
+	l := args[0].(*object.YearMonthDuration)
+	r := args[1].(*object.YearMonthDuration)
+	months, ok := addInt64(int64(l.Months()), -int64(r.Months()))
+	if !ok {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	return NewYearMonthDuration(int(months))
+}
+
+func opMultiplyYearMonthDuration(ctx *object.Context, args ...object.Item) object.Item {
+	d := args[0].(*object.YearMonthDuration)
+	n, e := durationFactor(args[1])
+	if e != nil {
+		return e
+	}
+	return yearMonthDuration(math.Floor(float64(d.Months())*n + 0.5))
+}
+
+func opDivideYearMonthDuration(ctx *object.Context, args ...object.Item) object.Item {
+	d := args[0].(*object.YearMonthDuration)
+	n, e := durationFactor(args[1])
+	if e != nil {
+		return e
+	}
+	if n == 0 {
+		return NewCodedError("FODT0002", "duration overflow: division by zero")
+	}
+	return yearMonthDuration(math.Floor(float64(d.Months())/n + 0.5))
+}
+
+func opDivideYearMonthDurationByYearMonthDuration(ctx *object.Context, args ...object.Item) object.Item {
+	l := args[0].(*object.YearMonthDuration)
+	r := args[1].(*object.YearMonthDuration)
+	if r.Months() == 0 {
+		return NewCodedError("FOAR0001", "division by zero")
+	}
+	return NewDecimal(float64(l.Months()) / float64(r.Months()))
+}
+
+func opAddDayTimeDurations(ctx *object.Context, args ...object.Item) object.Item {
+	l := args[0].(*object.DayTimeDuration)
+	r := args[1].(*object.DayTimeDuration)
+	dur, ok := addInt64(int64(l.Duration()), int64(r.Duration()))
+	if !ok {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	return NewDayTimeDuration(time.Duration(dur))
+}
+
+func opSubtractDayTimeDurations(ctx *object.Context, args ...object.Item) object.Item {
+	l := args[0].(*object.DayTimeDuration)
+	r := args[1].(*object.DayTimeDuration)
+	if r.Duration() == math.MinInt64 {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	dur, ok := addInt64(int64(l.Duration()), -int64(r.Duration()))
+	if !ok {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	return NewDayTimeDuration(time.Duration(dur))
+}
+
+func opMultiplyDayTimeDuration(ctx *object.Context, args ...object.Item) object.Item {
+	d := args[0].(*object.DayTimeDuration)
+	n, e := durationFactor(args[1])
+	if e != nil {
+		return e
+	}
+	return dayTimeDuration(math.Round(float64(d.Duration()) * n))
+}
+
+func opDivideDayTimeDuration(ctx *object.Context, args ...object.Item) object.Item {
+	d := args[0].(*object.DayTimeDuration)
+	n, e := durationFactor(args[1])
+	if e != nil {
+		return e
+	}
+	if n == 0 {
+		return NewCodedError("FODT0002", "duration overflow: division by zero")
+	}
+	return dayTimeDuration(math.Round(float64(d.Duration()) / n))
+}
+
+func opDivideDayTimeDurationByDayTimeDuration(ctx *object.Context, args ...object.Item) object.Item {
+	l := args[0].(*object.DayTimeDuration)
+	r := args[1].(*object.DayTimeDuration)
+	if r.Duration() == 0 {
+		return NewCodedError("FOAR0001", "division by zero")
+	}
+	return NewDecimal(float64(l.Duration()) / float64(r.Duration()))
+}
+
+// addInt64 returns a + b, ok is false if the sum overflows
+func addInt64(a, b int64) (int64, bool) {
+	sum := a + b
+	return sum, (sum > a) == (b > 0)
+}
+
+// yearMonthDuration returns the duration of the months, it is an error if they do not fit in an int
+func yearMonthDuration(months float64) object.Item {
+	if months >= math.MaxInt64 || months < math.MinInt64 {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	return NewYearMonthDuration(int(months))
+}
+
+// dayTimeDuration returns the duration of the nanoseconds, it is an error if they do not fit in a time.Duration
+func dayTimeDuration(ns float64) object.Item {
+	if ns >= math.MaxInt64 || ns < math.MinInt64 {
+		return NewCodedError("FODT0002", "duration overflow")
+	}
+	return NewDayTimeDuration(time.Duration(ns))
+}
+
+// sumDurations returns the sum of the durations in items for fn:sum and fn:avg
+// The items must be all xs:yearMonthDuration or all xs:dayTimeDuration
+func sumDurations(items []object.Item) object.Item {
+	var sum int64
+	var ok bool
+
+	switch items[0].(type) {
+	case *object.YearMonthDuration:
+		for _, item := range items {
+			d, isYM := item.(*object.YearMonthDuration)
+			if !isYM {
+				return NewCodedError("FORG0006", "cannot add %s to xs:yearMonthDuration", TypeName(item))
+			}
+			if sum, ok = addInt64(sum, int64(d.Months())); !ok {
+				return NewCodedError("FODT0002", "duration overflow")
+			}
+		}
+		return NewYearMonthDuration(int(sum))
+	case *object.DayTimeDuration:
+		for _, item := range items {
+			d, isDT := item.(*object.DayTimeDuration)
+			if !isDT {
+				return NewCodedError("FORG0006", "cannot add %s to xs:dayTimeDuration", TypeName(item))
+			}
+			if sum, ok = addInt64(sum, int64(d.Duration())); !ok {
+				return NewCodedError("FODT0002", "duration overflow")
+			}
+		}
+		return NewDayTimeDuration(time.Duration(sum))
+	}
+	return NewCodedError("FORG0006", "cannot sum items of type %s", TypeName(items[0]))
+}
+
+// durationFactor returns the numeric operand of the duration multiplication and division
+func durationFactor(item object.Item) (float64, object.Item) {
+	var n float64
+	switch item := item.(type) {
+	case *object.Integer:
+		n = float64(item.Value())
+	case *object.Decimal:
+		n = item.Value()
+	case *object.Double:
+		n = item.Value()
+	default:
+		return 0, NewCodedError("XPTY0004", "cannot match item type with required type")
+	}
+
+	if math.IsNaN(n) {
+		return 0, NewCodedError("FOCA0005", "cannot multiply or divide a duration by NaN")
+	}
+	if math.IsInf(n, 0) {
+		return 0, NewCodedError("FODT0002", "duration overflow")
+	}
+	return n, nil
+}
+
+// temporalArg unwraps the argument of a date, time or duration function.
+// It returns nil for an empty sequence. Strings and nodes are cast to the required type.
+// xs:duration as the required type accepts all the duration types.
+func temporalArg(arg object.Item, ty object.Type) object.Item {
+	if seq, ok := arg.(*object.Sequence); ok {
+		switch len(seq.Items) {
+		case 0:
+			return nil
+		case 1:
+			return temporalArg(seq.Items[0], ty)
+		default:
+			return NewCodedError("XPTY0004", "wrong number of sequence items. got=%d, want=1", len(seq.Items))
+		}
+	}
+
+	switch {
+	case arg.Type() == ty:
+		return arg
+	case ty == object.DurationType && IsDuration(arg):
+		return arg
+	case IsString(arg) || IsNode(arg):
+		return CastType(arg, ty)
+	}
+	return NewCodedError("XPTY0004", "cannot match item type with required type. got=%s, want=%s", TypeName(arg), ty)
+}
+
+// emptyOr returns the error item or an empty sequence if item is nil
+func emptyOr(item object.Item) object.Item {
+	if item == nil {
+		return NewSequence()
+	}
+	return item
+}
@@ -0,0 +1,433 @@
+package bif
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+// dateMarker is a variable marker in the picture string of fn:format-dateTime such as [Y0001] or [MNn,*-3]
+type dateMarker struct {
+	component rune
+	modifier  string
+	second    rune
+	width     bool
+	min       int
+	max       int
+}
+
+var (
+	dateComponents = map[object.Type]string{
+		object.DateTimeType: "YMDdFWwHhPmsfZzCE",
+		object.DateType:     "YMDdFWwZzCE",
+		object.TimeType:     "HhPmsfZzC",
+	}
+	defaultModifiers = map[rune]string{
+		'F': "n", 'P': "n", 'C': "n", 'E': "n",
+		'm': "01", 's': "01", 'Z': "01:01", 'z': "01:01",
+	}
+	militaryZones = "YXWVUTSRQPONZABCDEFGHIKLM"
+)
+
+func fnFormatDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return formatTemporal(args, object.DateTimeType, "fn:format-dateTime")
+}
+
+func fnFormatDate(ctx *object.Context, args ...object.Item) object.Item {
+	return formatTemporal(args, object.DateType, "fn:format-date")
+}
+
+func fnFormatTime(ctx *object.Context, args ...object.Item) object.Item {
+	return formatTemporal(args, object.TimeType, "fn:format-time")
+}
+
+func formatTemporal(args []object.Item, ty object.Type, name string) object.Item {
+	if len(args) < 2 {
+		return NewCodedError("XPST0017", "too few parameters for function call: %s", name)
+	}
+	if len(args) > 5 {
+		return NewCodedError("XPST0017", "too many parameters for function call: %s", name)
+	}
+	if len(args) != 2 && len(args) != 5 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=2 or 5", len(args))
+	}
+
+	arg := temporalArg(args[0], ty)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+	dt := arg.(object.DateTimeItem)
+
+	picture, e := stringArg(args[1])
+	if e != nil {
+		return e
+	}
+
+	var sb strings.Builder
+	v := dt.Value()
+	if len(args) == 5 {
+		lang, e := stringArg(args[2])
+		if e != nil {
+			return e
+		}
+		calendar, e := stringArg(args[3])
+		if e != nil {
+			return e
+		}
+		place, e := stringArg(args[4])
+		if e != nil {
+			return e
+		}
+
+		if lang != "" && lang != "en" && !strings.HasPrefix(lang, "en-") {
+			sb.WriteString("[Language: en]")
+		}
+		switch calendar {
+		case "", "AD", "ISO", "CE":
+		default:
+			sb.WriteString("[Calendar: AD]")
+		}
+		if place != "" && dt.HasTZ() {
+			if loc, err := time.LoadLocation(place); err == nil {
+				v = v.In(loc)
+			}
+		}
+	}
+
+	runes := []rune(picture)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '[' && i+1 < len(runes) && runes[i+1] == '[':
+			sb.WriteRune('[')
+			i++
+		case r == ']' && i+1 < len(runes) && runes[i+1] == ']':
+			sb.WriteRune(']')
+			i++
+		case r == ']':
+			return NewCodedError("FOFD1340", "invalid picture string for %s: %q", name, picture)
+		case r == '[':
+			end := i + 1
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return NewCodedError("FOFD1340", "invalid picture string for %s: %q", name, picture)
+			}
+
+			m, ok := parseDateMarker(runes[i+1 : end])
+			if !ok {
+				return NewCodedError("FOFD1340", "invalid picture string for %s: %q", name, picture)
+			}
+			if !strings.ContainsRune(dateComponents[ty], m.component) {
+				return NewCodedError("FOFD1350", "the component [%c] is not available in %s", m.component, ty)
+			}
+
+			s, e := m.format(v, dt.HasTZ())
+			if e != nil {
+				return e
+			}
+			sb.WriteString(s)
+			i = end
+		default:
+			sb.WriteRune(r)
+		}
+	}
+
+	return NewString(sb.String())
+}
+
+func parseDateMarker(runes []rune) (*dateMarker, bool) {
+	var marker []rune
+	for _, r := range runes {
+		if !unicode.IsSpace(r) {
+			marker = append(marker, r)
+		}
+	}
+	if len(marker) == 0 || !strings.ContainsRune(dateComponents[object.DateTimeType], marker[0]) {
+		return nil, false
+	}
+
+	m := &dateMarker{component: marker[0], min: -1, max: -1}
+	rest := string(marker[1:])
+
+	if i := strings.LastIndex(rest, ","); i >= 0 {
+		width := rest[i+1:]
+		rest = rest[:i]
+		m.width = true
+
+		min, max := width, "*"
+		if j := strings.Index(width, "-"); j >= 0 {
+			min, max = width[:j], width[j+1:]
+		}
+
+		var ok bool
+		if m.min, ok = widthValue(min); !ok {
+			return nil, false
+		}
+		if m.max, ok = widthValue(max); !ok {
+			return nil, false
+		}
+		if m.max > 0 && m.min > m.max {
+			return nil, false
+		}
+	}
+
+	if n := len(rest); n > 0 && strings.ContainsRune("toc", rune(rest[n-1])) {
+		m.second = rune(rest[n-1])
+		rest = rest[:n-1]
+	}
+
+	m.modifier = rest
+	if m.modifier == "" {
+		m.modifier = defaultModifiers[m.component]
+		if m.modifier == "" {
+			m.modifier = "1"
+		}
+	}
+	return m, true
+}
+
+// widthValue parses the min or max width of a marker, * is returned as -1
+func widthValue(s string) (int, bool) {
+	if s == "*" {
+		return -1, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+func (m *dateMarker) format(v time.Time, tz bool) (string, *object.Error) {
+	switch m.component {
+	case 'Z', 'z':
+		return m.formatTimezone(v, tz), nil
+	case 'f':
+		return m.formatFraction(v.Nanosecond()), nil
+	}
+
+	var n int
+	var name string
+	switch m.component {
+	case 'Y':
+		n = v.Year()
+	case 'M':
+		n = int(v.Month())
+		name = v.Month().String()
+	case 'D':
+		n = v.Day()
+	case 'd':
+		n = v.YearDay()
+	case 'F':
+		n = int(v.Weekday())
+		if n == 0 {
+			n = 7
+		}
+		name = v.Weekday().String()
+	case 'W':
+		_, n = v.ISOWeek()
+	case 'w':
+		n = weekOfMonth(v)
+	case 'H':
+		n = v.Hour()
+	case 'h':
+		n = v.Hour() % 12
+		if n == 0 {
+			n = 12
+		}
+	case 'P':
+		name = "am"
+		if v.Hour() >= 12 {
+			name = "pm"
+		}
+	case 'm':
+		n = v.Minute()
+	case 's':
+		n = v.Second()
+	case 'C':
+		name = "ISO"
+	case 'E':
+		name = "AD"
+		if v.Year() <= 0 {
+			name = "BC"
+		}
+	}
+
+	switch m.modifier {
+	case "N", "n", "Nn":
+		if name == "" {
+			break
+		}
+		switch m.modifier {
+		case "N":
+			name = strings.ToUpper(name)
+		case "n":
+			name = strings.ToLower(name)
+		case "Nn":
+			name = titleCase(strings.ToLower(name))
+		}
+		if m.max > 0 && len(name) > m.max {
+			name = name[:m.max]
+		}
+		for len(name) < m.min {
+			name += " "
+		}
+		return name, nil
+	}
+
+	if name != "" && n == 0 {
+		// the component has no numeric value such as [P] or [E]
+		return name, nil
+	}
+
+	dp, ok, e := parseDigitPattern(m.modifier)
+	if e != nil {
+		return "", e
+	}
+	if !ok {
+		return formatNumbering(n, m.modifier, m.second == 'o')
+	}
+
+	min, max := m.digitWidth(dp)
+
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	s := strconv.Itoa(n)
+	if m.component == 'Y' && max > 0 && len(s) > max {
+		s = s[len(s)-max:]
+	}
+	for len(s) < min {
+		s = "0" + s
+	}
+	s = dp.group(translateDigits(s, dp.zero))
+	if m.second == 'o' {
+		s += ordinalSuffix(n)
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+// digitWidth returns the min and max number of digits, -1 means unbounded.
+// Without a width modifier they are taken from the digit pattern, so [Y01] gives a two-digit year.
+func (m *dateMarker) digitWidth(dp *digitPattern) (int, int) {
+	if m.width {
+		min := 1
+		if m.min > 0 {
+			min = m.min
+		}
+		return min, m.max
+	}
+
+	if dp.digits > 1 {
+		return dp.mandatory, dp.digits
+	}
+	return dp.mandatory, -1
+}
+
+// formatFraction formats fractional seconds, the digits are truncated to the max width
+func (m *dateMarker) formatFraction(nsec int) string {
+	dp, ok, _ := parseDigitPattern(m.modifier)
+	if !ok {
+		dp, _, _ = parseDigitPattern("1")
+	}
+
+	min, max := m.digitWidth(dp)
+
+	s := strings.TrimRight(strconv.Itoa(nsec + 1e9)[1:], "0")
+	if max > 0 && len(s) > max {
+		s = s[:max]
+	}
+	for len(s) < min {
+		s += "0"
+	}
+	return translateDigits(s, dp.zero)
+}
+
+func (m *dateMarker) formatTimezone(v time.Time, tz bool) string {
+	if !tz {
+		return ""
+	}
+
+	_, offset := v.Zone()
+	if offset == 0 && m.second == 't' {
+		return "Z"
+	}
+
+	modifier := m.modifier
+	if modifier == "Z" {
+		if offset%3600 == 0 && offset >= -12*3600 && offset <= 12*3600 {
+			return string(militaryZones[offset/3600+12])
+		}
+		modifier = "01:01"
+	}
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	hours, minutes := offset/3600, offset%3600/60
+
+	var digits, sep []rune
+	for _, r := range modifier {
+		switch {
+		case unicode.IsDigit(r) && sep == nil:
+			digits = append(digits, r)
+		case sep == nil:
+			sep = append(sep, r)
+		}
+	}
+	if len(digits) == 0 {
+		digits, sep = []rune("01"), []rune(":")
+	}
+
+	pad := func(n, width int) string {
+		s := strconv.Itoa(n)
+		for len(s) < width {
+			s = "0" + s
+		}
+		return s
+	}
+
+	var s string
+	switch {
+	case sep != nil:
+		s = sign + pad(hours, len(digits)) + string(sep) + pad(minutes, 2)
+	case len(digits) <= 2:
+		s = sign + pad(hours, len(digits))
+		if minutes != 0 {
+			s += ":" + pad(minutes, 2)
+		}
+	default:
+		s = sign + pad(hours, len(digits)-2) + pad(minutes, 2)
+	}
+
+	if m.component == 'z' {
+		return "GMT" + s
+	}
+	return s
+}
+
+// weekOfMonth returns the week number within the month, weeks start on Monday
+// and the first week of a month is the one that contains the first Thursday
+func weekOfMonth(v time.Time) int {
+	first := time.Date(v.Year(), v.Month(), 1, 0, 0, 0, 0, v.Location())
+	offset := (int(first.Weekday()) + 6) % 7
+
+	week := (v.Day()-1+offset)/7 + 1
+	if offset > 3 {
+		week--
+	}
+	if week == 0 {
+		return weekOfMonth(first.AddDate(0, 0, -1))
+	}
+	return week
+}
@@ -0,0 +1,303 @@
+package bif
+
+import (
+	"time"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+func fnDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	if len(args) != 2 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arg1 := temporalArg(args[0], object.DateType)
+	if arg1 == nil || IsError(arg1) {
+		return emptyOr(arg1)
+	}
+	arg2 := temporalArg(args[1], object.TimeType)
+	if arg2 == nil || IsError(arg2) {
+		return emptyOr(arg2)
+	}
+
+	d := arg1.(*object.Date)
+	t := arg2.(*object.Time)
+
+	loc := time.UTC
+	switch {
+	case d.HasTZ() && t.HasTZ():
+		_, do := d.Value().Zone()
+		_, to := t.Value().Zone()
+		if do != to {
+			return NewCodedError("FORG0008", "the two arguments to fn:dateTime have inconsistent timezones")
+		}
+		loc = d.Value().Location()
+	case d.HasTZ():
+		loc = d.Value().Location()
+	case t.HasTZ():
+		loc = t.Value().Location()
+	}
+
+	dv, tv := d.Value(), t.Value()
+	v := time.Date(dv.Year(), dv.Month(), dv.Day(), tv.Hour(), tv.Minute(), tv.Second(), tv.Nanosecond(), loc)
+	return NewDateTime(v, d.HasTZ() || t.HasTZ())
+}
+
+func fnYearFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "year")
+}
+
+func fnMonthFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "month")
+}
+
+func fnDayFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "day")
+}
+
+func fnHoursFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "hours")
+}
+
+func fnMinutesFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "minutes")
+}
+
+func fnSecondsFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "seconds")
+}
+
+func fnTimezoneFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateTimeType, "timezone")
+}
+
+func fnYearFromDate(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateType, "year")
+}
+
+func fnMonthFromDate(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateType, "month")
+}
+
+func fnDayFromDate(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateType, "day")
+}
+
+func fnTimezoneFromDate(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.DateType, "timezone")
+}
+
+func fnHoursFromTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.TimeType, "hours")
+}
+
+func fnMinutesFromTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.TimeType, "minutes")
+}
+
+func fnSecondsFromTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.TimeType, "seconds")
+}
+
+func fnTimezoneFromTime(ctx *object.Context, args ...object.Item) object.Item {
+	return dateTimeComponent(args, object.TimeType, "timezone")
+}
+
+func dateTimeComponent(args []object.Item, ty object.Type, component string) object.Item {
+	if len(args) != 1 {
+		return NewCodedError("XPST0017", "wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arg := temporalArg(args[0], ty)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+
+	dt := arg.(object.DateTimeItem)
+	v := dt.Value()
+
+	switch component {
+	case "year":
+		return NewInteger(v.Year())
+	case "month":
+		return NewInteger(int(v.Month()))
+	case "day":
+		return NewInteger(v.Day())
+	case "hours":
+		return NewInteger(v.Hour())
+	case "minutes":
+		return NewInteger(v.Minute())
+	case "seconds":
+		return NewDecimal(float64(v.Second()) + float64(v.Nanosecond())/1e9)
+	case "timezone":
+		if !dt.HasTZ() {
+			return NewSequence()
+		}
+		_, offset := v.Zone()
+		return NewDayTimeDuration(time.Duration(offset) * time.Second)
+	}
+	return NewCodedError("FOER0000", "unknown component: %s", component)
+}
+
+func fnAdjustDateTimeToTimezone(ctx *object.Context, args ...object.Item) object.Item {
+	return adjustToTimezone(ctx, args, object.DateTimeType)
+}
+
+func fnAdjustDateToTimezone(ctx *object.Context, args ...object.Item) object.Item {
+	return adjustToTimezone(ctx, args, object.DateType)
+}
+
+func fnAdjustTimeToTimezone(ctx *object.Context, args ...object.Item) object.Item {
+	return adjustToTimezone(ctx, args, object.TimeType)
+}
+
+// adjustToTimezone implements fn:adjust-dateTime-to-timezone, fn:adjust-date-to-timezone and fn:adjust-time-to-timezone.
+// Without the second argument the value is adjusted to the implicit timezone.
+// An empty sequence as the second argument removes the timezone.
+func adjustToTimezone(ctx *object.Context, args []object.Item, ty object.Type) object.Item {
+	if len(args) < 1 {
+		return NewCodedError("XPST0017", "too few parameters for function call: fn:adjust-%s-to-timezone", ty[3:])
+	}
+	if len(args) > 2 {
+		return NewCodedError("XPST0017", "too many parameters for function call: fn:adjust-%s-to-timezone", ty[3:])
+	}
+
+	arg := temporalArg(args[0], ty)
+	if arg == nil || IsError(arg) {
+		return emptyOr(arg)
+	}
+	dt := arg.(object.DateTimeItem)
+
+	var loc *time.Location
+	if len(args) == 1 {
+		loc = currentDateTime(ctx).Location()
+	} else {
+		tz := temporalArg(args[1], object.DayTimeDurationType)
+		if IsError(tz) {
+			return tz
+		}
+		if tz != nil {
+			offset := tz.(*object.DayTimeDuration).Duration()
+			if offset < -14*time.Hour || offset > 14*time.Hour || offset%time.Minute != 0 {
+				return NewCodedError("FODT0003", "invalid timezone value: %s", tz.Inspect())
+			}
+			loc = time.FixedZone("", int(offset/time.Second))
+		}
+	}
+
+	v := dt.Value()
+	switch {
+	case loc == nil:
+		v = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), time.UTC)
+	case !dt.HasTZ():
+		v = time.Date(v.Year(), v.Month(), v.Day(), v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), loc)
+	default:
+		v = v.In(loc)
+	}
+
+	switch ty {
+	case object.DateType:
+		return NewDate(v, loc != nil)
+	case object.TimeType:
+		return NewTime(v, loc != nil)
+	}
+	return NewDateTime(v, loc != nil)
+}
+
+func opSubtractDateTimes(ctx *object.Context, args ...object.Item) object.Item {
+	return subtractInstants(args[0].(object.DateTimeItem), args[1].(object.DateTimeItem))
+}
+
+func opSubtractDates(ctx *object.Context, args ...object.Item) object.Item {
+	return subtractInstants(args[0].(object.DateTimeItem), args[1].(object.DateTimeItem))
+}
+
+func opSubtractTimes(ctx *object.Context, args ...object.Item) object.Item {
+	return subtractInstants(args[0].(object.DateTimeItem), args[1].(object.DateTimeItem))
+}
+
+func opAddYearMonthDurationToDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.DateTime)
+	d := args[1].(*object.YearMonthDuration)
+	return NewDateTime(addMonths(dt.Value(), d.Months()), dt.HasTZ())
+}
+
+func opAddDayTimeDurationToDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.DateTime)
+	d := args[1].(*object.DayTimeDuration)
+	return NewDateTime(dt.Value().Add(d.Duration()), dt.HasTZ())
+}
+
+func opSubtractYearMonthDurationFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.DateTime)
+	d := args[1].(*object.YearMonthDuration)
+	return NewDateTime(addMonths(dt.Value(), -d.Months()), dt.HasTZ())
+}
+
+func opSubtractDayTimeDurationFromDateTime(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.DateTime)
+	d := args[1].(*object.DayTimeDuration)
+	return NewDateTime(dt.Value().Add(-d.Duration()), dt.HasTZ())
+}
+
+func opAddYearMonthDurationToDate(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.Date)
+	d := args[1].(*object.YearMonthDuration)
+	return NewDate(addMonths(dt.Value(), d.Months()), dt.HasTZ())
+}
+
+func opAddDayTimeDurationToDate(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.Date)
+	d := args[1].(*object.DayTimeDuration)
+	return NewDate(dt.Value().Add(d.Duration()), dt.HasTZ())
+}
+
+func opSubtractYearMonthDurationFromDate(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.Date)
+	d := args[1].(*object.YearMonthDuration)
+	return NewDate(addMonths(dt.Value(), -d.Months()), dt.HasTZ())
+}
+
+func opSubtractDayTimeDurationFromDate(ctx *object.Context, args ...object.Item) object.Item {
+	dt := args[0].(*object.Date)
+	d := args[1].(*object.DayTimeDuration)
+	return NewDate(dt.Value().Add(-d.Duration()), dt.HasTZ())
+}
+
+func opAddDayTimeDurationToTime(ctx *object.Context, args ...object.Item) object.Item {
+	t := args[0].(*object.Time)
+	d := args[1].(*object.DayTimeDuration)
+	return NewTime(t.Value().Add(d.Duration()), t.HasTZ())
+}
+
+func opSubtractDayTimeDurationFromTime(ctx *object.Context, args ...object.Item) object.Item {
+	t := args[0].(*object.Time)
+	d := args[1].(*object.DayTimeDuration)
+	return NewTime(t.Value().Add(-d.Duration()), t.HasTZ())
+}
+
+func subtractInstants(l, r object.DateTimeItem) object.Item {
+	li := object.Instant(l.Value(), l.HasTZ())
+	ri := object.Instant(r.Value(), r.HasTZ())
+	return NewDayTimeDuration(li.Sub(ri))
+}
+
+// addMonths adds months to t. The day is pinned to the last day of the resulting month if it does not exist,
+// so 2021-01-31 plus one month is 2021-02-28
+func addMonths(t time.Time, months int) time.Time {
+	y, m, d := t.Date()
+
+	total := int(m) - 1 + months
+	y += total / 12
+	total %= 12
+	if total < 0 {
+		total += 12
+		y--
+	}
+	month := time.Month(total + 1)
+
+	if last := daysIn(y, month); d > last {
+		d = last
+	}
+	return time.Date(y, month, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
@@ -4,10 +4,27 @@ import (
 	"github.com/zzossig/rabbit/ast"
 	"github.com/zzossig/rabbit/bif"
 	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/token"
 )
 
 // Eval function evaluate a ast.ExprSingle to object.Item
+// An error is annotated with the innermost sub-expression that raised it and the position of the sub-expression in the input
+// A result that exceeds the limits of ctx.Guard is replaced by an error
 func Eval(expr ast.ExprSingle, ctx *object.Context) object.Item {
+	item := evalExprSingle(expr, ctx)
+	if e := ctx.Guard.CheckItem(item); e != nil {
+		item = e
+	}
+	if e, ok := item.(*object.Error); ok && e.Expr == "" {
+		if tok, ok := exprToken(expr); ok {
+			e.Expr = expr.String()
+			e.Pos = tok.Pos
+		}
+	}
+	return item
+}
+
+func evalExprSingle(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	switch expr := expr.(type) {
 	case *ast.XPath:
 		return evalXPath(expr, ctx)
@@ -47,6 +64,12 @@ func Eval(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		return evalMultiplicativeExpr(expr, ctx)
 	case *ast.StringConcatExpr:
 		return evalStringConcatExpr(expr, ctx)
+	case *ast.StringTemplate:
+		return evalStringTemplate(expr, ctx)
+	case *ast.OtherwiseExpr:
+		return evalOtherwiseExpr(expr, ctx)
+	case *ast.TryCatchExpr:
+		return evalTryCatchExpr(expr, ctx)
 	case *ast.RangeExpr:
 		return evalRangeExpr(expr, ctx)
 	case *ast.ComparisonExpr:
@@ -97,5 +120,69 @@ func Eval(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		return evalTreatExpr(expr, ctx)
 	}
 
-	return bif.NewError("not a valid xpath expression")
+	return bif.NewCodedError("XPST0003", "not a valid xpath expression")
+}
+
+// exprToken returns the token that locates the expression in the input.
+// Some expressions such as literals and parenthesized expressions have no token,
+// an error raised by them is located by the enclosing expression.
+func exprToken(expr ast.ExprSingle) (token.Token, bool) {
+	var tok token.Token
+	switch expr := expr.(type) {
+	case *ast.FunctionCall:
+		tok = expr.Token
+	case *ast.VarRef:
+		tok = expr.Token
+	case *ast.ContextItemExpr:
+		tok = expr.Token
+	case *ast.PostfixExpr:
+		tok = expr.Token
+	case *ast.ArrowExpr:
+		if len(expr.Bindings) > 0 {
+			tok = expr.Bindings[0].Token
+		}
+	case *ast.AdditiveExpr:
+		tok = expr.Token
+	case *ast.MultiplicativeExpr:
+		tok = expr.Token
+	case *ast.UnaryExpr:
+		tok = expr.Token
+	case *ast.StringConcatExpr:
+		tok = expr.Token
+	case *ast.OtherwiseExpr:
+		tok = expr.Token
+	case *ast.TryCatchExpr:
+		tok = expr.Token
+	case *ast.RangeExpr:
+		tok = expr.Token
+	case *ast.ComparisonExpr:
+		tok = expr.Token
+	case *ast.UnionExpr:
+		tok = expr.Token
+	case *ast.IntersectExceptExpr:
+		tok = expr.Token
+	case *ast.OrExpr:
+		tok = expr.Token
+	case *ast.AndExpr:
+		tok = expr.Token
+	case *ast.SimpleMapExpr:
+		tok = expr.Token
+	case *ast.QuantifiedExpr:
+		tok = expr.Token
+	case *ast.UnaryLookup:
+		tok = expr.Token
+	case *ast.PathExpr:
+		tok = expr.Token
+	case *ast.RelativePathExpr:
+		tok = expr.Token
+	case *ast.InstanceofExpr:
+		tok = expr.Token
+	case *ast.CastExpr:
+		tok = expr.Token
+	case *ast.CastableExpr:
+		tok = expr.Token
+	case *ast.TreatExpr:
+		tok = expr.Token
+	}
+	return tok, tok.Type != ""
 }
@@ -23,6 +23,9 @@ func evalComparisonExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	op := ce.Token
 
 	switch {
+	case isTemporalComparand(left) || isTemporalComparand(right):
+		return compSeqSeq(op, bif.NewSequence(bif.UnwrapSeq(left)...), bif.NewSequence(bif.UnwrapSeq(right)...), ctx)
+
 	case bif.IsSeq(left) && bif.IsNumeric(right):
 		return compSeqNumber(op, left, right)
 	case bif.IsSeq(left) && bif.IsString(right):
@@ -70,7 +73,17 @@ func evalComparisonExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		return compBool(op, left, right)
 	}
 
-	return bif.NewError("the operator '%s' is not defined for operands of type %s and %s", op.Literal, left.Type(), right.Type())
+	return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s", op.Literal, bif.TypeName(left), bif.TypeName(right))
+}
+
+// isTemporalComparand checks if item is a date, time or duration or a sequence that contains one of them
+func isTemporalComparand(item object.Item) bool {
+	for _, it := range bif.UnwrapSeq(item) {
+		if bif.IsTemporal(it) {
+			return true
+		}
+	}
+	return false
 }
 
 func compNumberNumber(op token.Token, left, right object.Item) object.Item {
@@ -112,7 +125,7 @@ func compNumberNumber(op token.Token, left, right object.Item) object.Item {
 
 		return boolean
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -193,7 +206,7 @@ func compNumberArray(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -274,7 +287,7 @@ func compNumberSeq(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -296,7 +309,7 @@ func compStringString(op token.Token, left, right object.Item) object.Item {
 	case token.GE, token.GEV:
 		return bif.NewBoolean(leftVal >= rightVal)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -309,7 +322,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		for _, item := range rightVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if leftVal.Value() == e.Value() {
 				return bif.NewBoolean(true)
@@ -320,7 +333,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		for _, item := range rightVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if leftVal.Value() != e.Value() {
 				return bif.NewBoolean(true)
@@ -331,7 +344,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		for _, item := range rightVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if leftVal.Value() < e.Value() {
 				return bif.NewBoolean(true)
@@ -342,7 +355,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		for _, item := range rightVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if leftVal.Value() <= e.Value() {
 				return bif.NewBoolean(true)
@@ -353,7 +366,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		for _, item := range rightVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if leftVal.Value() > e.Value() {
 				return bif.NewBoolean(true)
@@ -364,7 +377,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		for _, item := range rightVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if leftVal.Value() >= e.Value() {
 				return bif.NewBoolean(true)
@@ -372,7 +385,7 @@ func compStringArray(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -402,7 +415,7 @@ func compStringSeq(op token.Token, left, right object.Item) object.Item {
 				continue
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.NE, token.NEV:
@@ -426,7 +439,7 @@ func compStringSeq(op token.Token, left, right object.Item) object.Item {
 				continue
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.LT, token.LTV:
@@ -444,7 +457,7 @@ func compStringSeq(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.LE, token.LEV:
@@ -462,7 +475,7 @@ func compStringSeq(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.GT, token.GTV:
@@ -480,7 +493,7 @@ func compStringSeq(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.GE, token.GEV:
@@ -498,11 +511,11 @@ func compStringSeq(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -583,7 +596,7 @@ func compArrayNumber(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -596,7 +609,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		for _, item := range leftVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if e.Value() == rightVal.Value() {
 				return bif.NewBoolean(true)
@@ -607,7 +620,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		for _, item := range leftVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if e.Value() != rightVal.Value() {
 				return bif.NewBoolean(true)
@@ -618,7 +631,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		for _, item := range leftVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if e.Value() < rightVal.Value() {
 				return bif.NewBoolean(true)
@@ -629,7 +642,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		for _, item := range leftVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if e.Value() <= rightVal.Value() {
 				return bif.NewBoolean(true)
@@ -640,7 +653,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		for _, item := range leftVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if e.Value() > rightVal.Value() {
 				return bif.NewBoolean(true)
@@ -651,7 +664,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		for _, item := range leftVal.Items {
 			e, ok := item.(*object.String)
 			if !ok {
-				return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+				return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 			}
 			if e.Value() >= rightVal.Value() {
 				return bif.NewBoolean(true)
@@ -659,7 +672,7 @@ func compArrayString(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -753,7 +766,7 @@ func compArraySeq(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -847,7 +860,7 @@ func compArrayArray(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -928,7 +941,7 @@ func compSeqNumber(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -958,7 +971,7 @@ func compSeqString(op token.Token, left, right object.Item) object.Item {
 				continue
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.NE, token.NEV:
@@ -982,7 +995,7 @@ func compSeqString(op token.Token, left, right object.Item) object.Item {
 				continue
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.LT, token.LTV:
@@ -1000,7 +1013,7 @@ func compSeqString(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.LE, token.LEV:
@@ -1018,7 +1031,7 @@ func compSeqString(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.GT, token.GTV:
@@ -1036,7 +1049,7 @@ func compSeqString(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	case token.GE, token.GEV:
@@ -1054,11 +1067,11 @@ func compSeqString(op token.Token, left, right object.Item) object.Item {
 				return bif.NewBoolean(true)
 			}
 
-			return bif.NewError("cannot compare types: %s, %s", leftVal.Type(), item.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare types: %s, %s", bif.TypeName(leftVal), bif.TypeName(item))
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -1152,7 +1165,7 @@ func compSeqArray(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -1247,16 +1260,15 @@ func compSeqSeq(op token.Token, left, right object.Item, ctx *object.Context) ob
 		return bif.NewBoolean(false)
 	case token.IS, token.DGT, token.DLT:
 		if len(leftVal.Items) != 1 {
-			return bif.NewError("wrong number of items. got=%d, expected=1", len(leftVal.Items))
+			return bif.NewCodedError("XPTY0004", "wrong number of items. got=%d, expected=1", len(leftVal.Items))
 		}
 		if len(rightVal.Items) != 1 {
-			return bif.NewError("wrong number of items. got=%d, expected=1", len(rightVal.Items))
+			return bif.NewCodedError("XPTY0004", "wrong number of items. got=%d, expected=1", len(rightVal.Items))
 		}
 		if !bif.IsNode(leftVal.Items[0]) || !bif.IsNode(rightVal.Items[0]) {
-			return bif.NewError("node types expected. got=%s, %s", leftVal.Items[0].Type(), rightVal.Items[0].Type())
+			return bif.NewCodedError("XPTY0004", "node types expected. got=%s, %s", bif.TypeName(leftVal.Items[0]), bif.TypeName(rightVal.Items[0]))
 		}
 
-		doc := ctx.Doc.(*object.BaseNode)
 		if leftItem, ok := leftVal.Items[0].(*object.BaseNode); ok {
 			switch rightItem := rightVal.Items[0].(type) {
 			case *object.BaseNode:
@@ -1264,18 +1276,18 @@ func compSeqSeq(op token.Token, left, right object.Item, ctx *object.Context) ob
 				case token.IS:
 					return bif.NewBoolean(leftItem.Tree() == rightItem.Tree())
 				case token.DGT:
-					return bif.IsPrecede(rightItem, leftItem, doc)
+					return bif.Precedes(rightItem, leftItem, ctx)
 				case token.DLT:
-					return bif.IsPrecede(leftItem, rightItem, doc)
+					return bif.Precedes(leftItem, rightItem, ctx)
 				}
 			case *object.AttrNode:
 				switch op.Type {
 				case token.IS:
 					return bif.NewBoolean(false)
 				case token.DGT:
-					return bif.IsPrecede(rightItem, leftItem, doc)
+					return bif.Precedes(rightItem, leftItem, ctx)
 				case token.DLT:
-					return bif.IsPrecede(leftItem, rightItem, doc)
+					return bif.Precedes(leftItem, rightItem, ctx)
 				}
 			}
 		}
@@ -1287,37 +1299,37 @@ func compSeqSeq(op token.Token, left, right object.Item, ctx *object.Context) ob
 				case token.IS:
 					return bif.NewBoolean(false)
 				case token.DGT:
-					return bif.IsPrecede(rightItem, leftItem, doc)
+					return bif.Precedes(rightItem, leftItem, ctx)
 				case token.DLT:
-					return bif.IsPrecede(leftItem, rightItem, doc)
+					return bif.Precedes(leftItem, rightItem, ctx)
 				}
 			case *object.AttrNode:
 				switch op.Type {
 				case token.IS:
 					return bif.NewBoolean(leftItem.Tree() == rightItem.Tree() && leftItem.Key() == rightItem.Key())
 				case token.DGT:
-					return bif.IsPrecede(rightItem, leftItem, doc)
+					return bif.Precedes(rightItem, leftItem, ctx)
 				case token.DLT:
-					return bif.IsPrecede(leftItem, rightItem, doc)
+					return bif.Precedes(leftItem, rightItem, ctx)
 				}
 			}
 		}
 
 		return bif.NewBoolean(false)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
 func compBool(op token.Token, left, right object.Item) object.Item {
 	leftVal, ok := left.(*object.Boolean)
 	if !ok {
-		return bif.NewError("cannot compare Types: %s, %s", left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "cannot compare Types: %s, %s", bif.TypeName(left), bif.TypeName(right))
 	}
 
 	rightVal, ok := right.(*object.Boolean)
 	if !ok {
-		return bif.NewError("cannot compare Types: %s, %s", left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "cannot compare Types: %s, %s", bif.TypeName(left), bif.TypeName(right))
 	}
 
 	switch op.Type {
@@ -1346,7 +1358,7 @@ func compBool(op token.Token, left, right object.Item) object.Item {
 		}
 		return bif.NewBoolean(true)
 	default:
-		return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 	}
 }
 
@@ -1381,7 +1393,7 @@ func compNodeString(op token.Token, left, right object.Item, ctx *object.Context
 				return bif.NewBoolean(true)
 			}
 		case token.IS, token.DGT, token.DLT:
-			return bif.NewError("cannot compare node with string: %s, %s", leftNode.Type(), rightVal.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare node with string: %s, %s", bif.TypeName(leftNode), bif.TypeName(rightVal))
 		}
 	case *object.AttrNode:
 		switch op.Type {
@@ -1410,7 +1422,7 @@ func compNodeString(op token.Token, left, right object.Item, ctx *object.Context
 				return bif.NewBoolean(true)
 			}
 		case token.IS, token.DGT, token.DLT:
-			return bif.NewError("cannot compare node with string: %s, %s", leftNode.Type(), rightVal.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare node with string: %s, %s", bif.TypeName(leftNode), bif.TypeName(rightVal))
 		}
 	}
 
@@ -1448,7 +1460,7 @@ func compStringNode(op token.Token, left, right object.Item, ctx *object.Context
 				return bif.NewBoolean(true)
 			}
 		case token.IS, token.DGT, token.DLT:
-			return bif.NewError("cannot compare node with string: %s, %s", leftVal.Type(), rightNode.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare node with string: %s, %s", bif.TypeName(leftVal), bif.TypeName(rightNode))
 		}
 	case *object.AttrNode:
 		switch op.Type {
@@ -1477,7 +1489,7 @@ func compStringNode(op token.Token, left, right object.Item, ctx *object.Context
 				return bif.NewBoolean(true)
 			}
 		case token.IS, token.DGT, token.DLT:
-			return bif.NewError("cannot compare node with string: %s, %s", leftVal.Type(), rightNode.Type())
+			return bif.NewCodedError("XPTY0004", "cannot compare node with string: %s, %s", bif.TypeName(leftVal), bif.TypeName(rightNode))
 		}
 	}
 
@@ -1587,7 +1599,7 @@ func compNodeNumber(op token.Token, left, right object.Item, ctx *object.Context
 		}
 	}
 
-	return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+	return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 }
 
 func compNumberNode(op token.Token, left, right object.Item, ctx *object.Context) object.Item {
@@ -1692,7 +1704,7 @@ func compNumberNode(op token.Token, left, right object.Item, ctx *object.Context
 		}
 	}
 
-	return bif.NewError("the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, left.Type(), right.Type())
+	return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s\n", op.Literal, bif.TypeName(left), bif.TypeName(right))
 }
 
 func compNodeNode(op token.Token, left, right object.Item, ctx *object.Context) object.Item {
@@ -1727,11 +1739,9 @@ func compNodeNode(op token.Token, left, right object.Item, ctx *object.Context)
 			case token.IS:
 				return bif.NewBoolean(leftNode.Tree() == rightNode.Tree())
 			case token.DGT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(rightNode, leftNode, doc)
+				return bif.Precedes(rightNode, leftNode, ctx)
 			case token.DLT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(leftNode, rightNode, doc)
+				return bif.Precedes(leftNode, rightNode, ctx)
 			}
 		case *object.AttrNode:
 			switch op.Type {
@@ -1762,11 +1772,9 @@ func compNodeNode(op token.Token, left, right object.Item, ctx *object.Context)
 			case token.IS:
 				return bif.NewBoolean(false)
 			case token.DGT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(rightNode, leftNode, doc)
+				return bif.Precedes(rightNode, leftNode, ctx)
 			case token.DLT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(leftNode, rightNode, doc)
+				return bif.Precedes(leftNode, rightNode, ctx)
 			}
 		}
 	}
@@ -1802,11 +1810,9 @@ func compNodeNode(op token.Token, left, right object.Item, ctx *object.Context)
 			case token.IS:
 				return bif.NewBoolean(false)
 			case token.DGT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(rightNode, leftNode, doc)
+				return bif.Precedes(rightNode, leftNode, ctx)
 			case token.DLT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(leftNode, rightNode, doc)
+				return bif.Precedes(leftNode, rightNode, ctx)
 			}
 		case *object.AttrNode:
 			switch op.Type {
@@ -1837,11 +1843,9 @@ func compNodeNode(op token.Token, left, right object.Item, ctx *object.Context)
 			case token.IS:
 				return bif.NewBoolean(leftNode.Tree() == rightNode.Tree() && leftNode.Key() == rightNode.Key())
 			case token.DGT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(rightNode, leftNode, doc)
+				return bif.Precedes(rightNode, leftNode, ctx)
 			case token.DLT:
-				doc := ctx.Doc.(*object.BaseNode)
-				return bif.IsPrecede(leftNode, rightNode, doc)
+				return bif.Precedes(leftNode, rightNode, ctx)
 			}
 		}
 	}
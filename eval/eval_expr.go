@@ -1,6 +1,8 @@
 package eval
 
 import (
+	"strings"
+
 	"github.com/zzossig/rabbit/ast"
 	"github.com/zzossig/rabbit/bif"
 	"github.com/zzossig/rabbit/object"
@@ -39,6 +41,9 @@ func evalExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		seq := &object.Sequence{}
 		for _, e := range expr.Exprs {
 			item := Eval(e, ctx)
+			if bif.IsError(item) {
+				return item
+			}
 			seq.Items = append(seq.Items, item)
 		}
 		return seq
@@ -46,6 +51,9 @@ func evalExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		seq := &object.Sequence{}
 		for _, e := range expr.Exprs {
 			item := Eval(e, ctx)
+			if bif.IsError(item) {
+				return item
+			}
 			seq.Items = append(seq.Items, item)
 		}
 		return seq
@@ -53,6 +61,9 @@ func evalExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		seq := &object.Sequence{}
 		for _, e := range expr.Exprs {
 			item := Eval(e, ctx)
+			if bif.IsError(item) {
+				return item
+			}
 			seq.Items = append(seq.Items, item)
 		}
 		return seq
@@ -60,11 +71,14 @@ func evalExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		seq := &object.Sequence{}
 		for _, e := range expr.Exprs {
 			item := Eval(e, ctx)
+			if bif.IsError(item) {
+				return item
+			}
 			seq.Items = append(seq.Items, item)
 		}
 		return seq
 	}
-	return bif.NewError("unexpected xpath expression. %#v", expr)
+	return bif.NewCodedError("XPST0003", "unexpected xpath expression. %#v", expr)
 }
 
 func evalContextItem(expr ast.ExprSingle, ctx *object.Context) object.Item {
@@ -76,7 +90,7 @@ func evalContextItem(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		return ctx.CItem
 	}
 
-	return bif.NewError("context item is undefined")
+	return bif.NewCodedError("XPDY0002", "context item is undefined")
 }
 
 func evalIntegerLiteral(expr ast.ExprSingle, ctx *object.Context) object.Item {
@@ -103,6 +117,9 @@ func evalUnaryExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	ue := expr.(*ast.UnaryExpr)
 
 	right := Eval(ue.ExprSingle, ctx)
+	if bif.IsError(right) {
+		return right
+	}
 	op := ue.Token
 
 	var funcName string
@@ -122,60 +139,24 @@ func evalIfExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	builtin := bif.F["fn:boolean"]
 
 	testE := Eval(ie.TestExpr, ctx)
+	if bif.IsError(testE) {
+		return testE
+	}
 	bl := builtin(nil, testE)
 	boolObj := bl.(*object.Boolean)
 
 	if boolObj.Value() {
 		return Eval(ie.ThenExpr, ctx)
 	}
+	if ie.ElseExpr == nil {
+		return bif.NewSequence()
+	}
 	return Eval(ie.ElseExpr, ctx)
 }
 
 func evalForExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	fe := expr.(*ast.ForExpr)
-	enclosedCtx := object.NewEnclosedContext(ctx)
-	var items []object.Item
-
-	if len(fe.Bindings) > 1 {
-		b := fe.Bindings[0]
-		bval := Eval(b.ExprSingle, ctx)
-
-		nfe := &ast.ForExpr{ExprSingle: fe.ExprSingle}
-		nfe.Bindings = fe.Bindings[1:]
-
-		switch bval := bval.(type) {
-		case *object.Sequence:
-			for _, item := range bval.Items {
-				enclosedCtx.Set(b.VarName.Value(), item)
-				e := evalForExpr(nfe, enclosedCtx).(*object.Sequence)
-				items = append(items, e.Items...)
-			}
-		default:
-			enclosedCtx.Set(b.VarName.Value(), bval)
-			e := evalForExpr(nfe, enclosedCtx).(*object.Sequence)
-			items = append(items, e.Items...)
-		}
-
-		return &object.Sequence{Items: items}
-	}
-
-	b := fe.Bindings[0]
-	bval := Eval(b.ExprSingle, enclosedCtx)
-
-	switch bval := bval.(type) {
-	case *object.Sequence:
-		for _, item := range bval.Items {
-			enclosedCtx.Set(b.VarName.Value(), item)
-			e := Eval(fe.ExprSingle, enclosedCtx)
-			items = append(items, e)
-		}
-	default:
-		enclosedCtx.Set(b.VarName.Value(), bval)
-		e := Eval(fe.ExprSingle, enclosedCtx)
-		items = append(items, e)
-	}
-
-	return &object.Sequence{Items: items}
+	return collect(lazyFor(fe, ctx), ctx)
 }
 
 func evalLetExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
@@ -184,6 +165,9 @@ func evalLetExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 
 	for _, b := range le.Bindings {
 		bval := Eval(b.ExprSingle, enclosedCtx)
+		if bif.IsError(bval) {
+			return bval
+		}
 		enclosedCtx.Set(b.VarName.Value(), bval)
 	}
 
@@ -192,99 +176,44 @@ func evalLetExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 
 func evalQuantifiedExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	qe := expr.(*ast.QuantifiedExpr)
-	enclosedCtx := object.NewEnclosedContext(ctx)
-
-	if len(qe.Bindings) > 1 {
-		b := qe.Bindings[0]
-		bval := Eval(b.ExprSingle, enclosedCtx)
-
-		nqe := &ast.QuantifiedExpr{ExprSingle: qe.ExprSingle, Token: qe.Token}
-		nqe.Bindings = qe.Bindings[1:]
-
-		switch bval := bval.(type) {
-		case *object.Sequence:
-			for _, item := range bval.Items {
-				enclosedCtx.Set(b.VarName.Value(), item)
-				e := evalQuantifiedExpr(nqe, enclosedCtx).(*object.Boolean)
-
-				if qe.Token.Type == token.EVERY && !e.Value() {
-					return bif.NewBoolean(false)
-				}
-				if qe.Token.Type == token.SOME && e.Value() {
-					return bif.NewBoolean(true)
-				}
-			}
-		default:
-			enclosedCtx.Set(b.VarName.Value(), bval)
-			e := evalQuantifiedExpr(nqe, enclosedCtx).(*object.Boolean)
+	every := qe.Token.Type == token.EVERY
+	builtin := bif.F["fn:boolean"]
 
-			if qe.Token.Type == token.EVERY && !e.Value() {
-				return bif.NewBoolean(false)
-			}
-			if qe.Token.Type == token.SOME && e.Value() {
-				return bif.NewBoolean(true)
-			}
-		}
+	var names []string
+	var exprs []ast.ExprSingle
+	for _, b := range qe.Bindings {
+		names = append(names, b.VarName.Value())
+		exprs = append(exprs, b.ExprSingle)
 	}
 
-	b := qe.Bindings[0]
-	bval := Eval(b.ExprSingle, enclosedCtx)
-
-	switch bval := bval.(type) {
-	case *object.Sequence:
-		for _, item := range bval.Items {
-			enclosedCtx.Set(b.VarName.Value(), item)
-			e, ok := Eval(qe.ExprSingle, enclosedCtx).(*object.Boolean)
-
-			if !ok {
-				builtin := bif.F["fn:boolean"]
-				bl := builtin(nil, e)
-
-				boolObj := bl.(*object.Boolean)
-				if qe.Token.Type == token.EVERY && !boolObj.Value() {
-					return bif.NewBoolean(false)
-				}
-				if qe.Token.Type == token.SOME && boolObj.Value() {
-					return bif.NewBoolean(true)
-				}
-			}
-
-			if qe.Token.Type == token.EVERY && !e.Value() {
-				return bif.NewBoolean(false)
-			}
-			if qe.Token.Type == token.SOME && e.Value() {
-				return bif.NewBoolean(true)
-			}
-		}
-	default:
-		enclosedCtx.Set(b.VarName.Value(), bval)
-		e, ok := Eval(qe.ExprSingle, enclosedCtx).(*object.Boolean)
-
-		if !ok {
-			builtin := bif.F["fn:boolean"]
-			bl := builtin(nil, e)
-
-			boolObj := bl.(*object.Boolean)
-			if qe.Token.Type == token.EVERY && !boolObj.Value() {
-				return bif.NewBoolean(false)
-			}
-			if qe.Token.Type == token.SOME && boolObj.Value() {
-				return bif.NewBoolean(true)
-			}
+	// the iteration stops at the first item that decides the result
+	result := every
+	var err object.Item
+	e := eachBinding(names, exprs, ctx, func(bctx *object.Context) bool {
+		item := Eval(qe.ExprSingle, bctx)
+		if bif.IsError(item) {
+			err = item
+			return false
 		}
 
-		if qe.Token.Type == token.EVERY && !e.Value() {
-			return bif.NewBoolean(false)
+		bl := builtin(nil, item)
+		if bif.IsError(bl) {
+			err = bl
+			return false
 		}
-		if qe.Token.Type == token.SOME && e.Value() {
-			return bif.NewBoolean(true)
+		if bl.(*object.Boolean).Value() != every {
+			result = !every
+			return false
 		}
+		return true
+	})
+	if e != nil {
+		return e
 	}
-
-	if qe.Token.Type == token.EVERY {
-		return bif.NewBoolean(true)
+	if err != nil {
+		return err
 	}
-	return bif.NewBoolean(false)
+	return bif.NewBoolean(result)
 }
 
 func evalAdditiveExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
@@ -292,8 +221,18 @@ func evalAdditiveExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 
 	left := Eval(ae.LeftExpr, ctx)
 	right := Eval(ae.RightExpr, ctx)
+	if bif.IsError(left) {
+		return left
+	}
+	if bif.IsError(right) {
+		return right
+	}
 	op := ae.Token
 
+	if isTemporalOperand(left) || isTemporalOperand(right) {
+		return evalTemporalArithmetic(op, left, right)
+	}
+
 	var funcName string
 	if op.Type == token.PLUS {
 		funcName = "op:numeric-add"
@@ -306,13 +245,134 @@ func evalAdditiveExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	return builtin(nil, left, right)
 }
 
+// isTemporalOperand checks if item is a date, time or duration or a sequence of one of them
+func isTemporalOperand(item object.Item) bool {
+	if seq, ok := item.(*object.Sequence); ok && len(seq.Items) == 1 {
+		return bif.IsTemporal(seq.Items[0])
+	}
+	return bif.IsTemporal(item)
+}
+
+// evalTemporalArithmetic finds the operator function for the date, time and duration operands
+// https://www.w3.org/TR/xpath-31/#mapping
+func evalTemporalArithmetic(op token.Token, left, right object.Item) object.Item {
+	if bif.IsError(left) {
+		return left
+	}
+	if bif.IsError(right) {
+		return right
+	}
+	if bif.IsSeqEmpty(left) || bif.IsSeqEmpty(right) {
+		return bif.NewSequence()
+	}
+	if seq, ok := left.(*object.Sequence); ok && len(seq.Items) == 1 {
+		left = seq.Items[0]
+	}
+	if seq, ok := right.(*object.Sequence); ok && len(seq.Items) == 1 {
+		right = seq.Items[0]
+	}
+
+	lt := left.Type()
+	rt := right.Type()
+	if bif.IsNumeric(left) {
+		lt = object.DoubleType
+	}
+	if bif.IsNumeric(right) {
+		rt = object.DoubleType
+	}
+
+	var funcName string
+	switch op.Type {
+	case token.PLUS:
+		switch {
+		case lt == object.YearMonthDurationType && rt == object.YearMonthDurationType:
+			funcName = "op:add-yearMonthDurations"
+		case lt == object.DayTimeDurationType && rt == object.DayTimeDurationType:
+			funcName = "op:add-dayTimeDurations"
+		case lt == object.DateTimeType && rt == object.YearMonthDurationType:
+			funcName = "op:add-yearMonthDuration-to-dateTime"
+		case lt == object.DateTimeType && rt == object.DayTimeDurationType:
+			funcName = "op:add-dayTimeDuration-to-dateTime"
+		case lt == object.DateType && rt == object.YearMonthDurationType:
+			funcName = "op:add-yearMonthDuration-to-date"
+		case lt == object.DateType && rt == object.DayTimeDurationType:
+			funcName = "op:add-dayTimeDuration-to-date"
+		case lt == object.TimeType && rt == object.DayTimeDurationType:
+			funcName = "op:add-dayTimeDuration-to-time"
+		case bif.IsDuration(left) && bif.IsDateTime(right):
+			return evalTemporalArithmetic(op, right, left)
+		}
+	case token.MINUS:
+		switch {
+		case lt == object.YearMonthDurationType && rt == object.YearMonthDurationType:
+			funcName = "op:subtract-yearMonthDurations"
+		case lt == object.DayTimeDurationType && rt == object.DayTimeDurationType:
+			funcName = "op:subtract-dayTimeDurations"
+		case lt == object.DateTimeType && rt == object.DateTimeType:
+			funcName = "op:subtract-dateTimes"
+		case lt == object.DateType && rt == object.DateType:
+			funcName = "op:subtract-dates"
+		case lt == object.TimeType && rt == object.TimeType:
+			funcName = "op:subtract-times"
+		case lt == object.DateTimeType && rt == object.YearMonthDurationType:
+			funcName = "op:subtract-yearMonthDuration-from-dateTime"
+		case lt == object.DateTimeType && rt == object.DayTimeDurationType:
+			funcName = "op:subtract-dayTimeDuration-from-dateTime"
+		case lt == object.DateType && rt == object.YearMonthDurationType:
+			funcName = "op:subtract-yearMonthDuration-from-date"
+		case lt == object.DateType && rt == object.DayTimeDurationType:
+			funcName = "op:subtract-dayTimeDuration-from-date"
+		case lt == object.TimeType && rt == object.DayTimeDurationType:
+			funcName = "op:subtract-dayTimeDuration-from-time"
+		}
+	case token.ASTERISK:
+		switch {
+		case lt == object.YearMonthDurationType && rt == object.DoubleType:
+			funcName = "op:multiply-yearMonthDuration"
+		case lt == object.DayTimeDurationType && rt == object.DoubleType:
+			funcName = "op:multiply-dayTimeDuration"
+		case lt == object.DoubleType && bif.IsDuration(right):
+			return evalTemporalArithmetic(op, right, left)
+		}
+	case token.DIV:
+		switch {
+		case lt == object.YearMonthDurationType && rt == object.DoubleType:
+			funcName = "op:divide-yearMonthDuration"
+		case lt == object.DayTimeDurationType && rt == object.DoubleType:
+			funcName = "op:divide-dayTimeDuration"
+		case lt == object.YearMonthDurationType && rt == object.YearMonthDurationType:
+			funcName = "op:divide-yearMonthDuration-by-yearMonthDuration"
+		case lt == object.DayTimeDurationType && rt == object.DayTimeDurationType:
+			funcName = "op:divide-dayTimeDuration-by-dayTimeDuration"
+		}
+	}
+
+	if funcName == "" {
+		return bif.NewCodedError("XPTY0004", "the operator '%s' is not defined for operands of type %s and %s", op.Literal, bif.TypeName(left), bif.TypeName(right))
+	}
+
+	builtin := bif.F[funcName]
+
+	return builtin(nil, left, right)
+}
+
 func evalMultiplicativeExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	me := expr.(*ast.MultiplicativeExpr)
 
 	left := Eval(me.LeftExpr, ctx)
 	right := Eval(me.RightExpr, ctx)
+	if bif.IsError(left) {
+		return left
+	}
+	if bif.IsError(right) {
+		return right
+	}
 	op := me.Token
 
+	if isTemporalOperand(left) || isTemporalOperand(right) {
+		return evalTemporalArithmetic(op, left, right)
+	}
+
 	var funcName string
 	if op.Type == token.ASTERISK {
 		funcName = "op:numeric-multiply"
@@ -334,6 +394,12 @@ func evalStringConcatExpr(expr ast.ExprSingle, ctx *object.Context) object.Item
 
 	left := Eval(sce.LeftExpr, ctx)
 	right := Eval(sce.RightExpr, ctx)
+	if bif.IsError(left) {
+		return left
+	}
+	if bif.IsError(right) {
+		return right
+	}
 
 	funcName := "fn:concat"
 	builtin := bif.F[funcName]
@@ -341,25 +407,128 @@ func evalStringConcatExpr(expr ast.ExprSingle, ctx *object.Context) object.Item
 	return builtin(nil, left, right)
 }
 
+// evalStringTemplate concatenates the fixed parts and the values of the enclosed expressions.
+// The value of an enclosed expression is atomized and the strings of the items are separated by a space.
+func evalStringTemplate(expr ast.ExprSingle, ctx *object.Context) object.Item {
+	st := expr.(*ast.StringTemplate)
+
+	var sb strings.Builder
+	for _, part := range st.Parts {
+		if sl, ok := part.(*ast.StringLiteral); ok {
+			sb.WriteString(sl.Value)
+			continue
+		}
+
+		e := Eval(part, ctx)
+		if bif.IsError(e) {
+			return e
+		}
+		for i, item := range bif.UnwrapSeq(e) {
+			str := bif.CastType(item, object.StringType)
+			if bif.IsError(str) {
+				return str
+			}
+			if i > 0 {
+				sb.WriteString(" ")
+			}
+			sb.WriteString(str.(*object.String).Value())
+		}
+	}
+
+	return bif.NewString(sb.String())
+}
+
+// evalOtherwiseExpr returns the value of the left operand unless it is the empty sequence
+func evalOtherwiseExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
+	oe := expr.(*ast.OtherwiseExpr)
+
+	left := Eval(oe.LeftExpr, ctx)
+	if bif.IsError(left) || !bif.IsSeqEmpty(left) {
+		return left
+	}
+	return Eval(oe.RightExpr, ctx)
+}
+
+// evalTryCatchExpr evaluates the first catch clause that matches the error code of the try expression.
+// The error is bound to the variables $err:code, $err:description and $err:value in the catch clause.
+func evalTryCatchExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
+	tce := expr.(*ast.TryCatchExpr)
+
+	item := Eval(&tce.EnclosedExpr, ctx)
+	e, ok := item.(*object.Error)
+	if !ok || e.Stops() {
+		return item
+	}
+
+	for _, cc := range tce.CatchClauses {
+		for _, nt := range cc.NameTests {
+			if !bif.IsErrorMatch(e.Code, nt, ctx) {
+				continue
+			}
+
+			var value object.Item = bif.NewSequence()
+			if e.Value != nil {
+				value = e.Value
+			}
+
+			enclosedCtx := object.NewEnclosedContext(ctx)
+			enclosedCtx.Set("err:code", bif.NewString(e.Code))
+			enclosedCtx.Set("err:description", bif.NewString(e.Message))
+			enclosedCtx.Set("err:value", value)
+			return Eval(&cc.EnclosedExpr, enclosedCtx)
+		}
+	}
+
+	return e
+}
+
+// maxInt is the largest int, the length of a range that does not fit in an int is maxInt
+const maxInt = int(^uint(0) >> 1)
+
 func evalRangeExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	re := expr.(*ast.RangeExpr)
 
 	l := Eval(re.LeftExpr, ctx)
 	r := Eval(re.RightExpr, ctx)
+	if bif.IsError(l) {
+		return l
+	}
+	if bif.IsError(r) {
+		return r
+	}
 
 	left, ok := l.(*object.Integer)
 	if !ok {
-		return bif.NewError("not allowed type in RangeExpr: %s", left.Type())
+		return bif.NewCodedError("XPTY0004", "not allowed type in RangeExpr: %s", bif.TypeName(l))
 	}
 
 	right, ok := r.(*object.Integer)
 	if !ok {
-		return bif.NewError("not allowed type in RangeExpr: %s", right.Type())
+		return bif.NewCodedError("XPTY0004", "not allowed type in RangeExpr: %s", bif.TypeName(r))
 	}
 
 	seq := &object.Sequence{}
-	for i := left.Value(); i <= right.Value(); i++ {
+	if left.Value() > right.Value() {
+		return seq
+	}
+
+	// the difference of the bounds can overflow an int, so the length is computed in uint64
+	length := maxInt
+	if n := uint64(right.Value()) - uint64(left.Value()); n < uint64(maxInt) {
+		length = int(n) + 1
+	}
+	if err := ctx.Guard.CheckLength(length); err != nil {
+		return err
+	}
+
+	for i := left.Value(); ; i++ {
+		if err := ctx.Guard.Poll(len(seq.Items) + 1); err != nil {
+			return err
+		}
 		seq.Items = append(seq.Items, bif.NewInteger(i))
+		if i == right.Value() {
+			break
+		}
 	}
 	return seq
 }
@@ -375,10 +544,22 @@ func evalLogicalExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	case *ast.AndExpr:
 		left = Eval(expr.LeftExpr, ctx)
 		right = Eval(expr.RightExpr, ctx)
+		if bif.IsError(left) {
+			return left
+		}
+		if bif.IsError(right) {
+			return right
+		}
 		op = expr.Token
 	case *ast.OrExpr:
 		left = Eval(expr.LeftExpr, ctx)
 		right = Eval(expr.RightExpr, ctx)
+		if bif.IsError(left) {
+			return left
+		}
+		if bif.IsError(right) {
+			return right
+		}
 		op = expr.Token
 	}
 
@@ -394,7 +575,7 @@ func evalLogicalExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	case token.OR:
 		return bif.NewBoolean(leftBool.Value() || rightBool.Value())
 	default:
-		return bif.NewError("undefined operator: %s in LogicalExpr", op.Type)
+		return bif.NewCodedError("FOER0000", "undefined operator: %s in LogicalExpr", op.Type)
 	}
 }
 
@@ -403,106 +584,21 @@ func evalUnionExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	cnode := ctx.CNode
 
 	left := Eval(ue.LeftExpr, ctx)
+	if bif.IsError(left) {
+		return left
+	}
 	ctx.CNode = cnode
 	right := Eval(ue.RightExpr, ctx)
-
-	var nodes []object.Node
-
-	switch {
-	case bif.IsSeq(left) && bif.IsSeq(right):
-		lseq := left.(*object.Sequence)
-		rseq := right.(*object.Sequence)
-
-		for _, item := range lseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			return bif.NewError("not allowed type in UnionExpr: %s", item.Type())
-		}
-
-		for _, item := range rseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			return bif.NewError("not allowed type in UnionExpr: %s", item.Type())
-		}
-	case bif.IsSeq(left) && bif.IsNode(right):
-		lseq := left.(*object.Sequence)
-
-		for _, item := range lseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			return bif.NewError("not allowed type in UnionExpr: %s", item.Type())
-		}
-
-		switch right := right.(type) {
-		case *object.BaseNode:
-			nodes = bif.AppendNode(nodes, right)
-		case *object.AttrNode:
-			nodes = bif.AppendNode(nodes, right)
-		}
-
-	case bif.IsNode(left) && bif.IsSeq(right):
-		rseq := right.(*object.Sequence)
-
-		switch left := left.(type) {
-		case *object.BaseNode:
-			nodes = bif.AppendNode(nodes, left)
-		case *object.AttrNode:
-			nodes = bif.AppendNode(nodes, left)
-		}
-
-		for _, item := range rseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			return bif.NewError("not allowed type in UnionExpr: %s", item.Type())
-		}
-	case bif.IsNode(left) && bif.IsNode(right):
-		switch left := left.(type) {
-		case *object.BaseNode:
-			nodes = bif.AppendNode(nodes, left)
-		case *object.AttrNode:
-			nodes = bif.AppendNode(nodes, left)
-		}
-
-		switch right := right.(type) {
-		case *object.BaseNode:
-			nodes = bif.AppendNode(nodes, right)
-		case *object.AttrNode:
-			nodes = bif.AppendNode(nodes, right)
-		}
-	default:
-		return bif.NewError("not allowed types in UnionExpr: %s, %s", left.Type(), right.Type())
+	if bif.IsError(right) {
+		return right
 	}
 
-	seq := &object.Sequence{}
-	for _, node := range nodes {
-		seq.Items = append(seq.Items, node)
+	lnodes, rnodes, err := operandNodes(left, right, "UnionExpr")
+	if err != nil {
+		return err
 	}
 
-	return seq
+	return nodeSeq(bif.SortNodes(append(lnodes, rnodes...), ctx))
 }
 
 func evalIntersectExceptExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
@@ -510,156 +606,86 @@ func evalIntersectExceptExpr(expr ast.ExprSingle, ctx *object.Context) object.It
 
 	left := Eval(iee.LeftExpr, ctx)
 	right := Eval(iee.RightExpr, ctx)
+	if bif.IsError(left) {
+		return left
+	}
+	if bif.IsError(right) {
+		return right
+	}
 
-	var nodes []object.Node
-	var inodes []object.Node
-	var enodes []object.Node
-
-	switch {
-	case bif.IsSeq(left) && bif.IsSeq(right):
-		lseq := left.(*object.Sequence)
-		rseq := right.(*object.Sequence)
-
-		for _, item := range lseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			return bif.NewError("not allowed type in IntersectExceptExpr: %s", item.Type())
-		}
-
-		for _, item := range rseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				if bif.IsContainN(nodes, item) {
-					inodes = append(inodes, item)
-				}
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				if bif.IsContainN(nodes, item) {
-					inodes = append(inodes, item)
-				}
-				continue
-			}
-			return bif.NewError("not allowed type in IntersectExceptExpr: %s", item.Type())
-		}
-	case bif.IsSeq(left) && bif.IsNode(right):
-		lseq := left.(*object.Sequence)
-
-		for _, item := range lseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				nodes = bif.AppendNode(nodes, item)
-				continue
-			}
-			return bif.NewError("not allowed type in IntersectExceptExpr: %s", item.Type())
-		}
-
-		switch right := right.(type) {
-		case *object.BaseNode:
-			if bif.IsContainN(nodes, right) {
-				inodes = append(inodes, right)
-			}
-		case *object.AttrNode:
-			if bif.IsContainN(nodes, right) {
-				inodes = append(inodes, right)
-			}
-		}
-	case bif.IsNode(left) && bif.IsSeq(right):
-		rseq := right.(*object.Sequence)
-
-		switch left := left.(type) {
-		case *object.BaseNode:
-			nodes = bif.AppendNode(nodes, left)
-		case *object.AttrNode:
-			nodes = bif.AppendNode(nodes, left)
-		}
+	lnodes, rnodes, err := operandNodes(left, right, "IntersectExceptExpr")
+	if err != nil {
+		return err
+	}
 
-		for _, item := range rseq.Items {
-			if item, ok := item.(*object.BaseNode); ok {
-				if bif.IsContainN(nodes, item) {
-					inodes = append(inodes, item)
-				}
-				continue
-			}
-			if item, ok := item.(*object.AttrNode); ok {
-				if bif.IsContainN(nodes, item) {
-					inodes = append(inodes, item)
-				}
-				continue
-			}
-			return bif.NewError("not allowed type in IntersectExceptExpr: %s", item.Type())
-		}
-	case bif.IsNode(left) && bif.IsNode(right):
-		switch left := left.(type) {
-		case *object.BaseNode:
-			nodes = bif.AppendNode(nodes, left)
-		case *object.AttrNode:
-			nodes = bif.AppendNode(nodes, left)
-		}
+	rkeys := make(map[interface{}]bool, len(rnodes))
+	for _, n := range rnodes {
+		rkeys[bif.NodeKey(n)] = true
+	}
 
-		switch right := right.(type) {
-		case *object.BaseNode:
-			if bif.IsContainN(nodes, right) {
-				inodes = append(inodes, right)
-			}
-		case *object.AttrNode:
-			if bif.IsContainN(nodes, right) {
-				inodes = append(inodes, right)
-			}
+	intersect := iee.Token.Type == token.INTERSECT
+	var nodes []object.Node
+	for _, n := range lnodes {
+		if rkeys[bif.NodeKey(n)] == intersect {
+			nodes = append(nodes, n)
 		}
-	default:
-		return bif.NewError("not allowed types in IntersectExceptExpr: %s, %s", left.Type(), right.Type())
 	}
 
-	seq := &object.Sequence{}
+	return nodeSeq(bif.SortNodes(nodes, ctx))
+}
 
-	if iee.Token.Type == token.INTERSECT {
-		for _, node := range inodes {
-			seq.Items = append(seq.Items, node)
-		}
-		return seq
+// operandNodes returns the nodes of the operands of a union, intersect or except expression
+// An operand must be a node or a sequence of nodes
+func operandNodes(left, right object.Item, name string) ([]object.Node, []object.Node, object.Item) {
+	if !(bif.IsSeq(left) || bif.IsNode(left)) || !(bif.IsSeq(right) || bif.IsNode(right)) {
+		return nil, nil, bif.NewCodedError("XPTY0004", "not allowed types in %s: %s, %s", name, bif.TypeName(left), bif.TypeName(right))
 	}
 
-	for _, n := range nodes {
-		if n, ok := n.(*object.BaseNode); ok {
-			if !bif.IsContainN(inodes, n) {
-				enodes = append(enodes, n)
-			}
-			continue
+	var operands [2][]object.Node
+	for i, item := range []object.Item{left, right} {
+		items := []object.Item{item}
+		if seq, ok := item.(*object.Sequence); ok {
+			items = seq.Items
 		}
-		if n, ok := n.(*object.AttrNode); ok {
-			if !bif.IsContainN(inodes, n) {
-				enodes = append(enodes, n)
+
+		for _, it := range items {
+			switch it := it.(type) {
+			case *object.BaseNode:
+				operands[i] = append(operands[i], it)
+			case *object.AttrNode:
+				operands[i] = append(operands[i], it)
+			default:
+				return nil, nil, bif.NewCodedError("XPTY0004", "not allowed type in %s: %s", name, bif.TypeName(it))
 			}
-			continue
 		}
 	}
+	return operands[0], operands[1], nil
+}
 
-	for _, node := range enodes {
-		seq.Items = append(seq.Items, node)
+func nodeSeq(nodes []object.Node) *object.Sequence {
+	seq := &object.Sequence{Items: make([]object.Item, len(nodes))}
+	for i, n := range nodes {
+		seq.Items[i] = n
 	}
-
 	return seq
 }
 
 func evalInstanceofExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	ie := expr.(*ast.InstanceofExpr)
 	item := Eval(ie.ExprSingle, ctx)
+	if bif.IsError(item) {
+		return item
+	}
 
-	return bif.IsTypeMatch(item, &ie.SequenceType)
+	return bif.IsTypeMatch(item, &ie.SequenceType, ctx)
 }
 
 func evalCastExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	ce := expr.(*ast.CastExpr)
 	item := Eval(ce.ExprSingle, ctx)
+	if bif.IsError(item) {
+		return item
+	}
 
 	var ty object.Type
 	switch ce.SingleType.Value() {
@@ -673,6 +699,18 @@ func evalCastExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		ty = object.StringType
 	case "xs:boolean":
 		ty = object.BooleanType
+	case "xs:dateTime":
+		ty = object.DateTimeType
+	case "xs:date":
+		ty = object.DateType
+	case "xs:time":
+		ty = object.TimeType
+	case "xs:duration":
+		ty = object.DurationType
+	case "xs:dayTimeDuration":
+		ty = object.DayTimeDurationType
+	case "xs:yearMonthDuration":
+		ty = object.YearMonthDurationType
 	}
 
 	return bif.CastType(item, ty)
@@ -681,6 +719,9 @@ func evalCastExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 func evalCastableExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	ce := expr.(*ast.CastableExpr)
 	item := Eval(ce.ExprSingle, ctx)
+	if bif.IsError(item) {
+		return item
+	}
 
 	var ty object.Type
 	switch ce.SingleType.Value() {
@@ -694,11 +735,40 @@ func evalCastableExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		ty = object.StringType
 	case "xs:boolean":
 		ty = object.BooleanType
+	case "xs:dateTime":
+		ty = object.DateTimeType
+	case "xs:date":
+		ty = object.DateType
+	case "xs:time":
+		ty = object.TimeType
+	case "xs:duration":
+		ty = object.DurationType
+	case "xs:dayTimeDuration":
+		ty = object.DayTimeDurationType
+	case "xs:yearMonthDuration":
+		ty = object.YearMonthDurationType
 	}
 
-	return bif.IsCastable(item, ty)
+	// the values that the cast raises a dynamic error for are not castable
+	if b := bif.IsCastable(item, ty); !bif.IsError(b) {
+		return b
+	}
+	return bif.NewBoolean(false)
 }
 
 func evalTreatExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
-	return bif.NewError("treat expression not supported")
+	te := expr.(*ast.TreatExpr)
+	item := Eval(te.ExprSingle, ctx)
+	if bif.IsError(item) {
+		return item
+	}
+
+	matched := bif.IsTypeMatch(item, &te.SequenceType, ctx)
+	if bif.IsError(matched) {
+		return matched
+	}
+	if !matched.(*object.Boolean).Value() {
+		return bif.NewCodedError("XPDY0050", "cannot treat %s as %s", bif.TypeName(item), te.SequenceType.String())
+	}
+	return item
 }
@@ -4,49 +4,49 @@ import (
 	"github.com/zzossig/rabbit/ast"
 	"github.com/zzossig/rabbit/bif"
 	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/token"
 	"github.com/zzossig/rabbit/util"
 )
 
 func evalFunctionLiteral(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	switch expr := expr.(type) {
 	case *ast.NamedFunctionRef:
-		if expr.EQName.Prefix() == "" {
-			expr.EQName.SetPrefix("fn")
-		}
-
-		builtin, ok := bif.F[expr.EQName.Value()]
+		name := functionName(expr.EQName)
+		builtin, ok := bif.Function(ctx, name.Value())
 		if !ok {
-			return bif.NewError("function not found: %s", expr.EQName.Value())
+			return bif.NewCodedError("XPST0017", "function not found: %s", name.Value())
+		}
+		if !bif.HasArity(ctx, name.Value(), expr.IntegerLiteral.Value) {
+			return bif.NewCodedError("XPST0017", "function not found: %s#%d", name.Value(), expr.IntegerLiteral.Value)
 		}
 
-		return &object.FuncNamed{Name: expr.EQName, Num: expr.IntegerLiteral.Value, Func: &builtin}
+		return &object.FuncNamed{Name: name, Num: expr.IntegerLiteral.Value, Func: &builtin}
 	case *ast.InlineFunctionExpr:
-		fi := &object.FuncInline{Body: &expr.FunctionBody, PL: &expr.ParamList}
+		fi := &object.FuncInline{Body: &expr.FunctionBody, PL: &expr.ParamList, ST: &expr.SequenceType, Focus: expr.Focus}
 		fi.Fn = Eval
 		return fi
 	}
-	return bif.NewError("unexpected xpath expression. %#v", expr)
+	return bif.NewCodedError("XPST0003", "unexpected xpath expression. %#v", expr)
 }
 
 func evalFunctionCall(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	fc := expr.(*ast.FunctionCall)
 
-	if ctxFunc, ok := ctx.Get(fc.EQName.Value()); ok {
-		args := evalArgumentList(fc.Args, ctx)
-		return evalDynamicFunctionCall(ctxFunc, args, ctx)
-	}
-
-	if fc.EQName.Prefix() == "" {
-		fc.EQName.SetPrefix("fn")
+	name := functionName(fc.EQName)
+	builtin, ok := bif.Function(ctx, name.Value())
+	if !ok {
+		return bif.NewCodedError("XPST0017", "function not found: %s", name.Value())
 	}
 
-	builtin, ok := bif.F[fc.EQName.Value()]
-	if !ok {
-		return bif.NewError("function not found: %s", fc.EQName.Value())
+	if item, ok := evalShortCircuitCall(name.Value(), fc, ctx); ok {
+		return item
 	}
 
 	pcnt := 0
-	args := evalArgumentList(fc.Args, ctx)
+	args, e := evalArgumentList(fc.Args, ctx)
+	if e != nil {
+		return e
+	}
 
 	for _, arg := range args {
 		if _, ok := arg.(*object.Placeholder); ok {
@@ -57,7 +57,7 @@ func evalFunctionCall(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	if pcnt > 0 {
 		fp := &object.FuncPartial{}
 		fp.Func = &builtin
-		fp.Name = fc.EQName
+		fp.Name = name
 		fp.Args = args
 		fp.PCnt = pcnt
 		fp.Context = ctx
@@ -68,6 +68,53 @@ func evalFunctionCall(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	return builtin(ctx, args...)
 }
 
+// evalShortCircuitCall evaluates fn:head, fn:exists and fn:empty without evaluating more of the argument than the first item
+// ok is false if the function is not one of them or the argument cannot be evaluated incrementally
+func evalShortCircuitCall(name string, fc *ast.FunctionCall, ctx *object.Context) (object.Item, bool) {
+	switch name {
+	case "fn:head", "fn:exists", "fn:empty":
+	default:
+		return nil, false
+	}
+	if _, ok := ctx.Functions[name]; ok || len(fc.Args) != 1 || fc.Args[0].TypeID != 1 {
+		return nil, false
+	}
+
+	it, ok := lazyExpr(fc.Args[0].ExprSingle, ctx)
+	if !ok {
+		return nil, false
+	}
+
+	var first object.Item
+	if e := it(func(item object.Item) bool {
+		first = item
+		return false
+	}); e != nil {
+		return e, true
+	}
+
+	switch name {
+	case "fn:head":
+		if first == nil {
+			return bif.NewSequence(), true
+		}
+		return first, true
+	case "fn:exists":
+		return bif.NewBoolean(first != nil), true
+	default:
+		return bif.NewBoolean(first == nil), true
+	}
+}
+
+// functionName returns the name of a called function, an unprefixed name refers to the fn namespace.
+// The name is a copy, so the ast is not modified during evaluation.
+func functionName(name ast.EQName) ast.EQName {
+	if name.Prefix() == "" {
+		name.SetPrefix("fn")
+	}
+	return name
+}
+
 func evalVarRef(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	vr := expr.(*ast.VarRef)
 
@@ -103,7 +150,7 @@ func evalVarRef(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		return v
 	}
 
-	return &object.Varref{Name: vr.VarName}
+	return bif.NewCodedError("XPST0008", "variable not defined: $%s", vr.VarName.Value())
 }
 
 func evalArgument(arg ast.Argument, ctx *object.Context) object.Item {
@@ -113,19 +160,22 @@ func evalArgument(arg ast.Argument, ctx *object.Context) object.Item {
 	case 2:
 		return &object.Placeholder{}
 	default:
-		return bif.NewError("unexpected argument expression. %#v", arg)
+		return bif.NewCodedError("XPST0003", "unexpected argument expression. %#v", arg)
 	}
 }
 
-func evalArgumentList(args []ast.Argument, ctx *object.Context) []object.Item {
+func evalArgumentList(args []ast.Argument, ctx *object.Context) ([]object.Item, object.Item) {
 	var items []object.Item
 
 	for _, arg := range args {
 		item := evalArgument(arg, ctx)
+		if bif.IsError(item) {
+			return nil, item
+		}
 		items = append(items, item)
 	}
 
-	return items
+	return items, nil
 }
 
 func evalPredicate(it object.Item, pred *ast.Predicate, ctx *object.Context) object.Item {
@@ -150,9 +200,13 @@ func evalPredicate(it object.Item, pred *ast.Predicate, ctx *object.Context) obj
 		ctx.CItem = s
 		ctx.CPos = i + 1
 
-		evaled := Eval(&pred.Expr, ctx).(*object.Sequence)
+		ev := Eval(&pred.Expr, ctx)
+		if bif.IsError(ev) {
+			return ev
+		}
+		evaled := ev.(*object.Sequence)
 		if len(evaled.Items) != 1 {
-			return bif.NewError("wrong number of argument. got=%d, want=1", len(evaled.Items))
+			return bif.NewCodedError("XPTY0004", "wrong number of argument. got=%d, want=1", len(evaled.Items))
 		}
 		if bif.IsError(evaled.Items[0]) {
 			return evaled
@@ -196,20 +250,23 @@ func evalLookup(it object.Item, lu *ast.Lookup, ctx *object.Context) object.Item
 	case *object.Array:
 		switch lu.KeySpecifier.TypeID {
 		case 1:
-			return bif.NewError("cannot convert xs:string to xs:integer: %s.", lu.NCName.Value())
+			return bif.NewCodedError("XPTY0004", "cannot convert xs:string to xs:integer: %s.", lu.NCName.Value())
 		case 2:
 			if lu.IntegerLiteral.Value == 0 || lu.IntegerLiteral.Value > len(it.Items) {
-				return bif.NewError("array index %d out of bounds (1..%d)", lu.IntegerLiteral.Value, len(it.Items))
+				return bif.NewCodedError("FOAY0001", "array index %d out of bounds (1..%d)", lu.IntegerLiteral.Value, len(it.Items))
 			}
 			return it.Items[lu.IntegerLiteral.Value-1]
 		case 3:
 			evaled := Eval(&lu.ParenthesizedExpr, ctx)
+			if bif.IsError(evaled) {
+				return evaled
+			}
 			src := evaled.(*object.Sequence)
 
 			for _, item := range src.Items {
 				if i, ok := item.(*object.Integer); ok {
 					if i.Value() == 0 || i.Value() > len(it.Items) {
-						return bif.NewError("array index %d out of bounds (1..%d)", i.Value(), len(it.Items))
+						return bif.NewCodedError("FOAY0001", "array index %d out of bounds (1..%d)", i.Value(), len(it.Items))
 					}
 					seq.Items = append(seq.Items, it.Items[i.Value()-1])
 				}
@@ -235,6 +292,9 @@ func evalLookup(it object.Item, lu *ast.Lookup, ctx *object.Context) object.Item
 			return pair.Value
 		case 3:
 			evaled := Eval(&lu.ParenthesizedExpr, ctx)
+			if bif.IsError(evaled) {
+				return evaled
+			}
 			src := evaled.(*object.Sequence)
 
 			for _, item := range src.Items {
@@ -255,7 +315,7 @@ func evalLookup(it object.Item, lu *ast.Lookup, ctx *object.Context) object.Item
 			seq.Items = append(seq.Items, evaled)
 		}
 	default:
-		return bif.NewError("input of lookup operator is not a map or array: %v.", it)
+		return bif.NewCodedError("XPTY0004", "input of lookup operator is not a map or array: %v.", it)
 	}
 
 	return seq
@@ -263,75 +323,104 @@ func evalLookup(it object.Item, lu *ast.Lookup, ctx *object.Context) object.Item
 
 func evalArrowExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	ae := expr.(*ast.ArrowExpr)
-	bindings := ae.Bindings
 
-	e := Eval(ae.ExprSingle, ctx)
-	args := []object.Item{e}
-	var result object.Item
-
-	for i, b := range bindings {
-		switch b.TypeID {
-		case 1:
-			if b.EQName.Prefix() == "" {
-				b.EQName.SetPrefix("fn")
-			}
-			builtin, ok := bif.F[b.EQName.Value()]
-			if !ok {
-				bif.NewError("function not defined: %s", b.EQName.Value())
-			}
+	result := Eval(ae.ExprSingle, ctx)
+	if bif.IsError(result) {
+		return result
+	}
 
-			evaled := evalArgumentList(b.Args, ctx)
-			args = append(args, evaled...)
-			result = builtin(ctx, args...)
-			if i < len(bindings)-1 {
-				args = []object.Item{result}
-			}
-		case 2:
-			ctxItem, ok := ctx.Get(b.VarName.Value())
-			if !ok {
-				bif.NewError("function not defined: %s", b.VarRef.String())
-			}
-			ctxFunc, ok := ctxItem.(*object.FuncInline)
-			if !ok {
-				bif.NewError("function not defined: %s", b.VarRef.String())
-			}
+	for _, b := range ae.Bindings {
+		f, e := arrowFunction(&b.ArrowFunctionSpecifier, ctx)
+		if e != nil {
+			return e
+		}
 
-			evaled := evalArgumentList(b.Args, ctx)
-			args = append(args, evaled...)
-			if len(ctxFunc.PL.Params) != len(args) {
-				return bif.NewError("wrong number of argument. got=%d, want=%d", len(args), len(ctxFunc.PL.Params))
-			}
+		evaled, e := evalArgumentList(b.Args, ctx)
+		if e != nil {
+			return e
+		}
 
-			enclosedCtx := object.NewEnclosedContext(ctx)
-			for i, param := range ctxFunc.PL.Params {
-				enclosedCtx.Set(param.Value(), args[i])
+		// the mapping arrow applies the function to each item of the left operand
+		if b.Token.Type == token.MARROW {
+			seq := &object.Sequence{}
+			for _, item := range bif.UnwrapSeq(result) {
+				r := f(append([]object.Item{item}, evaled...)...)
+				if bif.IsError(r) {
+					return r
+				}
+				seq.Items = append(seq.Items, bif.UnwrapSeq(r)...)
 			}
+			result = seq
+			continue
+		}
 
-			result = Eval(&ctxFunc.Body.Expr, enclosedCtx)
-			if i < len(bindings)-1 {
-				args = []object.Item{result}
-			}
-		case 3:
-			result = Eval(&b.ParenthesizedExpr, ctx)
-			if i < len(bindings)-1 {
-				args = []object.Item{result}
-			}
+		result = f(append([]object.Item{result}, evaled...)...)
+		if bif.IsError(result) {
+			return result
 		}
 	}
 
 	return result
 }
 
+// arrowFunction returns the function that the arrow function specifier refers to
+func arrowFunction(afs *ast.ArrowFunctionSpecifier, ctx *object.Context) (func(args ...object.Item) object.Item, object.Item) {
+	switch afs.TypeID {
+	case 1:
+		name := functionName(afs.EQName)
+		builtin, ok := bif.Function(ctx, name.Value())
+		if !ok {
+			return nil, bif.NewCodedError("XPST0017", "function not defined: %s", name.Value())
+		}
+		return func(args ...object.Item) object.Item {
+			return builtin(ctx, args...)
+		}, nil
+	case 2:
+		ctxItem, ok := ctx.Get(afs.VarName.Value())
+		if !ok {
+			return nil, bif.NewCodedError("XPST0008", "variable not defined: %s", afs.VarRef.String())
+		}
+		if !bif.IsFunc(ctxItem) {
+			return nil, bif.NewCodedError("XPST0017", "function not defined: %s", afs.VarRef.String())
+		}
+		return func(args ...object.Item) object.Item {
+			return evalDynamicFunctionCall(ctxItem, args, ctx)
+		}, nil
+	case 3:
+		f := Eval(&afs.ParenthesizedExpr, ctx)
+		if bif.IsError(f) {
+			return nil, f
+		}
+		if items := bif.UnwrapSeq(f); len(items) != 1 || !bif.IsFunc(items[0]) {
+			return nil, bif.NewCodedError("XPST0017", "function not defined: %s", afs.ParenthesizedExpr.String())
+		}
+		return func(args ...object.Item) object.Item {
+			return evalDynamicFunctionCall(f, args, ctx)
+		}, nil
+	}
+	return nil, bif.NewCodedError("XPST0003", "unexpected arrow function specifier: %s", afs.String())
+}
+
 func evalPostfixExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	pe := expr.(*ast.PostfixExpr)
+	if it, ok := lazyExpr(pe, ctx); ok {
+		return collect(it, ctx)
+	}
+
 	evaled := Eval(pe.ExprSingle, ctx)
+	if bif.IsError(evaled) {
+		return evaled
+	}
 
 	for _, pal := range pe.Pals {
 		switch pal := pal.(type) {
 		case *ast.Predicate:
 			evaled = evalPredicate(evaled, pal, ctx)
 		case *ast.ArgumentList:
-			args := evalArgumentList(pal.Args, ctx)
+			args, e := evalArgumentList(pal.Args, ctx)
+			if e != nil {
+				return e
+			}
 			evaled = evalDynamicFunctionCall(evaled, args, ctx)
 		case *ast.Lookup:
 			evaled = evalLookup(evaled, pal, ctx)
@@ -342,86 +431,12 @@ func evalPostfixExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 }
 
 func evalDynamicFunctionCall(f object.Item, args []object.Item, ctx *object.Context) object.Item {
-	switch f := f.(type) {
-	case *object.FuncInline:
-		if len(f.PL.Params) != len(args) {
-			return bif.NewError("wrong number of argument. got=%d, want=%d", len(args), len(f.PL.Params))
-		}
-
-		enclosedCtx := object.NewEnclosedContext(ctx)
-		for i, param := range f.PL.Params {
-			enclosedCtx.Set(param.EQName.Value(), args[i])
-		}
-
-		return Eval(&f.Body.Expr, enclosedCtx)
-	case *object.FuncNamed:
-		if f.Name.Prefix() == "" {
-			f.Name.SetPrefix("fn")
-		}
-		builtin, ok := bif.F[f.Name.Value()]
-		if !ok {
-			return bif.NewError("function not found: %s", f.Name.Value())
-		}
-		if len(args) != f.Num {
-			return bif.NewError("wrong number of argument. got=%d, want=%d", len(args), f.Num)
-		}
-
-		return builtin(ctx, args...)
-	case *object.Array:
-		if len(args) != 1 {
-			return bif.NewError("wrong number of argument. got=%d, want=1", len(args))
-		}
-
-		index, ok := args[0].(*object.Integer)
-		if !ok {
-			return bif.NewError("dynamic function call on array should have integer argument")
-		}
-		if index.Value() == 0 || index.Value() > len(f.Items) {
-			return bif.NewError("Index out of range: size(%d)", len(f.Items))
-		}
-		return f.Items[index.Value()-1]
-	case *object.Map:
-		if len(args) != 1 {
-			return bif.NewError("wrong number of argument. got=%d, want=1", len(args))
-		}
-
-		h, ok := args[0].(object.Hasher)
-		if !ok {
-			return bif.NewError("dynamic function call on map should have atomic argument")
-		}
-
-		key := h.HashKey()
-		pair, ok := f.Pairs[key]
-		if !ok {
-			return bif.NewSequence()
-		}
-		return pair.Value
-	default:
-		bif.NewError("cannot match item type with required type")
-	}
-	return nil
+	return bif.CallFunc(ctx, f, args...)
 }
 
 func evalSimpleMapExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	sme := expr.(*ast.SimpleMapExpr)
-	left := Eval(sme.LeftExpr, ctx)
-
-	var cItems []object.Item
-	switch left := left.(type) {
-	case *object.Sequence:
-		cItems = append(cItems, left.Items...)
-	default:
-		cItems = append(cItems, left)
-	}
-
-	var items []object.Item
-	for _, c := range cItems {
-		ctx.CItem = c
-		right := Eval(sme.RightExpr, ctx)
-		items = append(items, right)
-	}
-
-	return &object.Sequence{Items: items}
+	return collect(lazyMap(sme, ctx), ctx)
 }
 
 func evalArrayExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
@@ -433,12 +448,18 @@ func evalArrayExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		exprs = expr.Exprs
 		for _, e := range exprs {
 			item := Eval(e, ctx)
+			if bif.IsError(item) {
+				return item
+			}
 			array.Items = append(array.Items, item)
 		}
 	case *ast.CurlyArrayConstructor:
 		exprs = expr.EnclosedExpr.Exprs
 		for _, e := range exprs {
 			item := Eval(e, ctx)
+			if bif.IsError(item) {
+				return item
+			}
 			if bif.IsSeq(item) {
 				array.Items = append(array.Items, bif.UnwrapSeq(item)...)
 			} else {
@@ -456,13 +477,19 @@ func evalMapExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 
 	for _, entry := range mc.Entries {
 		key := Eval(entry.MapKeyExpr.ExprSingle, ctx)
+		if bif.IsError(key) {
+			return key
+		}
 
 		hashKey, ok := key.(object.Hasher)
 		if !ok {
-			return bif.NewError("unusable as hash key: %s", key.Type())
+			return bif.NewCodedError("XPTY0004", "unusable as hash key: %s", bif.TypeName(key))
 		}
 
 		value := Eval(entry.MapValueExpr.ExprSingle, ctx)
+		if bif.IsError(value) {
+			return value
+		}
 
 		hashed := hashKey.HashKey()
 		pairs[hashed] = object.Pair{Key: key, Value: value}
@@ -479,23 +506,26 @@ func evalUnaryLookup(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	case *object.Array:
 		switch ul.KeySpecifier.TypeID {
 		case 1:
-			return bif.NewError("NCName not supported in unary lookup")
+			return bif.NewCodedError("XPTY0004", "NCName not supported in unary lookup")
 		case 2:
 			if ul.IntegerLiteral.Value == 0 || ul.IntegerLiteral.Value > len(it.Items) {
-				return bif.NewError("array index %d out of bounds (1..%d)", ul.IntegerLiteral.Value, len(it.Items))
+				return bif.NewCodedError("FOAY0001", "array index %d out of bounds (1..%d)", ul.IntegerLiteral.Value, len(it.Items))
 			}
 			return it.Items[ul.IntegerLiteral.Value-1]
 		case 3:
 			evaled := Eval(&ul.ParenthesizedExpr, ctx)
+			if bif.IsError(evaled) {
+				return evaled
+			}
 			src := evaled.(*object.Sequence)
 
 			for _, item := range src.Items {
 				i, ok := item.(*object.Integer)
 				if !ok {
-					return bif.NewError("cannot convert %s to xs:integer", i.Type())
+					return bif.NewCodedError("XPTY0004", "cannot convert %s to xs:integer", bif.TypeName(item))
 				}
 				if i.Value() == 0 || i.Value() > len(it.Items) {
-					return bif.NewError("array index %d out of bounds (1..%d)", ul.IntegerLiteral.Value, len(it.Items))
+					return bif.NewCodedError("FOAY0001", "array index %d out of bounds (1..%d)", ul.IntegerLiteral.Value, len(it.Items))
 				}
 				seq.Items = append(seq.Items, it.Items[i.Value()-1])
 			}
@@ -520,6 +550,9 @@ func evalUnaryLookup(expr ast.ExprSingle, ctx *object.Context) object.Item {
 			return pair.Value
 		case 3:
 			evaled := Eval(&ul.ParenthesizedExpr, ctx)
+			if bif.IsError(evaled) {
+				return evaled
+			}
 			src := evaled.(*object.Sequence)
 
 			for _, item := range src.Items {
@@ -535,7 +568,7 @@ func evalUnaryLookup(expr ast.ExprSingle, ctx *object.Context) object.Item {
 			}
 		}
 	default:
-		return bif.NewError("context item is not a map or an array")
+		return bif.NewCodedError("XPTY0004", "context item is not a map or an array")
 	}
 
 	return seq
@@ -0,0 +1,628 @@
+package eval
+
+import (
+	"github.com/zzossig/rabbit/ast"
+	"github.com/zzossig/rabbit/bif"
+	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/token"
+)
+
+// iterator produces the items of a sequence one by one
+// It passes the items to yield in order and stops as soon as yield returns false
+// It returns an error item if the evaluation fails, otherwise nil
+type iterator func(yield func(item object.Item) bool) object.Item
+
+// lazy returns an iterator of the items of expr
+// Path expressions, for expressions and the simple map operator produce their items incrementally,
+// so a consumer that stops early does not evaluate the rest of the sequence
+// The other expressions are evaluated with Eval when the iterator is called
+func lazy(expr ast.ExprSingle, ctx *object.Context) iterator {
+	if it, ok := lazyExpr(expr, ctx); ok {
+		return it
+	}
+
+	return func(yield func(item object.Item) bool) object.Item {
+		item := Eval(expr, ctx)
+		if bif.IsError(item) {
+			return item
+		}
+		if item == nil {
+			return nil
+		}
+		for _, it := range bif.UnwrapSeq(item) {
+			if !yield(it) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+// lazyExpr returns an iterator that produces the items of expr incrementally
+// ok is false if expr cannot be evaluated incrementally
+func lazyExpr(expr ast.ExprSingle, ctx *object.Context) (iterator, bool) {
+	switch expr := expr.(type) {
+	case *ast.Expr:
+		return lazySeq(expr.Exprs, ctx)
+	case *ast.ParenthesizedExpr:
+		return lazySeq(expr.Exprs, ctx)
+	case *ast.PathExpr, *ast.RelativePathExpr:
+		return lazyPath(expr, ctx)
+	case *ast.ForExpr:
+		return lazyFor(expr, ctx), true
+	case *ast.SimpleMapExpr:
+		return lazyMap(expr, ctx), true
+	case *ast.PostfixExpr:
+		if n, ok := positionalPredicate(expr); ok {
+			if it, ok := lazyExpr(expr.ExprSingle, ctx); ok {
+				return lazyPosition(it, n), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// collect evaluates an iterator to a sequence
+func collect(it iterator, ctx *object.Context) object.Item {
+	seq := &object.Sequence{}
+	var err object.Item
+
+	e := it(func(item object.Item) bool {
+		seq.Items = append(seq.Items, item)
+		if e := ctx.Guard.CheckLength(len(seq.Items)); e != nil {
+			err = e
+			return false
+		}
+		return true
+	})
+	if e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+	return seq
+}
+
+// collectPath evaluates an iterator of a path expression to a sequence
+// The result nodes become the context nodes and the last one becomes the context item at the last position
+// as the steps of a path do
+func collectPath(it iterator, ctx *object.Context) object.Item {
+	item := collect(it, ctx)
+	if seq, ok := item.(*object.Sequence); ok {
+		ctx.CNode = make([]object.Node, len(seq.Items))
+		for i, n := range seq.Items {
+			ctx.CNode[i] = n.(object.Node)
+		}
+		ctx.CSize = len(ctx.CNode)
+		ctx.CAxis = "child::"
+		if len(seq.Items) > 0 {
+			ctx.CItem = seq.Items[len(seq.Items)-1]
+			ctx.CPos = len(seq.Items)
+		}
+	}
+	return item
+}
+
+// lazySeq concatenates the items of exprs, ok is false if one of exprs cannot be evaluated incrementally
+func lazySeq(exprs []ast.ExprSingle, ctx *object.Context) (iterator, bool) {
+	its := make([]iterator, len(exprs))
+	for i, e := range exprs {
+		it, ok := lazyExpr(e, ctx)
+		if !ok {
+			return nil, false
+		}
+		its[i] = it
+	}
+
+	return func(yield func(item object.Item) bool) object.Item {
+		stopped := false
+		for _, it := range its {
+			e := it(func(item object.Item) bool {
+				if !yield(item) {
+					stopped = true
+					return false
+				}
+				return true
+			})
+			if e != nil {
+				return e
+			}
+			if stopped {
+				break
+			}
+		}
+		return nil
+	}, true
+}
+
+// positionalPredicate returns the position of a postfix expression that has only a predicate of an integer literal such as (//div)[1]
+func positionalPredicate(pe *ast.PostfixExpr) (int, bool) {
+	if len(pe.Pals) != 1 {
+		return 0, false
+	}
+	pred, ok := pe.Pals[0].(*ast.Predicate)
+	if !ok || len(pred.Exprs) != 1 {
+		return 0, false
+	}
+	il, ok := pred.Exprs[0].(*ast.IntegerLiteral)
+	if !ok {
+		return 0, false
+	}
+	return il.Value, true
+}
+
+// lazyPosition produces the nth item of it and stops it there
+func lazyPosition(it iterator, n int) iterator {
+	return func(yield func(item object.Item) bool) object.Item {
+		if n < 1 {
+			return nil
+		}
+
+		i := 0
+		return it(func(item object.Item) bool {
+			i++
+			if i < n {
+				return true
+			}
+			yield(item)
+			return false
+		})
+	}
+}
+
+// eachBinding binds the variables of names to each combination of the items of exprs and calls fn with the context of the bindings
+// A binding expression is evaluated incrementally and can refer to the previous variables, the iteration stops when fn returns false
+func eachBinding(names []string, exprs []ast.ExprSingle, ctx *object.Context, fn func(bctx *object.Context) bool) object.Item {
+	bctx := object.NewEnclosedContext(ctx)
+	var err object.Item
+	stopped := false
+
+	e := lazy(exprs[0], object.NewEnclosedContext(ctx))(func(item object.Item) bool {
+		if e := ctx.Guard.Check(); e != nil {
+			err = e
+			return false
+		}
+
+		bctx.Set(names[0], item)
+		if len(names) == 1 {
+			return fn(bctx)
+		}
+
+		e := eachBinding(names[1:], exprs[1:], bctx, func(bctx *object.Context) bool {
+			if !fn(bctx) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if e != nil {
+			err = e
+			return false
+		}
+		return !stopped
+	})
+	if e != nil {
+		return e
+	}
+	return err
+}
+
+func lazyFor(fe *ast.ForExpr, ctx *object.Context) iterator {
+	var names []string
+	var exprs []ast.ExprSingle
+	for _, b := range fe.Bindings {
+		names = append(names, b.VarName.Value())
+		exprs = append(exprs, b.ExprSingle)
+	}
+
+	return func(yield func(item object.Item) bool) object.Item {
+		var err object.Item
+		stopped := false
+
+		e := eachBinding(names, exprs, ctx, func(bctx *object.Context) bool {
+			e := lazy(fe.ExprSingle, object.NewEnclosedContext(bctx))(func(item object.Item) bool {
+				if !yield(item) {
+					stopped = true
+					return false
+				}
+				return true
+			})
+			if e != nil {
+				err = e
+				return false
+			}
+			return !stopped
+		})
+		if e != nil {
+			return e
+		}
+		return err
+	}
+}
+
+func lazyMap(sme *ast.SimpleMapExpr, ctx *object.Context) iterator {
+	return func(yield func(item object.Item) bool) object.Item {
+		var err object.Item
+		stopped := false
+		i := 0
+
+		e := lazy(sme.LeftExpr, object.NewEnclosedContext(ctx))(func(item object.Item) bool {
+			i++
+			mctx := object.NewEnclosedContext(ctx)
+			mctx.CItem = item
+			mctx.CPos = i
+			if n, ok := item.(object.Node); ok {
+				mctx.CNode = []object.Node{n}
+			}
+
+			e := lazy(sme.RightExpr, mctx)(func(item object.Item) bool {
+				if !yield(item) {
+					stopped = true
+					return false
+				}
+				return true
+			})
+			if e != nil {
+				err = e
+				return false
+			}
+			return !stopped
+		})
+		if e != nil {
+			return e
+		}
+		return err
+	}
+}
+
+// lazyPath returns an iterator of a path expression whose steps use the child, attribute or self axis
+// The nodes are produced in document order without evaluating the steps for the whole document first
+func lazyPath(expr ast.ExprSingle, ctx *object.Context) (iterator, bool) {
+	if ctx.Doc == nil {
+		return nil, false
+	}
+
+	switch expr := expr.(type) {
+	case *ast.PathExpr:
+		step, axis, ok := pathStep(expr.ExprSingle)
+		if !ok {
+			return nil, false
+		}
+		return stepIterator(nodeIterator(ctx.Doc), expr.Token.Type, step, axis, ctx), true
+	case *ast.RelativePathExpr:
+		step, axis, ok := pathStep(expr.RightExpr)
+		if !ok {
+			return nil, false
+		}
+
+		var src iterator
+		switch left := expr.LeftExpr.(type) {
+		case *ast.PathExpr, *ast.RelativePathExpr:
+			src, ok = lazyPath(left, ctx)
+			if !ok {
+				return nil, false
+			}
+		case *ast.AxisStep:
+			lstep, laxis, ok := pathStep(left)
+			if !ok || len(ctx.CNode) > 1 {
+				return nil, false
+			}
+			var cnode object.Node = ctx.Doc
+			if len(ctx.CNode) == 1 {
+				cnode = ctx.CNode[0]
+			}
+			src = stepIterator(nodeIterator(cnode), token.SLASH, lstep, laxis, ctx)
+		default:
+			return nil, false
+		}
+		return stepIterator(src, expr.Token.Type, step, axis, ctx), true
+	}
+	return nil, false
+}
+
+// pathStep returns an axis step that can be evaluated incrementally and its axis
+func pathStep(expr ast.ExprSingle) (*ast.AxisStep, string, bool) {
+	as, ok := expr.(*ast.AxisStep)
+	if !ok || as.TypeID != 2 || len(as.PredicateList.PL) > 1 {
+		return nil, "", false
+	}
+
+	var axis string
+	switch as.ForwardStep.TypeID {
+	case 1:
+		axis = as.ForwardAxis.Value()
+		if axis == "" {
+			axis = "child::"
+		}
+	case 2:
+		if as.AbbrevForwardStep.NodeTest == nil {
+			return nil, "", false
+		}
+		axis = "child::"
+		if as.AbbrevForwardStep.Token.Type == token.AT {
+			axis = "attribute::"
+		}
+	default:
+		return nil, "", false
+	}
+
+	// document-node() and attribute() on the child axis select the context node and its attributes
+	if kt, ok := nodeTest(as).(*ast.KindTest); ok && axis == "child::" && (kt.TypeID == 1 || kt.TypeID == 3) {
+		return nil, "", false
+	}
+
+	switch axis {
+	case "child::", "attribute::", "self::":
+		return as, axis, true
+	}
+	return nil, "", false
+}
+
+func nodeTest(as *ast.AxisStep) ast.NodeTest {
+	if as.ForwardStep.TypeID == 1 {
+		return as.ForwardStep.NodeTest
+	}
+	return as.AbbrevForwardStep.NodeTest
+}
+
+func nodeIterator(n object.Node) iterator {
+	return func(yield func(item object.Item) bool) object.Item {
+		yield(n)
+		return nil
+	}
+}
+
+// evalStepFrom evaluates an axis step with n as the only context node
+func evalStepFrom(step *ast.AxisStep, n object.Node, ctx *object.Context) ([]object.Node, object.Item) {
+	ctx.CNode = []object.Node{n}
+	ctx.CItem = n
+	ctx.CSize = 1
+	ctx.CPos = 1
+
+	item := evalAxisStep(step, ctx)
+	if bif.IsError(item) {
+		return nil, item
+	}
+
+	var nodes []object.Node
+	for _, it := range bif.UnwrapSeq(item) {
+		if n, ok := it.(object.Node); ok {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
+
+// stepIterator applies an axis step to the nodes of src, which are in document order
+// op is token.SLASH for src/step or token.DSLASH for src//step
+func stepIterator(src iterator, op token.Type, step *ast.AxisStep, axis string, ctx *object.Context) iterator {
+	if op == token.DSLASH {
+		return descStepIterator(src, step, axis, ctx)
+	}
+	if axis == "child::" {
+		return childStepIterator(src, step, ctx)
+	}
+
+	return func(yield func(item object.Item) bool) object.Item {
+		sctx := object.NewEnclosedContext(ctx)
+		var err object.Item
+
+		e := src(func(item object.Item) bool {
+			n, ok := item.(object.Node)
+			if !ok {
+				err = bif.NewCodedError("XPTY0019", "not a valid xpath expression")
+				return false
+			}
+
+			nodes, e := evalStepFrom(step, n, sctx)
+			if e != nil {
+				err = e
+				return false
+			}
+			for _, n := range nodes {
+				if !yield(n) {
+					return false
+				}
+			}
+			return true
+		})
+		if e != nil {
+			return e
+		}
+		return err
+	}
+}
+
+// pending is the children of a context node that are selected by a child step but not produced yet
+type pending struct {
+	node  object.Node
+	nodes []object.Node
+	next  object.Node
+}
+
+// flush produces the pending nodes up to the child branch, all of them if branch is nil
+func (p *pending) flush(branch object.Node, yield func(item object.Item) bool) bool {
+	for p.next != nil && len(p.nodes) > 0 {
+		c := p.next
+		p.next = c.NextSibling()
+
+		if p.nodes[0].Tree() == c.Tree() {
+			n := p.nodes[0]
+			p.nodes = p.nodes[1:]
+			if !yield(n) {
+				return false
+			}
+		}
+		if branch != nil && branch.Tree() == c.Tree() {
+			break
+		}
+	}
+	return true
+}
+
+// childStepIterator applies a child step to the nodes of src
+// The children of a context node are kept pending while the following context nodes are its descendants,
+// so the result is in document order even if the context nodes are nested
+func childStepIterator(src iterator, step *ast.AxisStep, ctx *object.Context) iterator {
+	return func(yield func(item object.Item) bool) object.Item {
+		sctx := object.NewEnclosedContext(ctx)
+		var stack []*pending
+		var err object.Item
+		stopped := false
+
+		e := src(func(item object.Item) bool {
+			n, ok := item.(object.Node)
+			if !ok {
+				err = bif.NewCodedError("XPTY0019", "not a valid xpath expression")
+				return false
+			}
+
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if branch := childBranch(top.node, n); branch != nil {
+					if branch.Type() != object.AttributeNodeType && !top.flush(branch, yield) {
+						stopped = true
+						return false
+					}
+					break
+				}
+				if !top.flush(nil, yield) {
+					stopped = true
+					return false
+				}
+				stack = stack[:len(stack)-1]
+			}
+
+			nodes, e := evalStepFrom(step, n, sctx)
+			if e != nil {
+				err = e
+				return false
+			}
+			if len(nodes) > 0 {
+				stack = append(stack, &pending{node: n, nodes: nodes, next: n.FirstChild()})
+			}
+			return true
+		})
+		if e != nil {
+			return e
+		}
+		if err != nil || stopped {
+			return err
+		}
+
+		for i := len(stack) - 1; i >= 0; i-- {
+			if !stack[i].flush(nil, yield) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+// childBranch returns the child of ancestor that is n or an ancestor of n, it returns nil if n is not a descendant of ancestor
+func childBranch(ancestor, n object.Node) object.Node {
+	if ancestor.Type() == object.AttributeNodeType {
+		return nil
+	}
+	for c := n; c != nil; c = c.Parent() {
+		p := c.Parent()
+		if p != nil && p.Tree() == ancestor.Tree() {
+			return c
+		}
+	}
+	return nil
+}
+
+// indexedName returns the element name of a child step that selects the elements by name only,
+// such steps after // are looked up in the index of the document
+func indexedName(step *ast.AxisStep, axis string) (string, bool) {
+	nt, ok := nodeTest(step).(*ast.NameTest)
+	if !ok || axis != "child::" || nt.TypeID != 1 || nt.EQName.TypeID == 2 || len(step.PredicateList.PL) > 0 {
+		return "", false
+	}
+	if nt.EQName.QName.Prefix() != "" {
+		return "", false
+	}
+	return nt.EQName.QName.Local(), true
+}
+
+// descStepIterator applies a step after // to the nodes of src
+// The subtree of each context node is walked once in document order, a context node in a subtree that is already walked is skipped
+// since the step selects the same nodes from it
+func descStepIterator(src iterator, step *ast.AxisStep, axis string, ctx *object.Context) iterator {
+	name, indexed := indexedName(step, axis)
+
+	return func(yield func(item object.Item) bool) object.Item {
+		sctx := object.NewEnclosedContext(ctx)
+		var root object.Node
+		var err object.Item
+
+		var walk func(n object.Node) bool
+		walk = func(n object.Node) bool {
+			if e := ctx.Guard.Visit(); e != nil {
+				err = e
+				return false
+			}
+
+			nodes, e := evalStepFrom(step, n, sctx)
+			if e != nil {
+				err = e
+				return false
+			}
+			if axis != "child::" {
+				for _, n := range nodes {
+					if !yield(n) {
+						return false
+					}
+				}
+				nodes = nil
+			}
+
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if len(nodes) > 0 && nodes[0].Tree() == c.Tree() {
+					if !yield(nodes[0]) {
+						return false
+					}
+					nodes = nodes[1:]
+				}
+				if !walk(c) {
+					return false
+				}
+			}
+			return true
+		}
+
+		e := src(func(item object.Item) bool {
+			n, ok := item.(object.Node)
+			if !ok {
+				err = bif.NewCodedError("XPTY0019", "not a valid xpath expression")
+				return false
+			}
+			if root != nil && (root.Tree() == n.Tree() || childBranch(root, n) != nil) {
+				return true
+			}
+
+			root = n
+			if indexed {
+				if nodes, ok := ctx.Index.Descendants(n, name); ok {
+					for _, n := range nodes {
+						if e := ctx.Guard.Visit(); e != nil {
+							err = e
+							return false
+						}
+						if !yield(n) {
+							return false
+						}
+					}
+					return true
+				}
+			}
+			return walk(n)
+		})
+		if e != nil {
+			return e
+		}
+		return err
+	}
+}
@@ -1,21 +1,29 @@
 package eval
 
 import (
+	"strings"
+
 	"github.com/zzossig/rabbit/ast"
 	"github.com/zzossig/rabbit/bif"
 	"github.com/zzossig/rabbit/object"
 	"github.com/zzossig/rabbit/token"
+	"github.com/zzossig/rabbit/util"
 )
 
 func evalPathExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	if ctx == nil {
-		return bif.NewError("context is undefined")
+		return bif.NewCodedError("XPDY0002", "context is undefined")
 	}
 	if ctx.Doc == nil {
-		return bif.NewError("context node is undefined")
+		return bif.NewCodedError("XPDY0002", "context node is undefined")
+	}
+
+	if it, ok := lazyPath(expr, ctx); ok {
+		return collectPath(it, ctx)
 	}
 
 	pe := expr.(*ast.PathExpr)
+	ctx.CNode = []object.Node{ctx.Doc}
 	ctx.CItem = ctx.Doc
 	ctx.CSize = 1
 
@@ -23,7 +31,7 @@ func evalPathExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		nodes := []object.Node{ctx.Doc}
 		var err object.Item
 
-		nodes, err = walkDescKind(nodes, ctx.Doc, 10, nil, nil, ctx)
+		nodes, err = walkDescKind(nodes, ctx.Doc, anyKindTest, nil, nil, ctx)
 		if err != nil {
 			return err
 		}
@@ -35,38 +43,41 @@ func evalPathExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 		ctx.CAxis = "child::"
 	}
 
-	e := Eval(pe.ExprSingle, ctx)
-
-	if bif.IsAnyAtomic(e) || bif.IsAnyFunc(e) {
-		seq := &object.Sequence{}
-		for i := 0; i < len(ctx.CNode); i++ {
-			seq.Items = append(seq.Items, e)
-		}
-		return seq
+	switch pe.ExprSingle.(type) {
+	case *ast.AxisStep, *ast.RelativePathExpr:
+		return Eval(pe.ExprSingle, ctx)
 	}
-
-	return e
+	return evalStepEach(pe.ExprSingle, ctx)
 }
 
 func evalRelativePathExpr(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	if ctx == nil {
-		return bif.NewError("context is undefined")
+		return bif.NewCodedError("XPDY0002", "context is undefined")
+	}
+
+	if it, ok := lazyPath(expr, ctx); ok {
+		return collectPath(it, ctx)
 	}
 
 	rpe := expr.(*ast.RelativePathExpr)
 
 	left := Eval(rpe.LeftExpr, ctx)
+	if bif.IsError(left) {
+		return left
+	}
 	if !bif.IsNode(left) && !bif.IsNodeSeq(left) {
-		return bif.NewError("not a valid xpath expression")
+		return bif.NewCodedError("XPTY0019", "not a valid xpath expression")
 	}
 
+	setStepNodes(rpe.LeftExpr, left, ctx)
+
 	if rpe.Token.Type == token.DSLASH {
 		var nodes []object.Node
 		var err object.Item
 
 		for _, c := range ctx.CNode {
 			nodes = append(nodes, c)
-			nodes, err = walkDescKind(nodes, c, 10, nil, nil, ctx)
+			nodes, err = walkDescKind(nodes, c, anyKindTest, nil, nil, ctx)
 			if err != nil {
 				return err
 			}
@@ -79,25 +90,95 @@ func evalRelativePathExpr(expr ast.ExprSingle, ctx *object.Context) object.Item
 		ctx.CAxis = "child::"
 	}
 
-	e := Eval(rpe.RightExpr, ctx)
+	switch rpe.RightExpr.(type) {
+	case *ast.AxisStep, *ast.RelativePathExpr:
+		return Eval(rpe.RightExpr, ctx)
+	}
+	return evalStepEach(rpe.RightExpr, ctx)
+}
+
+// evalStepEach evaluates a step that is not an axis step once for each context node
+// The node is the context item, and the position and the size are those of the node in the context nodes
+// The result is the nodes in document order or the other items in the order of the context nodes
+func evalStepEach(step ast.ExprSingle, ctx *object.Context) object.Item {
+	cnode := ctx.CNode
+	var nodes []object.Node
+	var items []object.Item
+
+	for i, n := range cnode {
+		if err := ctx.Guard.Poll(i + 1); err != nil {
+			return err
+		}
 
-	if bif.IsAnyAtomic(e) || bif.IsAnyFunc(e) {
-		seq := &object.Sequence{}
-		for i := 0; i < len(ctx.CNode); i++ {
-			seq.Items = append(seq.Items, e)
+		sctx := object.NewEnclosedContext(ctx)
+		sctx.CNode = []object.Node{n}
+		sctx.CItem = n
+		sctx.CPos = i + 1
+		sctx.CSize = len(cnode)
+		sctx.CAxis = "child::"
+
+		e := Eval(step, sctx)
+		if bif.IsError(e) {
+			return e
+		}
+		for _, item := range bif.UnwrapSeq(e) {
+			if n, ok := item.(object.Node); ok {
+				nodes = append(nodes, n)
+			} else {
+				items = append(items, item)
+			}
 		}
-		return seq
+		if len(nodes) > 0 && len(items) > 0 {
+			return bif.NewCodedError("XPTY0018", "the result of the last step in a path contains both nodes and non-nodes")
+		}
+	}
+
+	if len(items) > 0 {
+		return &object.Sequence{Items: items}
+	}
+
+	nodes = bif.SortNodes(nodes, ctx)
+	if len(nodes) > 0 {
+		ctx.CNode = nodes
+		ctx.CItem = nodes[len(nodes)-1]
+		ctx.CSize = len(nodes)
+		ctx.CPos = len(nodes)
+		ctx.CAxis = "child::"
+	}
+	return nodeSeq(nodes)
+}
+
+// setStepNodes makes the nodes returned by the first step of a path the context nodes of the next step
+// Only the steps that produce a new node set such as a function call, a variable reference, a filter expression, a union
+// or a parenthesized expression are set here. An axis step sets the context nodes while it is evaluated,
+// and a path or the context item keeps the context nodes, so ./a selects from each of them
+func setStepNodes(step ast.ExprSingle, item object.Item, ctx *object.Context) {
+	switch step.(type) {
+	case *ast.FunctionCall, *ast.VarRef, *ast.PostfixExpr, *ast.ParenthesizedExpr, *ast.ArrowExpr, *ast.UnionExpr, *ast.IntersectExceptExpr:
+	default:
+		return
+	}
+	if !bif.IsNode(item) && !bif.IsNodeSeq(item) {
+		return
 	}
 
-	return e
+	ctx.CNode = []object.Node{}
+	for _, it := range bif.UnwrapSeq(item) {
+		if n, ok := it.(object.Node); ok {
+			ctx.CNode = append(ctx.CNode, n)
+		}
+	}
+	ctx.CSize = len(ctx.CNode)
+	ctx.CAxis = "child::"
 }
 
 func evalAxisStep(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	if ctx == nil {
-		return bif.NewError("context is undefined")
+		return bif.NewCodedError("XPDY0002", "context is undefined")
 	}
-	if ctx.Doc == nil {
-		return bif.NewError("context node is undefined")
+	// the context nodes can be in a document returned by fn:doc when the context document is not set
+	if ctx.Doc == nil && len(ctx.CNode) == 0 {
+		return bif.NewCodedError("XPDY0002", "context node is undefined")
 	}
 	if ctx.CNode == nil {
 		ctx.CNode = []object.Node{ctx.Doc}
@@ -105,6 +186,9 @@ func evalAxisStep(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	}
 
 	as := expr.(*ast.AxisStep)
+	if usesLast(&as.PredicateList) || walksSubtrees(as) && len(as.PredicateList.PL) > 0 {
+		return evalStepCollected(as, ctx)
+	}
 
 	switch as.TypeID {
 	case 1: // ReverseStep
@@ -114,13 +198,11 @@ func evalAxisStep(expr ast.ExprSingle, ctx *object.Context) object.Item {
 			ctx.CAxis = as.ReverseAxis.Value()
 			return evalNodeTest(as.ReverseStep.NodeTest, &as.PredicateList, ctx)
 		case 2:
+			// .. is parent::node(), so the parent of an attribute, a namespace node or a text node is selected too
 			ctx.CAxis = "parent::"
-			nt := &ast.NameTest{TypeID: 2}
-			nt.Wildcard.TypeID = 1
-			as.ReverseStep.NodeTest = nt
-			return evalNodeTest(as.ReverseStep.NodeTest, &as.PredicateList, ctx)
+			return evalNodeTest(anyKindTest, &as.PredicateList, ctx)
 		default:
-			return bif.NewError("not supported axis: %s", as.ReverseAxis.Value())
+			return bif.NewCodedError("XPST0010", "not supported axis: %s", as.ReverseAxis.Value())
 		}
 	case 2: // ForwardStep
 		switch as.ForwardStep.TypeID {
@@ -142,20 +224,121 @@ func evalAxisStep(expr ast.ExprSingle, ctx *object.Context) object.Item {
 				if ctx.CItem.Type() == object.DocumentNodeType {
 					return ctx.Doc
 				}
-				return bif.NewError("not a valid xpath expression")
+				return bif.NewCodedError("XPDY0050", "not a valid xpath expression")
 			}
 
 			return evalNodeTest(as.ForwardStep.AbbrevForwardStep.NodeTest, &as.PredicateList, ctx)
 		default:
-			return bif.NewError("not supported axis: %s", as.ForwardAxis.Value())
+			return bif.NewCodedError("XPST0010", "not supported axis: %s", as.ForwardAxis.Value())
 		}
 	default:
-		return bif.NewError("unexpected AxisStep expression")
+		return bif.NewCodedError("XPST0003", "unexpected AxisStep expression")
+	}
+}
+
+// usesLast reports whether a predicate of plist calls fn:last
+// A call in a string literal or a nested path is reported too, the step is evaluated correctly in either case
+func usesLast(plist *ast.PredicateList) bool {
+	for i := range plist.PL {
+		if strings.Contains(plist.PL[i].String(), "last(") {
+			return true
+		}
+	}
+	return false
+}
+
+// walksSubtrees reports whether the axis of the step walks the subtrees of several nodes
+// such an axis does not count the positions of the nodes in the axis order while it walks them
+func walksSubtrees(as *ast.AxisStep) bool {
+	var axis string
+	switch {
+	case as.TypeID == 1 && as.ReverseStep.TypeID == 1:
+		axis = as.ReverseAxis.Value()
+	case as.TypeID == 2 && as.ForwardStep.TypeID == 1:
+		axis = as.ForwardAxis.Value()
+	}
+
+	switch axis {
+	case "descendant::", "descendant-or-self::", "following::", "preceding::":
+		return true
 	}
+	return false
+}
+
+// evalStepCollected evaluates an axis step whose predicates call fn:last or whose axis walks several subtrees
+// The nodes that the step selects from each context node are collected before the predicates are applied,
+// so the context position is the position in the axis order and the context size is the number of them
+func evalStepCollected(as *ast.AxisStep, ctx *object.Context) object.Item {
+	step := *as
+	step.PredicateList = ast.PredicateList{}
+
+	var result []object.Node
+	for _, c := range ctx.CNode {
+		ctx.CNode = []object.Node{c}
+		ctx.CItem = c
+
+		item := evalAxisStep(&step, ctx)
+		if bif.IsError(item) {
+			return item
+		}
+		axis := ctx.CAxis
+
+		var nodes []object.Node
+		for _, it := range bif.UnwrapSeq(item) {
+			if n, ok := it.(object.Node); ok {
+				nodes = append(nodes, n)
+			}
+		}
+		nodes = bif.SortNodes(nodes, ctx)
+		if util.IsReverseAxis(axis) {
+			for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+				nodes[i], nodes[j] = nodes[j], nodes[i]
+			}
+		}
+
+		for _, p := range as.PredicateList.PL {
+			var matched []object.Node
+			var ii int
+			plist := &ast.PredicateList{PL: []ast.Predicate{p}}
+
+			for i, n := range nodes {
+				ctx.CNode = []object.Node{n}
+				ctx.CItem = n
+				ctx.CPos = i + 1
+				ctx.CSize = len(nodes)
+				ctx.CAxis = axis
+
+				pred := evalPredicateList(plist, &ii, ctx)
+				if bif.IsError(pred) {
+					return pred
+				}
+				if pred.(*object.Boolean).Value() {
+					matched = append(matched, n)
+				}
+			}
+			nodes = matched
+		}
+		result = append(result, nodes...)
+		ctx.CAxis = axis
+	}
+
+	result = bif.SortNodes(result, ctx)
+	ctx.CNode = result
+	ctx.CSize = len(result)
+
+	seq := &object.Sequence{}
+	for _, n := range result {
+		seq.Items = append(seq.Items, n)
+	}
+	return seq
 }
 
 func evalNodeTest(test ast.NodeTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
 	if t, ok := test.(*ast.KindTest); ok {
+		if e := bif.KindTestError(t); e != nil {
+			return e
+		}
+
 		switch ctx.CAxis {
 		case "child::":
 			return kindTestChild(t, plist, ctx)
@@ -184,7 +367,7 @@ func evalNodeTest(test ast.NodeTest, plist *ast.PredicateList, ctx *object.Conte
 		case "ancestor-or-self::":
 			return kindTestAncestorOrSelf(t, plist, ctx)
 		default:
-			return bif.NewError("not supported axis: %s", ctx.CAxis)
+			return bif.NewCodedError("XPST0010", "not supported axis: %s", ctx.CAxis)
 		}
 	}
 
@@ -217,11 +400,11 @@ func evalNodeTest(test ast.NodeTest, plist *ast.PredicateList, ctx *object.Conte
 		case "ancestor-or-self::":
 			return nameTestAncestorOrSelf(t, plist, ctx)
 		default:
-			return bif.NewError("not supported axis: %s", ctx.CAxis)
+			return bif.NewCodedError("XPST0010", "not supported axis: %s", ctx.CAxis)
 		}
 	}
 
-	return bif.NewError("unexpected xpath expression. %#v", test)
+	return bif.NewCodedError("XPST0003", "unexpected xpath expression. %#v", test)
 }
 
 // ii param is used when len(plist.PL.Params) > 1
@@ -232,23 +415,26 @@ func evalPredicateList(plist *ast.PredicateList, ii *int, ctx *object.Context) o
 
 	for i, p := range plist.PL {
 		if len(p.Exprs) == 0 {
-			return bif.NewError("not a valid predicate expression")
+			return bif.NewCodedError("XPST0003", "not a valid predicate expression")
 		}
 		if len(p.Exprs) > 1 {
-			return bif.NewError("too many items in predicate expression")
+			return bif.NewCodedError("FORG0006", "too many items in predicate expression")
 		}
 
 		ctx.CNode = cnode
 		bif.ReplaceFocus(ctx, focus)
 
 		p := Eval(&p.Expr, ctx)
+		if bif.IsError(p) {
+			return p
+		}
 		seq := p.(*object.Sequence)
 
 		if len(seq.Items) == 0 {
 			return bif.NewBoolean(false)
 		}
 		if len(seq.Items) > 1 {
-			return bif.NewError("too many items in predicate expression")
+			return bif.NewCodedError("FORG0006", "too many items in predicate expression")
 		}
 
 		switch item := seq.Items[0].(type) {
@@ -324,6 +510,9 @@ func evalWildcard(expr ast.ExprSingle, ctx *object.Context) object.Item {
 	return seq
 }
 
+// anyKindTest is node() that is used to walk all the descendants in abbreviated paths such as //
+var anyKindTest = &ast.KindTest{TypeID: 10}
+
 func kindTestChild(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
 	var nodes []object.Node
 	var ii int
@@ -335,6 +524,9 @@ Loop:
 			j := 0
 
 			for _, a := range c.Attr() {
+				if t.TypeID == 3 && !bif.IsKindMatch(a, t, ctx) {
+					continue
+				}
 				j++
 				ctx.CPos = j
 				ctx.CItem = a
@@ -358,7 +550,7 @@ Loop:
 
 		i := 0
 		for n := c.FirstChild(); n != nil; n = n.NextSibling() {
-			if t.TypeID == 1 && c.Type() == object.DocumentNodeType {
+			if t.TypeID == 1 && bif.IsKindMatch(c, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = c
@@ -378,7 +570,7 @@ Loop:
 					nodes = append(nodes, c)
 				}
 				break Loop
-			} else if t.TypeID != 3 && bif.IsKindMatch(n, t.TypeID) {
+			} else if t.TypeID != 3 && bif.IsKindMatch(n, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = n
@@ -418,7 +610,7 @@ func kindTestDesc(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context
 	var ii int
 
 	for _, c := range ctx.CNode {
-		nodes, err = walkDescKind(nodes, c, t.TypeID, &ii, plist, ctx)
+		nodes, err = walkDescKind(nodes, c, t, &ii, plist, ctx)
 		if err != nil {
 			return err
 		}
@@ -445,6 +637,9 @@ func kindTestAttr(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context
 			j := 0
 
 			for _, a := range c.Attr() {
+				if t.TypeID == 3 && !bif.IsKindMatch(a, t, ctx) {
+					continue
+				}
 				j++
 				ctx.CPos = j
 				ctx.CItem = a
@@ -485,7 +680,7 @@ func kindTestSelf(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context
 	for _, c := range ctx.CNode {
 		i := 0
 
-		if bif.IsKindMatch(c, t.TypeID) {
+		if bif.IsKindMatch(c, t, ctx) {
 			i++
 			ctx.CPos = i
 			ctx.CItem = c
@@ -526,7 +721,7 @@ func kindTestDescOrSelf(t *ast.KindTest, plist *ast.PredicateList, ctx *object.C
 	for _, c := range ctx.CNode {
 		i := 0
 
-		if bif.IsKindMatch(c, t.TypeID) {
+		if bif.IsKindMatch(c, t, ctx) {
 			i++
 			ctx.CPos = i
 			ctx.CItem = c
@@ -547,7 +742,7 @@ func kindTestDescOrSelf(t *ast.KindTest, plist *ast.PredicateList, ctx *object.C
 			}
 		}
 
-		nodes, err = walkDescKind(nodes, c, t.TypeID, &ii, plist, ctx)
+		nodes, err = walkDescKind(nodes, c, t, &ii, plist, ctx)
 		if err != nil {
 			return err
 		}
@@ -572,7 +767,7 @@ func kindTestFS(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context)
 		for s := c.NextSibling(); s != nil; s = s.NextSibling() {
 			i := 0
 
-			if bif.IsKindMatch(s, t.TypeID) {
+			if bif.IsKindMatch(s, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = s
@@ -627,7 +822,7 @@ func kindTestFollowing(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Co
 			}
 			c = s
 
-			if bif.IsKindMatch(s, t.TypeID) {
+			if bif.IsKindMatch(s, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = s
@@ -648,7 +843,7 @@ func kindTestFollowing(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Co
 				}
 			}
 
-			nodes, err = walkDescKind(nodes, s, t.TypeID, &ii, plist, ctx)
+			nodes, err = walkDescKind(nodes, s, t, &ii, plist, ctx)
 			if err != nil {
 				return err
 			}
@@ -667,7 +862,55 @@ func kindTestFollowing(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Co
 }
 
 func kindTestNS(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
-	return nil
+	return namespaceAxis(func(n object.Node) bool { return bif.IsKindMatch(n, t, ctx) }, plist, ctx)
+}
+
+// namespaceAxis selects the namespace nodes of the context elements that match the node test
+// Only the elements of a xml document have namespace nodes
+func namespaceAxis(match func(n object.Node) bool, plist *ast.PredicateList, ctx *object.Context) object.Item {
+	var nodes []object.Node
+	var ii int
+
+	for _, c := range ctx.CNode {
+		if c.Type() != object.ElementNodeType {
+			continue
+		}
+
+		j := 0
+		for _, ns := range object.NamespaceNodes(c.Tree()) {
+			if !match(ns) {
+				continue
+			}
+			j++
+			ctx.CPos = j
+			ctx.CItem = ns
+			ctx.CNode = []object.Node{ns}
+
+			if plist != nil && len(plist.PL) > 0 {
+				pred := evalPredicateList(plist, &ii, ctx)
+				if bif.IsError(pred) {
+					return pred
+				}
+
+				boolObj := pred.(*object.Boolean)
+				if boolObj.Value() {
+					nodes = bif.AppendNode(nodes, ns)
+				}
+			} else {
+				nodes = bif.AppendNode(nodes, ns)
+			}
+		}
+	}
+
+	ctx.CNode = nodes
+	ctx.CSize = len(nodes)
+
+	seq := &object.Sequence{}
+	for _, node := range nodes {
+		seq.Items = append(seq.Items, node)
+	}
+
+	return seq
 }
 
 func kindTestParent(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
@@ -677,7 +920,7 @@ func kindTestParent(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Conte
 	for _, c := range ctx.CNode {
 		i := 0
 
-		if c.Parent() != nil && bif.IsKindMatch(c.Parent(), t.TypeID) {
+		if c.Parent() != nil && bif.IsKindMatch(c.Parent(), t, ctx) {
 			i++
 			ctx.CPos = i
 			ctx.CItem = c.Parent()
@@ -718,7 +961,7 @@ func kindTestAncestor(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Con
 		i := 0
 
 		for p := c.Parent(); p != nil; p = p.Parent() {
-			if bif.IsKindMatch(p, t.TypeID) {
+			if bif.IsKindMatch(p, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = p
@@ -760,7 +1003,7 @@ func kindTestPS(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Context)
 		i := 0
 
 		for s := c.PrevSibling(); s != nil; s = s.PrevSibling() {
-			if bif.IsKindMatch(s, t.TypeID) {
+			if bif.IsKindMatch(s, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = s
@@ -816,9 +1059,9 @@ func kindTestPreceding(t *ast.KindTest, plist *ast.PredicateList, ctx *object.Co
 			}
 			c = s
 
-			nodes, err = walkPrevKind(nodes, s, t.TypeID, &i, &ii, plist, ctx)
+			nodes, err = walkPrevKind(nodes, s, t, &i, &ii, plist, ctx)
 
-			if bif.IsKindMatch(s, t.TypeID) {
+			if bif.IsKindMatch(s, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = s
@@ -863,7 +1106,7 @@ func kindTestAncestorOrSelf(t *ast.KindTest, plist *ast.PredicateList, ctx *obje
 	for _, c := range ctx.CNode {
 		i := 0
 
-		if bif.IsKindMatch(c, t.TypeID) {
+		if bif.IsKindMatch(c, t, ctx) {
 			i++
 			ctx.CPos = i
 			ctx.CItem = c
@@ -885,7 +1128,7 @@ func kindTestAncestorOrSelf(t *ast.KindTest, plist *ast.PredicateList, ctx *obje
 		}
 
 		for p := c.Parent(); p != nil; p = p.Parent() {
-			if bif.IsKindMatch(p, t.TypeID) {
+			if bif.IsKindMatch(p, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = p
@@ -919,17 +1162,25 @@ func kindTestAncestorOrSelf(t *ast.KindTest, plist *ast.PredicateList, ctx *obje
 	return seq
 }
 
+// nameTestKind returns 1 if the node names must be compared with the name test, 2 if the name test is the * wildcard
+func nameTestKind(t *ast.NameTest) byte {
+	if t.TypeID == 2 && t.Wildcard.TypeID != 1 {
+		return 1
+	}
+	return t.TypeID
+}
+
 func nameTestChild(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			for n := c.FirstChild(); n != nil; n = n.NextSibling() {
 				if n.Type() == object.ElementNodeType &&
-					t.EQName.Value() == n.Tree().Data {
+					bif.IsNameMatch(n, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = n
@@ -1028,7 +1279,7 @@ func nameTestAttr(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
@@ -1036,7 +1287,7 @@ func nameTestAttr(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context
 				c := c.(*object.BaseNode)
 				for _, a := range c.Attr() {
 					a := a.(*object.AttrNode)
-					if a.Key() == t.EQName.Value() {
+					if bif.IsNameMatch(a, t, ctx) {
 						i++
 						ctx.CPos = i
 						ctx.CItem = a
@@ -1106,12 +1357,12 @@ func nameTestSelf(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			if c.Type() == object.ElementNodeType &&
-				t.EQName.Value() == c.Tree().Data {
+				bif.IsNameMatch(c, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = c
@@ -1178,12 +1429,12 @@ func nameTestDescOrSelf(t *ast.NameTest, plist *ast.PredicateList, ctx *object.C
 	var ii int
 	cnode := ctx.CNode
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			if c.Type() == object.ElementNodeType &&
-				t.EQName.Value() == c.Tree().Data {
+				bif.IsNameMatch(c, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = c
@@ -1255,13 +1506,13 @@ func nameTestFS(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context)
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			for s := c.NextSibling(); s != nil; s = s.NextSibling() {
 				if s.Type() == object.ElementNodeType &&
-					t.EQName.Value() == s.Tree().Data {
+					bif.IsNameMatch(s, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = s
@@ -1330,7 +1581,7 @@ func nameTestFollowing(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Co
 	var err object.Item
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
@@ -1349,7 +1600,7 @@ func nameTestFollowing(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Co
 				c = s
 
 				if s.Type() == object.ElementNodeType &&
-					t.EQName.Value() == s.Tree().Data {
+					bif.IsNameMatch(s, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = s
@@ -1437,20 +1688,22 @@ func nameTestFollowing(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Co
 }
 
 func nameTestNS(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
-	return nil
+	// * selects the namespace node of the default namespace too, which has no name
+	all := nameTestKind(t) == 2 && t.Wildcard.TypeID == 1
+	return namespaceAxis(func(n object.Node) bool { return all || bif.IsNameMatch(n, t, ctx) }, plist, ctx)
 }
 
 func nameTestParent(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context) object.Item {
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			if c.Parent() != nil &&
 				c.Parent().Type() == object.ElementNodeType &&
-				t.EQName.Value() == c.Parent().Tree().Data {
+				bif.IsNameMatch(c.Parent(), t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = c.Parent()
@@ -1477,7 +1730,7 @@ func nameTestParent(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Conte
 			for _, c := range ctx.CNode {
 				i := 0
 				if c.Parent() != nil &&
-					c.Type() == object.ElementNodeType {
+					c.Parent().Type() == object.ElementNodeType {
 					i++
 					ctx.CPos = i
 					ctx.CItem = c.Parent()
@@ -1516,13 +1769,13 @@ func nameTestAncestor(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Con
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			for p := c.Parent(); p != nil; p = p.Parent() {
 				if p.Type() == object.ElementNodeType &&
-					t.EQName.Value() == p.Tree().Data {
+					bif.IsNameMatch(p, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = p
@@ -1590,13 +1843,13 @@ func nameTestPS(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Context)
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			for s := c.PrevSibling(); s != nil; s = s.PrevSibling() {
 				if s.Type() == object.ElementNodeType &&
-					t.EQName.Value() == s.Tree().Data {
+					bif.IsNameMatch(s, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = s
@@ -1665,7 +1918,7 @@ func nameTestPreceding(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Co
 	var err object.Item
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
@@ -1686,7 +1939,7 @@ func nameTestPreceding(t *ast.NameTest, plist *ast.PredicateList, ctx *object.Co
 
 				nodes, err = walkPrevName(nodes, s, t, &i, &ii, plist, ctx)
 
-				if t.EQName.Value() == s.Tree().Data {
+				if bif.IsNameMatch(s, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = s
@@ -1778,12 +2031,12 @@ func nameTestAncestorOrSelf(t *ast.NameTest, plist *ast.PredicateList, ctx *obje
 	var nodes []object.Node
 	var ii int
 
-	switch t.TypeID {
+	switch nameTestKind(t) {
 	case 1:
 		for _, c := range ctx.CNode {
 			i := 0
 			if c.Type() == object.ElementNodeType &&
-				t.EQName.Value() == c.Tree().Data {
+				bif.IsNameMatch(c, t, ctx) {
 				i++
 				ctx.CPos = i
 				ctx.CItem = c
@@ -1805,7 +2058,7 @@ func nameTestAncestorOrSelf(t *ast.NameTest, plist *ast.PredicateList, ctx *obje
 			}
 			for p := c.Parent(); p != nil; p = p.Parent() {
 				if p.Type() == object.ElementNodeType &&
-					t.EQName.Value() == p.Tree().Data {
+					bif.IsNameMatch(p, t, ctx) {
 					i++
 					ctx.CPos = i
 					ctx.CItem = p
@@ -1891,12 +2144,15 @@ func nameTestAncestorOrSelf(t *ast.NameTest, plist *ast.PredicateList, ctx *obje
 }
 
 // be careful using walkDescKind bacause this function changes the ctx.CNode
-func walkDescKind(nodes []object.Node, n object.Node, typeID byte, ii *int, plist *ast.PredicateList, ctx *object.Context) ([]object.Node, object.Item) {
+func walkDescKind(nodes []object.Node, n object.Node, t *ast.KindTest, ii *int, plist *ast.PredicateList, ctx *object.Context) ([]object.Node, object.Item) {
 	var err object.Item
 
 	i := 0
 	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
-		if bif.IsKindMatch(c, typeID) {
+		if e := ctx.Guard.Visit(); e != nil {
+			return nodes, e
+		}
+		if bif.IsKindMatch(c, t, ctx) {
 			i++
 			ctx.CPos = i
 			ctx.CItem = c
@@ -1918,7 +2174,7 @@ func walkDescKind(nodes []object.Node, n object.Node, typeID byte, ii *int, plis
 		}
 
 		if c.FirstChild() != nil {
-			nodes, err = walkDescKind(nodes, c, typeID, ii, plist, ctx)
+			nodes, err = walkDescKind(nodes, c, t, ii, plist, ctx)
 			if err != nil {
 				return nodes, err
 			}
@@ -1927,11 +2183,14 @@ func walkDescKind(nodes []object.Node, n object.Node, typeID byte, ii *int, plis
 	return nodes, nil
 }
 
-func walkPrevKind(nodes []object.Node, n object.Node, typeID byte, pos, ii *int, plist *ast.PredicateList, ctx *object.Context) ([]object.Node, object.Item) {
+func walkPrevKind(nodes []object.Node, n object.Node, t *ast.KindTest, pos, ii *int, plist *ast.PredicateList, ctx *object.Context) ([]object.Node, object.Item) {
 	var err object.Item
 
 	for c := n.LastChild(); c != nil; c = c.PrevSibling() {
-		if bif.IsKindMatch(c, typeID) {
+		if e := ctx.Guard.Visit(); e != nil {
+			return nodes, e
+		}
+		if bif.IsKindMatch(c, t, ctx) {
 			*pos++
 			ctx.CPos = *pos
 			ctx.CItem = c
@@ -1953,7 +2212,7 @@ func walkPrevKind(nodes []object.Node, n object.Node, typeID byte, pos, ii *int,
 		}
 
 		if c.LastChild() != nil {
-			nodes, err = walkPrevKind(nodes, c, typeID, pos, ii, plist, ctx)
+			nodes, err = walkPrevKind(nodes, c, t, pos, ii, plist, ctx)
 			if err != nil {
 				return nodes, err
 			}
@@ -1968,15 +2227,18 @@ func walkDescName(nodes []object.Node, n object.Node, t *ast.NameTest, ii *int,
 
 	i := 0
 	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if e := ctx.Guard.Visit(); e != nil {
+			return nodes, e
+		}
 		i++
 		ctx.CPos = i
 		ctx.CItem = c
 		ctx.CNode = []object.Node{c}
 
 		if c.Type() == object.ElementNodeType {
-			switch t.TypeID {
+			switch nameTestKind(t) {
 			case 1:
-				if c.Tree().Data == t.EQName.Value() {
+				if bif.IsNameMatch(c, t, ctx) {
 					if plist != nil && len(plist.PL) > 0 {
 						pred := evalPredicateList(plist, ii, ctx)
 						if bif.IsError(pred) {
@@ -2027,15 +2289,18 @@ func walkPrevName(nodes []object.Node, n object.Node, t *ast.NameTest, pos, ii *
 	var err object.Item
 
 	for c := n.LastChild(); c != nil; c = c.PrevSibling() {
+		if e := ctx.Guard.Visit(); e != nil {
+			return nodes, e
+		}
 		*pos++
 		ctx.CPos = *pos
 		ctx.CItem = c
 		ctx.CNode = []object.Node{c}
 
 		if c.Type() == object.ElementNodeType {
-			switch t.TypeID {
+			switch nameTestKind(t) {
 			case 1:
-				if c.Tree().Data == t.EQName.Value() {
+				if bif.IsNameMatch(c, t, ctx) {
 					if plist != nil && len(plist.PL) > 0 {
 						pred := evalPredicateList(plist, ii, ctx)
 						if bif.IsError(pred) {
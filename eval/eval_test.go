@@ -1,6 +1,8 @@
 package eval
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
@@ -10,6 +12,7 @@ import (
 	"github.com/zzossig/rabbit/lexer"
 	"github.com/zzossig/rabbit/object"
 	"github.com/zzossig/rabbit/parser"
+	"golang.org/x/net/html"
 )
 
 func TestEvalArithmetic(t *testing.T) {
@@ -265,7 +268,7 @@ func TestFunctionCall(t *testing.T) {
 		{"let $seq2 := (98.5, 98.3, 98.9) return fn:count($seq2[. > 100])", []interface{}{0}},
 		{"let $seq2 := (98.5, 98.3, 98.9) return fn:count($seq2)", []interface{}{3}},
 		{"let $seq3 := () return fn:count($seq3)", []interface{}{0}},
-		{"let $seq1 := ($item1, $item2) return fn:count($seq1)", []interface{}{2}},
+		{"let $item1 := 1, $item2 := 2, $seq1 := ($item1, $item2) return fn:count($seq1)", []interface{}{2}},
 		{"let $seq := ('item1', 'item2', 'item3', 'item4', 'item5') return subsequence($seq,-2,-1)", []interface{}{}},
 		{"let $seq := ('item1', 'item2', 'item3', 'item4', 'item5') return subsequence($seq,-2,0)", []interface{}{}},
 		{"let $seq := ('item1', 'item2', 'item3', 'item4', 'item5') return subsequence($seq,-2,5)", []interface{}{"item1", "item2"}},
@@ -2580,6 +2583,1076 @@ func TestPathExpr2(t *testing.T) {
 	}
 }
 
+func TestRegex(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []interface{}
+	}{
+		{`matches("abracadabra", "bra")`, []interface{}{true}},
+		{`matches("abracadabra", "^a.*a$")`, []interface{}{true}},
+		{`matches("abracadabra", "^bra")`, []interface{}{false}},
+		{`matches("Mad Hatter", "h a t", "ix")`, []interface{}{true}},
+		{`matches("a" || codepoints-to-string(10) || "b", "a.b")`, []interface{}{false}},
+		{`matches("a" || codepoints-to-string(10) || "b", "a.b", "s")`, []interface{}{true}},
+		{`matches("a+b", "a+b", "q")`, []interface{}{true}},
+		{`matches("e", "[a-z-[aeiou]]")`, []interface{}{false}},
+		{`matches("b", "[a-z-[aeiou]]")`, []interface{}{true}},
+		{`matches("가", "^\p{IsHangulSyllables}$")`, []interface{}{true}},
+		{`matches("é", "\p{L}")`, []interface{}{true}},
+		{`matches("١", "\d")`, []interface{}{true}},
+		{`matches("name", "^\i\c*$")`, []interface{}{true}},
+		{`replace("abracadabra", "bra", "*")`, []interface{}{"a*cada*"}},
+		{`replace("abracadabra", "a(.)", "a$1$1")`, []interface{}{"abbraccaddabbra"}},
+		{`replace("abracadabra", "a.*?a", "*")`, []interface{}{"*c*bra"}},
+		{`replace("AAAA", "A+?", "b")`, []interface{}{"bbbb"}},
+		{`replace("darted", "^(.*?)d(.*)$", "$1c$2")`, []interface{}{"carted"}},
+		{`replace("$1.50", "\$", "\$\\")`, []interface{}{"$\\1.50"}},
+		{`replace("a.b.c", ".", "$", "q")`, []interface{}{"a$b$c"}},
+		{`tokenize(" red green  blue ")`, []interface{}{"red", "green", "blue"}},
+		{`tokenize("The cat sat", "\s+")`, []interface{}{"The", "cat", "sat"}},
+		{`tokenize("1, 15, 24, 50", ",\s*")`, []interface{}{"1", "15", "24", "50"}},
+		{`tokenize("1,15,,24,50,", ",")`, []interface{}{"1", "15", "", "24", "50", ""}},
+		{`tokenize("", ",")`, []interface{}{}},
+		{`count(analyze-string("a1b22", "\d+")/match)`, []interface{}{2}},
+		{`analyze-string("a1b22", "\d+")/non-match/string()`, []interface{}{"a", "b"}},
+		{`analyze-string("a1b22", "(\d)(\d)?")/match[2]/group/@nr/string()`, []interface{}{"1", "2"}},
+		{`analyze-string("2021-03", "(\d+)-((\d)(\d))")/match/group[2]/group/string()`, []interface{}{"0", "3"}},
+		{`matches("aa", "(a)\1")`, []interface{}{true}},
+		{`matches("ab", "(a)\1")`, []interface{}{false}},
+		{`matches("aA", "(a)\1", "i")`, []interface{}{true}},
+		{`matches("b", "^(a)?b\1$")`, []interface{}{true}},
+		{`matches("abcdefghijj", "(a)(b)(c)(d)(e)(f)(g)(h)(i)(j)\10")`, []interface{}{true}},
+		{`replace("hello  world", "(\s)\1+", "$1")`, []interface{}{"hello world"}},
+		{`tokenize("a--b-c==d", "(-|=)\1")`, []interface{}{"a", "b-c", "d"}},
+		{`analyze-string("xyyzz", "(.)\1")/match/string()`, []interface{}{"yy", "zz"}},
+		{`analyze-string("-a- =b=", "(-|=)[a-z]\1")/match/group/string()`, []interface{}{"-", "="}},
+	}
+
+	for _, tt := range tests {
+		seq := testEval(tt.input)
+		testSequenceObject(t, seq, tt.expected)
+	}
+
+	errors := []string{
+		`matches("a", "[")`,
+		`matches("a", "a{2")`,
+		`matches("a", "\p{IsUnknown}")`,
+		`matches("a", "\k")`,
+		`matches("aa", "(a)\2")`,
+		`matches("aa", "(a\1)")`,
+		`matches("a", "a", "z")`,
+		`replace("abc", "b", "$x")`,
+		`tokenize("abba", ".?")`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(testEval(input)) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+
+	// a full cache evicts the least recently used expression and keeps caching the new ones
+	abc, _ := bif.CompileRegex("abc", "")
+	for i := 0; i < 256; i++ {
+		bif.CompileRegex(fmt.Sprintf("a{%d}", i), "")
+		bif.CompileRegex("abc", "")
+	}
+	if again, _ := bif.CompileRegex("abc", ""); again != abc {
+		t.Errorf("recently used regular expression should stay in the full cache")
+	}
+	last, _ := bif.CompileRegex("b{300}", "")
+	if again, _ := bif.CompileRegex("b{300}", ""); again != last {
+		t.Errorf("new regular expression should be cached in the full cache")
+	}
+}
+
+func TestDateTime(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`xs:dateTime("2021-03-04T10:20:30.5Z")`, "2021-03-04T10:20:30.5Z"},
+		{`xs:dateTime("2021-12-31T24:00:00")`, "2022-01-01T00:00:00"},
+		{`xs:date("2021-03-04+09:00")`, "2021-03-04+09:00"},
+		{`xs:time("13:20:00-05:00")`, "13:20:00-05:00"},
+		{`xs:duration("P1Y2M3DT4H5M6.5S")`, "P1Y2M3DT4H5M6.5S"},
+		{`xs:dayTimeDuration("PT36H")`, "P1DT12H"},
+		{`xs:yearMonthDuration("P14M")`, "P1Y2M"},
+		{`xs:duration("PT0S")`, "PT0S"},
+		{`"2021-03-04" cast as xs:date`, "2021-03-04"},
+		{`xs:date("2021-03-04") cast as xs:dateTime`, "2021-03-04T00:00:00"},
+		{`xs:dateTime("2021-03-04T10:20:30Z") cast as xs:time`, "10:20:30Z"},
+		{`xs:date("2021-03-04") - xs:date("2021-01-01")`, "P62D"},
+		{`xs:dateTime("2021-03-04T10:00:00+09:00") - xs:dateTime("2021-03-04T00:00:00Z")`, "PT1H"},
+		{`xs:date("2021-01-31") + xs:yearMonthDuration("P1M")`, "2021-02-28"},
+		{`xs:dateTime("2021-03-04T10:00:00Z") + xs:dayTimeDuration("PT36H")`, "2021-03-05T22:00:00Z"},
+		{`xs:yearMonthDuration("P1M") + xs:date("2021-01-31")`, "2021-02-28"},
+		{`xs:date("2021-03-01") - xs:dayTimeDuration("P1D")`, "2021-02-28"},
+		{`xs:time("23:00:00") + xs:dayTimeDuration("PT2H")`, "01:00:00"},
+		{`xs:dayTimeDuration("PT2H") * 1.5`, "PT3H"},
+		{`2 * xs:yearMonthDuration("P5M")`, "P10M"},
+		{`xs:yearMonthDuration("P1Y") div 5`, "P2M"},
+		{`xs:yearMonthDuration("P1Y") - xs:yearMonthDuration("P13M")`, "-P1M"},
+		{`timezone-from-dateTime(xs:dateTime("2021-03-04T10:20:30-05:00"))`, "-PT5H"},
+		{`adjust-dateTime-to-timezone(xs:dateTime("2002-03-07T10:00:00-05:00"), xs:dayTimeDuration("-PT10H"))`, "2002-03-07T05:00:00-10:00"},
+		{`adjust-dateTime-to-timezone(xs:dateTime("2002-03-07T10:00:00-05:00"), ())`, "2002-03-07T10:00:00"},
+		{`adjust-date-to-timezone(xs:date("2002-03-07"), xs:dayTimeDuration("-PT10H"))`, "2002-03-07-10:00"},
+		{`adjust-time-to-timezone(xs:time("10:00:00-05:00"), xs:dayTimeDuration("PT0S"))`, "15:00:00Z"},
+		{`dateTime(xs:date("1999-12-31"), xs:time("12:00:00Z"))`, "1999-12-31T12:00:00Z"},
+		{`max((xs:date("2021-03-04"), xs:date("2022-01-01"), xs:date("2020-05-05")))`, "2022-01-01"},
+		{`min(("P1D", "PT1H") ! xs:dayTimeDuration(.))`, "PT1H"},
+		{`sum((xs:dayTimeDuration("P1D"), xs:dayTimeDuration("PT12H")))`, "P1DT12H"},
+		{`sum(("P1Y", "P3M") ! xs:yearMonthDuration(.))`, "P1Y3M"},
+		{`sum(xs:dayTimeDuration("PT1H"))`, "PT1H"},
+		{`avg((xs:yearMonthDuration("P1Y"), xs:yearMonthDuration("P2Y")))`, "P1Y6M"},
+		{`avg((xs:dayTimeDuration("P1D"), xs:dayTimeDuration("PT12H")))`, "PT18H"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		items := bif.UnwrapSeq(item)
+		if len(items) != 1 {
+			t.Errorf("wrong number of items for %s. got=%s", tt.input, item.Inspect())
+			continue
+		}
+		if items[0].Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, items[0].Inspect(), tt.expected)
+		}
+	}
+
+	tests2 := []struct {
+		input    string
+		expected []interface{}
+	}{
+		{`xs:date("2021-03-04") lt xs:date("2021-03-05")`, []interface{}{true}},
+		{`xs:dateTime("2021-03-04T10:00:00+09:00") eq xs:dateTime("2021-03-04T01:00:00Z")`, []interface{}{true}},
+		{`xs:time("10:00:00Z") gt xs:time("09:00:00-02:00")`, []interface{}{false}},
+		{`(xs:date("2021-03-04"), xs:date("2020-01-01")) = xs:date("2020-01-01")`, []interface{}{true}},
+		{`xs:date("2021-03-04") != "2021-03-04"`, []interface{}{false}},
+		{`xs:duration("P1Y") eq xs:yearMonthDuration("P12M")`, []interface{}{true}},
+		{`xs:dayTimeDuration("P1D") gt xs:dayTimeDuration("PT23H")`, []interface{}{true}},
+		{`"x" castable as xs:date`, []interface{}{false}},
+		{`"2021-02-29" castable as xs:date`, []interface{}{false}},
+		{`"P1D" castable as xs:yearMonthDuration`, []interface{}{false}},
+		{`"P9999999999999999D" castable as xs:dayTimeDuration`, []interface{}{false}},
+		{`year-from-dateTime(xs:dateTime("2021-03-04T10:20:30.5-05:00"))`, []interface{}{2021}},
+		{`seconds-from-dateTime(xs:dateTime("2021-03-04T10:20:30.5-05:00"))`, []interface{}{30.5}},
+		{`month-from-date("2021-03-04")`, []interface{}{3}},
+		{`minutes-from-time(xs:time("10:20:30"))`, []interface{}{20}},
+		{`days-from-duration(xs:dayTimeDuration("P3DT10H"))`, []interface{}{3}},
+		{`hours-from-duration(xs:dayTimeDuration("P3DT10H"))`, []interface{}{10}},
+		{`years-from-duration(xs:yearMonthDuration("-P14M"))`, []interface{}{-1}},
+		{`months-from-duration(xs:yearMonthDuration("-P14M"))`, []interface{}{-2}},
+		{`xs:dayTimeDuration("PT2H") div xs:dayTimeDuration("PT30M")`, []interface{}{4.0}},
+		{`current-dateTime() eq current-dateTime()`, []interface{}{true}},
+		{`year-from-date(())`, []interface{}{}},
+	}
+
+	for _, tt := range tests2 {
+		seq := testEval(tt.input)
+		testSequenceObject(t, seq, tt.expected)
+	}
+
+	errors := []string{
+		`xs:duration("P1Y") lt xs:duration("P2Y")`,
+		`xs:date("2021-03-04") + xs:date("2021-03-04")`,
+		`xs:time("10:00:00") + xs:yearMonthDuration("P1M")`,
+		`xs:dateTime("2021-03-04")`,
+		`xs:duration("P")`,
+		`adjust-time-to-timezone(xs:time("10:00:00"), xs:dayTimeDuration("PT15H"))`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(testEval(input)) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+
+	codes := []struct {
+		input string
+		code  string
+	}{
+		{`xs:dayTimeDuration("P9999999999999999D")`, "err:FODT0002"},
+		{`xs:yearMonthDuration("P99999999999999999999Y")`, "err:FODT0002"},
+		{`xs:duration("PT9999999999999999999S")`, "err:FODT0002"},
+		{`xs:dayTimeDuration("P106751D") + xs:dayTimeDuration("P106751D")`, "err:FODT0002"},
+		{`xs:yearMonthDuration("P1M") * 1e300`, "err:FODT0002"},
+		{`sum((xs:yearMonthDuration("P1M"), xs:dayTimeDuration("P1D")))`, "err:FORG0006"},
+		{`sum(xs:duration("P1D"))`, "err:FORG0006"},
+	}
+
+	for _, tt := range codes {
+		e, ok := testEval(tt.input).(*object.Error)
+		if !ok || e.Code != tt.code {
+			t.Errorf("expected %s for %s. got=%v", tt.code, tt.input, e)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []interface{}
+	}{
+		{`format-number(12345.6, '#,##0.00')`, []interface{}{"12,345.60"}},
+		{`format-number(1234.5678, '#,##0.##')`, []interface{}{"1,234.57"}},
+		{`format-number(-6, '000')`, []interface{}{"-006"}},
+		{`format-number(-12, '#.00;(#.00)')`, []interface{}{"(12.00)"}},
+		{`format-number(0.14, '01%')`, []interface{}{"14%"}},
+		{`format-number(0.25, '#‰')`, []interface{}{"250‰"}},
+		{`format-number(1234.5678, '00.000e0')`, []interface{}{"12.346e2"}},
+		{`format-number(0.234, '0.0e0')`, []interface{}{"2.3e-1"}},
+		{`format-number(0.5, '#.00')`, []interface{}{".50"}},
+		{`format-number((), '#')`, []interface{}{"NaN"}},
+		{`format-integer(123, 'w')`, []interface{}{"one hundred and twenty-three"}},
+		{`format-integer(1005, 'W')`, []interface{}{"ONE THOUSAND AND FIVE"}},
+		{`format-integer(14, 'Ww;o')`, []interface{}{"Fourteenth"}},
+		{`format-integer(21, '1;o')`, []interface{}{"21st"}},
+		{`format-integer(7, '000')`, []interface{}{"007"}},
+		{`format-integer(1999, 'I')`, []interface{}{"MCMXCIX"}},
+		{`format-integer(1999, 'i')`, []interface{}{"mcmxcix"}},
+		{`format-integer(1234567, '#,##0')`, []interface{}{"1,234,567"}},
+		{`format-integer(28, 'a')`, []interface{}{"ab"}},
+		{`format-integer(-5, '01')`, []interface{}{"-05"}},
+		{`format-integer((), '1')`, []interface{}{""}},
+		{`format-date(xs:date('2002-12-31'), '[Y0001]-[M01]-[D01]')`, []interface{}{"2002-12-31"}},
+		{`format-date(xs:date('2002-12-31'), '[M]/[D]/[Y01]')`, []interface{}{"12/31/02"}},
+		{`format-date(xs:date('2002-12-31'), '[D1o] [MNn], [Y]')`, []interface{}{"31st December, 2002"}},
+		{`format-date(xs:date('2002-12-31'), '[FNn,*-3], [D] [MNn,*-3]')`, []interface{}{"Tue, 31 Dec"}},
+		{`format-date(xs:date('2002-12-31'), '[[[Y]]]')`, []interface{}{"[2002]"}},
+		{`format-date(xs:date('2002-12-31'), '[Y]', 'de', (), ())`, []interface{}{"[Language: en]2002"}},
+		{`format-time(xs:time('15:58:45.762'), '[h]:[m01] [PN]')`, []interface{}{"3:58 PM"}},
+		{`format-time(xs:time('15:58:45.762+02:00'), '[H01]:[m01]:[s01].[f001] [Z]')`, []interface{}{"15:58:45.762 +02:00"}},
+		{`format-dateTime(xs:dateTime('2002-12-31T15:58:45Z'), '[Y0001]-[M01]-[D01]T[H01]:[m01]:[s01][Zt]')`, []interface{}{"2002-12-31T15:58:45Z"}},
+		{`format-date((), '[Y]')`, []interface{}{}},
+	}
+
+	for _, tt := range tests {
+		seq := testEval(tt.input)
+		testSequenceObject(t, seq, tt.expected)
+	}
+
+	df := object.NewDecimalFormat()
+	df.DecimalSeparator = ','
+	df.GroupingSeparator = '.'
+
+	l := lexer.New(`format-number(1234567.891, '#.##0,00', 'de')`)
+	p := parser.New(l)
+	ctx := object.NewContext()
+	ctx.DecimalFormats = map[string]*object.DecimalFormat{"de": df}
+	testSequenceObject(t, Eval(p.ParseXPath(), ctx), []interface{}{"1.234.567,89"})
+
+	errors := []string{
+		`format-number(1, '#;#;#')`,
+		`format-number(1, '#.#.#')`,
+		`format-number(1, '#', 'unknown')`,
+		`format-integer(1, '')`,
+		`format-integer(1, '1;x')`,
+		`format-date(xs:date('2002-12-31'), '[H]')`,
+		`format-time(xs:time('10:00:00'), '[Y]')`,
+		`format-date(xs:date('2002-12-31'), '[Y')`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(testEval(input)) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+}
+
+func TestHigherOrder(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`array:sort([3, 1, 2])`, "([1, 2, 3])"},
+		{`array:sort(["b", "a", "C"], (), upper-case#1)`, "([a, b, C])"},
+		{`array:sort([[2, "b"], [1, "a"]], (), function($r) {$r(1)})`, "([[1, a], [2, b]])"},
+		{`array:for-each([1, 2, 3], function($x) {$x * 2})`, "([2, 4, 6])"},
+		{`array:for-each(["a", "bc"], string-length#1)`, "([1, 2])"},
+		{`array:filter([1, 2, 3, 4], function($x) {$x mod 2 = 0})`, "([2, 4])"},
+		{`array:for-each-pair([1, 2], [3, 4, 5], function($a, $b) {$a + $b})`, "([4, 6])"},
+		{`array:fold-left([1, 2, 3], 0, function($a, $b) {$a + $b})`, "(6)"},
+		{`array:fold-right([1, 2, 3], (), function($a, $b) {($b, $a)})`, "(3, 2, 1)"},
+		{`fold-left((1, 2, 3), 0, function($a, $b) {$a - $b})`, "(-6)"},
+		{`fold-right((1, 2, 3), 0, function($a, $b) {$a - $b})`, "(2)"},
+		{`fold-left(("a", "b"), "", concat#2)`, "(ab)"},
+		{`fold-left((), 0, function($a, $b) {$a + $b})`, "(0)"},
+		{`sort((3, 1, 2))`, "(1, 2, 3)"},
+		{`sort((-3, 1, -2), (), abs#1)`, "(1, -2, -3)"},
+		{`sort(("b", "a"), "http://www.w3.org/2005/xpath-functions/collation/codepoint")`, "(a, b)"},
+		{`apply(concat#3, ["a", "b", "c"])`, "(abc)"},
+		{`apply(function($a) {$a + 1}, [1])`, "(2)"},
+		{`(function-lookup("concat", 2))("a", "b")`, "(ab)"},
+		{`function-lookup("Q{http://www.w3.org/2005/xpath-functions/math}pi", 0) => function-name()`, "(math:pi)"},
+		{`function-lookup("unknown", 1)`, "()"},
+		{`function-lookup("upper-case", 5)`, "()"},
+		{`function-lookup("format-date", 3)`, "()"},
+		{`(function-lookup("concat", 4))("a", "b", "c", "d")`, "(abcd)"},
+		{`function-lookup("string", 0) => function-arity()`, "(0)"},
+		{`function-name(substring#2)`, "(fn:substring)"},
+		{`function-name(function($a) {$a})`, "()"},
+		{`function-arity(substring#2)`, "(2)"},
+		{`function-arity(function($a, $b) {$a})`, "(2)"},
+		{`function-arity(substring(?, 1))`, "(1)"},
+		{`let $f := function($x) {$x} return $f(1) + 1`, "(2)"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`array:filter([1, 2], function($x) {1})`,
+		`array:for-each([1, 2], function($a, $b) {$a})`,
+		`sort(("b", "a"), "http://example.com/collation")`,
+		`sort((1, "a"))`,
+		`apply(concat#3, ["a", "b"])`,
+		`function-arity(1)`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(bif.UnwrapSeq(testEval(input))[0]) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+}
+
+func TestSequenceFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`distinct-values((1, 1.0, 2, "1", 1e0, "a", "a", true(), 1 = 1))`, "(1, 2, 1, a, true)"},
+		{`distinct-values((0 div 0e0, 0 div 0e0))`, "(NaN)"},
+		{`distinct-values(())`, "()"},
+		{`count(distinct-values(//a[@class="tag"]/@href))`, "(36)"},
+		{`index-of((10, 20, 30, 20), 20)`, "(2, 4)"},
+		{`index-of(("a", 1, "b"), "b")`, "(3)"},
+		{`index-of((), 1)`, "()"},
+		{`deep-equal((1, 2), (1.0, 2e0))`, "(true)"},
+		{`deep-equal((1, 2), (2, 1))`, "(false)"},
+		{`deep-equal("1", 1)`, "(false)"},
+		{`deep-equal(0 div 0e0, 0 div 0e0)`, "(true)"},
+		{`deep-equal(map{"a": [1, 2]}, map{"a": [1, 2]})`, "(true)"},
+		{`deep-equal(map{"a": [1, 2]}, map{"a": [1, 3]})`, "(false)"},
+		{`deep-equal([1, (2, 3)], [1, (2, 3)])`, "(true)"},
+		{`deep-equal(//div[@class="quote"][1], //div[@class="quote"][1])`, "(true)"},
+		{`deep-equal(//div[@class="quote"][1], //div[@class="quote"][2])`, "(false)"},
+		{`deep-equal(//div[@class="quote"][1]/@class, //div[@class="quote"][2]/@class)`, "(true)"},
+		{`zero-or-one(())`, "()"},
+		{`one-or-more((1, 2))`, "(1, 2)"},
+		{`exactly-one(1)`, "(1)"},
+		{`unordered((3, 1))`, "(3, 1)"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`deep-equal(abs#1, abs#1)`,
+		`distinct-values((1, abs#1))`,
+		`zero-or-one((1, 2))`,
+		`one-or-more(())`,
+		`exactly-one(())`,
+		`exactly-one((1, 2))`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(bif.UnwrapSeq(testEval(input))[0]) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`parse-json('[1, "a", true, null]')`, "([1.000000e+00, a, true, ()])"},
+		{`(parse-json('{"a": [1, true, null, "x"]}'))?a?4`, "(x)"},
+		{`parse-json('{}')`, "(map{})"},
+		{`parse-json(())`, "()"},
+		{`(parse-json('{"a": 1, "a": 2}'))?a`, "(1.000000e+00)"},
+		{`(parse-json('{"a": 1, "a": 2}', map{"duplicates": "use-last"}))?a`, "(2.000000e+00)"},
+		{`parse-json("[1, 2, ]", map{"liberal": true()})`, "([1.000000e+00, 2.000000e+00])"},
+		{`(parse-json("{'a': 'b'}", map{"liberal": true()}))?a`, "(b)"},
+		{`parse-json('"\té"', map{"escape": true()})`, `(\té)`},
+		{`string-to-codepoints(parse-json('"\u0000"'))`, "(65533)"},
+		{`parse-json('"a\u0000"', map{"fallback": function($s) {"[" || $s || "]"}})`, `(a[\u0000])`},
+		{`(json-doc("testdata/quotes.json"))?quotes?2?author`, "(J.K. Rowling)"},
+		{`array:size((json-doc("testdata/quotes.json"))?quotes?1?tags)`, "(4)"},
+		{`json-to-xml('{"a": [1, "x"], "b": null}')/map/array[@key="a"]/string/string()`, "(x)"},
+		{`json-to-xml('{"a": [1, "x"], "b": null}')/*/*/@key/string()`, "(a, b)"},
+		{`json-to-xml('{"a": [1.50, true]}')//array/*/string()`, "(1.50, true)"},
+		{`json-to-xml('{"a": 1, "a": 2}')/map/number/string()`, "(1, 2)"},
+		{`json-to-xml('"a\u0001"', map{"escape": true()})/string/@escaped/string()`, "(true)"},
+		{`xml-to-json(json-to-xml('{"a": [1, "x\n", true], "b": null, "c": {}}'))`, `({"a":[1,"x\n",true],"b":null,"c":{}})`},
+		{`xml-to-json(json-to-xml('[1.50, 2e3, "a\"b"]'))`, `([1.5,2000,"a\"b"])`},
+		{`xml-to-json(json-to-xml('"a\u0001"', map{"escape": true()}))`, `("a\u0001")`},
+		{`xml-to-json(json-to-xml('{"a": [1]}'), map{"indent": true()})`, "({\n  \"a\": [\n    1\n  ]\n})"},
+		{`xml-to-json(())`, "()"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`parse-json("[1, 2, ]")`,
+		`parse-json("01")`,
+		`parse-json("[1] x")`,
+		`parse-json('"abc')`,
+		`parse-json('{"a": 1, "a": 2}', map{"duplicates": "reject"})`,
+		`parse-json('{}', map{"duplicates": "retain"})`,
+		`parse-json('{}', map{"liberal": "yes"})`,
+		`json-to-xml('{"a": 1, "a": 2}', map{"duplicates": "reject"})`,
+		`json-doc("testdata/missing.json")`,
+		`xml-to-json(//div[@class="quote"][1])`,
+		`xml-to-json(1)`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(bif.UnwrapSeq(testEval(input))[0]) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`serialize(//div[@class="quote"][1]/span[2]/small)`, `(<small class="author" itemprop="author">Albert Einstein</small>)`},
+		{`serialize(//div[@class="quote"][1]/span[2]/small, map{"method": "html"})`, `(<small class="author" itemprop="author">Albert Einstein</small>)`},
+		{`serialize(//div[@class="quote"][1]/span[2]/small, map{"method": "text"})`, "(Albert Einstein)"},
+		{`serialize(//div[@class="quote"][1]/span[2]/small, map{"method": "json"})`, `("<small class=\"author\" itemprop=\"author\">Albert Einstein</small>")`},
+		{`serialize(//div[@class="quote"][1]/span[2]/small, map{"omit-xml-declaration": false()})`, `(<?xml version="1.0" encoding="UTF-8"?><small class="author" itemprop="author">Albert Einstein</small>)`},
+		{`serialize((1, 2, "a<b"))`, "(1 2 a&lt;b)"},
+		{`serialize((1, 2, "a<b"), map{"method": "text"})`, "(1 2 a<b)"},
+		{`serialize((1, [2, 3]), map{"item-separator": ","})`, "(1,2,3)"},
+		{`serialize(json-to-xml('{"a": 1}'))`, `(<map xmlns="http://www.w3.org/2005/xpath-functions"><number key="a">1</number></map>)`},
+		{`serialize(json-to-xml('{"a": [1]}'), map{"indent": true()})`, "(<map xmlns=\"http://www.w3.org/2005/xpath-functions\">\n  <array key=\"a\">\n    <number>1</number>\n  </array>\n</map>)"},
+		{`serialize(json-to-xml('{"a": [1]}'), map{"method": "html", "indent": true()})`, "(<map>\n  <array key=\"a\">\n    <number>1</number>\n  </array>\n</map>)"},
+		{`serialize(map{"b": [1, 2.5, true(), ()], "a": 'x"y'}, map{"method": "json"})`, `({"a":"x\"y","b":[1,2.5,true,null]})`},
+		{`serialize([1, map{}], map{"method": "json", "indent": true()})`, "([\n  1,\n  {}\n])"},
+		{`serialize((), map{"method": "json"})`, "(null)"},
+		{`serialize((1, 1.5, 1.5e0, 'a"b', true(), xs:date("2020-01-02")), map{"method": "adaptive"})`, "(1\n1.5\n1.5e0\n\"a\"\"b\"\ntrue()\nxs:date(\"2020-01-02\"))"},
+		{`serialize(([1, (2, 3)], map{"k": abs#1}, function($a) {$a}), map{"method": "adaptive", "item-separator": " "})`, `([1,(2,3)] map{"k":fn:abs#1} (anonymous-function)#1)`},
+		{`serialize(//div[@class="quote"][1]/@class, map{"method": "adaptive"})`, `(class="quote")`},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`serialize(map{"a": 1})`,
+		`serialize(abs#1)`,
+		`serialize(//div/@class)`,
+		`serialize((1, 2), map{"method": "json"})`,
+		`serialize(0 div 0e0, map{"method": "json"})`,
+		`serialize(1, map{"method": "yaml"})`,
+		`serialize(1, map{"indent": "yes"})`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(bif.UnwrapSeq(testEval(input))[0]) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+}
+
+func TestFunctionTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`function($a as xs:string) as xs:string {$a || "!"}("hi")`, "(hi!)"},
+		{`(function($a as xs:double) {$a instance of xs:double})(1)`, "(true)"},
+		{`(function($a as xs:decimal) {$a instance of xs:integer})(1)`, "(true)"},
+		{`(function($a as xs:numeric*) {count($a)})(())`, "(0)"},
+		{`(function($a as xs:integer+) {sum($a)})((1, 2, 3))`, "(6)"},
+		{`(function($a as xs:string?) {empty($a)})(())`, "(true)"},
+		{`(function($a as xs:string) {$a})(//title)`, "(Quotes to Scrape)"},
+		{`(function($a as xs:integer) {$a + 1})(//div[@class="quote"] => count())`, "(11)"},
+		{`(function($a as element()*) {count($a)})(//div[@class="quote"])`, "(10)"},
+		{`(function($a as item()) {$a})(map{})?a`, "()"},
+		{`(function($a as array(*)) {array:size($a)})([1, 2])`, "(2)"},
+		{`(function($f as function(*)) {$f(2)})(function($x) {$x * 2})`, "(4)"},
+		{`(function() as xs:integer* {(1, 2)})()`, "(1, 2)"},
+		{`(function() as empty-sequence() {()})()`, "()"},
+		{`for-each((1, 2), function($a as xs:double) {$a instance of xs:double})`, "(true, true)"},
+		{`let $f := function($a as xs:string) as xs:integer {string-length($a)} return "abc" => $f()`, "(3)"},
+		{`1 instance of xs:string`, "(false)"},
+		{`(1, 2.5) instance of xs:decimal+`, "(true)"},
+		{`"P1D" cast as xs:dayTimeDuration instance of xs:duration`, "(true)"},
+		{`(1, 2) treat as xs:integer+`, "(1, 2)"},
+		{`() treat as empty-sequence()`, "()"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`(function($a as xs:string) {$a})(1)`,
+		`(function($a as xs:integer) {$a})(1.5)`,
+		`(function($a as xs:integer) {$a})((1, 2))`,
+		`(function($a as xs:integer) {$a})(())`,
+		`(function($a as xs:integer+) {$a})(())`,
+		`(function($a as element()) {$a})("a")`,
+		`(function() as xs:string {1})()`,
+		`(function() as empty-sequence() {1})()`,
+		`for-each((1, "a"), function($a as xs:integer) {$a})`,
+		`filter((1, 2), function($a as xs:string) {true()})`,
+	}
+
+	for _, input := range errors {
+		item := bif.UnwrapSeq(testEval(input))[0]
+		if !bif.IsError(item) {
+			t.Errorf("expected an error for %s", input)
+			continue
+		}
+		if code := item.(*object.Error).Code; code != "err:XPTY0004" {
+			t.Errorf("wrong error code for %s. got=%s, expected=err:XPTY0004", input, code)
+		}
+	}
+
+	messages := []struct {
+		input    string
+		code     string
+		expected string
+	}{
+		{`(function($a as element(p)) {$a})((//span)[1])`, "err:XPTY0004", "got=element(span), want=element(p)"},
+		{`(function($a as xs:string) {$a})(map{})`, "err:XPTY0004", "got=map(*), want=xs:string"},
+		{`(function($a as xs:string) {$a})((1, 2))`, "err:XPTY0004", "got=xs:integer+, want=xs:string"},
+		{`(function($a as attribute(id)) {$a})((//@class)[1])`, "err:XPTY0004", "got=attribute(class), want=attribute(id)"},
+		{`(function($a as xs:integer) {$a})(//title)`, "err:FORG0001", "element(title)"},
+		{`(function($a as xs:date) {$a})(//title)`, "err:FORG0001", "element(title)"},
+	}
+
+	for _, tt := range messages {
+		item := bif.UnwrapSeq(testEval(tt.input))[0]
+		e, ok := item.(*object.Error)
+		if !ok {
+			t.Errorf("expected an error for %s", tt.input)
+			continue
+		}
+		if e.Code != tt.code || !strings.Contains(e.Message, tt.expected) {
+			t.Errorf("wrong error for %s. got=%s %s, expected=%s %s", tt.input, e.Code, e.Message, tt.code, tt.expected)
+		}
+	}
+}
+
+func TestKindTestArgs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`count(//element(div))`, "(28)"},
+		{`count(//element(*)) = count(//element())`, "(true)"},
+		{`count(descendant::element(span))`, "(32)"},
+		{`count(//div/attribute(class))`, "(28)"},
+		{`count(//span/attribute::attribute(class))`, "(21)"},
+		{`count(//div/attribute(*)) = count(//div/attribute())`, "(true)"},
+		{`count(//div/element(span)) = count(//div/span)`, "(true)"},
+		{`count(//span[1]/parent::element(div)) = count(//span[1]/parent::div)`, "(true)"},
+		{`count(self::document-node(element(html)))`, "(1)"},
+		{`count(self::document-node(element(body)))`, "(0)"},
+		{`count(/document-node(element(*)))`, "(1)"},
+		{`//div instance of element(div)+`, "(true)"},
+		{`//div instance of element(span)+`, "(false)"},
+		{`(//div)[1] instance of element(*)`, "(true)"},
+		{`(//@class)[1] instance of attribute(class)`, "(true)"},
+		{`(//@class)[1] instance of attribute(id)`, "(false)"},
+		{`(function($a as element(title)) {string($a)})(//title)`, "(Quotes to Scrape)"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`//element(div, xs:string)`,
+		`//div/attribute(*, xs:decimal)`,
+		`(//div)[1] instance of element(div, xs:untyped)`,
+		`self::document-node(element(html, xs:anyType))`,
+		`(function($a as element(div)) {$a})(//title)`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(bif.UnwrapSeq(testEval(input))[0]) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+
+	schemaErrors := []string{
+		`//schema-element(div)`,
+		`count(//schema-element(div))`,
+		`//div/schema-attribute(class)`,
+		`//div/attribute::schema-attribute(class)`,
+		`self::document-node(schema-element(html))`,
+		`(//div)[1] instance of schema-element(div)`,
+		`//div[child::schema-element(span)]`,
+	}
+
+	for _, input := range schemaErrors {
+		item := bif.UnwrapSeq(testEval(input))[0]
+		if !bif.IsError(item) {
+			t.Errorf("expected an error for %s. got=%s", input, item.Inspect())
+			continue
+		}
+		if code := item.(*object.Error).Code; code != "err:XPST0008" {
+			t.Errorf("wrong error code for %s. got=%s, expected=err:XPST0008", input, code)
+		}
+	}
+}
+
+func TestXPath40(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`() otherwise 1`, "(1)"},
+		{`(2, 3) otherwise 1`, "(2, 3)"},
+		{`//table otherwise //title/text() => string()`, "(Quotes to Scrape)"},
+		{`1 + 2 otherwise 5`, "(3)"},
+		{"let $name := 'World' return `Hello {$name}!`", "(Hello World!)"},
+		{"`a{{b}}c``d`", "(a{b}c`d)"},
+		{"`{(1, 2, 3)}|{}|{ 'x' || '}' }`", "(1 2 3||x})"},
+		{"`{`nested {1 + 1}`}`", "(nested 2)"},
+		{"`{//title}`", "(Quotes to Scrape)"},
+		{`if (1 = 1) { 'yes' }`, "(yes)"},
+		{`count(if (1 = 2) { 'yes' })`, "(0)"},
+		{`if (1 = 2) { 'a' } otherwise 'b'`, "(b)"},
+		{`(fn { . + 1 })(2)`, "(3)"},
+		{`for-each((1, 2), fn { . * 10 })`, "(10, 20)"},
+		{`(function { string-length(.) })('abc')`, "(3)"},
+		{`filter(//span, fn { @class = 'text' }) => count()`, "(10)"},
+		{`(//title)[1] => (fn { name(.) })()`, "(title)"},
+		{`(1, 2, 3) =!> string()`, "(1, 2, 3)"},
+		{`('a', 'b') =!> concat('-')`, "(a-, b-)"},
+		{`(1, 2) =!> (fn { . * 2 })() => sum()`, "(6)"},
+		{`(1, 2) => sum()`, "(3)"},
+		{`1 => (function($a) { $a + 1 })()`, "(2)"},
+		{`try { abs('a') } catch * { 'caught' }`, "(caught)"},
+		{`try { 1 + 1 } catch * { 0 }`, "(2)"},
+		{`try { 1 div 0 } catch err:XPTY0004 { 1 } catch err:FOAR0001 { 2 }`, "(2)"},
+		{`try { abs('a') } catch err:FOAR0001 | err:XPTY0004 { $err:code }`, "(err:XPTY0004)"},
+		{`try { error('my:oops', 'bad input', (1, 2)) } catch err:* { 0 } catch my:oops { $err:description, sum($err:value) }`, "(bad input, 3)"},
+		{`try { error() } catch *:FOER0000 { $err:code }`, "(err:FOER0000)"},
+		{`try { error('Q{http://www.w3.org/2005/xqt-errors}XPTY0004') } catch Q{http://www.w3.org/2005/xqt-errors}* { $err:code }`, "(err:XPTY0004)"},
+		{`try { try { abs('a') } catch err:FOAR0001 { 1 } } catch * { 2 }`, "(2)"},
+		{`map { 'a': try { //missing/number() => exactly-one() } catch * { () }, 'b': 1 }?b`, "(1)"},
+		{`count(//try)`, "(0)"},
+	}
+
+	for _, tt := range tests {
+		item := testEval40(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		"`{map{}}`",
+		`(fn { . })((1, 2))`,
+		`(fn { . })()`,
+		"`a } b`",
+		`try { abs('a') } catch err:FOAR0001 { 0 }`,
+		`try { abs('a') } catch * { abs('b') }`,
+	}
+
+	for _, input := range errors {
+		if !bif.IsError(bif.UnwrapSeq(testEval40(input))[0]) {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+
+	if !bif.IsError(bif.UnwrapSeq(testEval("`a`"))[0]) {
+		t.Errorf("string templates should be an error in XPath 3.1")
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedCode string
+		expectedExpr string
+	}{
+		{`abs('a')`, "err:XPTY0004", "abs('a')"},
+		{`1 + 'a'`, "err:XPTY0004", "(1 + 'a')"},
+		{`1 div 0`, "err:FOAR0001", "(1 div 0)"},
+		{`1 idiv 0e0`, "err:FOAR0001", "(1 idiv 0.000000e+00)"},
+		{`5 mod 0`, "err:FOAR0001", "(5 mod 0)"},
+		{`count(1, 2)`, "err:XPST0017", "count(1, 2)"},
+		{`$undeclared`, "err:XPST0008", "$undeclared"},
+		{`let $a := 'x' return (1, 2) ! (. + $a)`, "err:XPTY0004", "(. + $a)"},
+		{`'a' cast as xs:integer`, "err:FORG0001", "'a' cast as xs:integer"},
+		{`[1, 2](3)`, "err:FOAY0001", "[1, 2](3)"},
+		{`exactly-one((1, 2))`, "err:FORG0005", "exactly-one((1, 2))"},
+		{`matches('a', '(')`, "err:FORX0002", "matches('a', '(')"},
+		{`parse-json('{')`, "err:FOJS0001", "parse-json('{')"},
+		{`upper-case('a', 'b')`, "err:XPST0017", "upper-case('a', 'b')"},
+		{`map:size((map{}, map{}))`, "err:XPTY0004", "map:size((map{}, map{}))"},
+		{`map:merge((map{1: 2}, map{1: 3}), map{"duplicates": "reject"})`, "err:FOJS0003", "map:merge((map{1: 2}, map{1: 3}), map{'duplicates': 'reject'})"},
+		{`xml-to-json((//div)[1])`, "err:FOJS0006", "xml-to-json(//div[1])"},
+		{`format-date(xs:date('2021-01-01'), '[Y')`, "err:FOFD1340", "format-date(xs:date('2021-01-01'), '[Y')"},
+		{`[1, 2]?a`, "err:XPTY0004", "[1, 2]?a"},
+		{`(1, 2)/@a`, "err:XPTY0019", "((1, 2) / @a)"},
+		{`(1, 'a') treat as xs:integer+`, "err:XPDY0050", "(1, 'a') treat as xs:integer+"},
+		{`for-each((1, 2), function($a, $b) {$a})`, "err:XPTY0004", "for-each((1, 2), function($a, $b) {$a})"},
+		{`let $f := function($x as xs:integer) {$x} return $f('a')`, "err:XPTY0004", "$f('a')"},
+		{`function($x as xs:integer) {$x}('a')`, "err:XPTY0004", "function($x as xs:integer) {$x}('a')"},
+	}
+
+	for _, tt := range tests {
+		item := bif.UnwrapSeq(testEval(tt.input))[0]
+		err, ok := item.(*object.Error)
+		if !ok {
+			t.Errorf("expected an error for %s. got=%s", tt.input, item.Inspect())
+			continue
+		}
+		if err.Code != tt.expectedCode {
+			t.Errorf("wrong error code for %s. got=%s, expected=%s", tt.input, err.Code, tt.expectedCode)
+		}
+		if err.Expr != tt.expectedExpr {
+			t.Errorf("wrong error expression for %s. got=%s, expected=%s", tt.input, err.Expr, tt.expectedExpr)
+		}
+	}
+}
+
+func TestFnError(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedCode    string
+		expectedMessage string
+	}{
+		{`error()`, "err:FOER0000", "error raised by fn:error"},
+		{`error(())`, "err:FOER0000", "error raised by fn:error"},
+		{`error('my:missing')`, "my:missing", "error raised by fn:error"},
+		{`error('err:FORG0001', 'not a number')`, "err:FORG0001", "not a number"},
+		{`error('Q{http://www.w3.org/2005/xqt-errors}FOAR0001', 'x', 1)`, "err:FOAR0001", "x"},
+		{`error(1)`, "err:XPTY0004", "cannot match item type with required type. got=xs:integer, want=xs:QName?"},
+		{`error('a', 'b', 'c', 'd')`, "err:XPST0017", "too many parameters for function call: fn:error"},
+	}
+
+	for _, tt := range tests {
+		item := bif.UnwrapSeq(testEval(tt.input))[0]
+		err, ok := item.(*object.Error)
+		if !ok {
+			t.Errorf("expected an error for %s. got=%s", tt.input, item.Inspect())
+			continue
+		}
+		if err.Code != tt.expectedCode || err.Message != tt.expectedMessage {
+			t.Errorf("wrong error for %s. got=%s %s, expected=%s %s", tt.input, err.Code, err.Message, tt.expectedCode, tt.expectedMessage)
+		}
+	}
+}
+
+func TestFnTrace(t *testing.T) {
+	var traces []string
+
+	l := lexer.New(`sum(trace((1, 2), 'nums')) + count(trace(//title, 'title')) + trace(1)`)
+	p := parser.New(l)
+	xpath := p.ParseXPath()
+	ctx := object.NewContext()
+	ctx.Trace = func(label, value string) {
+		traces = append(traces, label+"="+value)
+	}
+
+	if err := bif.SetDoc(ctx, "testdata/quotes-1.html"); err != nil {
+		t.Fatal(err.Inspect())
+	}
+
+	item := Eval(xpath, ctx)
+	if item.Inspect() != "(5)" {
+		t.Errorf("wrong value. got=%s, expected=(5)", item.Inspect())
+	}
+
+	expected := []string{"nums=1, 2", "title=<title>Quotes to Scrape</title>", "=1"}
+	if strings.Join(traces, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong traces. got=%q, expected=%q", traces, expected)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	recursion := `let $f := function($f, $n) { if ($n = 0) then 0 else $f($f, $n - 1) } return $f($f, %d)`
+
+	tests := []struct {
+		input    string
+		guard    *object.Guard
+		expected string
+	}{
+		{`count(//*//*//*)`, object.NewGuard(nil, object.Limits{MaxNodes: 100}), "rabbit:max-nodes"},
+		{`count(//*)`, object.NewGuard(nil, object.Limits{MaxNodes: 1000}), ""},
+		{`1 to 1000`, object.NewGuard(nil, object.Limits{MaxSequenceLength: 100}), "rabbit:max-sequence-length"},
+		{`count(1 to 100)`, object.NewGuard(nil, object.Limits{MaxSequenceLength: 100}), ""},
+		{`for $i in 1 to 60 return ($i, $i)`, object.NewGuard(nil, object.Limits{MaxSequenceLength: 100}), "rabbit:max-sequence-length"},
+		{fmt.Sprintf(recursion, 100), object.NewGuard(nil, object.Limits{MaxDepth: 10}), "rabbit:max-depth"},
+		{fmt.Sprintf(recursion, 5), object.NewGuard(nil, object.Limits{MaxDepth: 10}), ""},
+		{`string-join(1 to 20)`, object.NewGuard(nil, object.Limits{MaxStringLength: 10}), "rabbit:max-string-length"},
+		{`try { 1 to 1000 } catch * { 0 }`, object.NewGuard(nil, object.Limits{MaxSequenceLength: 100}), "rabbit:max-sequence-length"},
+		{`for $i in 1 to 10 return $i`, object.NewGuard(canceled, object.Limits{}), "rabbit:canceled"},
+		{`some $i in 1 to 10 satisfies $i = 5`, object.NewGuard(canceled, object.Limits{}), "rabbit:canceled"},
+		{`for $i in 1 to 10 return $i`, nil, ""},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewVersion(tt.input, lexer.XPath40)
+		p := parser.New(l)
+		xpath := p.ParseXPath()
+		ctx := object.NewContext()
+
+		if err := bif.SetDoc(ctx, "testdata/quotes-1.html"); err != nil {
+			t.Fatal(err.Inspect())
+		}
+		ctx.Guard = tt.guard
+
+		item := Eval(xpath, ctx)
+		err, ok := item.(*object.Error)
+		switch {
+		case tt.expected == "" && ok:
+			t.Errorf("unexpected error for %s. got=%s", tt.input, err.Inspect())
+		case tt.expected != "" && !ok:
+			t.Errorf("expected an error for %s. got=%s", tt.input, item.Inspect())
+		case tt.expected != "" && err.Code != tt.expected:
+			t.Errorf("wrong error code for %s. got=%s, expected=%s", tt.input, err.Code, tt.expected)
+		case ok && err.Code == object.CodeCanceled && !errors.Is(err.Err, context.Canceled):
+			t.Errorf("expected context.Canceled for %s. got=%v", tt.input, err.Err)
+		}
+	}
+}
+
+func TestLazy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(//div)[2]/@class`, "(Attr{class:row header-box})"},
+		{`(//a)[3]/text()`, "(Text{(about)})"},
+		{`head(//small)/text()`, "(Text{Albert Einstein})"},
+		{`exists(//span)`, "(true)"},
+		{`empty(//span)`, "(false)"},
+		{`exists(//nope/a)`, "(false)"},
+		{`some $s in //span satisfies $s/@itemprop = 'text'`, "(true)"},
+		{`(for $d in //div return $d/@class)[1]`, "(Attr{class:container})"},
+		{`(//div ! @class)[2]`, "(Attr{class:row header-box})"},
+		{`count(//div ! a)`, "(30)"},
+		{`count(//div//span)`, "(32)"},
+		{`count(//div//a) = count(//a[ancestor::div])`, "(true)"},
+		{`head(//div)/@class`, "(Attr{class:container})"},
+		{`/head(//div)/@class/string()`, "(container)"},
+		{`count(//a[last()]) = count(//a[not(following-sibling::a)])`, "(true)"},
+		{`count(//a/preceding-sibling::*[last()]) = count(//a/preceding-sibling::*[not(preceding-sibling::*)])`, "(true)"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	// the lazy paths select the same nodes as the paths that are evaluated eagerly
+	// the descendant-or-self axis and a function call on the left are not evaluated lazily
+	eager := []struct {
+		lazy  string
+		eager string
+	}{
+		{`//span[last()]`, `/descendant-or-self::node()/span[last()]`},
+		{`//div/a[last()]`, `/descendant-or-self::node()/div/a[last()]`},
+		{`//div[last()]/@class`, `/descendant-or-self::node()/div[last()]/@class`},
+		{`//span[last() - 1]/text()`, `/descendant-or-self::node()/span[last() - 1]/text()`},
+		{`//a[position() = last()]/@href`, `/descendant-or-self::node()/a[position() = last()]/@href`},
+		{`head(//div)/@class`, `subsequence(//div, 1, 1)/@class`},
+	}
+
+	for _, tt := range eager {
+		lazy, eager := testEval(tt.lazy), testEval(tt.eager)
+		if lazy.Inspect() != eager.Inspect() {
+			t.Errorf("wrong result for %s. got=%s, expected=%s", tt.lazy, lazy.Inspect(), eager.Inspect())
+		}
+	}
+
+	// the expressions stop walking the document at the first item they need
+	shortCircuit := []struct {
+		input    string
+		complete bool
+	}{
+		{`(//span)[1]`, true},
+		{`exists(//span)`, true},
+		{`head(//div/span)`, true},
+		{`some $s in //span satisfies $s/@class = 'text'`, true},
+		{`(for $d in //div return $d/span)[1]`, true},
+		{`count(//span)`, false},
+		{`empty(//nope)`, false},
+	}
+
+	for _, tt := range shortCircuit {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		xpath := p.ParseXPath()
+		ctx := object.NewContext()
+
+		if err := bif.SetDoc(ctx, "testdata/quotes-1.html"); err != nil {
+			t.Fatal(err.Inspect())
+		}
+		ctx.Guard = object.NewGuard(nil, object.Limits{MaxNodes: 100})
+
+		item := Eval(xpath, ctx)
+		if complete := !bif.IsError(item); complete != tt.complete {
+			t.Errorf("wrong completion for %s within 100 nodes. got=%s", tt.input, item.Inspect())
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<div id="a" class="x y"><p id="b">1</p><span>2</span></div><div id="c" class="y"><p>3</p><p id="b">4</p></div>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docNode := &object.BaseNode{}
+	docNode.SetTree(doc)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`//p/text()`, "(Text{1}, Text{3}, Text{4})"},
+		{`//div[2]//p/text()`, "(Text{3}, Text{4})"},
+		{`count(//div//p)`, "(3)"},
+		{`//span | //p`, "(Elem{p}, Elem{span}, Elem{p}, Elem{p})"},
+		{`(//p, //div/@id) ! name()`, "(p, p, p, id, id)"},
+		{`(//div/@id | //p) ! name()`, "(id, p, id, p, p)"},
+		{`//p except //div[2]/p`, "(Elem{p})"},
+		{`(//p intersect //*[@id]) ! string()`, "(1, 4)"},
+		{`//div[1] << //div[2]`, "(true)"},
+		{`//div[1]/@id << //div[1]/p`, "(true)"},
+		{`//div[2]/p[1] >> //div[1]/@class`, "(true)"},
+		{`id('c a')/@class ! string()`, "(x y, y)"},
+		{`id('b')/text()`, "(Text{1})"},
+		{`id(('nope', 'c'), //span)/p[1]/text()`, "(Text{3})"},
+		{`/id('a')/string()`, "(12)"},
+		{`/id('a')/@class/string()`, "(x y)"},
+		{`(/id('c'))/p/text()`, "(Text{3}, Text{4})"},
+		{`/id('c a')/p ! string()`, "(1, 3, 4)"},
+		{`/id('nope')/p`, "()"},
+		{`//div/(./p)/text()`, "(Text{1}, Text{3}, Text{4})"},
+		{`//div/(./p)/string()`, "(1, 3, 4)"},
+		{`//div/./p/string()`, "(1, 3, 4)"},
+		{`count(//div/./p)`, "(3)"},
+		{`//p/./string()`, "(1, 3, 4)"},
+		{`//p/./@id/string()`, "(b, b)"},
+		{`//div/./p/./text()`, "(Text{1}, Text{3}, Text{4})"},
+		{`//p/position()`, "(1, 2, 3)"},
+		{`//div/p/position()`, "(1, 2, 3)"},
+		{`//p/(position(), last())`, "(1, 3, 2, 3, 3, 3)"},
+		{`//div/(p|span)/name()`, "(p, span, p, p)"},
+		{`//div/(p|span)/position()`, "(1, 2, 3, 4)"},
+		{`//p[1]/following::*[1]/name()`, "(span, p)"},
+		{`//p[1]/following::*[position() < 3]/name()`, "(span, div, p)"},
+		{`//p[last()]/preceding::*[1]/string()`, "(3)"},
+		{`//div/descendant::*[1]/string()`, "(1, 3)"},
+	}
+
+	for _, tt := range tests {
+		for _, indexed := range []bool{false, true} {
+			l := lexer.New(tt.input)
+			p := parser.New(l)
+			xpath := p.ParseXPath()
+			ctx := object.NewContext()
+			ctx.Doc = docNode
+			ctx.CNode = []object.Node{docNode}
+			if indexed {
+				ctx.Index = object.NewIndex(docNode)
+			}
+
+			item := Eval(xpath, ctx)
+			if item.Inspect() != tt.expected {
+				t.Errorf("wrong result for %s with index=%t. got=%s, expected=%s", tt.input, indexed, item.Inspect(), tt.expected)
+			}
+		}
+	}
+
+	idx := object.NewIndex(docNode)
+	if n := len(idx.Class("y")); n != 2 {
+		t.Errorf("wrong number of elements with class y. got=%d, expected=2", n)
+	}
+	if n := len(idx.Elements("p")); n != 3 {
+		t.Errorf("wrong number of p elements. got=%d, expected=3", n)
+	}
+	stats := idx.Stats()
+	if stats.IDs != 3 || stats.Classes != 2 || stats.Bytes <= 0 {
+		t.Errorf("wrong index stats. got=%+v", stats)
+	}
+}
+
+func TestVarRef(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let $count := 2 return count(($count, $count))`, "(2)"},
+		{`let $f := upper-case#1 return $f("a")`, "(A)"},
+		{`let $a := [1, 2] return $a(2)`, "(2)"},
+	}
+
+	for _, tt := range tests {
+		item := testEval(tt.input)
+		if item.Inspect() != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, item.Inspect(), tt.expected)
+		}
+	}
+
+	errors := []string{
+		`$x`,
+		`count($x)`,
+		`(1, $x)`,
+		`//div[$x]`,
+		`[$x]`,
+		`map{"a": $x}`,
+		`$x(1)`,
+		`for $i in $x return $i`,
+	}
+
+	for _, input := range errors {
+		item := bif.UnwrapSeq(testEval(input))[0]
+		if !bif.IsError(item) {
+			t.Errorf("expected an error for %s", input)
+			continue
+		}
+		if code := item.(*object.Error).Code; code != "err:XPST0008" {
+			t.Errorf("wrong error code for %s. got=%s, expected=err:XPST0008", input, code)
+		}
+	}
+}
+
 func testEval(input string) object.Item {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -2594,9 +3667,28 @@ func testEval(input string) object.Item {
 		return bif.NewError(sb.String())
 	}
 
-	docFunc := bif.F["fn:doc"]
-	err := docFunc(ctx, bif.NewString("testdata/quotes-1.html"))
-	if err != nil {
+	if err := bif.SetDoc(ctx, "testdata/quotes-1.html"); err != nil {
+		return err
+	}
+
+	return Eval(xpath, ctx)
+}
+
+func testEval40(input string) object.Item {
+	l := lexer.NewVersion(input, lexer.XPath40)
+	p := parser.New(l)
+	xpath := p.ParseXPath()
+	ctx := object.NewContext()
+
+	if len(p.Errors()) > 0 {
+		var sb strings.Builder
+		for _, e := range p.Errors() {
+			sb.WriteString(e.Error())
+		}
+		return bif.NewError(sb.String())
+	}
+
+	if err := bif.SetDoc(ctx, "testdata/quotes-1.html"); err != nil {
 		return err
 	}
 
@@ -2617,9 +3709,7 @@ func testEvalXML(input string) object.Item {
 		return bif.NewError(sb.String())
 	}
 
-	docFunc := bif.F["fn:doc"]
-	err := docFunc(ctx, bif.NewString("testdata/company.xml"))
-	if err != nil {
+	if err := bif.SetDoc(ctx, "testdata/company.xml"); err != nil {
 		return err
 	}
 
@@ -2640,9 +3730,7 @@ func testEvalXML2(input string) object.Item {
 		return bif.NewError(sb.String())
 	}
 
-	docFunc := bif.F["fn:doc"]
-	err := docFunc(ctx, bif.NewString("testdata/company_2.xml"))
-	if err != nil {
+	if err := bif.SetDoc(ctx, "testdata/company_2.xml"); err != nil {
 		return err
 	}
 
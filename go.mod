@@ -2,4 +2,7 @@ module github.com/zzossig/rabbit
 
 go 1.16
 
-require golang.org/x/net v0.0.0-20210226101413-39120d07d75e
+require (
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
+)
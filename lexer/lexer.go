@@ -3,45 +3,100 @@ package lexer
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/zzossig/rabbit/token"
 	"github.com/zzossig/rabbit/util"
 )
 
+// Version is the version of the XPath language that the input is written in
+type Version int
+
+// XPath31 is the default version, XPath40 adds the syntax extensions of the XPath 4.0 drafts
+const (
+	XPath31 Version = iota
+	XPath40
+)
+
 // Lexer reads input string one by one
 type Lexer struct {
-	input string // user input
-	pos   int    // current position within input
-	fPos  int    // following position
-	ch    byte   // current char under examination
+	input   string  // user input
+	pos     int     // current position within input
+	fPos    int     // following position
+	ch      byte    // current char under examination
+	comment int     // position of an unterminated comment, -1 if none
+	version Version // XPath version of the input
+	offset  int     // position of the input in the enclosing input, added to the token positions
 }
 
 // New returns Lexer pointer
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewVersion(input, XPath31)
+}
+
+// NewVersion returns Lexer pointer that reads the input as the XPath version v
+func NewVersion(input string, v Version) *Lexer {
+	l := &Lexer{input: input, comment: -1, version: v}
 	l.readChar()
 	return l
 }
 
-// PeekSpace checks if next char is space or not
+// NewAt is like NewVersion but the input is a part of another input that starts at offset.
+// It is used for the enclosed expressions of a string template, so the token positions are the ones in the whole input.
+func NewAt(input string, v Version, offset int) *Lexer {
+	l := NewVersion(input, v)
+	l.offset = offset
+	return l
+}
+
+// Version returns the XPath version of the input
+func (l *Lexer) Version() Version {
+	return l.version
+}
+
+// PeekSpace checks if next char is space or not, a comment is treated as a space
 func (l *Lexer) PeekSpace() bool {
-	return unicode.IsSpace(rune(l.ch))
+	return unicode.IsSpace(rune(l.ch)) || l.isComment()
 }
 
-// Remaining returns not yet parsed input
+// Remaining returns not yet parsed input without comments
 func (l *Lexer) Remaining() string {
 	if l.fPos >= len(l.input) {
-		return l.input
+		return stripComments(l.input)
 	}
-	return l.input[l.pos:]
+	return stripComments(l.input[l.pos:])
 }
 
 // NextToken returns next token by reading input characters
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skipSpace()
 
+	if l.comment >= 0 {
+		tok := token.Token{Type: token.ILLEGAL, Literal: "(:", Pos: l.offset + l.comment}
+		l.comment = -1
+		return tok
+	}
+
+	pos := l.pos
+	tok := l.readToken()
+	tok.Pos = l.offset + pos
+	return tok
+}
+
+// Position returns the line and the column of the byte position pos in the input, both start at 1.
+// The column counts characters, not bytes.
+func Position(input string, pos int) (line, column int) {
+	if pos > len(input) {
+		pos = len(input)
+	}
+	line = 1 + strings.Count(input[:pos], "\n")
+	column = 1 + utf8.RuneCountInString(input[strings.LastIndex(input[:pos], "\n")+1:pos])
+	return line, column
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '"', '\'':
 		tok = token.Token{Type: token.STRING, Literal: l.readString()}
@@ -80,7 +135,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok = token.Token{Type: token.GT, Literal: ">"}
 		}
 	case '=':
-		if l.peekChar() == '>' {
+		if l.version == XPath40 && l.peekChar() == '!' && l.peekChar2() == '>' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.MARROW, Literal: "=!>"}
+		} else if l.peekChar() == '>' {
 			l.readChar()
 			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
@@ -146,12 +205,26 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = token.Token{Type: token.VBAR, Literal: "|"}
 		}
+	case '`':
+		if l.version != XPath40 {
+			tok = token.Token{Type: token.ILLEGAL, Literal: "`"}
+			break
+		}
+		if literal, ok := l.readTemplate(); ok {
+			tok = token.Token{Type: token.TEMPLATE, Literal: literal}
+		} else {
+			tok = token.Token{Type: token.ILLEGAL, Literal: "`"}
+		}
 	case 0:
 		tok = token.Token{Type: token.EOF, Literal: ""}
 	default:
 		if unicode.IsLetter(rune(l.ch)) {
 			tok.Literal = l.readIdent()
-			tok.Type = token.LookupIdent(tok.Literal)
+			if l.version == XPath40 {
+				tok.Type = token.LookupIdent40(tok.Literal)
+			} else {
+				tok.Type = token.LookupIdent(tok.Literal)
+			}
 			return tok
 		} else if unicode.IsDigit(rune(l.ch)) {
 			tok.Literal = l.readNumber()
@@ -192,12 +265,81 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.fPos]
 }
 
+func (l *Lexer) peekChar2() byte {
+	if l.fPos+1 >= len(l.input) {
+		return 0
+	}
+	return l.input[l.fPos+1]
+}
+
+// skipSpace skips whitespace and comments, comments can be nested: (: a (: b :) c :)
 func (l *Lexer) skipSpace() {
-	for unicode.IsSpace(rune(l.ch)) {
+	for {
+		for unicode.IsSpace(rune(l.ch)) {
+			l.readChar()
+		}
+		if !l.isComment() {
+			return
+		}
+
+		pos := l.pos
+		l.readChar()
 		l.readChar()
+		for depth := 1; depth > 0; {
+			switch {
+			case l.ch == 0:
+				l.comment = pos
+				return
+			case l.ch == '(' && l.peekChar() == ':':
+				l.readChar()
+				depth++
+			case l.ch == ':' && l.peekChar() == ')':
+				l.readChar()
+				depth--
+			}
+			l.readChar()
+		}
 	}
 }
 
+func (l *Lexer) isComment() bool {
+	return l.ch == '(' && l.peekChar() == ':'
+}
+
+// stripComments removes comments from s, string literals are kept as they are
+func stripComments(s string) string {
+	var sb strings.Builder
+	var quote byte
+	depth := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case depth == 0 && quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case depth == 0 && (s[i] == '"' || s[i] == '\''):
+			quote = s[i]
+		case s[i] == '(' && i+1 < len(s) && s[i+1] == ':':
+			depth++
+			i++
+			continue
+		case depth > 0 && s[i] == ':' && i+1 < len(s) && s[i+1] == ')':
+			depth--
+			i++
+			if depth == 0 {
+				sb.WriteByte(' ')
+			}
+			continue
+		}
+
+		if depth == 0 {
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
 func (l *Lexer) readString() string {
 	ch := l.ch
 	pos := l.pos + 1
@@ -222,6 +364,44 @@ func (l *Lexer) readString() string {
 	return strings.ReplaceAll(l.input[pos:l.pos], "''", "'")
 }
 
+// readTemplate reads a string template such as `Hello {$name}` and returns the content between the backticks.
+// Quotes, braces and nested templates in the enclosed expressions are skipped, so they do not end the template.
+// ok is false if the template is not terminated.
+func (l *Lexer) readTemplate() (literal string, ok bool) {
+	pos := l.pos + 1
+	depth := 0
+
+	for {
+		l.readChar()
+		switch {
+		case l.ch == 0:
+			return l.input[pos:l.pos], false
+		case depth == 0 && l.ch == '`':
+			if l.peekChar() != '`' {
+				return l.input[pos:l.pos], true
+			}
+			l.readChar()
+		case depth == 0 && (l.ch == '{' || l.ch == '}') && l.peekChar() == l.ch:
+			l.readChar()
+		case l.ch == '{':
+			depth++
+		case l.ch == '}' && depth > 0:
+			depth--
+		case (l.ch == '"' || l.ch == '\'') && depth > 0:
+			quote := l.ch
+			for l.readChar(); l.ch != quote; l.readChar() {
+				if l.ch == 0 {
+					return l.input[pos:l.pos], false
+				}
+			}
+		case l.ch == '`' && depth > 0:
+			if _, ok := l.readTemplate(); !ok {
+				return l.input[pos:l.pos], false
+			}
+		}
+	}
+}
+
 func (l *Lexer) readNumber() string {
 	pos := l.pos
 	l.readChar()
@@ -230,3 +230,124 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestComment(t *testing.T) {
+	input := `(: outer (: nested :) :)(1, (: a, ) b :) 2)(:trailing:)`
+
+	tokens := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+		expectedPos     int
+	}{
+		{token.LPAREN, "(", 24},
+		{token.INT, "1", 25},
+		{token.COMMA, ",", 26},
+		{token.INT, "2", 41},
+		{token.RPAREN, ")", 42},
+		{token.EOF, "", 55},
+	}
+
+	lexer := New(input)
+
+	for i, tt := range tokens {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("TestComment:type[%d] - expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("TestComment:literal[%d] - expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("TestComment:pos[%d] - expected=%d, got=%d", i, tt.expectedPos, tok.Pos)
+		}
+	}
+
+	lexer = New(`1 + (: (: :) 2`)
+	lexer.NextToken()
+	lexer.NextToken()
+
+	tok := lexer.NextToken()
+	if tok.Type != token.ILLEGAL || tok.Pos != 4 {
+		t.Fatalf("TestComment:unterminated - expected=ILLEGAL at 4, got=%q at %d", tok.Type, tok.Pos)
+	}
+}
+
+func TestXPath40(t *testing.T) {
+	input := "`Hello {$a || `x{1}`}!` otherwise $b =!> f()"
+
+	tokens := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.TEMPLATE, "Hello {$a || `x{1}`}!"},
+		{token.OTHERWISE, "otherwise"},
+		{token.DOLLAR, "$"},
+		{token.IDENT, "b"},
+		{token.MARROW, "=!>"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.RPAREN, ")"},
+		{token.EOF, ""},
+	}
+
+	lexer := NewVersion(input, XPath40)
+
+	for i, tt := range tokens {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("TestXPath40:type[%d] - expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("TestXPath40:literal[%d] - expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	lexer = NewVersion("`a{'}'", XPath40)
+	if tok := lexer.NextToken(); tok.Type != token.ILLEGAL {
+		t.Fatalf("TestXPath40:unterminated - expected=ILLEGAL, got=%q", tok.Type)
+	}
+
+	// the XPath 4.0 tokens are not recognized in XPath 3.1
+	lexer = New("otherwise =!> `")
+	for i, expected := range []token.Type{token.IDENT, token.EQ, token.BANG, token.GT, token.ILLEGAL} {
+		if tok := lexer.NextToken(); tok.Type != expected {
+			t.Fatalf("TestXPath40:xpath31[%d] - expected=%q, got=%q", i, expected, tok.Type)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	tests := []struct {
+		input          string
+		pos            int
+		expectedLine   int
+		expectedColumn int
+	}{
+		{"1 + 2", 0, 1, 1},
+		{"1 + 2", 4, 1, 5},
+		{"1 +\n  2", 6, 2, 3},
+		{"'日本' || $a", 12, 1, 9},
+		{"1 +\n", 4, 2, 1},
+		{"1", 5, 1, 2},
+	}
+
+	for i, tt := range tests {
+		line, column := Position(tt.input, tt.pos)
+		if line != tt.expectedLine || column != tt.expectedColumn {
+			t.Errorf("TestPosition[%d] - expected=%d:%d, got=%d:%d", i, tt.expectedLine, tt.expectedColumn, line, column)
+		}
+	}
+
+	// the tokens of an input embedded in another input have the positions in the enclosing input
+	lexer := NewAt("$a}", XPath40, 10)
+	for i, expected := range []int{10, 11, 12} {
+		if tok := lexer.NextToken(); tok.Pos != expected {
+			t.Errorf("TestPosition:offset[%d] - expected=%d, got=%d", i, expected, tok.Pos)
+		}
+	}
+}
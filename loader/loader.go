@@ -0,0 +1,291 @@
+// Package loader provides the document loaders of fn:doc, fn:doc-available, fn:json-doc and XPath.SetDoc.
+//
+// A loader is set with XPath.SetLoader or Options.Loader, the default loader reads the uris
+// with the http or https scheme over http and the other uris from the local filesystem.
+// A Map loader lets tests run without the filesystem and the network.
+package loader
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+// ErrNotFound is returned when a document does not exist
+var ErrNotFound = errors.New("document not found")
+
+// ErrTooLarge is returned when a document exceeds the size limit of the loader
+var ErrTooLarge = errors.New("document too large")
+
+// ContentTyper is implemented by the documents that have a media type, such as the documents loaded by HTTP
+// The charset parameter of the media type is used to decode the document
+type ContentTyper interface {
+	ContentType() string
+}
+
+// typedBody is a document that has a media type
+type typedBody struct {
+	io.ReadCloser
+	contentType string
+}
+
+func (b *typedBody) ContentType() string {
+	return b.contentType
+}
+
+// ContentType returns the media type of the document, it is empty if the document has none
+func ContentType(r io.Reader) string {
+	if ct, ok := r.(ContentTyper); ok {
+		return ct.ContentType()
+	}
+	return ""
+}
+
+// DefaultTimeout is the timeout of the http client of an HTTP loader that has no client
+const DefaultTimeout = 30 * time.Second
+
+// New returns the default loader
+// The uris with the http or https scheme are loaded by an HTTP loader that has no size limit,
+// the other uris are paths of the local filesystem relative to the working directory
+func New() object.DocumentLoader {
+	return &Scheme{File: &File{}, HTTP: &HTTP{}}
+}
+
+// Scheme loads the uris with the http or https scheme with HTTP and the other uris with File
+// A nil loader fails to load the uris of its scheme
+type Scheme struct {
+	File object.DocumentLoader
+	HTTP object.DocumentLoader
+}
+
+// Load implements object.DocumentLoader
+func (l *Scheme) Load(ctx context.Context, uri string) (io.ReadCloser, error) {
+	next := l.File
+	if IsHTTP(uri) {
+		next = l.HTTP
+	}
+	if next == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, uri)
+	}
+	return next.Load(ctx, uri)
+}
+
+// IsHTTP reports whether the uri has the http or https scheme
+func IsHTTP(uri string) bool {
+	u, err := url.Parse(uri)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// File loads documents from the local filesystem
+// The uri is a slash separated path, a file uri such as file:///path/to/doc.html is accepted too
+// If Root is set, the path is resolved in Root and cannot escape it with .. or an absolute path,
+// otherwise it is used as it is
+type File struct {
+	Root string
+}
+
+// Load implements object.DocumentLoader
+func (l *File) Load(ctx context.Context, uri string) (io.ReadCloser, error) {
+	name := uri
+	if strings.HasPrefix(uri, "file://") {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		name = u.Path
+	}
+	if l.Root != "" {
+		name = filepath.Join(l.Root, filepath.FromSlash(path.Clean("/"+name)))
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, uri)
+		}
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s is a directory", ErrNotFound, uri)
+	}
+	return f, nil
+}
+
+// HTTP loads documents with http GET requests
+// Client is the http client, a client with DefaultTimeout is used if it is nil
+// Header is added to each request, a User-Agent or an Authorization header for example
+// MaxSize is the maximum size of a document in bytes, a zero value means no limit
+type HTTP struct {
+	Client  *http.Client
+	Header  http.Header
+	MaxSize int64
+}
+
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Load implements object.DocumentLoader
+func (l *HTTP) Load(ctx context.Context, uri string) (io.ReadCloser, error) {
+	if !IsHTTP(uri) {
+		return nil, fmt.Errorf("%w: %s is not a http uri", ErrNotFound, uri)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range l.Header {
+		req.Header[k] = v
+	}
+
+	client := l.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, uri)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		resp.Body.Close()
+		return nil, fmt.Errorf("cannot load %s: %s", uri, resp.Status)
+	case l.MaxSize > 0 && resp.ContentLength > l.MaxSize:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s has %d bytes", ErrTooLarge, uri, resp.ContentLength)
+	}
+
+	var body io.ReadCloser = resp.Body
+	if l.MaxSize > 0 {
+		body = &limitedBody{r: resp.Body, n: l.MaxSize, uri: uri}
+	}
+	return &typedBody{ReadCloser: body, contentType: resp.Header.Get("Content-Type")}, nil
+}
+
+// limitedBody fails with ErrTooLarge when more than n bytes are read
+type limitedBody struct {
+	r   io.ReadCloser
+	n   int64
+	uri string
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n < 0 {
+		return 0, fmt.Errorf("%w: %s", ErrTooLarge, b.uri)
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return n, fmt.Errorf("%w: %s", ErrTooLarge, b.uri)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.r.Close()
+}
+
+// Map loads documents from memory, it maps the uris to the contents of the documents
+//
+//	loader.Map{"https://example.com/": "<title>Example</title>"}
+type Map map[string]string
+
+// Load implements object.DocumentLoader
+func (l Map) Load(ctx context.Context, uri string) (io.ReadCloser, error) {
+	s, ok := l[uri]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, uri)
+	}
+	return io.NopCloser(strings.NewReader(s)), nil
+}
+
+// Cache keeps the recently loaded documents of another loader in memory
+// The least recently used document is evicted when the cache has more than size documents,
+// the failed loads are not cached
+// It is safe to use from many goroutines at the same time
+type Cache struct {
+	next  object.DocumentLoader
+	size  int
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	uri         string
+	data        []byte
+	contentType string
+}
+
+// NewCache creates a cache of at most size documents loaded by next
+func NewCache(next object.DocumentLoader, size int) *Cache {
+	return &Cache{next: next, size: size, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Load implements object.DocumentLoader
+func (c *Cache) Load(ctx context.Context, uri string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	if e, ok := c.items[uri]; ok {
+		c.lru.MoveToFront(e)
+		entry := e.Value.(*cacheEntry)
+		c.mu.Unlock()
+		return entry.open(), nil
+	}
+	c.mu.Unlock()
+
+	r, err := c.next.Load(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{uri: uri, data: data, contentType: ContentType(r)}
+
+	c.mu.Lock()
+	if _, ok := c.items[uri]; !ok && c.size > 0 {
+		c.items[uri] = c.lru.PushFront(entry)
+		for c.lru.Len() > c.size {
+			e := c.lru.Back()
+			c.lru.Remove(e)
+			delete(c.items, e.Value.(*cacheEntry).uri)
+		}
+	}
+	c.mu.Unlock()
+
+	return entry.open(), nil
+}
+
+// open returns a reader of the cached document that keeps its media type
+func (e *cacheEntry) open() io.ReadCloser {
+	return &typedBody{ReadCloser: io.NopCloser(bytes.NewReader(e.data)), contentType: e.contentType}
+}
+
+// Len returns the number of the cached documents
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
@@ -0,0 +1,157 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zzossig/rabbit/object"
+)
+
+func load(l object.DocumentLoader, uri string) (string, error) {
+	r, err := l.Load(context.Background(), uri)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.html"), []byte("<p>a</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &File{Root: filepath.Join(dir, "sub")}
+	tests := []struct {
+		uri string
+		err error
+	}{
+		{"../a.html", ErrNotFound},
+		{"/../../a.html", ErrNotFound},
+		{"", ErrNotFound},
+		{"missing.html", ErrNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := load(l, tt.uri); !errors.Is(err, tt.err) {
+			t.Errorf("wrong error for %q. got=%v, expected=%v", tt.uri, err, tt.err)
+		}
+	}
+
+	l = &File{Root: dir}
+	for _, uri := range []string{"a.html", "/a.html", "sub/../a.html", "file:///a.html"} {
+		if s, err := load(l, uri); err != nil || s != "<p>a</p>" {
+			t.Errorf("wrong content for %q. got=%q, err=%v", uri, s, err)
+		}
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth":
+			fmt.Fprint(w, r.Header.Get("Authorization"))
+		case "/large":
+			w.Header().Set("Content-Length", "100")
+			fmt.Fprint(w, string(make([]byte, 100)))
+		case "/stream":
+			w.(http.Flusher).Flush()
+			fmt.Fprint(w, string(make([]byte, 100)))
+		case "/error":
+			http.Error(w, "error", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	l := &HTTP{Client: server.Client(), Header: http.Header{"Authorization": {"token"}}, MaxSize: 10}
+	if s, err := load(l, server.URL+"/auth"); err != nil || s != "token" {
+		t.Errorf("wrong content. got=%q, err=%v", s, err)
+	}
+
+	tests := []struct {
+		uri string
+		err error
+	}{
+		{server.URL + "/large", ErrTooLarge},
+		{server.URL + "/stream", ErrTooLarge},
+		{server.URL + "/missing", ErrNotFound},
+		{"./a.html", ErrNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := load(l, tt.uri); !errors.Is(err, tt.err) {
+			t.Errorf("wrong error for %s. got=%v, expected=%v", tt.uri, err, tt.err)
+		}
+	}
+	if _, err := load(l, server.URL+"/error"); err == nil {
+		t.Errorf("expected an error for the status 500")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.Load(ctx, server.URL+"/auth"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the canceled error. got=%v", err)
+	}
+}
+
+func TestScheme(t *testing.T) {
+	l := &Scheme{File: Map{"a.html": "file"}, HTTP: Map{"https://example.com/": "http"}}
+	if s, err := load(l, "a.html"); err != nil || s != "file" {
+		t.Errorf("wrong content. got=%q, err=%v", s, err)
+	}
+	if s, err := load(l, "https://example.com/"); err != nil || s != "http" {
+		t.Errorf("wrong content. got=%q, err=%v", s, err)
+	}
+
+	l = &Scheme{File: Map{}}
+	if _, err := load(l, "https://example.com/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the not found error. got=%v", err)
+	}
+}
+
+type countLoader struct {
+	Map
+	count int
+}
+
+func (l *countLoader) Load(ctx context.Context, uri string) (io.ReadCloser, error) {
+	l.count++
+	return l.Map.Load(ctx, uri)
+}
+
+func TestCache(t *testing.T) {
+	next := &countLoader{Map: Map{"a": "a", "b": "b", "c": "c"}}
+	c := NewCache(next, 2)
+
+	for _, uri := range []string{"a", "b", "a", "c", "a", "b"} {
+		if s, err := load(c, uri); err != nil || s != uri {
+			t.Errorf("wrong content for %s. got=%q, err=%v", uri, s, err)
+		}
+	}
+	// b is evicted when c is loaded, then c is evicted when b is loaded again
+	if next.count != 4 {
+		t.Errorf("wrong number of loads. got=%d, expected=4", next.count)
+	}
+	if c.Len() != 2 {
+		t.Errorf("wrong number of cached documents. got=%d, expected=2", c.Len())
+	}
+
+	if _, err := load(c, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the not found error. got=%v", err)
+	}
+	if c.Len() != 2 {
+		t.Errorf("a failed load is cached")
+	}
+}
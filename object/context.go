@@ -1,9 +1,19 @@
 package object
 
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
 // Context contains items that is used in Eval or built-in functions
 // store field stores Varref as a key, Item as as value
 // In example expression, let $a := 1 return $a, 'a' is a key and 1 is a value
 // outer is a context to make inner scope of function.
+// Guard is shared by the enclosed contexts to stop an evaluation that is canceled or exceeds the limits
+// Index is the index of the document, it is used only for the nodes of the indexed tree
 // In example expression, let $a := 1 return function($a) {$a}
 // returned function makes new context to keep the varref $a
 type Context struct {
@@ -12,8 +22,11 @@ type Context struct {
 	Doc   Node
 	CNode []Node
 	CItem Item
+	Guard *Guard
+	Index *Index
 	Focus
 	Static
+	Dynamic
 }
 
 // Focus contains context size, context position, context axis
@@ -27,8 +40,59 @@ type Focus struct {
 }
 
 // Static contains information that is available during static analysis of the expression, prior to its evaluation
+// DecimalFormats is keyed by the decimal format name, the default decimal format has an empty name
+// Functions is keyed by the prefixed function name such as my:slugify, they are looked up before the built-in functions
+// Arities lists the numbers of arguments that the Functions accept, -1 means any greater number
+// Namespaces maps the prefixes bound by the user to namespace uris, they are used in name tests and function names
 type Static struct {
-	BaseURI string
+	BaseURI        string
+	DecimalFormats map[string]*DecimalFormat
+	Functions      map[string]Func
+	Arities        map[string][]int
+	Namespaces     map[string]string
+}
+
+// DecimalFormat contains the properties that control fn:format-number
+// https://www.w3.org/TR/xpath-31/#dt-decimal-format
+type DecimalFormat struct {
+	DecimalSeparator  rune
+	GroupingSeparator rune
+	ExponentSeparator rune
+	Percent           rune
+	PerMille          rune
+	ZeroDigit         rune
+	Digit             rune
+	PatternSeparator  rune
+	MinusSign         rune
+	Infinity          string
+	NaN               string
+}
+
+// Dynamic contains information that is available at the time the expression is evaluated
+// Now is fixed on the first use so that fn:current-dateTime() is stable during an evaluation
+// Trace receives the label and the serialized value of fn:trace, the trace is written to the standard error if it is nil
+// Loader loads the documents of fn:doc, fn:doc-available and fn:json-doc, the default loader is used if it is nil
+// Encoding is the label of the character encoding of the loaded documents such as shift_jis, it is detected if empty
+// XML parses the loaded documents with the xml parser instead of the html parser
+// docs maps the uris to the loaded documents, so fn:doc returns the same node for the same uri
+// encodings maps the root nodes of the loaded documents to the names of their character encodings
+// ranks maps the root nodes of the documents to their ranks, they order the nodes in different documents
+type Dynamic struct {
+	Now       time.Time
+	Trace     func(label, value string)
+	Loader    DocumentLoader
+	Encoding  string
+	XML       bool
+	docs      map[string]Node
+	encodings map[*html.Node]string
+	ranks     map[*html.Node]int
+}
+
+// DocumentLoader loads a document by its uri
+// Load returns the content of the document, which is closed by the caller
+// ctx is done when the evaluation is canceled
+type DocumentLoader interface {
+	Load(ctx context.Context, uri string) (io.ReadCloser, error)
 }
 
 // NewContext creates a new context
@@ -44,13 +108,44 @@ func NewEnclosedContext(outer *Context) *Context {
 	ctx.Doc = outer.Doc
 	ctx.CNode = outer.CNode
 	ctx.CItem = outer.CItem
+	ctx.Guard = outer.Guard
+	ctx.Index = outer.Index
 	ctx.CSize = outer.CSize
 	ctx.CAxis = outer.CAxis
 	ctx.CPos = outer.CPos
-	ctx.BaseURI = outer.BaseURI
+	ctx.Static = outer.Static
 	return ctx
 }
 
+// NewDecimalFormat creates a decimal format that has the default property values
+func NewDecimalFormat() *DecimalFormat {
+	return &DecimalFormat{
+		DecimalSeparator:  '.',
+		GroupingSeparator: ',',
+		ExponentSeparator: 'e',
+		Percent:           '%',
+		PerMille:          '‰',
+		ZeroDigit:         '0',
+		Digit:             '#',
+		PatternSeparator:  ';',
+		MinusSign:         '-',
+		Infinity:          "Infinity",
+		NaN:               "NaN",
+	}
+}
+
+// DecimalFormat returns the decimal format with the given name
+// an empty name refers to the default decimal format
+func (c *Context) DecimalFormat(name string) (*DecimalFormat, bool) {
+	if df, ok := c.DecimalFormats[name]; ok {
+		return df, true
+	}
+	if name == "" {
+		return NewDecimalFormat(), true
+	}
+	return nil, false
+}
+
 // Get retrieve items from the store field or the outer field.
 func (c *Context) Get(name string) (Item, bool) {
 	item, ok := c.store[name]
@@ -60,6 +155,128 @@ func (c *Context) Get(name string) (Item, bool) {
 	return item, ok
 }
 
+// CurrentDateTime returns the current date and time of the outermost context
+func (c *Context) CurrentDateTime() time.Time {
+	if c.outer != nil {
+		return c.outer.CurrentDateTime()
+	}
+	if c.Now.IsZero() {
+		c.Now = time.Now()
+	}
+	return c.Now
+}
+
+// TraceFunc returns the trace function of the outermost context
+func (c *Context) TraceFunc() func(label, value string) {
+	if c.outer != nil {
+		return c.outer.TraceFunc()
+	}
+	return c.Trace
+}
+
+// DocumentLoader returns the document loader of the outermost context
+func (c *Context) DocumentLoader() DocumentLoader {
+	if c.outer != nil {
+		return c.outer.DocumentLoader()
+	}
+	return c.Loader
+}
+
+// Document returns the document loaded from the uri in the outermost context
+func (c *Context) Document(uri string) (Node, bool) {
+	if c.outer != nil {
+		return c.outer.Document(uri)
+	}
+	n, ok := c.docs[uri]
+	return n, ok
+}
+
+// AddDocument saves the document loaded from the uri in the outermost context
+func (c *Context) AddDocument(uri string, n Node) {
+	if c.outer != nil {
+		c.outer.AddDocument(uri, n)
+		return
+	}
+	if c.docs == nil {
+		c.docs = make(map[string]Node)
+	}
+	c.docs[uri] = n
+	c.DocumentRank(n)
+}
+
+// DocumentRank returns the rank of the document of the node in the outermost context
+// The context document comes first, the other documents are ranked in the order they are loaded or first compared,
+// so the rank of a document does not change during an evaluation
+func (c *Context) DocumentRank(n Node) int {
+	if c.outer != nil {
+		return c.outer.DocumentRank(n)
+	}
+	if c.ranks == nil {
+		c.ranks = make(map[*html.Node]int)
+		if c.Doc != nil {
+			c.ranks[TreeRoot(c.Doc)] = 0
+		}
+	}
+	root := TreeRoot(n)
+	if r, ok := c.ranks[root]; ok {
+		return r
+	}
+	r := len(c.ranks)
+	c.ranks[root] = r
+	return r
+}
+
+// DocumentURI returns the uri that the document of the node is loaded from
+func (c *Context) DocumentURI(n Node) (string, bool) {
+	if c.outer != nil {
+		return c.outer.DocumentURI(n)
+	}
+	root := TreeRoot(n)
+	for uri, doc := range c.docs {
+		if doc.Tree() == root {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// EncodingLabel returns the character encoding label of the outermost context
+func (c *Context) EncodingLabel() string {
+	if c.outer != nil {
+		return c.outer.EncodingLabel()
+	}
+	return c.Encoding
+}
+
+// XMLMode reports whether the outermost context parses the loaded documents as xml
+func (c *Context) XMLMode() bool {
+	if c.outer != nil {
+		return c.outer.XMLMode()
+	}
+	return c.XML
+}
+
+// DocumentEncoding returns the name of the character encoding that the document of the node is decoded from
+// It is empty if the document is not loaded by the context
+func (c *Context) DocumentEncoding(n Node) string {
+	if c.outer != nil {
+		return c.outer.DocumentEncoding(n)
+	}
+	return c.encodings[TreeRoot(n)]
+}
+
+// SetDocumentEncoding saves the name of the character encoding of the document whose root is root
+func (c *Context) SetDocumentEncoding(root *html.Node, name string) {
+	if c.outer != nil {
+		c.outer.SetDocumentEncoding(root, name)
+		return
+	}
+	if c.encodings == nil {
+		c.encodings = make(map[*html.Node]string)
+	}
+	c.encodings[root] = name
+}
+
 // Set save item in the current context
 func (c *Context) Set(name string, val Item) Item {
 	c.store[name] = val
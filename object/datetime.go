@@ -0,0 +1,270 @@
+package object
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateTimeItem is implemented by xs:dateTime, xs:date and xs:time
+type DateTimeItem interface {
+	Item
+	Value() time.Time
+	HasTZ() bool
+}
+
+// DurationItem is implemented by xs:duration, xs:dayTimeDuration and xs:yearMonthDuration
+type DurationItem interface {
+	Item
+	Months() int
+	Duration() time.Duration
+}
+
+// DateTime is an item that is represents xs:dateTime data-type
+// tz field is false if the value has no timezone component
+type DateTime struct {
+	value time.Time
+	tz    bool
+}
+
+// Type ::= DateTimeType
+func (dt *DateTime) Type() Type { return DateTimeType }
+
+// Inspect ::= YYYY-MM-DDThh:mm:ss(.s+)?(zzzzzz)?
+func (dt *DateTime) Inspect() string {
+	return dt.value.Format("2006-01-02T15:04:05.999999999") + formatTZ(dt.value, dt.tz)
+}
+
+// SetValue is setter for the DateTime
+func (dt *DateTime) SetValue(v time.Time, tz bool) {
+	dt.value = v
+	dt.tz = tz
+}
+
+// Value is getter for the DateTime
+func (dt *DateTime) Value() time.Time { return dt.value }
+
+// HasTZ returns true if the DateTime has a timezone component
+func (dt *DateTime) HasTZ() bool { return dt.tz }
+
+// HashKey used as a map key
+func (dt *DateTime) HashKey() HashKey {
+	return HashKey{Type: dt.Type(), Value: uint64(Instant(dt.value, dt.tz).UnixNano())}
+}
+
+// Date is an item that is represents xs:date data-type
+// the time part of the value is always midnight
+type Date struct {
+	value time.Time
+	tz    bool
+}
+
+// Type ::= DateType
+func (d *Date) Type() Type { return DateType }
+
+// Inspect ::= YYYY-MM-DD(zzzzzz)?
+func (d *Date) Inspect() string {
+	return d.value.Format("2006-01-02") + formatTZ(d.value, d.tz)
+}
+
+// SetValue is setter for the Date
+func (d *Date) SetValue(v time.Time, tz bool) {
+	d.value = time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, v.Location())
+	d.tz = tz
+}
+
+// Value is getter for the Date
+func (d *Date) Value() time.Time { return d.value }
+
+// HasTZ returns true if the Date has a timezone component
+func (d *Date) HasTZ() bool { return d.tz }
+
+// HashKey used as a map key
+func (d *Date) HashKey() HashKey {
+	return HashKey{Type: d.Type(), Value: uint64(Instant(d.value, d.tz).UnixNano())}
+}
+
+// Time is an item that is represents xs:time data-type
+// the date part of the value is always the reference date 1972-12-31
+type Time struct {
+	value time.Time
+	tz    bool
+}
+
+// Type ::= TimeType
+func (t *Time) Type() Type { return TimeType }
+
+// Inspect ::= hh:mm:ss(.s+)?(zzzzzz)?
+func (t *Time) Inspect() string {
+	return t.value.Format("15:04:05.999999999") + formatTZ(t.value, t.tz)
+}
+
+// SetValue is setter for the Time
+func (t *Time) SetValue(v time.Time, tz bool) {
+	t.value = time.Date(1972, 12, 31, v.Hour(), v.Minute(), v.Second(), v.Nanosecond(), v.Location())
+	t.tz = tz
+}
+
+// Value is getter for the Time
+func (t *Time) Value() time.Time { return t.value }
+
+// HasTZ returns true if the Time has a timezone component
+func (t *Time) HasTZ() bool { return t.tz }
+
+// HashKey used as a map key
+func (t *Time) HashKey() HashKey {
+	return HashKey{Type: t.Type(), Value: uint64(Instant(t.value, t.tz).UnixNano())}
+}
+
+// Duration is an item that is represents xs:duration data-type
+// months field holds the year and month components, dur field holds the rest
+type Duration struct {
+	months int
+	dur    time.Duration
+}
+
+// Type ::= DurationType
+func (d *Duration) Type() Type { return DurationType }
+
+// Inspect ::= -?PnYnMnDTnHnMnS
+func (d *Duration) Inspect() string { return formatDuration(d.months, d.dur, "PT0S") }
+
+// SetValue is setter for the Duration
+func (d *Duration) SetValue(months int, dur time.Duration) {
+	d.months = months
+	d.dur = dur
+}
+
+// Months is getter for the year and month components
+func (d *Duration) Months() int { return d.months }
+
+// Duration is getter for the day and time components
+func (d *Duration) Duration() time.Duration { return d.dur }
+
+// HashKey used as a map key
+func (d *Duration) HashKey() HashKey {
+	return HashKey{Type: d.Type(), Value: uint64(d.months)<<48 ^ uint64(d.dur)}
+}
+
+// DayTimeDuration is an item that is represents xs:dayTimeDuration data-type
+type DayTimeDuration struct {
+	dur time.Duration
+}
+
+// Type ::= DayTimeDurationType
+func (d *DayTimeDuration) Type() Type { return DayTimeDurationType }
+
+// Inspect ::= -?PnDTnHnMnS
+func (d *DayTimeDuration) Inspect() string { return formatDuration(0, d.dur, "PT0S") }
+
+// SetValue is setter for the DayTimeDuration
+func (d *DayTimeDuration) SetValue(dur time.Duration) { d.dur = dur }
+
+// Months always returns 0
+func (d *DayTimeDuration) Months() int { return 0 }
+
+// Duration is getter for the DayTimeDuration
+func (d *DayTimeDuration) Duration() time.Duration { return d.dur }
+
+// HashKey used as a map key
+func (d *DayTimeDuration) HashKey() HashKey {
+	return HashKey{Type: DurationType, Value: uint64(d.dur)}
+}
+
+// YearMonthDuration is an item that is represents xs:yearMonthDuration data-type
+type YearMonthDuration struct {
+	months int
+}
+
+// Type ::= YearMonthDurationType
+func (d *YearMonthDuration) Type() Type { return YearMonthDurationType }
+
+// Inspect ::= -?PnYnM
+func (d *YearMonthDuration) Inspect() string { return formatDuration(d.months, 0, "P0M") }
+
+// SetValue is setter for the YearMonthDuration
+func (d *YearMonthDuration) SetValue(months int) { d.months = months }
+
+// Months is getter for the YearMonthDuration
+func (d *YearMonthDuration) Months() int { return d.months }
+
+// Duration always returns 0
+func (d *YearMonthDuration) Duration() time.Duration { return 0 }
+
+// HashKey used as a map key
+func (d *YearMonthDuration) HashKey() HashKey {
+	return HashKey{Type: DurationType, Value: uint64(d.months) << 48}
+}
+
+// Instant returns the point on the time line for a date/time value.
+// A value without timezone is taken to be in the implicit timezone which is the local timezone.
+func Instant(t time.Time, tz bool) time.Time {
+	if tz {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+func formatTZ(t time.Time, tz bool) string {
+	if !tz {
+		return ""
+	}
+
+	_, offset := t.Zone()
+	if offset == 0 {
+		return "Z"
+	}
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+func formatDuration(months int, dur time.Duration, zero string) string {
+	if months == 0 && dur == 0 {
+		return zero
+	}
+
+	var sb strings.Builder
+	if months < 0 || dur < 0 {
+		sb.WriteString("-")
+		months = -months
+		dur = -dur
+	}
+	sb.WriteString("P")
+
+	if y := months / 12; y > 0 {
+		fmt.Fprintf(&sb, "%dY", y)
+	}
+	if m := months % 12; m > 0 {
+		fmt.Fprintf(&sb, "%dM", m)
+	}
+
+	day := 24 * time.Hour
+	if d := dur / day; d > 0 {
+		fmt.Fprintf(&sb, "%dD", d)
+	}
+
+	dur %= day
+	if dur == 0 {
+		return sb.String()
+	}
+
+	sb.WriteString("T")
+	if h := dur / time.Hour; h > 0 {
+		fmt.Fprintf(&sb, "%dH", h)
+	}
+	if m := dur % time.Hour / time.Minute; m > 0 {
+		fmt.Fprintf(&sb, "%dM", m)
+	}
+	if s := dur % time.Minute; s > 0 {
+		sb.WriteString(strconv.FormatFloat(s.Seconds(), 'f', -1, 64))
+		sb.WriteString("S")
+	}
+
+	return sb.String()
+}
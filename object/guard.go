@@ -0,0 +1,148 @@
+package object
+
+import (
+	"context"
+	"fmt"
+)
+
+// The codes of the errors that stop an evaluation
+// they are raised by a Guard and cannot be caught by try/catch
+const (
+	CodeCanceled          = "rabbit:canceled"
+	CodeMaxNodes          = "rabbit:max-nodes"
+	CodeMaxSequenceLength = "rabbit:max-sequence-length"
+	CodeMaxDepth          = "rabbit:max-depth"
+	CodeMaxStringLength   = "rabbit:max-string-length"
+)
+
+// checkInterval is the number of visited nodes between the checks of the cancellation
+const checkInterval = 256
+
+// Limits contains the resource limits of an evaluation, a zero value means no limit
+// MaxNodes is the number of nodes that the axis steps can visit
+// MaxSequenceLength is the number of items in a sequence
+// MaxDepth is the depth of the nested function calls
+// MaxStringLength is the length of a string in bytes
+type Limits struct {
+	MaxNodes          int
+	MaxSequenceLength int
+	MaxDepth          int
+	MaxStringLength   int
+}
+
+// Guard stops an evaluation that is canceled or exceeds the limits
+// It is shared by the enclosed contexts, so the counters apply to the whole evaluation
+// The methods can be called on a nil Guard, which never stops an evaluation
+type Guard struct {
+	ctx    context.Context
+	limits Limits
+	nodes  int
+	depth  int
+}
+
+// NewGuard creates a guard that stops the evaluation when ctx is done or the limits are exceeded, ctx can be nil
+func NewGuard(ctx context.Context, limits Limits) *Guard {
+	return &Guard{ctx: ctx, limits: limits}
+}
+
+// Check returns an error if the evaluation is canceled
+func (g *Guard) Check() *Error {
+	if g == nil || g.ctx == nil {
+		return nil
+	}
+	select {
+	case <-g.ctx.Done():
+		err := g.ctx.Err()
+		return &Error{Code: CodeCanceled, Message: "evaluation is canceled: " + err.Error(), Err: err, stop: true}
+	default:
+		return nil
+	}
+}
+
+// Poll returns an error if the evaluation is canceled, the cancellation is checked once every checkInterval iterations
+// It is used by the loops that do not visit nodes such as a range expression, i is the count of the iterations
+func (g *Guard) Poll(i int) *Error {
+	if i%checkInterval != 0 {
+		return nil
+	}
+	return g.Check()
+}
+
+// Context returns the context that cancels the evaluation, it returns context.Background() if there is none
+func (g *Guard) Context() context.Context {
+	if g == nil || g.ctx == nil {
+		return context.Background()
+	}
+	return g.ctx
+}
+
+// Visit counts a node visited by an axis step
+func (g *Guard) Visit() *Error {
+	if g == nil {
+		return nil
+	}
+	g.nodes++
+	if g.limits.MaxNodes > 0 && g.nodes > g.limits.MaxNodes {
+		return limitError(CodeMaxNodes, "the number of visited nodes exceeds %d", g.limits.MaxNodes)
+	}
+	if g.nodes%checkInterval == 0 {
+		return g.Check()
+	}
+	return nil
+}
+
+// Enter counts a function call, each call must be paired with Leave
+func (g *Guard) Enter() *Error {
+	if g == nil {
+		return nil
+	}
+	g.depth++
+	if g.limits.MaxDepth > 0 && g.depth > g.limits.MaxDepth {
+		return limitError(CodeMaxDepth, "the depth of the function calls exceeds %d", g.limits.MaxDepth)
+	}
+	return g.Check()
+}
+
+// Leave counts the return of a function call
+func (g *Guard) Leave() {
+	if g != nil {
+		g.depth--
+	}
+}
+
+// CheckLength returns an error if a sequence of n items exceeds the limit
+func (g *Guard) CheckLength(n int) *Error {
+	if g == nil {
+		return nil
+	}
+	if g.limits.MaxSequenceLength > 0 && n > g.limits.MaxSequenceLength {
+		return limitError(CodeMaxSequenceLength, "the length of the sequence exceeds %d", g.limits.MaxSequenceLength)
+	}
+	return nil
+}
+
+// CheckItem returns an error if the item is a sequence or a string that exceeds the limits
+func (g *Guard) CheckItem(item Item) *Error {
+	if g == nil {
+		return nil
+	}
+	switch item := item.(type) {
+	case *Sequence:
+		return g.CheckLength(len(item.Items))
+	case *String:
+		if g.limits.MaxStringLength > 0 && len(item.value) > g.limits.MaxStringLength {
+			return limitError(CodeMaxStringLength, "the length of the string exceeds %d", g.limits.MaxStringLength)
+		}
+	}
+	return nil
+}
+
+// Stops reports whether the error stops the evaluation
+// such errors are raised by a Guard and cannot be caught by try/catch
+func (e *Error) Stops() bool {
+	return e.stop
+}
+
+func limitError(code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...), stop: true}
+}
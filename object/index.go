@@ -0,0 +1,251 @@
+package object
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// The estimated sizes in bytes of the parts of an index
+const (
+	mapEntrySize   = 48
+	sliceEntrySize = 8
+	sliceSize      = 24
+)
+
+// Index contains the lookup tables of a document, it is built once and is read only after that
+// so it can be shared by the evaluations that run at the same time
+// It must be built again if the document is modified
+// nodes is the nodes in document order and order maps a node to its position in nodes
+// ends is the position of the last descendant of each node, so the descendants of nodes[i] are nodes[i+1:ends[i]+1]
+// names maps an element name to the positions of the elements in document order
+// ids maps an id to the first element that has the id, classes maps a class token to the elements that have the token
+type Index struct {
+	root    *html.Node
+	nodes   []*html.Node
+	order   map[*html.Node]int
+	ends    []int
+	names   map[string][]int
+	ids     map[string]*html.Node
+	classes map[string][]*html.Node
+}
+
+// IndexStats reports the size of an index
+// Bytes is an estimate of the memory used by the index
+type IndexStats struct {
+	Nodes   int
+	Names   int
+	IDs     int
+	Classes int
+	Bytes   int
+}
+
+// NewIndex builds the index of the tree that doc belongs to
+func NewIndex(doc Node) *Index {
+	root := TreeRoot(doc)
+
+	idx := &Index{
+		root:    root,
+		order:   make(map[*html.Node]int),
+		names:   make(map[string][]int),
+		ids:     make(map[string]*html.Node),
+		classes: make(map[string][]*html.Node),
+	}
+	idx.add(root)
+	return idx
+}
+
+// TreeRoot returns the root of the tree that n belongs to
+func TreeRoot(n Node) *html.Node {
+	root := n.Tree()
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return root
+}
+
+func (idx *Index) add(n *html.Node) {
+	i := len(idx.nodes)
+	idx.nodes = append(idx.nodes, n)
+	idx.ends = append(idx.ends, i)
+	idx.order[n] = i
+
+	if n.Type == html.ElementNode {
+		idx.names[n.Data] = append(idx.names[n.Data], i)
+		for _, a := range n.Attr {
+			if a.Namespace != "" {
+				continue
+			}
+			switch a.Key {
+			case "id":
+				if _, ok := idx.ids[a.Val]; !ok && a.Val != "" {
+					idx.ids[a.Val] = n
+				}
+			case "class":
+				for _, t := range strings.Fields(a.Val) {
+					if c := idx.classes[t]; len(c) == 0 || c[len(c)-1] != n {
+						idx.classes[t] = append(c, n)
+					}
+				}
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		idx.add(c)
+	}
+	idx.ends[i] = len(idx.nodes) - 1
+}
+
+// Root returns the root node of the indexed tree
+func (idx *Index) Root() Node {
+	return &BaseNode{idx.root}
+}
+
+// Contains reports whether n is a node of the indexed tree
+func (idx *Index) Contains(n Node) bool {
+	if idx == nil || n == nil {
+		return false
+	}
+	_, ok := idx.order[n.Tree()]
+	return ok
+}
+
+// Compare returns -1 if a precedes b in document order, 1 if a follows b and 0 if they are the same node
+// An attribute or a namespace node follows its element and precedes the children of the element
+// ok is false if a or b is not a node of the indexed tree
+func (idx *Index) Compare(a, b Node) (int, bool) {
+	if idx == nil || a == nil || b == nil {
+		return 0, false
+	}
+	ia, ok := idx.order[a.Tree()]
+	if !ok {
+		return 0, false
+	}
+	ib, ok := idx.order[b.Tree()]
+	if !ok {
+		return 0, false
+	}
+
+	if ia == ib {
+		ia, ib = compareAttrs(a, b), 0
+	}
+	switch {
+	case ia < ib:
+		return -1, true
+	case ia > ib:
+		return 1, true
+	}
+	return 0, true
+}
+
+// compareAttrs compares the nodes that belong to the same element
+// The namespace nodes follow the element in the order of their prefixes and precede the attributes
+func compareAttrs(a, b Node) int {
+	ka, kb := attrKind(a), attrKind(b)
+	switch {
+	case ka != kb:
+		return ka - kb
+	case ka == 1:
+		return strings.Compare(a.(*AttrNode).Key(), b.(*AttrNode).Key())
+	case ka == 2:
+		return attrPosition(a) - attrPosition(b)
+	}
+	return 0
+}
+
+// attrKind returns 0 for the element, 1 for a namespace node and 2 for an attribute
+func attrKind(n Node) int {
+	switch n.Type() {
+	case NamespaceNodeType:
+		return 1
+	case AttributeNodeType:
+		return 2
+	}
+	return 0
+}
+
+// attrPosition returns the position of the attribute in its element
+func attrPosition(n Node) int {
+	an := n.(*AttrNode)
+	for i, a := range an.parent.Attr {
+		if a.Key == an.attr.Key && a.Namespace == an.attr.Namespace {
+			return i
+		}
+	}
+	return len(an.parent.Attr)
+}
+
+// Elements returns the elements named name in document order
+func (idx *Index) Elements(name string) []Node {
+	return idx.wrap(idx.names[name])
+}
+
+// Descendants returns the descendant elements of n named name in document order
+// ok is false if n is not a node of the indexed tree
+func (idx *Index) Descendants(n Node, name string) ([]Node, bool) {
+	if idx == nil || n == nil {
+		return nil, false
+	}
+	i, ok := idx.order[n.Tree()]
+	if !ok {
+		return nil, false
+	}
+	if n.Type() == AttributeNodeType {
+		return nil, true
+	}
+
+	postings := idx.names[name]
+	from := sort.SearchInts(postings, i+1)
+	to := sort.SearchInts(postings, idx.ends[i]+1)
+	return idx.wrap(postings[from:to]), true
+}
+
+func (idx *Index) wrap(postings []int) []Node {
+	nodes := make([]Node, len(postings))
+	for i, p := range postings {
+		nodes[i] = &BaseNode{idx.nodes[p]}
+	}
+	return nodes
+}
+
+// ID returns the first element that has the id attribute, it returns nil if there is none
+func (idx *Index) ID(id string) Node {
+	if n, ok := idx.ids[id]; ok {
+		return &BaseNode{n}
+	}
+	return nil
+}
+
+// Class returns the elements whose class attribute contains the token in document order
+func (idx *Index) Class(token string) []Node {
+	elems := idx.classes[token]
+	nodes := make([]Node, len(elems))
+	for i, n := range elems {
+		nodes[i] = &BaseNode{n}
+	}
+	return nodes
+}
+
+// Stats returns the number of the entries of the index and an estimate of its memory use
+func (idx *Index) Stats() IndexStats {
+	s := IndexStats{
+		Nodes:   len(idx.nodes),
+		Names:   len(idx.names),
+		IDs:     len(idx.ids),
+		Classes: len(idx.classes),
+	}
+
+	s.Bytes = s.Nodes * (2*sliceEntrySize + mapEntrySize)
+	for name, postings := range idx.names {
+		s.Bytes += mapEntrySize + len(name) + sliceSize + len(postings)*sliceEntrySize
+	}
+	for id := range idx.ids {
+		s.Bytes += mapEntrySize + len(id)
+	}
+	for t, elems := range idx.classes {
+		s.Bytes += mapEntrySize + len(t) + sliceSize + len(elems)*sliceEntrySize
+	}
+	return s
+}
@@ -32,15 +32,30 @@ type Node interface {
 }
 
 // Error is an item that is represents error when doing evaluation
+// Code is an error code as a QName such as err:XPST0008, it is empty if the error has no code
+// Expr is the sub-expression that raised the error and Pos is its byte position in the input, both are set by eval.Eval
+// Value is the error object passed to fn:error, it is nil for the other errors
+// Err is the go error that caused the error such as context.Canceled, it is nil if there is none
 type Error struct {
+	Code    string
 	Message string
+	Expr    string
+	Pos     int
+	Value   Item
+	Err     error
+	stop    bool
 }
 
 // Type ::= ErrorType
 func (e *Error) Type() Type { return ErrorType }
 
 // Inspect ::= "Error: " + e.Message
-func (e *Error) Inspect() string { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Code != "" {
+		return "ERROR: " + e.Code + " " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
 
 // Placeholder is an item that is represents ?(question token) when doing evaluation
 type Placeholder struct{}
@@ -88,6 +103,7 @@ func (s *Sequence) Inspect() string {
 
 // Hasher implemented in atomic types: integer, decimal, double, boolean, string
 // So, atomic types are used as a Map key
+// Numeric types are promoted to xs:double so that 1 and 1.0 have the same key
 type Hasher interface {
 	HashKey() HashKey
 }
@@ -166,9 +182,11 @@ func (fn *FuncNamed) Inspect() string { return fmt.Sprintf("%s#%d", fn.Name.Valu
 
 // FuncInline ::= function() {}
 type FuncInline struct {
-	PL   *ast.ParamList
-	Body *ast.EnclosedExpr
-	Fn   Ev
+	PL    *ast.ParamList
+	ST    *ast.SequenceType // return type, the TypeID is 0 if it is not declared
+	Body  *ast.EnclosedExpr
+	Fn    Ev
+	Focus bool // focus function, the argument is the context item of the body
 	*Context
 }
 
@@ -210,7 +228,7 @@ func (i *Integer) Value() int { return i.value }
 
 // HashKey used as a map key
 func (i *Integer) HashKey() HashKey {
-	return HashKey{Type: i.Type(), Value: uint64(i.value)}
+	return numericHashKey(float64(i.value))
 }
 
 // Decimal is an item that is represents float64 data-type
@@ -233,7 +251,7 @@ func (d *Decimal) Value() float64 { return d.value }
 
 // HashKey used as a map key
 func (d *Decimal) HashKey() HashKey {
-	return HashKey{Type: d.Type(), Value: math.Float64bits(d.value)}
+	return numericHashKey(d.value)
 }
 
 // Double is an item that is represents float64 data-type
@@ -256,7 +274,18 @@ func (d *Double) Value() float64 { return d.value }
 
 // HashKey used as a map key
 func (d *Double) HashKey() HashKey {
-	return HashKey{Type: d.Type(), Value: math.Float64bits(d.value)}
+	return numericHashKey(d.value)
+}
+
+// numericHashKey makes -0 equal to 0 and all NaN values equal to each other
+func numericHashKey(v float64) HashKey {
+	switch {
+	case v == 0:
+		v = 0
+	case math.IsNaN(v):
+		v = math.NaN()
+	}
+	return HashKey{Type: DoubleType, Value: math.Float64bits(v)}
 }
 
 // Boolean is an item that is represents bool data-type
@@ -314,13 +343,13 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
-// BaseNode ::= ElementNode | TextNode | DocumentNode | CommentNode | DoctypeNode
+// BaseNode ::= ElementNode | TextNode | DocumentNode | CommentNode | DoctypeNode | ProcessingInstructionNode
 // BaseNode just wraps *html.Node
 type BaseNode struct {
 	tree *html.Node
 }
 
-// Type ::= ElementNodeType | TextNodeType | DocumentNodeType | CommentNodeType | DoctypeNodeType | RawNodeType
+// Type ::= ElementNodeType | TextNodeType | DocumentNodeType | CommentNodeType | DoctypeNodeType | PINodeType | RawNodeType
 func (bn *BaseNode) Type() Type {
 	switch bn.tree.Type {
 	case html.ElementNode:
@@ -333,6 +362,8 @@ func (bn *BaseNode) Type() Type {
 		return CommentNodeType
 	case html.DoctypeNode:
 		return DoctypeNodeType
+	case ProcessingInstructionNode:
+		return PINodeType
 	default:
 		return RawNodeType
 	}
@@ -351,6 +382,8 @@ func (bn *BaseNode) Inspect() string {
 		return fmt.Sprintf("Comm{%s}", bn.tree.Data)
 	case html.DoctypeNode:
 		return fmt.Sprintf("Doctype{%s}", bn.tree.Data)
+	case ProcessingInstructionNode:
+		return fmt.Sprintf("PI{%s}", bn.tree.Data)
 	}
 	return bn.tree.Data
 }
@@ -405,10 +438,14 @@ func (bn *BaseNode) NextSibling() Node {
 }
 
 // Attr returns Attr field of element node with wrap it to AttrNode
+// The namespace declarations of a xml document are not attributes, so they are skipped
 func (bn *BaseNode) Attr() []Node {
 	if len(bn.tree.Attr) > 0 {
 		var nodes []Node
 		for _, a := range bn.tree.Attr {
+			if isNamespaceDecl(a) {
+				continue
+			}
 			nodes = append(nodes, &AttrNode{bn.tree, a})
 		}
 		return nodes
@@ -417,11 +454,18 @@ func (bn *BaseNode) Attr() []Node {
 }
 
 // Text returns *html.Node.Data
+// The text of a processing instruction is its content
 func (bn *BaseNode) Text() string {
 	if bn.Type() == CommentNodeType || bn.Type() == TextNodeType {
 		return bn.Tree().Data
 	}
-	for c := bn.FirstChild(); c != nil; c = bn.NextSibling() {
+	if bn.Type() == PINodeType {
+		if len(bn.tree.Attr) > 0 {
+			return bn.tree.Attr[0].Val
+		}
+		return ""
+	}
+	for c := bn.FirstChild(); c != nil; c = c.NextSibling() {
 		if c.Type() == TextNodeType {
 			return c.Tree().Data
 		}
@@ -433,13 +477,20 @@ func (bn *BaseNode) Text() string {
 // Attribute node is not exist in the golang.org/x/net/html package
 // so the struct field is different from the BaseNode.
 // AttrNode is basically, a child of ElementNode.
+// A namespace node of a xml document is an AttrNode too, its attr is in the XMLNSNamespace,
+// the key is the prefix and the value is the namespace uri
 type AttrNode struct {
 	parent *html.Node
 	attr   html.Attribute
 }
 
-// Type ::= AttributeNodeType
-func (an *AttrNode) Type() Type { return AttributeNodeType }
+// Type ::= AttributeNodeType | NamespaceNodeType
+func (an *AttrNode) Type() Type {
+	if isNamespaceDecl(an.attr) {
+		return NamespaceNodeType
+	}
+	return AttributeNodeType
+}
 
 // Inspect returns a value of the attr field
 func (an *AttrNode) Inspect() string { return fmt.Sprintf("Attr{%s:%s}", an.attr.Key, an.attr.Val) }
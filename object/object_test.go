@@ -1,6 +1,10 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+	"time"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{"Hello World"}
@@ -82,3 +86,58 @@ func TestDecimalHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestNumericHashKey(t *testing.T) {
+	int1 := &Integer{1}
+	dec1 := &Decimal{1.0}
+	dbl1 := &Double{1.0}
+	zero := &Double{0}
+	negZero := &Double{math.Copysign(0, -1)}
+	str1 := &String{"1"}
+
+	if int1.HashKey() != dec1.HashKey() || dec1.HashKey() != dbl1.HashKey() {
+		t.Errorf("numerics with same value have different hash keys")
+	}
+
+	if zero.HashKey() != negZero.HashKey() {
+		t.Errorf("0 and -0 have different hash keys")
+	}
+
+	if int1.HashKey() == str1.HashKey() {
+		t.Errorf("1 and \"1\" have same hash key")
+	}
+}
+
+func TestDurationHashKey(t *testing.T) {
+	day1 := &DayTimeDuration{24 * time.Hour}
+	day2 := &Duration{0, 24 * time.Hour}
+	year1 := &YearMonthDuration{12}
+	year2 := &Duration{12, 0}
+	hour := &DayTimeDuration{time.Hour}
+
+	if day1.HashKey() != day2.HashKey() {
+		t.Errorf("durations with same value have different hash keys")
+	}
+
+	if year1.HashKey() != year2.HashKey() {
+		t.Errorf("durations with same value have different hash keys")
+	}
+
+	if day1.HashKey() == hour.HashKey() {
+		t.Errorf("durations with different value have same hash keys")
+	}
+}
+
+func TestDateTimeHashKey(t *testing.T) {
+	dt1 := &DateTime{time.Date(2021, 3, 4, 10, 0, 0, 0, time.FixedZone("", 9*3600)), true}
+	dt2 := &DateTime{time.Date(2021, 3, 4, 1, 0, 0, 0, time.UTC), true}
+	dt3 := &DateTime{time.Date(2021, 3, 4, 2, 0, 0, 0, time.UTC), true}
+
+	if dt1.HashKey() != dt2.HashKey() {
+		t.Errorf("dateTimes with same instant have different hash keys")
+	}
+
+	if dt1.HashKey() == dt3.HashKey() {
+		t.Errorf("dateTimes with different instant have same hash keys")
+	}
+}
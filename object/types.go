@@ -33,6 +33,8 @@ const (
 	DoctypeNodeType   Type = "5"
 	RawNodeType       Type = "6"
 	AttributeNodeType Type = "7"
+	PINodeType        Type = "8"
+	NamespaceNodeType Type = "9"
 
 	// atomic
 	DoubleType  Type = "xs:double"
@@ -40,4 +42,12 @@ const (
 	IntegerType Type = "xs:integer"
 	StringType  Type = "xs:string"
 	BooleanType Type = "xs:boolean"
+
+	// date, time and duration
+	DateTimeType          Type = "xs:dateTime"
+	DateType              Type = "xs:date"
+	TimeType              Type = "xs:time"
+	DurationType          Type = "xs:duration"
+	DayTimeDurationType   Type = "xs:dayTimeDuration"
+	YearMonthDurationType Type = "xs:yearMonthDuration"
 )
@@ -0,0 +1,122 @@
+package object
+
+import (
+	"sort"
+
+	"golang.org/x/net/html"
+)
+
+// The namespace uris that are bound to the xml and xmlns prefixes in every xml document
+const (
+	XMLNamespace   = "http://www.w3.org/XML/1998/namespace"
+	XMLNSNamespace = "http://www.w3.org/2000/xmlns/"
+)
+
+// XMLDocument is the Data of the document node of a xml document, it tells the nodes of a xml document from the nodes of a html document
+const XMLDocument = "#xml"
+
+// ProcessingInstructionNode is the html.NodeType of a processing instruction, the html package has no such node type
+// The Data of the node is the target and the content is the value of its only attribute
+const ProcessingInstructionNode html.NodeType = 8
+
+// A xml document is a tree of html nodes that is built by the xml parser instead of the html parser
+// The names of the elements and attributes keep their case, their Namespace field is the namespace uri
+// The namespace declarations are kept as the attributes in the XMLNSNamespace whose key is the prefix,
+// the key of the default namespace declaration is empty
+// They are not attributes in the data model, so BaseNode.Attr skips them and NamespaceNodes returns them as namespace nodes
+
+// NewProcessingInstruction creates a processing instruction node
+func NewProcessingInstruction(target, content string) *html.Node {
+	return &html.Node{
+		Type: ProcessingInstructionNode,
+		Data: target,
+		Attr: []html.Attribute{{Val: content}},
+	}
+}
+
+// IsXML reports whether n is a node of a xml document
+func IsXML(n Node) bool {
+	root := TreeRoot(n)
+	return root.Type == html.DocumentNode && root.Data == XMLDocument
+}
+
+// isNamespaceDecl reports whether the attribute is a namespace declaration of a xml document
+func isNamespaceDecl(a html.Attribute) bool {
+	return a.Namespace == XMLNSNamespace
+}
+
+// LookupNamespaceURI returns the namespace uri that is bound to the prefix in the scope of the element n
+// The empty prefix is the default namespace, the xml prefix is always bound
+func LookupNamespaceURI(n *html.Node, prefix string) (string, bool) {
+	if prefix == "xml" {
+		return XMLNamespace, true
+	}
+	for e := n; e != nil; e = e.Parent {
+		if e.Type != html.ElementNode {
+			continue
+		}
+		for _, a := range e.Attr {
+			if isNamespaceDecl(a) && a.Key == prefix {
+				return a.Val, a.Val != ""
+			}
+		}
+	}
+	return "", false
+}
+
+// LookupPrefix returns the prefix that is bound to the namespace uri in the scope of the element n
+// The nearest declaration is used if the uri is bound to many prefixes
+func LookupPrefix(n *html.Node, uri string) (string, bool) {
+	if uri == "" {
+		return "", false
+	}
+	if uri == XMLNamespace {
+		return "xml", true
+	}
+	for e := n; e != nil; e = e.Parent {
+		if e.Type != html.ElementNode {
+			continue
+		}
+		for _, a := range e.Attr {
+			if isNamespaceDecl(a) && a.Val == uri {
+				if u, ok := LookupNamespaceURI(n, a.Key); ok && u == uri {
+					return a.Key, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// NamespaceNodes returns the namespace nodes of the element n sorted by prefix
+// They are the in-scope namespaces of the element, the xml prefix is included
+// The elements of a html document have no namespace nodes
+func NamespaceNodes(n *html.Node) []Node {
+	if n.Type != html.ElementNode || !IsXML(&BaseNode{n}) {
+		return nil
+	}
+
+	uris := map[string]string{"xml": XMLNamespace}
+	for e := n; e != nil; e = e.Parent {
+		for _, a := range e.Attr {
+			if _, ok := uris[a.Key]; isNamespaceDecl(a) && !ok {
+				uris[a.Key] = a.Val
+			}
+		}
+	}
+
+	var prefixes []string
+	for p, uri := range uris {
+		// xmlns="" undeclares the default namespace
+		if uri != "" {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+
+	nodes := make([]Node, len(prefixes))
+	for i, p := range prefixes {
+		nodes[i] = &AttrNode{parent: n, attr: html.Attribute{Namespace: XMLNSNamespace, Key: p, Val: uris[p]}}
+	}
+	return nodes
+}
@@ -18,6 +18,7 @@ const (
 	OR
 	AND
 	EQ
+	OTHERWISE
 	DVBAR
 	TO
 	SUM
@@ -60,6 +61,7 @@ var precedences = map[token.Type]int{
 	token.GEV:       EQ,
 	token.DGT:       EQ,
 	token.DLT:       EQ,
+	token.OTHERWISE: OTHERWISE,
 	token.DVBAR:     DVBAR,
 	token.TO:        TO,
 	token.PLUS:      SUM,
@@ -77,6 +79,7 @@ var precedences = map[token.Type]int{
 	token.CASTABLE:  CASTABLEAS,
 	token.CAST:      CASTAS,
 	token.ARROW:     ARROW,
+	token.MARROW:    ARROW,
 	token.UPLUS:     UNARY,
 	token.UMINUS:    UNARY,
 	token.BANG:      BANG,
@@ -94,9 +97,11 @@ type (
 )
 
 // Parser object
+// version is the XPath version of the lexer, the XPath 4.0 syntax is parsed only if it is lexer.XPath40
 type Parser struct {
-	l      *lexer.Lexer
-	errors []error
+	l       *lexer.Lexer
+	errors  []error
+	version lexer.Version
 
 	curToken  token.Token
 	peekToken token.Token
@@ -111,8 +116,9 @@ type Parser struct {
 // New returns parser object
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []error{},
+		l:       l,
+		errors:  []error{},
+		version: l.Version(),
 	}
 
 	p.prefixParseFns = make(map[token.Type]prefixParseFn)
@@ -194,6 +200,12 @@ func New(l *lexer.Lexer) *Parser {
 	p.infixParseFns[token.GTV] = p.parseComparisonExpr
 	p.infixParseFns[token.GEV] = p.parseComparisonExpr
 
+	if p.version == lexer.XPath40 {
+		p.prefixParseFns[token.TEMPLATE] = p.parseStringTemplate
+		p.infixParseFns[token.OTHERWISE] = p.parseOtherwiseExpr
+		p.infixParseFns[token.MARROW] = p.parseArrowExpr
+	}
+
 	// Read two tokens, so curToken and peekToken are both set
 	p.nextToken()
 	p.nextToken()
@@ -206,8 +218,23 @@ func (p *Parser) Errors() []error {
 	return p.errors
 }
 
+// Error is a syntax error found while parsing, Pos is the byte position of the token where the error is found
+type Error struct {
+	Pos     int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// newError adds an error at the position of the peek token, most errors are found when the peek token is not the expected one
 func (p *Parser) newError(format string, a ...interface{}) {
-	p.errors = append(p.errors, fmt.Errorf(format, a...))
+	p.newErrorAt(p.peekToken.Pos, format, a...)
+}
+
+func (p *Parser) newErrorAt(pos int, format string, a ...interface{}) {
+	p.errors = append(p.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
 }
 
 func (p *Parser) nextToken() {
@@ -215,6 +242,13 @@ func (p *Parser) nextToken() {
 	p.peekSpace = p.l.PeekSpace()
 	p.remaining = p.l.Remaining()
 	p.peekToken = p.l.NextToken()
+
+	if p.peekToken.Type == token.ILLEGAL && p.peekToken.Literal == "(:" {
+		p.newErrorAt(p.peekToken.Pos, "unterminated comment at position %d", p.peekToken.Pos)
+	}
+	if p.peekToken.Type == token.ILLEGAL && p.peekToken.Literal == "`" && p.version == lexer.XPath40 {
+		p.newErrorAt(p.peekToken.Pos, "unterminated string template at position %d", p.peekToken.Pos)
+	}
 }
 
 // cur t or t1 or t2 or ..
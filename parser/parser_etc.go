@@ -54,6 +54,7 @@ func (p *Parser) parsePredicate() ast.Predicate {
 	pc.Exprs = ex.Exprs
 
 	if !p.expectPeek(token.RBRACKET) {
+		p.newError("error while parsing Predicate: expectPeek: ], got=%s", p.peekToken.Literal)
 		return pc
 	}
 
@@ -95,12 +96,16 @@ func (p *Parser) parseArgumentList() ast.ArgumentList {
 	al := ast.ArgumentList{}
 
 	if !p.curTokenIs(token.LPAREN) {
-		p.newError("error while parsing ArgumentList: expectCur: (, got=%s", p.curToken.Literal)
+		p.newErrorAt(p.curToken.Pos, "error while parsing ArgumentList: expectCur: (, got=%s", p.curToken.Literal)
 		return al
 	}
 	p.nextToken()
 
 	for !p.curTokenIs(token.RPAREN) {
+		if p.curTokenIs(token.EOF) {
+			p.newErrorAt(p.curToken.Pos, "error while parsing ArgumentList: expectCur: ), got=EOF")
+			return al
+		}
 		arg := p.parseArgument()
 
 		al.Args = append(al.Args, arg)
@@ -118,23 +123,28 @@ func (p *Parser) parseArrowFunctionSpecifier() ast.ArrowFunctionSpecifier {
 
 	switch p.curToken.Type {
 	case token.DOLLAR:
+		vr := &ast.VarRef{Token: p.curToken}
 		p.nextToken()
-
-		vr := &ast.VarRef{}
 		vr.VarName = p.parseEQName()
 
 		afs.TypeID = 2
 		afs.VarRef = *vr
 	case token.LPAREN:
-		e := p.parseParenthesizedExpr()
-		pe, ok := e.(*ast.ParenthesizedExpr)
+		// the argument list after the parenthesized expression belongs to the arrow, so it is not parsed as a postfix
+		p.nextToken()
+		e := p.parseExpr()
+		er, ok := e.(*ast.Expr)
 		if !ok {
 			p.newError("cannot parse ParenthesizedExpr")
 			return afs
 		}
+		if !p.expectPeek(token.RPAREN) {
+			p.newError("error while parsing ParenthesizedExpr: expectPeek: ), got=%s", p.peekToken.Literal)
+			return afs
+		}
 
 		afs.TypeID = 3
-		afs.ParenthesizedExpr = *pe
+		afs.ParenthesizedExpr.Exprs = er.Exprs
 	default:
 		afs.TypeID = 1
 		afs.EQName = p.parseEQName()
@@ -229,6 +239,7 @@ func (p *Parser) parseNameTest() ast.NodeTest {
 	switch p.curToken.Type {
 	case token.ASTERISK:
 		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
 			p.nextToken()
 			test.Wildcard.NCName.SetValue(p.readNCName())
 			test.Wildcard.TypeID = 3
@@ -240,10 +251,13 @@ func (p *Parser) parseNameTest() ast.NodeTest {
 	default:
 		if p.curToken.Literal == "Q" && p.peekTokenIs(token.LBRACE) {
 			bracedURI := p.readBracedURI()
-			if !p.expectPeek(token.ASTERISK) {
-				p.newError("error while parsing Wildcard: expectPeek: *, got=%s", p.peekToken.Literal)
-				return nil
+			if !p.peekTokenIs(token.ASTERISK) {
+				p.nextToken()
+				test.EQName.SetValue(bracedURI + p.readNCName())
+				test.TypeID = 1
+				return test
 			}
+			p.nextToken()
 			test.Wildcard.BracedURILiteral.SetValue(bracedURI)
 			test.Wildcard.TypeID = 4
 			test.TypeID = 2
@@ -792,7 +806,7 @@ func (p *Parser) parsePal() ast.PAL {
 		return pal
 	}
 
-	p.newError("cannot parse PAL expression. expectCur: [, {, (. got=%s", p.curToken.Literal)
+	p.newErrorAt(p.curToken.Pos, "cannot parse PAL expression. expectCur: [, {, (. got=%s", p.curToken.Literal)
 	return nil
 }
 
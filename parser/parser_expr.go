@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/zzossig/rabbit/ast"
+	"github.com/zzossig/rabbit/lexer"
 	"github.com/zzossig/rabbit/token"
 	"github.com/zzossig/rabbit/util"
 )
@@ -13,7 +14,7 @@ func (p *Parser) parseExpr() ast.ExprSingle {
 	expr := &ast.Expr{}
 
 	for {
-		e := p.parseExprSingle(LOWEST)
+		e := p.parseOperand(LOWEST)
 		expr.Exprs = append(expr.Exprs, e)
 
 		if !p.peekTokenIs(token.COMMA) {
@@ -47,10 +48,24 @@ func (p *Parser) parseExprSingle(precedence int) ast.ExprSingle {
 	return leftExp
 }
 
+// parseOperand parses an operand of an operator, a missing operand such as the right operand of 1 + is a syntax error
+// It is not reported after another error since the operand is usually missing because of that error
+func (p *Parser) parseOperand(precedence int) ast.ExprSingle {
+	expr := p.parseExprSingle(precedence)
+	if expr == nil && len(p.errors) == 0 {
+		if p.curTokenIs(token.EOF) {
+			p.newErrorAt(p.curToken.Pos, "unexpected end of the expression")
+		} else {
+			p.newErrorAt(p.curToken.Pos, "unexpected token: %s", p.curToken.Literal)
+		}
+	}
+	return expr
+}
+
 func (p *Parser) parseIntegerLiteral() ast.ExprSingle {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		p.newError("cannot parse token %s to int", p.curToken.Literal)
+		p.newErrorAt(p.curToken.Pos, "cannot parse token %s to int", p.curToken.Literal)
 		return nil
 	}
 
@@ -61,7 +76,7 @@ func (p *Parser) parseIntegerLiteral() ast.ExprSingle {
 func (p *Parser) parseDecimalLiteral() ast.ExprSingle {
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.newError("cannot parse token %s to float", p.curToken.Literal)
+		p.newErrorAt(p.curToken.Pos, "cannot parse token %s to float", p.curToken.Literal)
 		return nil
 	}
 
@@ -72,7 +87,7 @@ func (p *Parser) parseDecimalLiteral() ast.ExprSingle {
 func (p *Parser) parseDoubleLiteral() ast.ExprSingle {
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.newError("cannot parse token %s to float", p.curToken.Literal)
+		p.newErrorAt(p.curToken.Pos, "cannot parse token %s to float", p.curToken.Literal)
 		return nil
 	}
 
@@ -86,31 +101,17 @@ func (p *Parser) parseStringLiteral() ast.ExprSingle {
 }
 
 func (p *Parser) parseVariable() ast.ExprSingle {
-	vr := &ast.VarRef{}
+	vr := &ast.VarRef{Token: p.curToken}
 
 	p.nextToken()
 
 	name := p.parseEQName()
 	vr.VarName = name
 
-	if p.peekTokenIs(token.LBRACKET, token.QUESTION) {
+	if p.peekTokenIs(token.LBRACKET, token.LPAREN, token.QUESTION) {
 		return p.parsePostfixExpr(vr)
 	}
 
-	if p.peekTokenIs(token.LPAREN) {
-		p.nextToken()
-
-		fc := &ast.FunctionCall{}
-		fc.ArgumentList = p.parseArgumentList()
-		fc.EQName = name
-
-		if p.peekTokenIs(token.LBRACKET, token.LPAREN, token.QUESTION) {
-			return p.parsePostfixExpr(fc)
-		}
-
-		return fc
-	}
-
 	return vr
 }
 
@@ -169,11 +170,7 @@ func (p *Parser) parseAdditiveExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	right := p.parseExprSingle(precedence)
-
-	if right != nil {
-		expr.RightExpr = right
-	}
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -183,11 +180,7 @@ func (p *Parser) parseMultiplicativeExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	right := p.parseExprSingle(precedence)
-
-	if right != nil {
-		expr.RightExpr = right
-	}
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -197,7 +190,7 @@ func (p *Parser) parseUnaryExpr() ast.ExprSingle {
 
 	precedence := p.precedence(token.UPLUS)
 	p.nextToken()
-	expr.ExprSingle = p.parseExprSingle(precedence)
+	expr.ExprSingle = p.parseOperand(precedence)
 
 	return expr
 }
@@ -206,11 +199,11 @@ func (p *Parser) parseUnaryLookupExpr() ast.ExprSingle {
 	expr := &ast.UnaryLookup{}
 
 	if !p.curTokenIs(token.QUESTION) {
-		p.newError("error while parsing UnaryLookup: expectCur: ?, got=%s", p.curToken.Literal)
+		p.newErrorAt(p.curToken.Pos, "error while parsing UnaryLookup: expectCur: ?, got=%s", p.curToken.Literal)
 		return nil
 	}
 
-	expr.Token = token.Token{Type: token.UQUESTION, Literal: "(?)"}
+	expr.Token = token.Token{Type: token.UQUESTION, Literal: "(?)", Pos: p.curToken.Pos}
 	p.nextToken()
 	expr.KeySpecifier = p.parseKeySpecifier()
 
@@ -242,6 +235,10 @@ func (p *Parser) parseSquareArrayExpr() ast.ExprSingle {
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACKET) {
+		if p.curTokenIs(token.EOF) {
+			p.newErrorAt(p.curToken.Pos, "error while parsing SquareArrayConstructor: expectCur: ], got=EOF")
+			return expr
+		}
 		e := p.parseExprSingle(LOWEST)
 		if e != nil {
 			expr.Exprs = append(expr.Exprs, e)
@@ -308,9 +305,9 @@ func (p *Parser) parseArrowExpr(left ast.ExprSingle) ast.ExprSingle {
 	expr := &ast.ArrowExpr{ExprSingle: left}
 
 	for {
+		b := ast.ArrowBinding{Token: p.curToken}
 		p.nextToken()
 
-		b := ast.ArrowBinding{}
 		b.ArrowFunctionSpecifier = p.parseArrowFunctionSpecifier()
 
 		p.nextToken()
@@ -318,7 +315,7 @@ func (p *Parser) parseArrowExpr(left ast.ExprSingle) ast.ExprSingle {
 		b.ArgumentList = p.parseArgumentList()
 		expr.Bindings = append(expr.Bindings, b)
 
-		if !p.expectPeek(token.ARROW) {
+		if !p.expectPeek(token.ARROW, token.MARROW) {
 			break
 		}
 	}
@@ -331,11 +328,7 @@ func (p *Parser) parseSimpleMapExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	right := p.parseExprSingle(precedence)
-
-	if right != nil {
-		expr.RightExpr = right
-	}
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -345,11 +338,7 @@ func (p *Parser) parseComparisonExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	right := p.parseExprSingle(precedence)
-
-	if right != nil {
-		expr.RightExpr = right
-	}
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -369,13 +358,20 @@ func (p *Parser) parseIfExpr() ast.ExprSingle {
 		return nil
 	}
 
+	if p.version == lexer.XPath40 && p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		then := p.parseEnclosedExpr()
+		expr.ThenExpr = &then
+		return expr
+	}
+
 	if !p.expectPeek(token.THEN) {
 		p.newError("error while parsing IfExpr: expectPeek: then, got=%s", p.peekToken.Literal)
 		return nil
 	}
 	p.nextToken()
 
-	expr.ThenExpr = p.parseExprSingle(LOWEST)
+	expr.ThenExpr = p.parseOperand(LOWEST)
 
 	if !p.expectPeek(token.ELSE) {
 		p.newError("error while parsing IfExpr: expectPeek: else, got=%s", p.peekToken.Literal)
@@ -383,7 +379,7 @@ func (p *Parser) parseIfExpr() ast.ExprSingle {
 	}
 	p.nextToken()
 
-	expr.ElseExpr = p.parseExprSingle(LOWEST)
+	expr.ElseExpr = p.parseOperand(LOWEST)
 
 	return expr
 }
@@ -393,6 +389,10 @@ func (p *Parser) parseForExpr() ast.ExprSingle {
 
 	for {
 		if !p.expectPeek(token.DOLLAR) {
+			if len(expr.Bindings) > 0 {
+				p.newError("error while parsing ForExpr: expectPeek: $, got=%s", p.peekToken.Literal)
+				return nil
+			}
 			return p.parseStepExpr()
 		}
 		p.nextToken()
@@ -406,7 +406,7 @@ func (p *Parser) parseForExpr() ast.ExprSingle {
 		}
 		p.nextToken()
 
-		binding.ExprSingle = p.parseExprSingle(LOWEST)
+		binding.ExprSingle = p.parseOperand(LOWEST)
 		expr.Bindings = append(expr.Bindings, binding)
 
 		if !p.peekTokenIs(token.COMMA) {
@@ -421,7 +421,7 @@ func (p *Parser) parseForExpr() ast.ExprSingle {
 	}
 	p.nextToken()
 
-	expr.ExprSingle = p.parseExprSingle(LOWEST)
+	expr.ExprSingle = p.parseOperand(LOWEST)
 
 	return expr
 }
@@ -431,6 +431,10 @@ func (p *Parser) parseLetExpr() ast.ExprSingle {
 
 	for {
 		if !p.expectPeek(token.DOLLAR) {
+			if len(expr.Bindings) > 0 {
+				p.newError("error while parsing LetExpr: expectPeek: $, got=%s", p.peekToken.Literal)
+				return nil
+			}
 			return p.parseStepExpr()
 		}
 		p.nextToken()
@@ -444,7 +448,7 @@ func (p *Parser) parseLetExpr() ast.ExprSingle {
 		}
 		p.nextToken()
 
-		binding.ExprSingle = p.parseExprSingle(LOWEST)
+		binding.ExprSingle = p.parseOperand(LOWEST)
 		expr.Bindings = append(expr.Bindings, binding)
 
 		if !p.expectPeek(token.COMMA) {
@@ -458,7 +462,7 @@ func (p *Parser) parseLetExpr() ast.ExprSingle {
 	}
 	p.nextToken()
 
-	expr.ExprSingle = p.parseExprSingle(LOWEST)
+	expr.ExprSingle = p.parseOperand(LOWEST)
 
 	return expr
 }
@@ -468,6 +472,10 @@ func (p *Parser) parseQuantifiedExpr() ast.ExprSingle {
 
 	for {
 		if !p.expectPeek(token.DOLLAR) {
+			if len(expr.Bindings) > 0 {
+				p.newError("error while parsing QuantifiedExpr: expectPeek: $, got=%s", p.peekToken.Literal)
+				return nil
+			}
 			return p.parseStepExpr()
 		}
 		p.nextToken()
@@ -481,7 +489,7 @@ func (p *Parser) parseQuantifiedExpr() ast.ExprSingle {
 		}
 		p.nextToken()
 
-		binding.ExprSingle = p.parseExprSingle(LOWEST)
+		binding.ExprSingle = p.parseOperand(LOWEST)
 		expr.Bindings = append(expr.Bindings, binding)
 
 		if !p.peekTokenIs(token.COMMA) {
@@ -496,7 +504,7 @@ func (p *Parser) parseQuantifiedExpr() ast.ExprSingle {
 	}
 	p.nextToken()
 
-	expr.ExprSingle = p.parseExprSingle(LOWEST)
+	expr.ExprSingle = p.parseOperand(LOWEST)
 
 	return expr
 }
@@ -506,7 +514,7 @@ func (p *Parser) parseOrExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	expr.RightExpr = p.parseExprSingle(precedence)
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -516,7 +524,7 @@ func (p *Parser) parseAndExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	expr.RightExpr = p.parseExprSingle(precedence)
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -526,7 +534,7 @@ func (p *Parser) parseRangeExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	expr.RightExpr = p.parseExprSingle(precedence)
+	expr.RightExpr = p.parseOperand(precedence)
 
 	if p.peekTokenIs(token.LBRACKET, token.LPAREN, token.QUESTION) {
 		return p.parsePostfixExpr(expr)
@@ -540,7 +548,7 @@ func (p *Parser) parseUnionExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	expr.RightExpr = p.parseExprSingle(precedence)
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
@@ -550,23 +558,85 @@ func (p *Parser) parseStringConcatExpr(left ast.ExprSingle) ast.ExprSingle {
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	expr.RightExpr = p.parseExprSingle(precedence)
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
 
+func (p *Parser) parseOtherwiseExpr(left ast.ExprSingle) ast.ExprSingle {
+	expr := &ast.OtherwiseExpr{LeftExpr: left, Token: p.curToken}
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	expr.RightExpr = p.parseOperand(precedence)
+
+	return expr
+}
+
+// parseStringTemplate splits the content of a string template into the fixed parts and the enclosed expressions.
+// An enclosed expression is parsed by another parser that stops at the closing brace.
+func (p *Parser) parseStringTemplate() ast.ExprSingle {
+	st := &ast.StringTemplate{}
+	input := p.curToken.Literal
+
+	var sb strings.Builder
+	for i := 0; i < len(input); i++ {
+		ch := input[i]
+		switch {
+		case (ch == '`' || ch == '{' || ch == '}') && i+1 < len(input) && input[i+1] == ch:
+			sb.WriteByte(ch)
+			i++
+		case ch == '}':
+			p.newErrorAt(p.curToken.Pos+i+1, "error while parsing StringTemplate: unmatched } at position %d", p.curToken.Pos+i+1)
+			return nil
+		case ch == '{':
+			if sb.Len() > 0 {
+				st.Parts = append(st.Parts, &ast.StringLiteral{Value: sb.String()})
+				sb.Reset()
+			}
+
+			offset := p.curToken.Pos + i + 2
+			sub := New(lexer.NewAt(input[i+1:], p.version, offset))
+			ee := &ast.EnclosedExpr{}
+			if !sub.curTokenIs(token.RBRACE) {
+				e := sub.parseExpr()
+				if er, ok := e.(*ast.Expr); ok {
+					ee.Exprs = er.Exprs
+				}
+				if !sub.expectPeek(token.RBRACE) {
+					sub.newError("error while parsing StringTemplate: expectPeek: }, got=%s", sub.peekToken.Literal)
+				}
+			}
+			p.errors = append(p.errors, sub.errors...)
+			if len(sub.errors) > 0 {
+				return nil
+			}
+
+			st.Parts = append(st.Parts, ee)
+			i += sub.curToken.Pos - offset + 1
+		default:
+			sb.WriteByte(ch)
+		}
+	}
+	if sb.Len() > 0 {
+		st.Parts = append(st.Parts, &ast.StringLiteral{Value: sb.String()})
+	}
+
+	return st
+}
+
 func (p *Parser) parseIntersectExceptExpr(left ast.ExprSingle) ast.ExprSingle {
 	expr := &ast.IntersectExceptExpr{LeftExpr: left, Token: p.curToken}
 
 	precedence := p.curPrecedence()
 	p.nextToken()
-	expr.RightExpr = p.parseExprSingle(precedence)
+	expr.RightExpr = p.parseOperand(precedence)
 
 	return expr
 }
 
 func (p *Parser) parseInstanceofExpr(left ast.ExprSingle) ast.ExprSingle {
-	expr := &ast.InstanceofExpr{ExprSingle: left}
+	expr := &ast.InstanceofExpr{ExprSingle: left, Token: p.curToken}
 
 	if !p.expectPeek(token.OF) {
 		p.newError("error while parsing InstanceofExpr: expectPeek: of, got=%s", p.peekToken.Literal)
@@ -580,7 +650,7 @@ func (p *Parser) parseInstanceofExpr(left ast.ExprSingle) ast.ExprSingle {
 }
 
 func (p *Parser) parseCastExpr(left ast.ExprSingle) ast.ExprSingle {
-	expr := &ast.CastExpr{ExprSingle: left}
+	expr := &ast.CastExpr{ExprSingle: left, Token: p.curToken}
 
 	if !p.expectPeek(token.AS) {
 		p.newError("error while parsing CastExpr: expectPeek: as, got=%s", p.peekToken.Literal)
@@ -594,7 +664,7 @@ func (p *Parser) parseCastExpr(left ast.ExprSingle) ast.ExprSingle {
 }
 
 func (p *Parser) parseCastableExpr(left ast.ExprSingle) ast.ExprSingle {
-	expr := &ast.CastableExpr{ExprSingle: left}
+	expr := &ast.CastableExpr{ExprSingle: left, Token: p.curToken}
 
 	if !p.expectPeek(token.AS) {
 		p.newError("error while parsing CastableExpr: expectPeek: as, got=%s", p.peekToken.Literal)
@@ -608,7 +678,7 @@ func (p *Parser) parseCastableExpr(left ast.ExprSingle) ast.ExprSingle {
 }
 
 func (p *Parser) parseTreatExpr(left ast.ExprSingle) ast.ExprSingle {
-	expr := &ast.TreatExpr{ExprSingle: left}
+	expr := &ast.TreatExpr{ExprSingle: left, Token: p.curToken}
 
 	if !p.expectPeek(token.AS) {
 		p.newError("error while parsing TreatExpr: expectPeek: as, got=%s", p.peekToken.Literal)
@@ -624,6 +694,10 @@ func (p *Parser) parseTreatExpr(left ast.ExprSingle) ast.ExprSingle {
 func (p *Parser) parseInlineFunctionExpr() ast.ExprSingle {
 	expr := &ast.InlineFunctionExpr{}
 
+	if p.version == lexer.XPath40 && p.peekTokenIs(token.LBRACE) {
+		return p.parseFocusFunctionExpr()
+	}
+
 	if !p.expectPeek(token.LPAREN) {
 		return p.parseStepExpr()
 	}
@@ -654,6 +728,63 @@ func (p *Parser) parseInlineFunctionExpr() ast.ExprSingle {
 	return expr
 }
 
+// parseFocusFunctionExpr parses fn { ... } or function { ... }, the function takes one argument that becomes the context item
+func (p *Parser) parseFocusFunctionExpr() ast.ExprSingle {
+	expr := &ast.InlineFunctionExpr{Focus: true}
+	expr.Params = []ast.Param{{}}
+
+	p.nextToken()
+	expr.FunctionBody = p.parseEnclosedExpr()
+
+	if p.peekTokenIs(token.LBRACKET, token.LPAREN, token.QUESTION) {
+		return p.parsePostfixExpr(expr)
+	}
+
+	return expr
+}
+
+// parseTryCatchExpr parses try { ... } catch NameTest | ... { ... }, try and catch are not keywords, so they can still be element names
+func (p *Parser) parseTryCatchExpr() ast.ExprSingle {
+	expr := &ast.TryCatchExpr{Token: p.curToken}
+
+	p.nextToken()
+	expr.EnclosedExpr = p.parseEnclosedExpr()
+
+	for p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "catch" {
+		p.nextToken()
+		cc := ast.CatchClause{}
+
+		for {
+			p.nextToken()
+			nt, ok := p.parseNameTest().(*ast.NameTest)
+			if !ok {
+				p.newError("error while parsing CatchClause: expected NameTest, got=%s", p.curToken.Literal)
+				return nil
+			}
+			cc.NameTests = append(cc.NameTests, nt)
+
+			if !p.peekTokenIs(token.VBAR) {
+				break
+			}
+			p.nextToken()
+		}
+
+		if !p.expectPeek(token.LBRACE) {
+			p.newError("error while parsing CatchClause: expectPeek: {, got=%s", p.peekToken.Literal)
+			return nil
+		}
+		cc.EnclosedExpr = p.parseEnclosedExpr()
+		expr.CatchClauses = append(expr.CatchClauses, cc)
+	}
+
+	if len(expr.CatchClauses) == 0 {
+		p.newError("error while parsing TryCatchExpr: expectPeek: catch, got=%s", p.peekToken.Literal)
+		return nil
+	}
+
+	return expr
+}
+
 func (p *Parser) parseNamedFunctionRef(left ast.ExprSingle) ast.ExprSingle {
 	ident := left.(*ast.Identifier)
 	expr := &ast.NamedFunctionRef{EQName: ident.EQName}
@@ -686,7 +817,7 @@ func (p *Parser) parseContextItemExpr() ast.ExprSingle {
 }
 
 func (p *Parser) parsePostfixExpr(left ast.ExprSingle) ast.ExprSingle {
-	pe := &ast.PostfixExpr{ExprSingle: left}
+	pe := &ast.PostfixExpr{ExprSingle: left, Token: p.peekToken}
 
 	for p.peekTokenIs(token.LBRACKET, token.LPAREN, token.QUESTION) {
 		p.nextToken()
@@ -702,7 +833,7 @@ func (p *Parser) parseParenthesizedExpr() ast.ExprSingle {
 	pe := &ast.ParenthesizedExpr{}
 
 	if !p.curTokenIs(token.LPAREN) {
-		p.newError("error while parsing ParenthesizedExpr: expectCur: (, got=%s", p.curToken.Literal)
+		p.newErrorAt(p.curToken.Pos, "error while parsing ParenthesizedExpr: expectCur: (, got=%s", p.curToken.Literal)
 		return nil
 	}
 	p.nextToken()
@@ -781,12 +912,19 @@ func (p *Parser) parseRelativePathExpr(left ast.ExprSingle) ast.ExprSingle {
 func (p *Parser) parseStepExpr() ast.ExprSingle {
 	as := &ast.AxisStep{}
 
+	if p.version == lexer.XPath40 && p.curToken.Literal == "fn" && p.peekTokenIs(token.LBRACE) {
+		return p.parseFocusFunctionExpr()
+	}
+	if p.version == lexer.XPath40 && p.curToken.Literal == "try" && p.peekTokenIs(token.LBRACE) {
+		return p.parseTryCatchExpr()
+	}
+
 	if p.peekTokenIs(token.LPAREN) {
 		if util.CheckKindTest(p.curToken.Literal) == 0 {
+			fc := &ast.FunctionCall{Token: p.curToken}
 			name := p.parseEQName()
 			p.nextToken()
 
-			fc := &ast.FunctionCall{}
 			fc.ArgumentList = p.parseArgumentList()
 			fc.EQName = name
 
@@ -806,19 +944,21 @@ func (p *Parser) parseStepExpr() ast.ExprSingle {
 	}
 
 	if p.peekTokenIs(token.COLON) {
-		name := p.parseEQName()
+		tok := p.curToken
+		nt := p.parseNameTest().(*ast.NameTest)
+		name := nt.EQName
 
-		if p.peekTokenIs(token.LPAREN) {
+		if nt.TypeID == 1 && p.peekTokenIs(token.LPAREN) {
 			p.nextToken()
 
-			fc := &ast.FunctionCall{}
+			fc := &ast.FunctionCall{Token: tok}
 			fc.ArgumentList = p.parseArgumentList()
 			fc.EQName = name
 
 			return fc
 		}
 
-		if p.peekTokenIs(token.HASH) {
+		if nt.TypeID == 1 && p.peekTokenIs(token.HASH) {
 			p.nextToken()
 
 			i := &ast.Identifier{EQName: name}
@@ -827,7 +967,7 @@ func (p *Parser) parseStepExpr() ast.ExprSingle {
 
 		as.TypeID = 2
 		as.ForwardStep.TypeID = 2
-		as.AbbrevForwardStep.NodeTest = &ast.NameTest{EQName: name, TypeID: 1}
+		as.AbbrevForwardStep.NodeTest = nt
 	}
 
 	if p.peekTokenIs(token.DCOLON) {
@@ -864,18 +1004,16 @@ func (p *Parser) parseStepExpr() ast.ExprSingle {
 				as.PredicateList = p.parsePredicateList()
 			}
 		} else {
-			p.newError("unknown axis: %s", axis)
+			p.newErrorAt(p.curToken.Pos, "unknown axis: %s", axis)
 			return nil
 		}
 	}
 
 	if as.TypeID == 0 {
-		if p.curTokenIs(token.ASTERISK) {
-			w := ast.Wildcard{TypeID: 1}
-
+		if p.curTokenIs(token.ASTERISK) || (p.curToken.Literal == "Q" && p.peekTokenIs(token.LBRACE)) {
 			as.TypeID = 2
 			as.ForwardStep.TypeID = 2
-			as.AbbrevForwardStep.NodeTest = &ast.NameTest{Wildcard: w, TypeID: 2}
+			as.AbbrevForwardStep.NodeTest = p.parseNameTest()
 		} else {
 			name := p.parseEQName()
 
@@ -467,7 +467,7 @@ func TestLetExpr(t *testing.T) {
         return 
             ($income_tax(300), $luxury_tax(50))
 			`,
-			"let $tax_rate := function($rate as xs:integer, $amount as xs:decimal) as xs:decimal {(($rate div 100) * $amount)}, $income_tax := function($amount as xs:decimal) as xs:decimal {tax_rate(15, ?)($amount)}, $luxury_tax := function($amount as xs:integer) as xs:decimal {tax_rate(50, ?)($amount)} return (income_tax(300), luxury_tax(50))",
+			"let $tax_rate := function($rate as xs:integer, $amount as xs:decimal) as xs:decimal {(($rate div 100) * $amount)}, $income_tax := function($amount as xs:decimal) as xs:decimal {$tax_rate(15, ?)($amount)}, $luxury_tax := function($amount as xs:integer) as xs:decimal {$tax_rate(50, ?)($amount)} return ($income_tax(300), $luxury_tax(50))",
 		},
 	}
 
@@ -589,7 +589,7 @@ func TestFunctionCall(t *testing.T) {
 		},
 		{
 			"$f(2, 3)",
-			"f(2, 3)",
+			"$f(2, 3)",
 		},
 		{
 			"$f[2]('Hi there')",
@@ -597,7 +597,7 @@ func TestFunctionCall(t *testing.T) {
 		},
 		{
 			"$f()[2]",
-			"f()[2]",
+			"$f()[2]",
 		},
 		{
 			"function() as xs:integer+ { 2, 3, 5, 7, 11, 13 }()",
@@ -1150,6 +1150,22 @@ func TestAbbreviatedSyntax(t *testing.T) {
 			"*/para",
 			"(* / para)",
 		},
+		{
+			"svg:*",
+			"svg:*",
+		},
+		{
+			"*:path/@*:href",
+			"(*:path / @*:href)",
+		},
+		{
+			"//Q{http://www.w3.org/2000/svg}*",
+			"//Q{http://www.w3.org/2000/svg}*",
+		},
+		{
+			"child::Q{http://www.w3.org/2000/svg}path",
+			"child::Q{http://www.w3.org/2000/svg}path",
+		},
 		{
 			"/book/chapter[5]/section[2]",
 			"((/book / chapter[5]) / section[2])",
@@ -1305,3 +1321,193 @@ func TestPathExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestComment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"1 (: one :) + (: two (: nested :) :) 2",
+			"(1 + 2)",
+		},
+		{
+			"//company(: the root :)/office",
+			"(//company / office)",
+		},
+		{
+			"concat('(: not a comment :)', (: a, b :) 'x')",
+			"concat('(: not a comment :)', 'x')",
+		},
+		{
+			"(1, (: ) :) 2)",
+			"(1, 2)",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		xpath := p.ParseXPath()
+
+		if len(p.Errors()) > 0 {
+			t.Fatalf("unexpected errors for %q: %v", tt.input, p.Errors())
+		}
+
+		actual := xpath.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + (: two", "unterminated comment at position 4"},
+		{"concat('a', (: (: :) 'b')", "unterminated comment at position 12"},
+	}
+
+	for _, tt := range errors {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseXPath()
+
+		if len(p.Errors()) == 0 || p.Errors()[0].Error() != tt.expected {
+			t.Errorf("expected error %q for %q, got=%v", tt.expected, tt.input, p.Errors())
+		}
+	}
+}
+
+func TestXPath40(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"$a otherwise $b otherwise 1",
+			"(($a otherwise $b) otherwise 1)",
+		},
+		{
+			"$a || 'x' otherwise 1 = 1",
+			"((($a || 'x') otherwise 1) = 1)",
+		},
+		{
+			"`Hello {$name}, {{x}} ``{}`",
+			"`Hello {$name}, {{x}} ``{}`",
+		},
+		{
+			"`{ `{1 + 2}` }`",
+			"`{`{(1 + 2)}`}`",
+		},
+		{
+			"if ($a) { 1, 2 }",
+			"if($a) {1, 2}",
+		},
+		{
+			"if ($a) then 1 else 2",
+			"if($a) then 1 else 2",
+		},
+		{
+			"fn { . + 1 }",
+			"fn {(. + 1)}",
+		},
+		{
+			"function { @id }",
+			"fn {@id}",
+		},
+		{
+			"$a =!> f(1) => g()",
+			"$a =!> f(1) => g()",
+		},
+		{
+			"$a =!> (fn { . * 2 })()",
+			"$a =!> (fn {(. * 2)})()",
+		},
+		{
+			"try { $a div 0 } catch err:FOAR0001 | err:XPTY0004 { 0 } catch * { $err:code }",
+			"try {($a div 0)} catch err:FOAR0001 | err:XPTY0004 {0} catch * {$err:code}",
+		},
+		{
+			"//try/catch",
+			"(//try / catch)",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewVersion(tt.input, lexer.XPath40)
+		p := New(l)
+		xpath := p.ParseXPath()
+
+		if len(p.Errors()) > 0 {
+			t.Fatalf("unexpected errors for %q: %v", tt.input, p.Errors())
+		}
+
+		actual := xpath.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	errors := []string{
+		"`a } b`",
+		"`{1 +}`",
+		"`{1, 2`",
+		"try { 1 }",
+		"try { 1 } catch { 2 }",
+		"try { 1 } catch * 2",
+	}
+
+	for _, input := range errors {
+		l := lexer.NewVersion(input, lexer.XPath40)
+		p := New(l)
+		p.ParseXPath()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+
+	l := lexer.New("if ($a) { 1 }")
+	p := New(l)
+	p.ParseXPath()
+	if len(p.Errors()) == 0 {
+		t.Errorf("braced if should be an error in XPath 3.1")
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		version  lexer.Version
+		expected int
+	}{
+		{"(1 + 2", lexer.XPath31, 6},
+		{"concat(1,", lexer.XPath31, 9},
+		{"1 + (: two", lexer.XPath31, 4},
+		{"for $a in (1, 2) retur $a", lexer.XPath31, 17},
+		{"`a{1 +}`", lexer.XPath40, 6},
+		{"1 +", lexer.XPath31, 3},
+		{"1 + )", lexer.XPath31, 4},
+		{"(1, 2) = !", lexer.XPath31, 9},
+		{"'x' || `a {'b'} }`", lexer.XPath40, 16},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.NewVersion(tt.input, tt.version))
+		p.ParseXPath()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		err, ok := p.Errors()[0].(*Error)
+		if !ok {
+			t.Errorf("expected *Error for %q, got=%T", tt.input, p.Errors()[0])
+			continue
+		}
+		if err.Pos != tt.expected {
+			t.Errorf("wrong error position for %q. expected=%d, got=%d (%s)", tt.input, tt.expected, err.Pos, err)
+		}
+	}
+}
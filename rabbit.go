@@ -1,19 +1,18 @@
 package rabbit
 
 import (
-	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/zzossig/rabbit/bif"
-	"github.com/zzossig/rabbit/eval"
-	"github.com/zzossig/rabbit/lexer"
 	"github.com/zzossig/rabbit/object"
-	"github.com/zzossig/rabbit/parser"
 	"github.com/zzossig/rabbit/repl"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 // XPath is a base object to evaluate xpath expressions.
@@ -24,11 +23,18 @@ import (
 // object.Item is a custom data type used in rabbit language.
 // You can convert object.Item to a golang data type using Data or Nodes method.
 // errors field is collected errors while parsing and evaluating
+// version field is the xpath version that expressions are parsed with
+// goctx and limits fields stop an evaluation that is canceled or exceeds the limits
+// noIndex field is set when the document index is turned off with SetIndex
 type XPath struct {
 	xpath   string
 	context *object.Context
 	evaled  object.Item
 	errors  []error
+	version Version
+	goctx   context.Context
+	limits  Limits
+	noIndex bool
 }
 
 // New creates new xpath object.
@@ -38,37 +44,47 @@ func New() *XPath {
 
 // SetDoc set document to a context.
 // if document is not set in a context, node related xpath expressions are not going to work.
-// input param can be url or local filepath.
+// input param can be url or local filepath, it is loaded by the loader set with SetLoader.
 func (x *XPath) SetDoc(input string) *XPath {
 	initContext(x.context)
 	x.xpath = ""
 
-	f := bif.F["fn:doc"]
-	err := f(x.context, bif.NewString(input))
-	if err != nil {
-		x.errors = append(x.errors, fmt.Errorf(err.Inspect()))
+	if err := bif.SetDoc(x.context, input); err != nil {
+		x.errors = append(x.errors, newEvalError("", err))
 	}
+	x.indexDoc()
 	return x
 }
 
+// SetDocXML is another version of SetDoc that parses the document as xml.
+// It turns on the xml mode as SetXML(true), so the documents of fn:doc are parsed as xml too.
+func (x *XPath) SetDocXML(input string) *XPath {
+	return x.SetXML(true).SetDoc(input)
+}
+
 // SetDocR is another version of SetDoc.
+// The document is read from the body of the response, the url of the request becomes the base uri.
+// The charset of the Content-Type header is used to decode the document as in SetEncoding.
 func (x *XPath) SetDocR(r *http.Response) *XPath {
 	initContext(x.context)
 	x.xpath = ""
 	defer r.Body.Close()
 
-	nr := bufio.NewReader(r.Body)
-	parsedHTML, err := html.Parse(nr)
+	doc, err := bif.ParseDoc(x.context, r.Body, r.Header.Get("Content-Type"))
 	if err != nil {
-		x.errors = append(x.errors, err)
+		x.errors = append(x.errors, newEvalError("", err))
+		return x
 	}
-	parsedHTML.Type = html.DocumentNode
 
 	docNode := &object.BaseNode{}
-	docNode.SetTree(parsedHTML)
+	docNode.SetTree(doc)
 	x.context.Doc = docNode
 	x.context.CNode = []object.Node{x.context.Doc}
+	if r.Request != nil && r.Request.URL != nil {
+		x.context.BaseURI = r.Request.URL.String()
+	}
 
+	x.indexDoc()
 	return x
 }
 
@@ -82,6 +98,7 @@ func (x *XPath) SetDocN(n *html.Node) *XPath {
 	x.context.Doc = docNode
 	x.context.CNode = []object.Node{x.context.Doc}
 
+	x.indexDoc()
 	return x
 }
 
@@ -93,7 +110,7 @@ func (x *XPath) SetDocS(s string) *XPath {
 	nr := strings.NewReader(s)
 	parsedHTML, err := html.Parse(nr)
 	if err != nil {
-		x.errors = append(x.errors, err)
+		x.errors = append(x.errors, newError(err))
 	}
 	parsedHTML.Type = html.DocumentNode
 
@@ -102,10 +119,179 @@ func (x *XPath) SetDocS(s string) *XPath {
 	x.context.Doc = docNode
 	x.context.CNode = []object.Node{x.context.Doc}
 
+	x.indexDoc()
+	return x
+}
+
+// SetFragment is another version of SetDocS that parses a html fragment such as an email body or a list of <li> items.
+// The fragment is parsed as the content of a contextTag element, "body" is used if contextTag is empty,
+// so SetFragment(s, "tbody") keeps the <tr> elements that are dropped outside a table.
+// The top-level nodes of the fragment become the children of a document node without the html, head and body wrapper,
+// so /li selects the top-level li elements and //td selects all the td elements of the fragment.
+func (x *XPath) SetFragment(s, contextTag string) *XPath {
+	initContext(x.context)
+	x.xpath = ""
+
+	if contextTag == "" {
+		contextTag = "body"
+	}
+	context := &html.Node{Type: html.ElementNode, Data: contextTag, DataAtom: atom.Lookup([]byte(contextTag))}
+	nodes, err := html.ParseFragment(strings.NewReader(s), context)
+	if err != nil {
+		x.errors = append(x.errors, newError(err))
+	}
+
+	doc := &html.Node{Type: html.DocumentNode}
+	for _, n := range nodes {
+		doc.AppendChild(n)
+	}
+
+	docNode := &object.BaseNode{}
+	docNode.SetTree(doc)
+	x.context.Doc = docNode
+	x.context.CNode = []object.Node{x.context.Doc}
+
+	x.indexDoc()
+	return x
+}
+
+// SetLoader sets the loader of the documents of SetDoc, fn:doc, fn:doc-available and fn:json-doc.
+// The default loader reads urls with the http or https scheme over http and the other uris from the local filesystem.
+// The loaders in the loader package restrict the filesystem to a directory, add headers to http requests,
+// serve documents from memory and cache them.
+//
+//	x := rabbit.New().SetLoader(loader.Map{"https://example.com/": "<title>Example</title>"}).SetDoc("https://example.com/")
+func (x *XPath) SetLoader(l DocumentLoader) *XPath {
+	x.context.Loader = l
+	return x
+}
+
+// SetXML sets whether the documents loaded by SetDoc, SetDocR and fn:doc are parsed as xml instead of html.
+// A xml document keeps the case of the names, the namespaces, the processing instructions and the CDATA sections,
+// and it is not wrapped in html, head and body elements.
+// The processing-instruction() and namespace-node() kind tests and the namespace axis select the nodes of a xml document.
+//
+//	x := rabbit.New().SetXML(true).SetDoc("feed.xml").Eval("/rss/channel/title")
+func (x *XPath) SetXML(enabled bool) *XPath {
+	x.context.XML = enabled
+	return x
+}
+
+// SetEncoding sets the character encoding of the documents loaded by SetDoc, SetDocR and fn:doc.
+// label is an encoding name such as "shift_jis", "euc-kr" or "windows-1252".
+// If it is not set, the encoding is detected from the byte order mark, the charset of the http Content-Type header
+// and the <meta charset> element, then the document is decoded to utf-8 before it is parsed.
+//
+//	x := rabbit.New().SetEncoding("euc-kr").SetDoc(url)
+func (x *XPath) SetEncoding(label string) *XPath {
+	x.context.Encoding = label
+	return x
+}
+
+// Encoding returns the name of the character encoding that the document was decoded from.
+// It is empty if the document was not loaded by SetDoc or SetDocR.
+func (x *XPath) Encoding() string {
+	if x.context.Doc == nil {
+		return ""
+	}
+	return x.context.DocumentEncoding(x.context.Doc)
+}
+
+// SetDecimalFormat adds a decimal format that is used in fn:format-number.
+// an empty name replaces the default decimal format.
+func (x *XPath) SetDecimalFormat(name string, df *object.DecimalFormat) *XPath {
+	if x.context.DecimalFormats == nil {
+		x.context.DecimalFormats = make(map[string]*object.DecimalFormat)
+	}
+	x.context.DecimalFormats[name] = df
 	return x
 }
 
+// SetVar binds a golang value to an external variable that can be referenced in expressions as $name.
+// int, float64, string, bool, []interface{}, map[string]interface{} and *html.Node values are converted to items.
+// The other integer kinds such as uint are converted to xs:integer, an unsigned value larger than an int is an error.
+func (x *XPath) SetVar(name string, value interface{}) *XPath {
+	if err := setVar(x.context, name, value); err != nil {
+		x.errors = append(x.errors, newError(err))
+	}
+	return x
+}
+
+// SetVars is like SetVar but binds all the values in vars, the keys are the variable names.
+func (x *XPath) SetVars(vars map[string]interface{}) *XPath {
+	for name, value := range vars {
+		x.SetVar(name, value)
+	}
+	return x
+}
+
+// SetTrace sets the function that receives the label and the serialized value of fn:trace.
+// The trace is written to the standard error if it is not set, TraceWriter writes it to an io.Writer.
+// A structured logger can be used with a function such as func(label, value string) { logger.Info(label, "value", value) }.
+func (x *XPath) SetTrace(trace func(label, value string)) *XPath {
+	x.context.Trace = trace
+	return x
+}
+
+// TraceWriter returns a trace function that writes the trace to w, one line for each call of fn:trace
+func TraceWriter(w io.Writer) func(label, value string) {
+	return func(label, value string) {
+		if label != "" {
+			fmt.Fprintf(w, "%s: %s\n", label, value)
+		} else {
+			fmt.Fprintln(w, value)
+		}
+	}
+}
+
+// SetVersion sets the xpath version that the following Eval calls parse expressions with.
+// XPath40 enables the syntax extensions of the XPath 4.0 drafts, the default is XPath31.
+func (x *XPath) SetVersion(v Version) *XPath {
+	x.version = v
+	return x
+}
+
+// SetContext sets the context that stops the following Eval calls when it is canceled or its deadline is exceeded.
+// A stopped evaluation fails with an error that has the code rabbit:canceled and wraps ctx.Err(),
+// so it can be inspected with errors.Is(err, context.DeadlineExceeded).
+func (x *XPath) SetContext(ctx context.Context) *XPath {
+	x.goctx = ctx
+	return x
+}
+
+// SetLimits sets the resource limits of the following Eval calls.
+// An evaluation that exceeds a limit fails with an error that has a code such as rabbit:max-nodes.
+func (x *XPath) SetLimits(l Limits) *XPath {
+	x.limits = l
+	return x
+}
+
+// SetIndex turns the document index on or off, it is on by default.
+// The index is built when a document is set and speeds up the name tests after //, fn:id and the document order of nodes.
+// Turning it off saves the memory of the index, which is reported by Index().Stats().
+func (x *XPath) SetIndex(enabled bool) *XPath {
+	x.noIndex = !enabled
+	x.indexDoc()
+	return x
+}
+
+// Index returns the index of the document, it returns nil if there is no document or the index is turned off.
+func (x *XPath) Index() *Index {
+	return x.context.Index
+}
+
+func (x *XPath) indexDoc() {
+	if x.noIndex || x.context.Doc == nil {
+		x.context.Index = nil
+		return
+	}
+	if !x.context.Index.Contains(x.context.Doc) {
+		x.context.Index = object.NewIndex(x.context.Doc)
+	}
+}
+
 // Eval evaluates a xpath expression and save the result to evaled field.
+// The expression is compiled with Compile, so the same expression is parsed only once.
 func (x *XPath) Eval(input string) *XPath {
 	if len(x.errors) > 0 {
 		return x
@@ -117,22 +303,13 @@ func (x *XPath) Eval(input string) *XPath {
 		x.xpath += input
 	}
 
-	l := lexer.New(input)
-	p := parser.New(l)
-	px := p.ParseXPath()
-
-	if len(p.Errors()) != 0 {
-		x.errors = append(x.errors, p.Errors()...)
-		return x
-	}
-
-	e := eval.Eval(px, x.context)
-	if bif.IsError(e) {
-		x.errors = append(x.errors, fmt.Errorf(e.Inspect()))
+	expr, errs := compile(input, x.version)
+	if len(errs) != 0 {
+		x.errors = append(x.errors, errs...)
 		return x
 	}
-	x.evaled = e
 
+	x.eval(expr)
 	return x
 }
 
@@ -148,18 +325,14 @@ func (x *XPath) Evals(input string) []*XPath {
 		x.xpath += input
 	}
 
-	l := lexer.New(input)
-	p := parser.New(l)
-	px := p.ParseXPath()
-
-	if len(p.Errors()) != 0 {
-		x.errors = append(x.errors, p.Errors()...)
+	expr, errs := compile(input, x.version)
+	if len(errs) != 0 {
+		x.errors = append(x.errors, errs...)
 		return []*XPath{x}
 	}
 
-	e := eval.Eval(px, x.context)
-	if bif.IsError(e) {
-		x.errors = append(x.errors, fmt.Errorf(e.Inspect()))
+	e := x.eval(expr)
+	if e == nil {
 		return []*XPath{x}
 	}
 
@@ -169,31 +342,34 @@ func (x *XPath) Evals(input string) []*XPath {
 	for _, item := range seq.Items {
 		switch item := item.(type) {
 		case *object.Integer:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.Decimal:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.Double:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.Boolean:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.String:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
+			result = append(result, newX)
+		case *object.DateTime, *object.Date, *object.Time, *object.Duration, *object.DayTimeDuration, *object.YearMonthDuration:
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.Map:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.Array:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContext(x.context), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.BaseNode:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContextN(x.context, item)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContextN(x.context, item), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		case *object.AttrNode:
-			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContextN(x.context, item)}
+			newX := &XPath{xpath: x.xpath, evaled: item, context: copyContextN(x.context, item), version: x.version, goctx: x.goctx, limits: x.limits}
 			result = append(result, newX)
 		}
 	}
@@ -214,7 +390,7 @@ func (x *XPath) GetAll() []string {
 	x.xpath = ""
 
 	if x.evaled == nil {
-		x.errors = append(x.errors, fmt.Errorf("cannot convert item since evaled field is nil"))
+		x.errors = append(x.errors, &Error{Message: "cannot convert item since evaled field is nil"})
 		return nil
 	}
 
@@ -236,16 +412,19 @@ func (x *XPath) DataAll() []interface{} {
 	x.xpath = ""
 
 	if x.evaled == nil {
-		x.errors = append(x.errors, fmt.Errorf("cannot convert item since evaled field is nil"))
+		x.errors = append(x.errors, &Error{Message: "cannot convert item since evaled field is nil"})
 		return nil
 	}
 
 	e, err := convert(x.evaled)
 	if err != nil {
-		x.errors = append(x.errors, err)
+		x.errors = append(x.errors, newError(err))
 		return nil
 	}
 
+	if _, ok := x.evaled.(*object.Sequence); !ok {
+		return []interface{}{e}
+	}
 	return e.([]interface{})
 }
 
@@ -264,13 +443,13 @@ func (x *XPath) NodeAll() []*html.Node {
 	x.xpath = ""
 
 	if x.evaled == nil {
-		x.errors = append(x.errors, fmt.Errorf("cannot convert item since evaled field is nil"))
+		x.errors = append(x.errors, &Error{Message: "cannot convert item since evaled field is nil"})
 		return nil
 	}
 
 	e, err := convertNode(x.evaled)
 	if err != nil {
-		x.errors = append(x.errors, err)
+		x.errors = append(x.errors, newError(err))
 		return nil
 	}
 
@@ -0,0 +1,82 @@
+package rabbit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zzossig/rabbit/lexer"
+	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/parser"
+)
+
+// Error is an error raised while parsing or evaluating a xpath expression.
+// The errors returned by Compile and XPath.Errors can be inspected with errors.As:
+//
+//	var e *rabbit.Error
+//	if errors.As(err, &e) && e.Code == "err:XPTY0004" {
+//		// ...
+//	}
+type Error struct {
+	// Code is the error code as a QName such as err:XPTY0004, it is empty if the error has no code.
+	// Syntax errors have the code err:XPST0003.
+	Code string
+	// Message describes the error
+	Message string
+	// Expr is the sub-expression that raised the error, it is empty if the sub-expression is not known
+	Expr string
+	// Line and Column locate the error in the expression, they start at 1 and are 0 if the location is not known
+	Line   int
+	Column int
+	// Err is the go error that caused the error such as context.Canceled, it is nil if there is none
+	Err error
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+
+	if e.Code != "" {
+		sb.WriteString(e.Code)
+		sb.WriteString(" ")
+	}
+	sb.WriteString(e.Message)
+	if e.Line > 0 {
+		fmt.Fprintf(&sb, " at line %d, column %d", e.Line, e.Column)
+	}
+	if e.Expr != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Expr)
+	}
+
+	return sb.String()
+}
+
+// Unwrap returns the go error that caused the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError converts a go error such as an error of converting the result to go values to *Error
+func newError(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return &Error{Message: err.Error(), Err: err}
+}
+
+// newSyntaxError converts an error of the parser to *Error, input is the parsed expression
+func newSyntaxError(input string, err error) *Error {
+	e := &Error{Code: "err:XPST0003", Message: err.Error()}
+	if pe, ok := err.(*parser.Error); ok {
+		e.Line, e.Column = lexer.Position(input, pe.Pos)
+	}
+	return e
+}
+
+// newEvalError converts an error item to *Error, input is the evaluated expression
+func newEvalError(input string, item *object.Error) *Error {
+	e := &Error{Code: item.Code, Message: item.Message, Expr: item.Expr, Err: item.Err}
+	if item.Expr != "" {
+		e.Line, e.Column = lexer.Position(input, item.Pos)
+	}
+	return e
+}
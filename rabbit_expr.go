@@ -0,0 +1,213 @@
+package rabbit
+
+import (
+	"context"
+
+	"github.com/zzossig/rabbit/ast"
+	"github.com/zzossig/rabbit/bif"
+	"github.com/zzossig/rabbit/eval"
+	"github.com/zzossig/rabbit/lexer"
+	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/parser"
+	"github.com/zzossig/rabbit/util"
+	"golang.org/x/net/html"
+)
+
+// Expr is a compiled xpath expression.
+// The ast is not modified during evaluation, so an Expr can be evaluated from many goroutines at the same time.
+type Expr struct {
+	xpath string
+	ast   *ast.XPath
+}
+
+// Options are the settings for an evaluation of a compiled expression.
+// A nil *Options evaluates the expression with the default settings.
+type Options struct {
+	// DecimalFormats are the decimal formats used in fn:format-number, an empty name replaces the default one
+	DecimalFormats map[string]*object.DecimalFormat
+	// Vars are the external variables, the values are converted as in XPath.SetVar
+	Vars map[string]interface{}
+	// Functions are the go functions that can be called in addition to the built-in functions
+	Functions *FunctionRegistry
+	// Namespaces binds the prefixes used in name tests to namespace uris in addition to the default ones
+	Namespaces map[string]string
+	// Trace receives the label and the serialized value of fn:trace as in XPath.SetTrace
+	Trace func(label, value string)
+	// Limits are the resource limits of the evaluation as in XPath.SetLimits
+	Limits Limits
+	// Index is the index of doc built with NewIndex, it can be shared by the evaluations of the same document
+	Index *Index
+	// Loader loads the documents of fn:doc, fn:doc-available and fn:json-doc as in XPath.SetLoader
+	Loader DocumentLoader
+	// Encoding is the character encoding of the documents loaded by fn:doc as in XPath.SetEncoding
+	Encoding string
+	// XML parses the documents loaded by fn:doc as xml as in XPath.SetXML
+	XML bool
+}
+
+// Limits are the resource limits of an evaluation, a zero field means no limit.
+// They guard against expressions such as //*//*//* or a recursive function that never ends.
+//
+//	x := rabbit.New().SetDoc(url).SetLimits(rabbit.Limits{MaxNodes: 100000, MaxDepth: 100})
+type Limits = object.Limits
+
+// Index is the index of a document, it speeds up the name tests after //, fn:id and the document order of nodes.
+// It is built once and is read only after that, it must be built again if the document is modified.
+type Index = object.Index
+
+// IndexStats reports the number of the indexed nodes, names, ids and class tokens and an estimate of the memory use in bytes
+type IndexStats = object.IndexStats
+
+// NewIndex builds the index of the document that doc belongs to, it returns nil if doc is nil.
+//
+//	idx := rabbit.NewIndex(doc)
+//	expr.Evaluate(doc, &rabbit.Options{Index: idx})
+func NewIndex(doc *html.Node) *Index {
+	if doc == nil {
+		return nil
+	}
+	docNode := &object.BaseNode{}
+	docNode.SetTree(doc)
+	return object.NewIndex(docNode)
+}
+
+// DocumentLoader loads the documents by their uris, the loader package has the implementations.
+type DocumentLoader = object.DocumentLoader
+
+// Version is the version of the xpath language that expressions are parsed with
+type Version = lexer.Version
+
+const (
+	// XPath31 is the default version
+	XPath31 = lexer.XPath31
+	// XPath40 adds the syntax extensions of the XPath 4.0 drafts:
+	// the otherwise operator, string templates, braced if, focus functions and the mapping arrow(=!>),
+	// and the try/catch expression of XQuery 3.1
+	XPath40 = lexer.XPath40
+)
+
+type exprKey struct {
+	xpath   string
+	version Version
+}
+
+// exprCache holds the recently compiled expressions
+var exprCache = util.NewCache(exprCacheSize)
+
+const exprCacheSize = 256
+
+// Compile parses a xpath expression.
+// Compiled expressions are cached, so compiling the same expression again does not parse it again.
+func Compile(input string) (*Expr, error) {
+	return CompileVersion(input, XPath31)
+}
+
+// CompileVersion is like Compile but parses the expression as the xpath version v.
+// The returned error is a *Error of the first syntax error.
+func CompileVersion(input string, v Version) (*Expr, error) {
+	expr, errs := compile(input, v)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return expr, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed.
+func MustCompile(input string) *Expr {
+	expr, err := Compile(input)
+	if err != nil {
+		panic(`rabbit: Compile(` + input + `): ` + err.Error())
+	}
+	return expr
+}
+
+func compile(input string, v Version) (*Expr, []error) {
+	key := exprKey{xpath: input, version: v}
+
+	if expr, ok := exprCache.Get(key); ok {
+		return expr.(*Expr), nil
+	}
+
+	l := lexer.NewVersion(input, v)
+	p := parser.New(l)
+	px := p.ParseXPath()
+	if len(p.Errors()) != 0 {
+		errs := make([]error, len(p.Errors()))
+		for i, err := range p.Errors() {
+			errs[i] = newSyntaxError(input, err)
+		}
+		return nil, errs
+	}
+
+	expr := &Expr{xpath: input, ast: px}
+	exprCache.Add(key, expr)
+
+	return expr, nil
+}
+
+// Evaluate evaluates the compiled expression against doc with a new context.
+// doc can be nil if the expression does not refer to a document.
+// The result can be converted with the methods of XPath such as Data or NodeAll.
+func (e *Expr) Evaluate(doc *html.Node, opts *Options) *XPath {
+	return e.EvaluateContext(context.Background(), doc, opts)
+}
+
+// EvaluateContext is like Evaluate but stops the evaluation when goctx is canceled as in XPath.SetContext.
+func (e *Expr) EvaluateContext(goctx context.Context, doc *html.Node, opts *Options) *XPath {
+	ctx := object.NewContext()
+	var limits Limits
+	var errs []error
+	if opts != nil {
+		limits = opts.Limits
+		ctx.Index = opts.Index
+		ctx.Loader = opts.Loader
+		ctx.Encoding = opts.Encoding
+		ctx.XML = opts.XML
+		ctx.DecimalFormats = opts.DecimalFormats
+		ctx.Trace = opts.Trace
+		for prefix, uri := range opts.Namespaces {
+			if err := setNamespace(ctx, prefix, uri); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		setFunctions(ctx, opts.Functions)
+		for name, value := range opts.Vars {
+			if err := setVar(ctx, name, value); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if doc != nil {
+		docNode := &object.BaseNode{}
+		docNode.SetTree(doc)
+		ctx.Doc = docNode
+		ctx.CNode = []object.Node{docNode}
+	}
+
+	x := &XPath{xpath: e.xpath, context: ctx, errors: errs, goctx: goctx, limits: limits}
+	if len(errs) == 0 {
+		x.eval(e)
+	}
+	return x
+}
+
+// String returns the source of the compiled expression
+func (e *Expr) String() string {
+	return e.xpath
+}
+
+// eval evaluates a compiled expression with the context of x and save the result to evaled field.
+func (x *XPath) eval(e *Expr) object.Item {
+	x.context.Guard = nil
+	if (x.goctx != nil && x.goctx.Done() != nil) || x.limits != (Limits{}) {
+		x.context.Guard = object.NewGuard(x.goctx, x.limits)
+	}
+
+	item := eval.Eval(e.ast, x.context)
+	if bif.IsError(item) {
+		x.errors = append(x.errors, newEvalError(e.xpath, item.(*object.Error)))
+		return nil
+	}
+	x.evaled = item
+	return item
+}
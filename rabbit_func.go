@@ -0,0 +1,419 @@
+package rabbit
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/zzossig/rabbit/bif"
+	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/util"
+	"golang.org/x/net/html"
+)
+
+// reservedPrefixes are the prefixes of the built-in functions, types and error codes, they cannot be bound in a FunctionRegistry
+var reservedPrefixes = map[string]bool{
+	"fn": true, "math": true, "map": true, "array": true, "xs": true, "err": true,
+}
+
+var (
+	contextType = reflect.TypeOf((*object.Context)(nil))
+	itemType    = reflect.TypeOf((*object.Item)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	nodeType    = reflect.TypeOf((*html.Node)(nil))
+)
+
+// FunctionRegistry is a set of go functions that can be called in expressions in addition to the built-in functions.
+// A registry is attached to an XPath with SetFunctions or to an evaluation of a compiled expression with Options.
+// It is safe to register functions while the registry is used by other goroutines.
+type FunctionRegistry struct {
+	mu         sync.Mutex
+	namespaces map[string]string
+	funcs      map[string]object.Func
+	arities    map[string][]int
+}
+
+// NewFunctionRegistry creates an empty function registry
+func NewFunctionRegistry() *FunctionRegistry {
+	return &FunctionRegistry{
+		namespaces: map[string]string{},
+		funcs:      map[string]object.Func{},
+		arities:    map[string][]int{},
+	}
+}
+
+// Bind binds a namespace prefix to a namespace uri.
+// A function can be called with the prefix such as my:slugify("a b"), or looked up with the uri
+// such as function-lookup("Q{http://example.com/my}slugify", 1).
+func (r *FunctionRegistry) Bind(prefix, uri string) error {
+	if !util.IsNCName(prefix) {
+		return fmt.Errorf("invalid namespace prefix: %q", prefix)
+	}
+	if reservedPrefixes[prefix] {
+		return fmt.Errorf("namespace prefix is reserved: %s", prefix)
+	}
+	if uri == "" {
+		return fmt.Errorf("namespace uri of the prefix %s is empty", prefix)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	namespaces := make(map[string]string, len(r.namespaces)+1)
+	for p, u := range r.namespaces {
+		namespaces[p] = u
+	}
+	namespaces[prefix] = uri
+	r.namespaces = namespaces
+	return nil
+}
+
+// Register adds a function with the prefixed name such as my:slugify.
+// The function accepts min to max arguments, a negative max means that the number of arguments is unbounded.
+func (r *FunctionRegistry) Register(name string, min, max int, f object.Func) error {
+	if f == nil {
+		return fmt.Errorf("function %s is nil", name)
+	}
+	if min < 0 || (max >= 0 && min > max) {
+		return fmt.Errorf("invalid arity range of function %s: %d..%d", name, min, max)
+	}
+
+	fn := func(ctx *object.Context, args ...object.Item) object.Item {
+		if len(args) < min {
+			return bif.NewCodedError("XPST0017", "too few parameters for function call: %s", name)
+		}
+		if max >= 0 && len(args) > max {
+			return bif.NewCodedError("XPST0017", "too many parameters for function call: %s", name)
+		}
+		return f(ctx, args...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := strings.Index(name, ":")
+	if i < 0 || !util.IsQName(name) {
+		return fmt.Errorf("function name must be a prefixed name such as my:%s", name)
+	}
+	if _, ok := r.namespaces[name[:i]]; !ok {
+		return fmt.Errorf("namespace prefix is not bound: %s", name[:i])
+	}
+
+	funcs := make(map[string]object.Func, len(r.funcs)+1)
+	for n, f := range r.funcs {
+		funcs[n] = f
+	}
+	funcs[name] = fn
+	r.funcs = funcs
+
+	arities := make(map[string][]int, len(r.arities)+1)
+	for n, a := range r.arities {
+		arities[n] = a
+	}
+	arities[name] = arityList(min, max)
+	r.arities = arities
+	return nil
+}
+
+// RegisterFunc adds a plain go function such as func(string) int with the prefixed name.
+// The arguments are converted to the parameter types of fn, and the result is converted as in XPath.SetVar.
+// An integer argument out of the range of an integer parameter such as uint8 and an unsigned result larger than xs:integer are errors.
+// fn can take *object.Context as the first parameter, and can return an error as the last result.
+// The arity of the function is the number of the other parameters, a variadic fn accepts any number of arguments.
+func (r *FunctionRegistry) RegisterFunc(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("function %s is not a go function: %T", name, fn)
+	}
+	t := v.Type()
+
+	offset := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		offset = 1
+	}
+	switch {
+	case t.NumOut() > 2:
+		return fmt.Errorf("function %s has too many results", name)
+	case t.NumOut() == 2 && t.Out(1) != errorType:
+		return fmt.Errorf("the second result of function %s must be an error", name)
+	}
+
+	min, max := t.NumIn()-offset, t.NumIn()-offset
+	if t.IsVariadic() {
+		min, max = min-1, -1
+	}
+
+	return r.Register(name, min, max, func(ctx *object.Context, args ...object.Item) object.Item {
+		var in []reflect.Value
+		if offset == 1 {
+			in = append(in, reflect.ValueOf(ctx))
+		}
+		for i, arg := range args {
+			pt := paramType(t, i+offset)
+			pv, err := convertArg(arg, pt)
+			if err != nil {
+				return bif.NewCodedError("XPTY0004", "%s: argument %d: %v", name, i+1, err)
+			}
+			in = append(in, pv)
+		}
+
+		out := v.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			err := out[1].Interface().(error)
+			e := bif.NewCodedError("FOER0000", "%s: %v", name, err)
+			e.Err = err
+			return e
+		}
+		if len(out) == 0 || (len(out) == 2 && t.Out(0) == errorType) {
+			return bif.NewSequence()
+		}
+
+		item, err := convertResult(out[0])
+		if err != nil {
+			return bif.NewCodedError("XPTY0004", "%s: %v", name, err)
+		}
+		return item
+	})
+}
+
+// arityList lists the numbers of arguments from min to max like the arities of the built-in functions
+func arityList(min, max int) []int {
+	if max < 0 {
+		return []int{min, -1}
+	}
+	a := make([]int, 0, max-min+1)
+	for n := min; n <= max; n++ {
+		a = append(a, n)
+	}
+	return a
+}
+
+// functions returns the registered functions, their arities and namespaces, they are replaced rather than modified on registration
+func (r *FunctionRegistry) functions() (map[string]object.Func, map[string][]int, map[string]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.funcs, r.arities, r.namespaces
+}
+
+// SetFunctions attaches a function registry, the functions in it can be called in the following Eval calls.
+func (x *XPath) SetFunctions(r *FunctionRegistry) *XPath {
+	setFunctions(x.context, r)
+	return x
+}
+
+// setFunctions sets the functions of the registry to the context.
+// The namespaces of the registry are added to the namespaces bound with SetNamespace.
+func setFunctions(ctx *object.Context, r *FunctionRegistry) {
+	if r == nil {
+		ctx.Functions = nil
+		ctx.Arities = nil
+		return
+	}
+
+	funcs, arities, namespaces := r.functions()
+	ctx.Functions = funcs
+	ctx.Arities = arities
+	for prefix, uri := range namespaces {
+		bindNamespace(ctx, prefix, uri)
+	}
+}
+
+// SetNamespace binds a namespace prefix to a namespace uri, the prefix can be used in name tests such as my:item.
+// The prefixes html, svg, mathml, xlink, xml and xmlns are bound by default, a bound prefix replaces the default one.
+func (x *XPath) SetNamespace(prefix, uri string) *XPath {
+	if err := setNamespace(x.context, prefix, uri); err != nil {
+		x.errors = append(x.errors, newError(err))
+	}
+	return x
+}
+
+func setNamespace(ctx *object.Context, prefix, uri string) error {
+	if !util.IsNCName(prefix) {
+		return fmt.Errorf("invalid namespace prefix: %q", prefix)
+	}
+	if reservedPrefixes[prefix] {
+		return fmt.Errorf("namespace prefix is reserved: %s", prefix)
+	}
+	bindNamespace(ctx, prefix, uri)
+	return nil
+}
+
+// bindNamespace replaces the namespaces map of the context rather than modifying it,
+// since the map is shared with the contexts copied from ctx.
+func bindNamespace(ctx *object.Context, prefix, uri string) {
+	namespaces := make(map[string]string, len(ctx.Namespaces)+1)
+	for p, u := range ctx.Namespaces {
+		namespaces[p] = u
+	}
+	namespaces[prefix] = uri
+	ctx.Namespaces = namespaces
+}
+
+// paramType returns the type of the i-th parameter, the element type is returned for the variadic parameter
+func paramType(t reflect.Type, i int) reflect.Type {
+	if t.IsVariadic() && i >= t.NumIn()-1 {
+		return t.In(t.NumIn() - 1).Elem()
+	}
+	return t.In(i)
+}
+
+// convertArg converts an argument to a value of type t.
+// Nodes are atomized, xs:untypedAtomic values are cast and numeric values are promoted like the function coercion rules.
+func convertArg(item object.Item, t reflect.Type) (reflect.Value, error) {
+	if t == itemType {
+		return reflect.ValueOf(&item).Elem(), nil
+	}
+
+	if t.Kind() == reflect.Slice && t != reflect.TypeOf([]byte(nil)) {
+		var items []object.Item
+		switch item := item.(type) {
+		case *object.Sequence:
+			items = item.Items
+		case *object.Array:
+			items = item.Items
+		default:
+			items = []object.Item{item}
+		}
+
+		slice := reflect.MakeSlice(t, 0, len(items))
+		for _, i := range items {
+			v, err := convertArg(i, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			slice = reflect.Append(slice, v)
+		}
+		return slice, nil
+	}
+
+	items := bif.UnwrapSeq(item)
+	switch len(items) {
+	case 0:
+		if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface || t.Kind() == reflect.Map {
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("an empty sequence cannot be converted to %s", t)
+	case 1:
+		item = items[0]
+	default:
+		return reflect.Value{}, fmt.Errorf("a sequence of %d items cannot be converted to %s", len(items), t)
+	}
+
+	if t == nodeType {
+		switch item := item.(type) {
+		case *object.BaseNode:
+			return reflect.ValueOf(item.Self()), nil
+		case *object.AttrNode:
+			return reflect.ValueOf(item.Self()), nil
+		}
+		return reflect.Value{}, fmt.Errorf("%s cannot be converted to %s", item.Type(), t)
+	}
+
+	if bif.IsNode(item) {
+		switch t.Kind() {
+		case reflect.String, reflect.Interface:
+			item = bif.CastType(item, object.StringType)
+		default:
+			item = bif.CastType(item, object.DoubleType)
+		}
+		if bif.IsError(item) {
+			return reflect.Value{}, fmt.Errorf("%s", item.(*object.Error).Message)
+		}
+	}
+
+	var v interface{}
+	switch item := item.(type) {
+	case *object.String:
+		if t.Kind() == reflect.String || t.Kind() == reflect.Interface {
+			v = item.Value()
+		}
+	case *object.Boolean:
+		if t.Kind() == reflect.Bool || t.Kind() == reflect.Interface {
+			v = item.Value()
+		}
+	case *object.Integer:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if reflect.Zero(t).OverflowInt(int64(item.Value())) {
+				return reflect.Value{}, fmt.Errorf("%d is out of the range of %s", item.Value(), t)
+			}
+			v = item.Value()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if item.Value() < 0 || reflect.Zero(t).OverflowUint(uint64(item.Value())) {
+				return reflect.Value{}, fmt.Errorf("%d is out of the range of %s", item.Value(), t)
+			}
+			v = uint64(item.Value())
+		case reflect.Float32, reflect.Float64, reflect.Interface:
+			v = item.Value()
+		}
+	case *object.Decimal:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 || t.Kind() == reflect.Interface {
+			v = item.Value()
+		}
+	case *object.Double:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 || t.Kind() == reflect.Interface {
+			v = item.Value()
+		}
+	default:
+		if t.Kind() == reflect.Interface {
+			d, err := convert(item)
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v = d
+		}
+	}
+	if v == nil {
+		return reflect.Value{}, fmt.Errorf("%s cannot be converted to %s", item.Type(), t)
+	}
+
+	rv := reflect.ValueOf(v)
+	if t.Kind() == reflect.Interface {
+		if !rv.Type().Implements(t) {
+			return reflect.Value{}, fmt.Errorf("%s cannot be converted to %s", item.Type(), t)
+		}
+		return rv, nil
+	}
+	return rv.Convert(t), nil
+}
+
+// convertResult converts a result of a go function to an item.
+// Slices and maps other than []interface{} and map[string]interface{} are converted element by element.
+func convertResult(v reflect.Value) (object.Item, error) {
+	switch v.Kind() {
+	case reflect.Int8, reflect.Int16:
+		return bif.NewInteger(int(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return convertUint(v.Uint())
+	case reflect.Slice:
+		if v.Type() == reflect.TypeOf([]*html.Node(nil)) || v.Type() == reflect.TypeOf([]interface{}(nil)) {
+			break
+		}
+		array := &object.Array{}
+		for i := 0; i < v.Len(); i++ {
+			item, err := convertResult(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			array.Items = append(array.Items, item)
+		}
+		return array, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type() == reflect.TypeOf(map[string]interface{}(nil)) {
+			break
+		}
+		pairs := make(map[object.HashKey]object.Pair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := convertResult(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			key := bif.NewString(iter.Key().String())
+			pairs[key.HashKey()] = object.Pair{Key: key, Value: value}
+		}
+		return &object.Map{Pairs: pairs}, nil
+	}
+	return convertValue(v.Interface())
+}
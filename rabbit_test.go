@@ -1,8 +1,22 @@
 package rabbit
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/zzossig/rabbit/loader"
+	"github.com/zzossig/rabbit/object"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
 )
 
 func TestXPath(t *testing.T) {
@@ -39,9 +53,13 @@ func TestXPath(t *testing.T) {
 		t.Errorf("expected='Hello, World!', got=%s", node2.Data)
 	}
 
-	resp, err := http.Get("https://golang.org/")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><head><title>The Go Programming Language</title></head></html>")
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
 	if err != nil {
-		t.Errorf(err.Error())
+		t.Fatal(err)
 	}
 	node := New().SetDocR(resp).Eval("//title/text()").Node()
 	if node.Data != "The Go Programming Language" {
@@ -65,6 +83,928 @@ func TestXPath(t *testing.T) {
 	}
 }
 
+func TestCompile(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<ul><li class="a">1</li><li>2</li><li class="a">3</li></ul>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`//li[@class="a"]/text()`, []string{"1", "3"}},
+		{`count(//li)`, []string{"3"}},
+		{`//li ! upper-case(concat("x", .))`, []string{"X1", "X2", "X3"}},
+		{`(//li => count()) + 1`, []string{"4"}},
+		{`for-each(//li, string#1)`, []string{"1", "2", "3"}},
+		{`//li[2]/../li[3]/text()`, []string{"3"}},
+	}
+
+	for _, tt := range tests {
+		expr := MustCompile(tt.input)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				x := expr.Evaluate(doc, nil)
+				if len(x.Errors()) > 0 {
+					t.Errorf("unexpected errors for %s: %v", tt.input, x.Errors())
+					return
+				}
+				if got := strings.Join(x.GetAll(), ","); got != strings.Join(tt.expected, ",") {
+					t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, got, strings.Join(tt.expected, ","))
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	if _, err := Compile("(1, 2"); err == nil {
+		t.Errorf("expected an error for (1, 2")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("MustCompile should panic for an invalid expression")
+			}
+		}()
+		MustCompile("[1, ")
+	}()
+
+	expr := MustCompile("1 + 1")
+	if again := MustCompile("1 + 1"); again != expr {
+		t.Errorf("compiled expression should be cached")
+	}
+	if data := expr.Evaluate(nil, nil).Data(); data != 2 {
+		t.Errorf("result value should be 2. got=%v", data)
+	}
+
+	// a full cache evicts the least recently used expression and keeps caching the new ones
+	for i := 0; i < exprCacheSize; i++ {
+		MustCompile(fmt.Sprintf("%d + 1", i))
+		MustCompile("1 + 1")
+	}
+	if n := exprCache.Len(); n != exprCacheSize {
+		t.Errorf("wrong size of the full cache. got=%d, expected=%d", n, exprCacheSize)
+	}
+	if again := MustCompile("1 + 1"); again != expr {
+		t.Errorf("recently used expression should stay in the full cache")
+	}
+	first := MustCompile("0 + 1")
+	last := MustCompile(fmt.Sprintf("%d + 1", exprCacheSize+1))
+	if again := MustCompile(fmt.Sprintf("%d + 1", exprCacheSize+1)); again != last {
+		t.Errorf("new expression should be cached in the full cache")
+	}
+	if again := MustCompile("0 + 1"); again != first {
+		t.Errorf("re-compiled expression should be cached in the full cache")
+	}
+
+	df := object.NewDecimalFormat()
+	df.DecimalSeparator, df.GroupingSeparator = ',', '.'
+	opts := &Options{DecimalFormats: map[string]*object.DecimalFormat{"de": df}}
+	if got := MustCompile(`format-number(1234.5, "#.##0,0", "de")`).Evaluate(nil, opts).Get(); got != "1.234,5" {
+		t.Errorf("expected=1.234,5, got=%s", got)
+	}
+}
+
+func TestTemporalData(t *testing.T) {
+	at := time.Date(2021, 3, 4, 10, 20, 30, 0, time.FixedZone("", 9*3600))
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`xs:dateTime("2021-03-04T10:20:30+09:00")`, at},
+		{`xs:date("2021-03-04")`, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{`xs:dayTimeDuration("P1DT2H")`, 26 * time.Hour},
+		{`xs:duration("PT1M")`, time.Minute},
+		{`xs:yearMonthDuration("P1Y2M")`, "P1Y2M"},
+	}
+
+	for _, tt := range tests {
+		x := New().Eval(tt.input)
+		got := x.Data()
+		if tm, ok := got.(time.Time); ok {
+			if want, ok := tt.expected.(time.Time); !ok || !tm.Equal(want) || tm.Format(time.RFC3339) != want.Format(time.RFC3339) {
+				t.Errorf("wrong value for %s. got=%v, expected=%v", tt.input, got, tt.expected)
+			}
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("wrong value for %s. got=%v (%T), expected=%v, errors=%v", tt.input, got, got, tt.expected, x.Errors())
+		}
+	}
+
+	items := New().Evals(`(xs:date("2021-03-04"), xs:dayTimeDuration("PT1H"))`)
+	if len(items) != 2 || items[0].Get() != "2021-03-04" || items[1].Data() != time.Hour {
+		t.Errorf("wrong xpath objects for temporal items. got=%d", len(items))
+	}
+}
+
+func TestSetVar(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<ul><li>5</li><li>10</li><li>15</li></ul>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		input    string
+		expected string
+	}{
+		{"min", 10, `//li[number(.) >= $min]/text()`, "10,15"},
+		{"$min", 10, `$min * 2`, "20"},
+		{"ratio", 0.5, `$ratio * 4 = 2`, "true"},
+		{"count", "10", `count(//li[. = $count])`, "1"},
+		{"flag", true, `if ($flag) then "yes" else "no"`, "yes"},
+		{"list", []interface{}{1, "a", false}, `array:size($list)`, "3"},
+		{"list", []interface{}{1, "a", false}, `$list(2)`, "a"},
+		{"conf", map[string]interface{}{"max": 15}, `//li[number(.) = $conf?max]/text()`, "15"},
+		{"node", doc.FirstChild, `count($node//li)`, "3"},
+		{"empty", nil, `empty($empty)`, "true"},
+		{"at", time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC), `year-from-dateTime($at)`, "2021"},
+		{"wait", 90 * time.Minute, `$wait`, "PT1H30M"},
+		{"n", uint(10), `$n * 2`, "20"},
+		{"n", uint64(10), `$n instance of xs:integer`, "true"},
+		{"n", uint8(255), `$n + 1`, "256"},
+		{"n", int16(-3), `abs($n)`, "3"},
+	}
+
+	for _, tt := range tests {
+		x := New().SetDocN(doc).SetVar(tt.name, tt.value).Eval(tt.input)
+		if len(x.Errors()) > 0 {
+			t.Errorf("unexpected errors for %s: %v", tt.input, x.Errors())
+			continue
+		}
+		if got := strings.Join(x.GetAll(), ","); got != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, got, tt.expected)
+		}
+	}
+
+	x := New().SetVars(map[string]interface{}{"a": 1, "b": 2}).Eval(`$a + $b`)
+	if got := x.Get(); got != "3" {
+		t.Errorf("expected=3, got=%s", got)
+	}
+
+	opts := &Options{Vars: map[string]interface{}{"a": "x"}}
+	if got := MustCompile(`concat($a, "y")`).Evaluate(nil, opts).Get(); got != "xy" {
+		t.Errorf("expected=xy, got=%s", got)
+	}
+
+	if errs := New().SetVar("a", struct{}{}).Errors(); len(errs) != 1 {
+		t.Errorf("expected an error for an unsupported value. got=%v", errs)
+	}
+	if errs := New().SetVar("a", uint64(math.MaxUint64)).Errors(); len(errs) != 1 {
+		t.Errorf("expected an error for a value out of the xs:integer range. got=%v", errs)
+	}
+	if errs := New().SetVar("1a", 1).Errors(); len(errs) != 1 {
+		t.Errorf("expected an error for an invalid name. got=%v", errs)
+	}
+
+	errs := New().Eval(`$undeclared + 1`).Errors()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "XPST0008") {
+		t.Errorf("expected XPST0008 for an undeclared variable. got=%v", errs)
+	}
+}
+
+func TestFunctionRegistry(t *testing.T) {
+	r := NewFunctionRegistry()
+	if err := r.Bind("my", "http://example.com/my"); err != nil {
+		t.Fatal(err)
+	}
+
+	slugify := func(s string) string {
+		return strings.ToLower(strings.Join(strings.Fields(s), "-"))
+	}
+	regs := []struct {
+		name string
+		fn   interface{}
+	}{
+		{"my:slugify", slugify},
+		{"my:len", func(s string) int { return len(s) }},
+		{"my:sum", func(ns ...float64) float64 {
+			sum := 0.0
+			for _, n := range ns {
+				sum += n
+			}
+			return sum
+		}},
+		{"my:words", func(s string) []string { return strings.Fields(s) }},
+		{"my:tag", func(n *html.Node) string { return n.Data }},
+		{"my:fail", func(s string) (string, error) { return "", fmt.Errorf("failed with %s", s) }},
+		{"my:items", func(items []object.Item) int { return len(items) }},
+		{"my:double", func(n uint) uint64 { return 2 * uint64(n) }},
+		{"my:byte", func(b uint8) int8 { return int8(b) }},
+		{"my:max", func() uint64 { return math.MaxUint64 }},
+	}
+	for _, reg := range regs {
+		if err := r.RegisterFunc(reg.name, reg.fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := r.Register("my:first", 1, 2, func(ctx *object.Context, args ...object.Item) object.Item {
+		return args[0]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := html.Parse(strings.NewReader(`<ul><li>Hello  World</li><li>Foo Bar</li></ul>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`my:slugify(//li[1])`, "hello-world"},
+		{`//li ! my:slugify(.)`, "hello-world,foo-bar"},
+		{`my:len("abc") + 1`, "4"},
+		{`my:sum() = 0`, "true"},
+		{`my:sum(1, 2.5, 3e0) = 6.5`, "true"},
+		{`array:size(my:words("a b c"))`, "3"},
+		{`my:tag(//li[2])`, "li"},
+		{`my:items((1, 2, 3))`, "3"},
+		{`my:first("a", "b")`, "a"},
+		{`my:slugify#1("A B")`, "a-b"},
+		{`for-each(("A B", "C D"), my:slugify#1)`, "a-b,c-d"},
+		{`(my:first(?, "b"))("a")`, "a"},
+		{`"X Y" => my:slugify()`, "x-y"},
+		{`(function-lookup("Q{http://example.com/my}slugify", 1))("Q R")`, "q-r"},
+		{`function-name(my:slugify#1)`, "my:slugify"},
+		{`my:first#2("a", "b")`, "a"},
+		{`my:sum#4(1, 2, 3, 4) = 10`, "true"},
+		{`empty(function-lookup("Q{http://example.com/my}first", 3))`, "true"},
+		{`upper-case("a")`, "A"},
+		{`my:double(21)`, "42"},
+		{`my:byte(127)`, "127"},
+	}
+
+	for _, tt := range tests {
+		x := New().SetDocN(doc).SetFunctions(r).Eval(tt.input)
+		if len(x.Errors()) > 0 {
+			t.Errorf("unexpected errors for %s: %v", tt.input, x.Errors())
+			continue
+		}
+		if got := strings.Join(x.GetAll(), ","); got != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, got, tt.expected)
+		}
+	}
+
+	errors := []string{
+		`my:slugify()`,
+		`my:slugify("a", "b")`,
+		`my:first()`,
+		`my:len(1)`,
+		`my:len(("a", "b"))`,
+		`my:fail("x")`,
+		`my:unknown(1)`,
+		`my:double(-1)`,
+		`my:byte(256)`,
+		`my:max()`,
+	}
+
+	for _, input := range errors {
+		if errs := New().SetFunctions(r).Eval(input).Errors(); len(errs) == 0 {
+			t.Errorf("expected an error for %s", input)
+		}
+	}
+
+	for _, input := range []string{`my:first#3`, `my:first#0`, `my:slugify#2`, `upper-case#5`} {
+		errs := New().SetFunctions(r).Eval(input).Errors()
+		if len(errs) != 1 {
+			t.Errorf("expected an error for %s, got=%v", input, errs)
+			continue
+		}
+		if e, ok := errs[0].(*Error); !ok || e.Code != "err:XPST0017" {
+			t.Errorf("expected err:XPST0017 for %s, got=%v", input, errs)
+		}
+	}
+
+	if errs := New().Eval(`my:slugify("a")`).Errors(); len(errs) == 0 {
+		t.Errorf("functions should not be visible without the registry")
+	}
+
+	opts := &Options{Functions: r}
+	if got := MustCompile(`my:slugify("Go Go")`).Evaluate(nil, opts).Get(); got != "go-go" {
+		t.Errorf("expected=go-go, got=%s", got)
+	}
+
+	if err := r.Bind("fn", "http://example.com/fn"); err == nil {
+		t.Errorf("expected an error for the reserved prefix")
+	}
+	if err := r.RegisterFunc("other:f", slugify); err == nil {
+		t.Errorf("expected an error for the unbound prefix")
+	}
+	if err := r.RegisterFunc("slugify", slugify); err == nil {
+		t.Errorf("expected an error for the unprefixed name")
+	}
+	if err := r.RegisterFunc("my:f", 1); err == nil {
+		t.Errorf("expected an error for a value that is not a function")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<body>
+<path d="custom"></path>
+<svg><path d="M0 0"/><a xlink:href="#top"><text>t</text></a></svg>
+<math><mi>x</mi></math>
+</body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`//svg:path/@d`, "M0 0"},
+		{`//html:path/@d`, "custom"},
+		{`//path/@d`, "custom,M0 0"},
+		{`//*:path/@d`, "custom,M0 0"},
+		{`count(//svg:*)`, "4"},
+		{`count(//mathml:*)`, "2"},
+		{`//Q{http://www.w3.org/2000/svg}path/@d`, "M0 0"},
+		{`count(//Q{http://www.w3.org/1998/Math/MathML}*)`, "2"},
+		{`//@xlink:href`, "#top"},
+		{`//svg:a/@*:href`, "#top"},
+		{`//svg:path ! (name(), local-name(), namespace-uri())`, "path,path,http://www.w3.org/2000/svg"},
+		{`//@xlink:href ! (name(.), local-name(.), namespace-uri(.))`, "xlink:href,href,http://www.w3.org/1999/xlink"},
+		{`namespace-uri(//body)`, "http://www.w3.org/1999/xhtml"},
+		{`namespace-uri(//html:path/@d)`, ""},
+		{`local-name(())`, ""},
+		{`count(//s:path)`, "1"},
+		{`//element(svg:path)/@d`, "M0 0"},
+		{`//element(html:path)/@d`, "custom"},
+		{`//element(path)/@d`, "custom,M0 0"},
+		{`count(//element(s:path))`, "1"},
+		{`//attribute(xlink:href)`, "#top"},
+		{`//svg:a/attribute(href)`, "#top"},
+		{`//svg:path instance of element(svg:path)`, "true"},
+		{`//html:path instance of element(svg:path)`, "false"},
+	}
+
+	for _, tt := range tests {
+		x := New().SetDocN(doc).SetNamespace("s", "http://www.w3.org/2000/svg").Eval(tt.input)
+		if len(x.Errors()) > 0 {
+			t.Errorf("unexpected errors for %s: %v", tt.input, x.Errors())
+			continue
+		}
+		if got := strings.Join(x.GetAll(), ","); got != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, got, tt.expected)
+		}
+	}
+
+	opts := &Options{Namespaces: map[string]string{"svg": "http://example.com/other"}}
+	if got := MustCompile(`count(//svg:path)`).Evaluate(doc, opts).Get(); got != "0" {
+		t.Errorf("a bound prefix should replace the default one. got=%s", got)
+	}
+
+	if errs := New().SetNamespace("fn", "http://example.com/fn").Errors(); len(errs) == 0 {
+		t.Errorf("expected an error for the reserved prefix")
+	}
+	if errs := New().SetNamespace("a:b", "http://example.com/ab").Errors(); len(errs) == 0 {
+		t.Errorf("expected an error for the invalid prefix")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`<body><h1>Title</h1><p class="a">one</p><p>two</p></body>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`//h2 otherwise //h1`, "Title"},
+		{"//p ! `{.}:{@class otherwise 'none'}`", "one:a,two:none"},
+		{`if (//p) { count(//p) }`, "2"},
+		{`//p =!> concat('!')`, "one!,two!"},
+		{`for-each(//p, fn { upper-case(.) })`, "ONE,TWO"},
+	}
+
+	for _, tt := range tests {
+		x := New().SetDocN(doc).SetVersion(XPath40).Eval(tt.input)
+		if len(x.Errors()) > 0 {
+			t.Errorf("unexpected errors for %s: %v", tt.input, x.Errors())
+			continue
+		}
+		if got := strings.Join(x.GetAll(), ","); got != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s", tt.input, got, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"`{1}`", `if (1) { 2 }`, `(1, 2) =!> string()`} {
+		if errs := New().Eval(input).Errors(); len(errs) == 0 {
+			t.Errorf("expected an error for %s in XPath 3.1", input)
+		}
+		if _, err := CompileVersion(input, XPath40); err != nil {
+			t.Errorf("unexpected error for %s in XPath 4.0: %v", input, err)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedCode   string
+		expectedExpr   string
+		expectedLine   int
+		expectedColumn int
+	}{
+		{`abs('a')`, "err:XPTY0004", "abs('a')", 1, 1},
+		{"let $a := 1 return\n  $a + fn:count(1, 2)", "err:XPST0017", "fn:count(1, 2)", 2, 8},
+		{`'日本' || 1 div 0`, "err:FOAR0001", "(1 div 0)", 1, 11},
+		{`$missing`, "err:XPST0008", "$missing", 1, 1},
+		{`(1, 2`, "err:XPST0003", "", 1, 3},
+		{"1 +\n  (: comment", "err:XPST0003", "", 2, 3},
+		{`1 +`, "err:XPST0003", "", 1, 4},
+		{"1 =\n  )", "err:XPST0003", "", 2, 3},
+		{`for $a in 1, return $a`, "err:XPST0003", "", 1, 14},
+		{`some $x in (1, 2) satisfies`, "err:XPST0003", "", 1, 28},
+		{"let $f := function($x as xs:integer) {$x}\nreturn $f('a')", "err:XPTY0004", "$f('a')", 2, 10},
+	}
+
+	for _, tt := range tests {
+		errs := New().Eval(tt.input).Errors()
+		if len(errs) == 0 {
+			t.Errorf("expected an error for %s", tt.input)
+			continue
+		}
+
+		var e *Error
+		if !errors.As(errs[0], &e) {
+			t.Errorf("expected *Error for %s. got=%T", tt.input, errs[0])
+			continue
+		}
+		if e.Code != tt.expectedCode {
+			t.Errorf("wrong code for %s. got=%s, expected=%s", tt.input, e.Code, tt.expectedCode)
+		}
+		if tt.expectedExpr != "" && e.Expr != tt.expectedExpr {
+			t.Errorf("wrong expression for %s. got=%s, expected=%s", tt.input, e.Expr, tt.expectedExpr)
+		}
+		if e.Line != tt.expectedLine || e.Column != tt.expectedColumn {
+			t.Errorf("wrong position for %s. got=%d:%d, expected=%d:%d", tt.input, e.Line, e.Column, tt.expectedLine, tt.expectedColumn)
+		}
+	}
+
+	_, err := Compile("concat(1,")
+	var e *Error
+	if !errors.As(err, &e) || e.Code != "err:XPST0003" {
+		t.Errorf("expected a syntax error from Compile. got=%v", err)
+	}
+
+	x := MustCompile(`//p ! xs:integer(.)`).Evaluate(nil, nil)
+	if len(x.Errors()) != 1 || !errors.As(x.Errors()[0], &e) || e.Code != "err:XPDY0002" {
+		t.Errorf("expected XPDY0002 for a path without a document. got=%v", x.Errors())
+	}
+
+	for _, errs := range [][]error{
+		func() []error { x := New().Eval(`upper-case#1`); x.DataAll(); return x.Errors() }(),
+		func() []error { x := New().Eval(`upper-case#1`); x.NodeAll(); return x.Errors() }(),
+		func() []error { x := New(); x.DataAll(); return x.Errors() }(),
+	} {
+		if len(errs) != 1 {
+			t.Errorf("expected a conversion error. got=%v", errs)
+			continue
+		}
+		if _, ok := errs[0].(*Error); !ok {
+			t.Errorf("expected *Error for a conversion error. got=%T", errs[0])
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	var sb strings.Builder
+	x := New().SetTrace(TraceWriter(&sb)).Eval(`trace(1 + 1, 'sum') * 2`)
+	if got := x.Get(); got != "4" {
+		t.Errorf("wrong value. got=%s, expected=4", got)
+	}
+	if sb.String() != "sum: 2\n" {
+		t.Errorf("wrong trace. got=%q", sb.String())
+	}
+
+	var labels []string
+	opts := &Options{Trace: func(label, value string) { labels = append(labels, label) }}
+	MustCompile(`(1, 2) ! trace(., 'item')`).Evaluate(nil, opts)
+	if strings.Join(labels, ",") != "item,item" {
+		t.Errorf("wrong trace labels. got=%v", labels)
+	}
+
+	errs := New().Eval(`error('my:invalid', 'invalid price')`).Errors()
+	var e *Error
+	if len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "my:invalid" || e.Message != "invalid price" {
+		t.Errorf("expected the error raised by fn:error. got=%v", errs)
+	}
+
+	x = New().SetVersion(XPath40).Eval(`map { 'price': try { xs:integer('n/a') } catch * { -1 } }?price`)
+	if got := x.Get(); got != "-1" {
+		t.Errorf("wrong value of try/catch. got=%s, expected=-1", got)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	x := New().SetLimits(Limits{MaxSequenceLength: 10}).Eval(`count(1 to 100)`)
+	var e *Error
+	if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "rabbit:max-sequence-length" {
+		t.Errorf("expected the sequence length limit error. got=%v", errs)
+	}
+
+	if got := New().SetLimits(Limits{MaxSequenceLength: 10}).Eval(`count(1 to 10)`).Get(); got != "10" {
+		t.Errorf("wrong value. got=%s, expected=10", got)
+	}
+
+	// the length of the range does not overflow
+	for _, input := range []string{`count(-9223372036854775807 to 9223372036854775807)`, `count(0 to 9223372036854775807)`} {
+		x = New().SetLimits(Limits{MaxSequenceLength: 100}).Eval(input)
+		if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "rabbit:max-sequence-length" {
+			t.Errorf("expected the sequence length limit error for %s. got=%v", input, errs)
+		}
+	}
+	if got := New().Eval(`9223372036854775806 to 9223372036854775807`).GetAll(); fmt.Sprint(got) != "[9223372036854775806 9223372036854775807]" {
+		t.Errorf("wrong range at the largest integer. got=%v", got)
+	}
+
+	// a long range stops at the deadline
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelDeadline()
+	start := time.Now()
+	x = New().SetContext(deadline).Eval(`count(1 to 20000000)`)
+	if errs := x.Errors(); len(errs) != 1 || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("expected the deadline error. got=%v", errs)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the range is not stopped at the deadline. took=%s", d)
+	}
+
+	// a back-reference that backtracks exponentially stops at the deadline or after the step budget
+	deadline, cancelDeadline = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancelDeadline()
+	start = time.Now()
+	x = New().SetContext(deadline).Eval(`matches("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa!", "^((a*)*)\1$")`)
+	if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "rabbit:canceled" && e.Code != "err:FORX0002" {
+		t.Errorf("expected the deadline or the step budget error. got=%v", errs)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the regular expression is not stopped at the deadline. took=%s", d)
+	}
+	x = New().Eval(`matches("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa!", "^((a*)*)\1$")`)
+	if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "err:FORX0002" {
+		t.Errorf("expected the step budget error. got=%v", errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	x = New().SetContext(ctx).Eval(`for $i in 1 to 10 return $i`)
+	if errs := x.Errors(); len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("expected the canceled error. got=%v", errs)
+	}
+
+	x = MustCompile(`sum(1 to 10)`).EvaluateContext(ctx, nil, nil)
+	if errs := x.Errors(); len(errs) != 0 {
+		t.Errorf("unexpected errors. got=%v", errs)
+	}
+
+	x = MustCompile(`for $i in 1 to 10 return $i`).EvaluateContext(ctx, nil, &Options{Limits: Limits{MaxDepth: 10}})
+	if errs := x.Errors(); len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Errorf("expected the canceled error. got=%v", errs)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := New().SetDoc("./eval/testdata/go1.html")
+	if x.Index() == nil {
+		t.Fatal("expected the document index")
+	}
+	if stats := x.Index().Stats(); stats.Nodes == 0 || stats.Bytes == 0 {
+		t.Errorf("wrong index stats. got=%+v", stats)
+	}
+	count := x.Eval("count(//a)").Get()
+
+	x = New().SetIndex(false).SetDoc("./eval/testdata/go1.html")
+	if x.Index() != nil {
+		t.Errorf("expected no document index")
+	}
+	if got := x.Eval("count(//a)").Get(); got != count {
+		t.Errorf("wrong value without the index. got=%s, expected=%s", got, count)
+	}
+	if x.SetIndex(true).Index() == nil {
+		t.Errorf("expected the document index")
+	}
+
+	doc := x.context.Doc.Tree()
+	x = MustCompile("count(//a)").Evaluate(doc, &Options{Index: NewIndex(doc)})
+	if got := x.Get(); got != count {
+		t.Errorf("wrong value with a shared index. got=%s, expected=%s", got, count)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	docs := loader.Map{
+		"https://example.com/":          "<title>Example</title><a href='/a'>a</a>",
+		"https://example.com/data.json": `{"name": "rabbit"}`,
+	}
+
+	x := New().SetLoader(docs).SetDoc("https://example.com/")
+	if got := x.Eval("//title/text()").Get(); got != "Example" {
+		t.Errorf("wrong value. got=%s, expected=Example", got)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`doc-available('https://example.com/')`, "true"},
+		{`doc-available('https://example.com/missing')`, "false"},
+		{`doc-available('./eval/testdata/company.xml')`, "false"},
+		{`(json-doc('https://example.com/data.json'))?name`, "rabbit"},
+		{`base-uri()`, "https://example.com/"},
+	}
+	for _, tt := range tests {
+		x := New().SetLoader(docs).SetDoc("https://example.com/")
+		if got := x.Eval(tt.input).Get(); got != tt.expected {
+			t.Errorf("wrong value for %s. got=%s, expected=%s, errors=%v", tt.input, got, tt.expected, x.Errors())
+		}
+	}
+
+	x = New().SetLoader(docs).SetDoc("https://example.com/missing")
+	var e *Error
+	if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "err:FODC0002" || !errors.Is(errs[0], loader.ErrNotFound) {
+		t.Errorf("expected the not found error. got=%v", errs)
+	}
+
+	x = MustCompile(`doc-available('https://example.com/')`).Evaluate(nil, &Options{Loader: docs})
+	if got := x.Get(); got != "true" {
+		t.Errorf("wrong value with the loader option. got=%s, expected=true", got)
+	}
+}
+
+func TestDoc(t *testing.T) {
+	docs := loader.Map{
+		"a.html": "<title>Rabbit</title><p>1</p><p>2</p>",
+		"b.html": "<title>Rabbit</title><p>3</p><div id=\"b\">4</div>",
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`doc('a.html')//title/text()`, []string{"Rabbit"}},
+		{`count(doc('a.html')//p)`, []string{"2"}},
+		{`doc('a.html') is doc('a.html')`, []string{"true"}},
+		{`doc('a.html') is doc('b.html')`, []string{"false"}},
+		{`doc('a.html')//title = doc('b.html')//title`, []string{"true"}},
+		{`doc('a.html')//p[1] << doc('a.html')//p[2]`, []string{"true"}},
+		{`(doc('a.html')//p[2] | doc('a.html')//p[1]) ! string()`, []string{"1", "2"}},
+		{`count(doc('a.html')//p | doc('b.html')//p)`, []string{"3"}},
+		{`(doc('a.html')//p[1] << doc('b.html')//p) != (doc('b.html')//p << doc('a.html')//p[1])`, []string{"true"}},
+		{`(doc('a.html')//p[1] >> doc('b.html')//p) = (doc('b.html')//p << doc('a.html')//p[1])`, []string{"true"}},
+		{`(doc('b.html')//p | doc('a.html')//p) ! string()`, []string{"3", "1", "2"}},
+		{`(doc('a.html')//p | doc('b.html')//title | doc('b.html')//p) ! string()`, []string{"1", "2", "Rabbit", "3"}},
+		{`for $u in ('a.html', 'b.html') return count(doc($u)//p)`, []string{"2", "1"}},
+		{`base-uri(doc('b.html'))`, []string{"b.html"}},
+		{`count(doc(()))`, []string{"0"}},
+		{`doc('b.html')/id('b')/string()`, []string{"4"}},
+	}
+	for _, tt := range tests {
+		x := MustCompile(tt.input).Evaluate(nil, &Options{Loader: docs})
+		if got := x.GetAll(); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong value for %s. got=%v, expected=%v, errors=%v", tt.input, got, tt.expected, x.Errors())
+		}
+	}
+
+	// fn:doc does not change the context document
+	x := New().SetLoader(docs).SetDoc("a.html")
+	if got := x.Eval(`count(//p[doc('b.html')//p])`).Get(); got != "2" {
+		t.Errorf("wrong value. got=%s, expected=2", got)
+	}
+	if got := x.Eval(`doc('a.html') is /`).Get(); got != "true" {
+		t.Errorf("wrong value. got=%s, expected=true", got)
+	}
+	// fn:id looks up the elements in the document of the context node
+	if got := x.Eval(`doc('b.html')/id('b')/string()`).GetAll(); fmt.Sprint(got) != "[4]" {
+		t.Errorf("wrong value. got=%v, expected=[4], errors=%v", got, x.Errors())
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	sjis, _ := japanese.ShiftJIS.NewEncoder().String("<title>日本語</title>")
+	euckr, _ := korean.EUCKR.NewEncoder().String("<title>한국어</title>")
+	docs := loader.Map{
+		"meta.html":       `<meta charset="shift_jis">` + sjis,
+		"http-equiv.html": `<meta http-equiv="Content-Type" content="text/html; charset=euc-kr">` + euckr,
+		"bom.html":        "\uFEFF<meta charset=\"shift_jis\"><title>ウサギ</title>",
+		"latin.html":      "<title>caf\xe9</title>",
+		"utf8.html":       "<title>토끼</title>",
+		"euckr.html":      euckr,
+	}
+
+	tests := []struct {
+		uri      string
+		label    string
+		title    string
+		encoding string
+	}{
+		{"meta.html", "", "日本語", "shift_jis"},
+		{"http-equiv.html", "", "한국어", "euc-kr"},
+		{"bom.html", "", "ウサギ", "utf-8"},
+		{"latin.html", "", "café", "windows-1252"},
+		{"utf8.html", "", "토끼", "utf-8"},
+		{"euckr.html", "euc-kr", "한국어", "euc-kr"},
+		{"euckr.html", "EUC-KR", "한국어", "euc-kr"},
+		{"meta.html", "utf-8", "", "utf-8"},
+	}
+	for _, tt := range tests {
+		x := New().SetLoader(docs).SetEncoding(tt.label).SetDoc(tt.uri)
+		if tt.title != "" {
+			if got := x.Eval("//title/text()").Get(); got != tt.title {
+				t.Errorf("wrong title for %s. got=%q, expected=%q, errors=%v", tt.uri, got, tt.title, x.Errors())
+			}
+		}
+		if got := x.Encoding(); got != tt.encoding {
+			t.Errorf("wrong encoding for %s. got=%q, expected=%q", tt.uri, got, tt.encoding)
+		}
+	}
+
+	x := MustCompile(`doc('euckr.html')//title/text()`).Evaluate(nil, &Options{Loader: docs, Encoding: "euc-kr"})
+	if got := x.Get(); got != "한국어" {
+		t.Errorf("wrong title with the encoding option. got=%q, errors=%v", got, x.Errors())
+	}
+
+	x = New().SetLoader(docs).SetEncoding("unknown").SetDoc("utf8.html")
+	var e *Error
+	if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "err:FODC0002" {
+		t.Errorf("expected the FODC0002 error. got=%v", errs)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=EUC-KR")
+		fmt.Fprint(w, euckr)
+	}))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x = New().SetDocR(resp)
+	if got := x.Eval("//title/text()").Get(); got != "한국어" || x.Encoding() != "euc-kr" {
+		t.Errorf("wrong title from the response. got=%q, encoding=%q", got, x.Encoding())
+	}
+
+	// the content type is kept by the cache
+	c := loader.NewCache(&loader.HTTP{Client: server.Client()}, 1)
+	for i := 0; i < 2; i++ {
+		x = New().SetLoader(c).SetDoc(server.URL)
+		if got := x.Eval("//title/text()").Get(); got != "한국어" || x.Encoding() != "euc-kr" {
+			t.Errorf("wrong title from the loader. got=%q, encoding=%q", got, x.Encoding())
+		}
+	}
+}
+
+func TestXML(t *testing.T) {
+	sjis, _ := japanese.ShiftJIS.NewEncoder().String(`<?xml version="1.0" encoding="Shift_JIS"?><title>日本語</title>`)
+	docs := loader.Map{
+		"feed.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<?xml-stylesheet href="feed.css"?>
+<!DOCTYPE Feed>
+<Feed xmlns="urn:feed" xmlns:dc="http://purl.org/dc/elements/1.1/" dc:lang="en" id="f">
+	<Entry><Title>A &amp; B</Title><dc:creator>Kim</dc:creator></Entry>
+	<Entry><Title><![CDATA[<b>C</b>]]> and D</Title><?index skip  ?></Entry>
+	<Note xmlns="">plain</Note><!-- end -->
+</Feed>`,
+		"sjis.xml":   sjis,
+		"broken.xml": "<Feed><Entry></Feed>",
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`name(/*)`, []string{"Feed"}},
+		{`count(/html)`, []string{"0"}},
+		{`count(//entry)`, []string{"0"}},
+		{`/Feed/Entry/Title/string()`, []string{"A & B", "<b>C</b> and D"}},
+		{`count(//Entry[2]/Title/text())`, []string{"1"}},
+		{`//dc:creator/text()`, []string{"Kim"}},
+		{`//Q{http://purl.org/dc/elements/1.1/}creator ! name()`, []string{"dc:creator"}},
+		{`namespace-uri(/*)`, []string{"urn:feed"}},
+		{`namespace-uri(//Note)`, []string{""}},
+		{`/*/@* ! name()`, []string{"dc:lang", "id"}},
+		{`count(/*/@xmlns)`, []string{"0"}},
+		{`/processing-instruction() ! name()`, []string{"xml-stylesheet"}},
+		{`//processing-instruction('index') ! string()`, []string{"skip  "}},
+		{`count(//processing-instruction(xml-stylesheet))`, []string{"1"}},
+		{`//processing-instruction() instance of processing-instruction()+`, []string{"true"}},
+		{`/*/namespace::* ! name()`, []string{"", "dc", "xml"}},
+		{`/*/namespace::dc ! string()`, []string{"http://purl.org/dc/elements/1.1/"}},
+		{`//Note/namespace::node() ! name()`, []string{"dc", "xml"}},
+		{`/*/namespace::* instance of namespace-node()+`, []string{"true"}},
+		{`/*/namespace::dc/.. ! name()`, []string{"Feed"}},
+		{`/*/namespace::dc << /*/@id`, []string{"true"}},
+		{`count(//comment())`, []string{"1"}},
+		{`id('f') ! name()`, []string{"Feed"}},
+		{`serialize(//Entry[1])`, []string{`<Entry><Title>A &amp; B</Title><dc:creator>Kim</dc:creator></Entry>`}},
+		{`serialize(//processing-instruction('index'))`, []string{`<?index skip  ?>`}},
+	}
+	for _, tt := range tests {
+		x := New().SetLoader(docs).SetDocXML("feed.xml").Eval(tt.input)
+		if got := x.GetAll(); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong value for %s. got=%q, expected=%q, errors=%v", tt.input, got, tt.expected, x.Errors())
+		}
+	}
+
+	// fn:doc parses xml with the option, the context document is html
+	x := MustCompile(`doc('feed.xml')/Feed/Note/text()`).Evaluate(nil, &Options{Loader: docs, XML: true})
+	if got := x.Get(); got != "plain" {
+		t.Errorf("wrong value with the xml option. got=%q, errors=%v", got, x.Errors())
+	}
+	x = New().SetLoader(docs).SetDoc("feed.xml")
+	if got := x.Eval(`count(/html/body/feed)`).Get(); got != "1" {
+		t.Errorf("the html parser is not used without the xml mode. got=%s", got)
+	}
+
+	x = New().SetLoader(docs).SetDocXML("sjis.xml")
+	if got := x.Eval(`/title/text()`).Get(); got != "日本語" || x.Encoding() != "shift_jis" {
+		t.Errorf("wrong title of the shift_jis document. got=%q, encoding=%q, errors=%v", got, x.Encoding(), x.Errors())
+	}
+
+	x = New().SetLoader(docs).SetDocXML("broken.xml")
+	var e *Error
+	if errs := x.Errors(); len(errs) != 1 || !errors.As(errs[0], &e) || e.Code != "err:FODC0002" {
+		t.Errorf("expected the FODC0002 error. got=%v", errs)
+	}
+
+	// the processing instructions and namespace nodes are not in html documents
+	x = New().SetDocS(`<?php echo 1 ?><svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	for _, input := range []string{`count(//processing-instruction())`, `count(//*/namespace::*)`} {
+		if got := x.Eval(input).Get(); got != "0" {
+			t.Errorf("wrong value for %s in the html document. got=%s", input, got)
+		}
+	}
+}
+
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		fragment   string
+		contextTag string
+		input      string
+		expected   []string
+	}{
+		{`<li>a</li><li>b</li>`, "", `/li/text()`, []string{"a", "b"}},
+		{`<li>a</li><li>b</li>`, "ul", `count(/*)`, []string{"2"}},
+		{`<li>a</li><li>b</li>`, "", `count(//body | //html)`, []string{"0"}},
+		{`Hello <b>World</b>!`, "", `/node()`, []string{"Hello ", "World", "!"}},
+		{`Hello <b>World</b>!`, "", `/b/../text()`, []string{"Hello ", "!"}},
+		{`<tr><td>1</td></tr><tr><td>2</td></tr>`, "tbody", `/tr/td/text()`, []string{"1", "2"}},
+		{`<tr><td>1</td></tr><tr><td>2</td></tr>`, "", `count(//td)`, []string{"0"}},
+		{`<td>1</td><td>2</td>`, "tr", `//td[2]/text()`, []string{"2"}},
+		{`<p id="a">1</p><p>2</p>`, "div", `id('a') is /p[1]`, []string{"true"}},
+		{`<p>1</p>`, "div", `//p/.. instance of document-node()`, []string{"true"}},
+	}
+	for _, tt := range tests {
+		x := New().SetFragment(tt.fragment, tt.contextTag)
+		if got := x.Eval(tt.input).GetAll(); fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong value for %s in %q. got=%q, expected=%q, errors=%v", tt.input, tt.fragment, got, tt.expected, x.Errors())
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	x := New().SetDoc("./eval/testdata/company_2.xml")
+	expr := MustCompile("//employee")
+	for n := 0; n < b.N; n++ {
+		expr.Evaluate(x.context.Doc.Tree(), nil).Data()
+	}
+}
+
+func BenchmarkFirst(b *testing.B) {
+	x := New().SetDoc("./eval/testdata/go1.html")
+	expr := MustCompile("(//a)[1]")
+	for n := 0; n < b.N; n++ {
+		expr.Evaluate(x.context.Doc.Tree(), nil).Node()
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	x := New().SetDoc("./eval/testdata/go1.html")
+	doc := x.context.Doc.Tree()
+	expr := MustCompile("//a | //span")
+	opts := &Options{Index: x.Index()}
+	for n := 0; n < b.N; n++ {
+		expr.Evaluate(doc, opts).NodeAll()
+	}
+}
+
 func BenchmarkXPath(b *testing.B) {
 	x := New().SetDoc("./eval/testdata/company_2.xml")
 	for n := 0; n < b.N; n++ {
@@ -2,8 +2,12 @@ package rabbit
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/zzossig/rabbit/bif"
 	"github.com/zzossig/rabbit/object"
+	"github.com/zzossig/rabbit/util"
 	"golang.org/x/net/html"
 )
 
@@ -19,6 +23,14 @@ func convert(item object.Item) (interface{}, error) {
 		return item.Value(), nil
 	case *object.String:
 		return item.Value(), nil
+	case object.DateTimeItem:
+		return item.Value(), nil
+	case object.DurationItem:
+		// time.Duration has no months, so a duration with months is its lexical form
+		if item.Months() != 0 {
+			return item.Inspect(), nil
+		}
+		return item.Duration(), nil
 	case *object.BaseNode:
 		return item.Tree(), nil
 	case *object.AttrNode:
@@ -73,6 +85,116 @@ func convertSequence(s *object.Sequence) ([]interface{}, error) {
 	return ss, nil
 }
 
+// convertValue converts a golang value to object.Item, it is the reverse of convert.
+// nil is an empty sequence, a slice is an array and a map is a map with xs:string keys.
+func convertValue(v interface{}) (object.Item, error) {
+	switch v := v.(type) {
+	case nil:
+		return bif.NewSequence(), nil
+	case object.Item:
+		return v, nil
+	case int:
+		return bif.NewInteger(v), nil
+	case int8:
+		return bif.NewInteger(int(v)), nil
+	case int16:
+		return bif.NewInteger(int(v)), nil
+	case int32:
+		return bif.NewInteger(int(v)), nil
+	case int64:
+		return bif.NewInteger(int(v)), nil
+	case uint:
+		return convertUint(uint64(v))
+	case uint8:
+		return bif.NewInteger(int(v)), nil
+	case uint16:
+		return bif.NewInteger(int(v)), nil
+	case uint32:
+		return convertUint(uint64(v))
+	case uint64:
+		return convertUint(v)
+	case float32:
+		return bif.NewDouble(float64(v)), nil
+	case float64:
+		return bif.NewDouble(v), nil
+	case string:
+		return bif.NewString(v), nil
+	case bool:
+		return bif.NewBoolean(v), nil
+	case time.Time:
+		return bif.NewDateTime(v, true), nil
+	case time.Duration:
+		return bif.NewDayTimeDuration(v), nil
+	case *html.Node:
+		if v == nil {
+			return bif.NewSequence(), nil
+		}
+		node := &object.BaseNode{}
+		node.SetTree(v)
+		return node, nil
+	case []*html.Node:
+		seq := bif.NewSequence()
+		for _, n := range v {
+			node := &object.BaseNode{}
+			node.SetTree(n)
+			seq.Items = append(seq.Items, node)
+		}
+		return seq, nil
+	case []interface{}:
+		array := &object.Array{}
+		for _, i := range v {
+			item, err := convertValue(i)
+			if err != nil {
+				return nil, err
+			}
+			array.Items = append(array.Items, item)
+		}
+		return array, nil
+	case []string:
+		array := &object.Array{}
+		for _, i := range v {
+			array.Items = append(array.Items, bif.NewString(i))
+		}
+		return array, nil
+	case map[string]interface{}:
+		pairs := make(map[object.HashKey]object.Pair, len(v))
+		for k, i := range v {
+			value, err := convertValue(i)
+			if err != nil {
+				return nil, err
+			}
+			key := bif.NewString(k)
+			pairs[key.HashKey()] = object.Pair{Key: key, Value: value}
+		}
+		return &object.Map{Pairs: pairs}, nil
+	}
+	return nil, fmt.Errorf("cannot convert value of type %T", v)
+}
+
+// convertUint converts an unsigned integer to xs:integer, which is an int, a larger value is an error
+func convertUint(v uint64) (object.Item, error) {
+	n := int(v)
+	if n < 0 || uint64(n) != v {
+		return nil, fmt.Errorf("%d is out of the range of xs:integer", v)
+	}
+	return bif.NewInteger(n), nil
+}
+
+// setVar converts value to an item and saves it in ctx, the name can have a leading $
+func setVar(ctx *object.Context, name string, value interface{}) error {
+	name = strings.TrimPrefix(name, "$")
+	if !util.IsQName(name) {
+		return fmt.Errorf("invalid variable name: %q", name)
+	}
+
+	item, err := convertValue(value)
+	if err != nil {
+		return fmt.Errorf("cannot set variable $%s: %v", name, err)
+	}
+	ctx.Set(name, item)
+	return nil
+}
+
 func convertNode(item object.Item) ([]*html.Node, error) {
 	switch item := item.(type) {
 	case *object.Sequence:
@@ -165,15 +287,15 @@ func initContext(ctx *object.Context) {
 	ctx.CNode = []object.Node{}
 }
 
+// copyContext makes a context that keeps the document, static context and variables of ctx
 func copyContext(ctx *object.Context) *object.Context {
-	c := object.NewContext()
-	c.Doc = ctx.Doc
+	c := object.NewEnclosedContext(ctx)
+	initContext(c)
 	return c
 }
 
 func copyContextN(ctx *object.Context, n object.Node) *object.Context {
-	c := object.NewContext()
-	c.Doc = ctx.Doc
+	c := copyContext(ctx)
 	c.CNode = append(c.CNode, n)
 	return c
 }
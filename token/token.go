@@ -6,6 +6,7 @@ import "strings"
 type Token struct {
 	Type    Type
 	Literal string
+	Pos     int // byte offset of the token in the input
 }
 
 // Type represents Token Type
@@ -37,6 +38,7 @@ const (
 	GE         Type = ">="
 	EQ         Type = "="
 	ARROW      Type = "=>"
+	MARROW     Type = "=!>" // XPath 4.0
 	BANG       Type = "!"
 	NE         Type = "!="
 	AT         Type = "@"
@@ -94,6 +96,8 @@ const (
 	TREATAS    Type = "treat as"
 	CASTABLEAS Type = "castable as"
 	CASTAS     Type = "cast as"
+	OTHERWISE  Type = "otherwise" // XPath 4.0
+	TEMPLATE   Type = "`"         // XPath 4.0, the literal is the content of a string template
 
 	//Reserved Function
 	ARRAY      Type = "array"
@@ -169,6 +173,11 @@ var keywords = map[string]Type{
 	"typeswitch":             TYPESWITCH,
 }
 
+// keywords40 are the keywords added in XPath 4.0
+var keywords40 = map[string]Type{
+	"otherwise": OTHERWISE,
+}
+
 // LookupIdent returns identifier token type
 func LookupIdent(ident string) Type {
 	if tok, ok := keywords[ident]; ok {
@@ -177,6 +186,14 @@ func LookupIdent(ident string) Type {
 	return IDENT
 }
 
+// LookupIdent40 is like LookupIdent but also recognizes the keywords added in XPath 4.0
+func LookupIdent40(ident string) Type {
+	if tok, ok := keywords40[ident]; ok {
+		return tok
+	}
+	return LookupIdent(ident)
+}
+
 // LookupNumber returns number token type
 func LookupNumber(number string) Type {
 	if strings.Contains(number, "e") || strings.Contains(number, "E") {
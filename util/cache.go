@@ -0,0 +1,64 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a LRU cache that is safe for concurrent use
+// When the cache is full, adding an entry evicts the least recently used one
+type Cache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[interface{}]*list.Element
+}
+
+type cacheEntry struct {
+	key   interface{}
+	value interface{}
+}
+
+// NewCache creates a cache that holds at most size entries
+func NewCache(size int) *Cache {
+	return &Cache{size: size, order: list.New(), items: map[interface{}]*list.Element{}}
+}
+
+// Get returns the value of the key and marks it as the most recently used
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+// Add sets the value of the key, the least recently used entry is evicted if the cache is full
+func (c *Cache) Add(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheEntry).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of the entries in the cache
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -249,3 +249,35 @@ func TestIsValueComp(t *testing.T) {
 		}
 	}
 }
+
+func TestCache(t *testing.T) {
+	c := NewCache(2)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("wrong value for a. got=%v", v)
+	}
+
+	// b is the least recently used entry
+	c.Add("c", 3)
+	if c.Len() != 2 {
+		t.Errorf("wrong length. got=%d, expected=2", c.Len())
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b should be evicted")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("%s should be cached", k)
+		}
+	}
+
+	c.Add("a", 4)
+	c.Add("d", 5)
+	if v, ok := c.Get("a"); !ok || v != 4 {
+		t.Errorf("wrong value for a. got=%v", v)
+	}
+	if _, ok := c.Get("c"); ok {
+		t.Errorf("c should be evicted")
+	}
+}